@@ -0,0 +1,260 @@
+// Command pipetop shows the state of a running pipeline in a terminal, like
+// top does for processes. It polls the JSON view of the pipeline's dashboard,
+// as served by the dashboard package, and redraws a table of its stages with
+// their throughput, backlog, busy workers, latency and latest error.
+//
+//	pipetop -interval 2s http://localhost:6060/debug/pipeline
+//
+// If the pipeline also serves a dashboard.Admin, by default at the dashboard
+// URL followed by "/admin", pipetop controls it too: type p to pause the
+// pipeline, r to resume it, d to drain it or q to quit, each followed by
+// Enter.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// snapshot is the JSON view of a dashboard.
+type snapshot struct {
+	Uptime time.Duration `json:"uptime_ns"`
+	Stages []stage       `json:"stages"`
+}
+
+type stage struct {
+	Name        string        `json:"name"`
+	In          int64         `json:"in"`
+	Out         int64         `json:"out"`
+	Errors      int64         `json:"errors"`
+	Backlog     int64         `json:"backlog"`
+	Active      int64         `json:"active_workers"`
+	AverageBusy float64       `json:"average_busy_workers"`
+	MaxLatency  time.Duration `json:"max_latency_ns"`
+	Recent      []struct {
+		Error string `json:"error"`
+	} `json:"recent_errors"`
+}
+
+// fetch gets a snapshot from the dashboard at addr.
+func fetch(client *http.Client, addr string) (*snapshot, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("format", "json")
+	u.RawQuery = q.Encode()
+	resp, err := client.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", u, resp.Status)
+	}
+	var s snapshot
+	if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+		return nil, fmt.Errorf("%s: %v", u, err)
+	}
+	return &s, nil
+}
+
+// control is the state of the pipeline reported by its dashboard.Admin.
+type control struct {
+	Paused   bool   `json:"paused"`
+	Draining bool   `json:"draining"`
+	Drained  bool   `json:"drained"`
+	Error    string `json:"drain_error"`
+}
+
+// String describes c on one line.
+func (c *control) String() string {
+	switch {
+	case c.Error != "":
+		return "drained: " + c.Error
+	case c.Drained:
+		return "drained"
+	case c.Draining:
+		return "draining"
+	case c.Paused:
+		return "paused"
+	}
+	return "running"
+}
+
+// adminURL returns the default address of the admin handler for the
+// dashboard at addr.
+func adminURL(addr string) (string, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", err
+	}
+	u.Path = path.Join("/", u.Path, "admin")
+	u.RawQuery = ""
+	return u.String(), nil
+}
+
+// act asks the admin handler at addr to carry out action, or only reports
+// the state of the pipeline if action is empty.
+func act(client *http.Client, addr, action string) (*control, error) {
+	var resp *http.Response
+	var err error
+	if action == "" {
+		resp, err = client.Get(addr)
+	} else {
+		resp, err = client.Post(addr+"?action="+url.QueryEscape(action), "", nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", addr, resp.Status)
+	}
+	var c control
+	if err := json.NewDecoder(resp.Body).Decode(&c); err != nil {
+		return nil, fmt.Errorf("%s: %v", addr, err)
+	}
+	return &c, nil
+}
+
+// actions maps the keys read from the terminal to admin actions.
+var actions = map[string]string{"p": "pause", "r": "resume", "d": "drain"}
+
+// keys sends the lines read from r to the returned channel, which is closed
+// once r is exhausted.
+func keys(r io.Reader) <-chan string {
+	out := make(chan string)
+	go func() {
+		defer close(out)
+		sc := bufio.NewScanner(r)
+		for sc.Scan() {
+			out <- strings.TrimSpace(sc.Text())
+		}
+	}()
+	return out
+}
+
+// render writes a table of the stages of cur to w. Rates are worked out from
+// the counts in prev, taken elapsed earlier, and left out if prev is nil.
+func render(w io.Writer, prev, cur *snapshot, elapsed time.Duration) {
+	fmt.Fprintf(w, "up %v, %d stages\n\n", cur.Uptime.Round(time.Second), len(cur.Stages))
+	before := make(map[string]stage)
+	if prev != nil {
+		for _, s := range prev.Stages {
+			before[s.Name] = s
+		}
+	}
+	// rate returns the change from was to now per second, as a column.
+	rate := func(now, was int64, ok bool) string {
+		if !ok || elapsed <= 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%.1f", float64(now-was)/elapsed.Seconds())
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprint(tw, "STAGE\tIN/S\tOUT/S\tIN\tOUT\tBACKLOG\tBUSY\tAVG BUSY\tMAX LATENCY\tERRORS\n")
+	var failing []stage
+	for _, s := range cur.Stages {
+		b, ok := before[s.Name]
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%d\t%d\t%.2f\t%v\t%d\n",
+			s.Name, rate(s.In, b.In, ok), rate(s.Out, b.Out, ok), s.In, s.Out,
+			s.Backlog, s.Active, s.AverageBusy, s.MaxLatency, s.Errors)
+		if len(s.Recent) > 0 {
+			failing = append(failing, s)
+		}
+	}
+	tw.Flush()
+	if len(failing) > 0 {
+		fmt.Fprintln(w, "\nlatest errors:")
+		for _, s := range failing {
+			// Keep every error on a line of its own.
+			msg := strings.ReplaceAll(s.Recent[0].Error, "\n", " ")
+			fmt.Fprintf(w, "  %s: %s\n", s.Name, msg)
+		}
+	}
+}
+
+func main() {
+	interval := flag.Duration("interval", 2*time.Second, "time between refreshes")
+	once := flag.Bool("once", false, "print the table once and exit")
+	admin := flag.String("admin", "", "URL of the admin handler (default dashboard-url/admin)")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: pipetop [flags] dashboard-url")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	addr := flag.Arg(0)
+	client := &http.Client{Timeout: 10 * time.Second}
+	if *admin == "" {
+		u, err := adminURL(addr)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		*admin = u
+	}
+
+	input := keys(os.Stdin)
+	var prev *snapshot
+	var last time.Time
+	action := ""
+	for {
+		cur, err := fetch(client, addr)
+		now := time.Now()
+		if *once {
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			render(os.Stdout, nil, cur, 0)
+			return
+		}
+		// Clear the screen and go back to its top left corner.
+		fmt.Print("\033[H\033[2J")
+		if err != nil {
+			// The pipeline may be restarting; keep trying.
+			fmt.Println(err)
+			prev = nil
+		} else {
+			render(os.Stdout, prev, cur, now.Sub(last))
+			prev, last = cur, now
+		}
+		if c, err := act(client, *admin, action); err != nil {
+			// Watching a pipeline without an admin handler is fine.
+			fmt.Printf("\ncontrols unavailable: %v\n", err)
+		} else {
+			fmt.Printf("\npipeline %v; p pause, r resume, d drain, q quit (then Enter)\n", c)
+		}
+		action = ""
+
+		select {
+		case <-time.After(*interval):
+		case key, ok := <-input:
+			if !ok {
+				// Without a terminal to read keys from, only watch.
+				input = nil
+				break
+			}
+			if key == "q" {
+				return
+			}
+			action = actions[key]
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"concurrency/pipeline"
+	"concurrency/pipeline/dashboard"
+)
+
+func TestFetch(t *testing.T) {
+	m := pipeline.NewMetrics()
+	fn := pipeline.InstrumentFunc(m, "parse", func(_ context.Context, v int) (int, error) {
+		if v == 2 {
+			return 0, errors.New("bad item\n2")
+		}
+		return v, nil
+	})
+	for v := 1; v <= 3; v++ {
+		fn(context.Background(), v)
+	}
+	srv := httptest.NewServer(dashboard.New(m))
+	defer srv.Close()
+
+	s, err := fetch(srv.Client(), srv.URL+"/?refresh=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Stages) != 1 || s.Stages[0].Name != "parse" || s.Stages[0].Errors != 1 {
+		t.Fatalf("got %+v, want parse with one error", s.Stages)
+	}
+	var out bytes.Buffer
+	render(&out, nil, s, 0)
+	for _, want := range []string{"STAGE", "parse", "latest errors:\n  parse: bad item 2\n"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("table lacks %q:\n%s", want, out.String())
+		}
+	}
+
+	srv.Config.Handler = http.NotFoundHandler()
+	if _, err := fetch(srv.Client(), srv.URL); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("got %v, want the status reported", err)
+	}
+}
+
+func TestRenderRates(t *testing.T) {
+	prev := &snapshot{Stages: []stage{{Name: "fetch", In: 10, Out: 4}}}
+	cur := &snapshot{Uptime: time.Minute, Stages: []stage{
+		{Name: "fetch", In: 30, Out: 8, Backlog: 22, Active: 3, AverageBusy: 2.5, MaxLatency: 150 * time.Millisecond},
+		{Name: "index", In: 5, Out: 5},
+	}}
+	var out bytes.Buffer
+	render(&out, prev, cur, 2*time.Second)
+	want := `up 1m0s, 2 stages
+
+STAGE  IN/S  OUT/S  IN  OUT  BACKLOG  BUSY  AVG BUSY  MAX LATENCY  ERRORS
+fetch  10.0  2.0    30  8    22       3     2.50      150ms        0
+index  -     -      5   5    0        0     0.00      0s           0
+`
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestAdminURL(t *testing.T) {
+	for addr, want := range map[string]string{
+		"http://localhost:6060/debug/pipeline":            "http://localhost:6060/debug/pipeline/admin",
+		"http://localhost:6060/debug/pipeline/?refresh=1": "http://localhost:6060/debug/pipeline/admin",
+		"http://localhost:6060":                           "http://localhost:6060/admin",
+	} {
+		if got, err := adminURL(addr); err != nil || got != want {
+			t.Errorf("adminURL(%q) = %q, %v, want %q", addr, got, err, want)
+		}
+	}
+}
+
+func TestAct(t *testing.T) {
+	s, _ := pipeline.NewShutdown(context.Background())
+	defer s.Cancel()
+	srv := httptest.NewServer(dashboard.NewAdmin(s))
+	defer srv.Close()
+
+	c, err := act(srv.Client(), srv.URL, actions["p"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Paused() || c.String() != "paused" {
+		t.Errorf("got %v, want the pipeline paused", c)
+	}
+	if c, err = act(srv.Client(), srv.URL, ""); err != nil || c.String() != "paused" {
+		t.Errorf("got %v, %v, want the state reported", c, err)
+	}
+	if c, err = act(srv.Client(), srv.URL, actions["r"]); err != nil || s.Paused() || c.String() != "running" {
+		t.Errorf("got %v, %v, want the pipeline running", c, err)
+	}
+	if _, err := act(srv.Client(), srv.URL, "explode"); err == nil || !strings.Contains(err.Error(), "400") {
+		t.Errorf("got %v, want the status reported", err)
+	}
+}
+
+func TestKeys(t *testing.T) {
+	var got []string
+	for key := range keys(strings.NewReader("p\n d \nq\n")) {
+		got = append(got, key)
+	}
+	if strings.Join(got, ",") != "p,d,q" {
+		t.Errorf("got keys %q, want p, d and q", got)
+	}
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"sync"
+
+	"concurrency/pipeline"
 )
 
 // GEN FUNCTION
@@ -27,17 +29,8 @@ import (
 // 	return out
 // }
 
-// we can simplify this function by using a buffered channel.
-// since the channel is buffered it can take all values without waiting or
-// blocking. We can close the channel immediately after sending.
-func gen(nums ...int) <-chan int {
-	out := make(chan int, len(nums))
-	for _, n := range nums {
-		out <- n
-	}
-	close(out)
-	return out
-}
+// pipeline.Gen is such a function, for values of any type, which also stops
+// sending once the pipeline is cancelled.
 
 // this function is basically a "worker" function. it takes in a raw material
 // of some sort, does work on it, and returns a value.
@@ -47,67 +40,30 @@ func gen(nums ...int) <-chan int {
 
 // there can be multiple "worker" function taking messages from a single
 // channel -- i.e. constituting a "fan out".
-func sq(done <-chan struct{}, in <-chan int) <-chan int {
-	out := make(chan int)
-	go func() {
-		defer close(out)
-		for n := range in {
-			select {
-			case out <- n * n:
-			case <-done:
-				return
-			}
-		}
-	}()
-	return out
-}
-
-// this function is basically the "fan in" part of the arrangement. It takes in
-// multiple channels as argument and returns values on a single channel.
-func merge(done <-chan struct{}, cs ...<-chan int) <-chan int {
-	var wg sync.WaitGroup
-	out := make(chan int)
-
-	// start an output goroutine for each input channel in cs. output
-	// copies values from c to out until c is closed, then calls wg.Done
-	output := func(c <-chan int) {
-		defer wg.Done()
-		for n := range c {
-			select {
-			case out <- n:
-			case <-done:
-				return
-			}
-		}
-	}
 
-	wg.Add(len(cs))
-	for _, c := range cs {
-		go output(c)
-	}
-
-	// Start a gouroutine to close out once all the output gouroutines are
-	// done. This must start after the wg.Add call.
-	go func() {
-		wg.Wait()
-		close(out)
-	}()
-	return out
+// the context carries the cancellation signal: when it is cancelled, whether
+// explicitly, by a timeout or because the HTTP request it came from has
+// ended, the worker stops and closes its output channel.
+func sq(ctx context.Context, in <-chan int) <-chan int {
+	return pipeline.Map(ctx, in, func(n int) int { return n * n })
 }
 
 func main() {
-	// Set up a done channel that's shared by the whole pipeline,
-	// and close that channel when this pipeline exits, as a signal
-	// for all the gouroutines we started to exit.
-	done := make(chan struct{})
-	defer close(done)
-
-	in := gen(2, 3)
-
-	c1 := sq(done, in)
-	c2 := sq(done, in)
-
-	// Consume the first value from output.
-	out := merge(done, c1, c2)
-	fmt.Println(<-out) // 4 or 9
+	// Set up a context that's shared by the whole pipeline, and cancel it
+	// when this pipeline exits, as a signal for all the gouroutines we
+	// started to exit.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	in := pipeline.Gen(ctx, 2, 3)
+
+	// Consume the first value from output. Take discards the value of the
+	// other worker, so that it is not left waiting to send it.
+	// pipeline.FanOut starts a number of workers over a single channel and
+	// merges their outputs, so that the level of concurrency is a
+	// parameter.
+	out := pipeline.FanOut(ctx, in, 2, sq)
+	for v := range pipeline.Take(ctx, out, 1) {
+		fmt.Println(v) // 4 or 9
+	}
 }
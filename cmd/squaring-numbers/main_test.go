@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"concurrency/pipeline"
+)
+
+func TestSq(t *testing.T) {
+	ctx := context.Background()
+	var got []int
+	for v := range sq(ctx, pipeline.Gen(ctx, 2, 3, 4)) {
+		got = append(got, v)
+	}
+	if len(got) != 3 || got[0] != 4 || got[1] != 9 || got[2] != 16 {
+		t.Errorf("got %v, want [4 9 16]", got)
+	}
+}
+
+func TestSqCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	out := sq(ctx, pipeline.Gen(ctx, 2, 3))
+	<-out
+	// The worker is blocked sending 9, which nobody receives; cancelling
+	// releases it and closes its output.
+	cancel()
+	select {
+	case <-out:
+	case <-time.After(time.Second):
+		t.Fatal("worker still running after cancellation")
+	}
+	select {
+	case _, ok := <-out:
+		if ok {
+			t.Error("worker sent a value after it was released")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("output not closed after cancellation")
+	}
+}
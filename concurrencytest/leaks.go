@@ -0,0 +1,125 @@
+// Package concurrencytest provides helpers for testing concurrent code.
+package concurrencytest
+
+import (
+	"bytes"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// LeakTimeout bounds how long AssertNoLeaks waits for goroutines to exit
+// before reporting them as leaked. Goroutines of a cancelled pipeline exit
+// shortly after the cancellation, not at once.
+var LeakTimeout = 5 * time.Second
+
+// AssertNoLeaks fails t if goroutines started after the call are still
+// running once the test has finished. Call it first thing in a test, so that
+// the check runs after every other cleanup:
+//
+//	func TestPipeline(t *testing.T) {
+//		concurrencytest.AssertNoLeaks(t)
+//		ctx, cancel := context.WithCancel(context.Background())
+//		defer cancel()
+//		...
+//	}
+//
+// It cannot tell the goroutines of one test from those of another, so tests
+// that use it must not run in parallel.
+func AssertNoLeaks(t testing.TB) {
+	t.Helper()
+	before := make(map[string]bool)
+	for _, g := range goroutines() {
+		before[g.id] = true
+	}
+	t.Cleanup(func() {
+		deadline := time.Now().Add(LeakTimeout)
+		for wait := time.Millisecond; ; wait *= 2 {
+			var leaked []goroutine
+			for _, g := range goroutines() {
+				if !before[g.id] && !ignored(g) {
+					leaked = append(leaked, g)
+				}
+			}
+			if len(leaked) == 0 {
+				return
+			}
+			if time.Now().After(deadline) {
+				var b strings.Builder
+				for _, g := range leaked {
+					b.WriteString("\n\n")
+					b.WriteString(g.stack)
+				}
+				t.Errorf("%d goroutines leaked:%s", len(leaked), b.String())
+				return
+			}
+			if wait > 100*time.Millisecond {
+				wait = 100 * time.Millisecond
+			}
+			time.Sleep(wait)
+		}
+	})
+}
+
+// A goroutine is a goroutine from a dump of all of them.
+type goroutine struct {
+	id    string
+	stack string
+}
+
+// goroutines returns every goroutine except the calling one.
+func goroutines() []goroutine {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	var gs []goroutine
+	// The first stack is that of the calling goroutine.
+	for i, s := range bytes.Split(buf, []byte("\n\n")) {
+		if i == 0 {
+			continue
+		}
+		stack := string(s)
+		// A stack starts with a header like "goroutine 7 [chan receive]:".
+		id, _, ok := strings.Cut(strings.TrimPrefix(stack, "goroutine "), " ")
+		if !ok {
+			continue
+		}
+		gs = append(gs, goroutine{id: id, stack: stack})
+	}
+	return gs
+}
+
+// ignored reports whether g belongs to the runtime or the testing package
+// rather than the code under test.
+func ignored(g goroutine) bool {
+	for _, fn := range []string{
+		"testing.(*T).Run",
+		"testing.tRunner",
+		"testing.runTests",
+		"testing.(*M).",
+		"runtime.goexit",
+		"os/signal.signal_recv",
+		"runtime.ensureSigM",
+	} {
+		if strings.Contains(firstFrame(g.stack), fn) {
+			return true
+		}
+	}
+	return false
+}
+
+// firstFrame returns the function at the top of stack.
+func firstFrame(stack string) string {
+	lines := strings.SplitN(stack, "\n", 3)
+	if len(lines) < 2 {
+		return ""
+	}
+	return lines[1]
+}
@@ -0,0 +1,76 @@
+package concurrencytest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recorder is a testing.TB that keeps its errors and cleanups to itself, so
+// that a test can check what AssertNoLeaks reports.
+type recorder struct {
+	testing.TB
+	errors   []string
+	cleanups []func()
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...any) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) Cleanup(fn func()) {
+	r.cleanups = append(r.cleanups, fn)
+}
+
+// finish runs the cleanups registered with r, as at the end of a test.
+func (r *recorder) finish() {
+	for i := len(r.cleanups) - 1; i >= 0; i-- {
+		r.cleanups[i]()
+	}
+}
+
+// blockUntil blocks until release is closed. It shows up by name in the
+// stack of a leaked goroutine.
+func blockUntil(release chan struct{}) {
+	<-release
+}
+
+func TestAssertNoLeaks(t *testing.T) {
+	defer func(d time.Duration) { LeakTimeout = d }(LeakTimeout)
+	LeakTimeout = 50 * time.Millisecond
+
+	// Goroutines already running when the check starts are not reported.
+	before := make(chan struct{})
+	defer close(before)
+	go blockUntil(before)
+
+	r := &recorder{TB: t}
+	AssertNoLeaks(r)
+	release := make(chan struct{})
+	defer close(release)
+	go blockUntil(release)
+	r.finish()
+	if len(r.errors) != 1 {
+		t.Fatalf("got errors %q, want the leak reported", r.errors)
+	}
+	if !strings.HasPrefix(r.errors[0], "1 goroutines leaked") || !strings.Contains(r.errors[0], "blockUntil") {
+		t.Errorf("got %q, want the stack of the leaked goroutine", r.errors[0])
+	}
+}
+
+func TestAssertNoLeaksWaits(t *testing.T) {
+	r := &recorder{TB: t}
+	AssertNoLeaks(r)
+	// A goroutine that exits shortly after the test, as those of a
+	// cancelled pipeline do, is not a leak.
+	release := make(chan struct{})
+	go blockUntil(release)
+	time.AfterFunc(20*time.Millisecond, func() { close(release) })
+	r.finish()
+	if len(r.errors) != 0 {
+		t.Errorf("got errors %q, want none", r.errors)
+	}
+}
@@ -0,0 +1,244 @@
+package concurrencytest
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"concurrency/pipeline"
+)
+
+// A Ramp configures RampUp.
+type Ramp struct {
+	// Rate is the number of items per second fed to the pipeline in the
+	// first step. Zero means 10.
+	Rate float64
+	// Growth multiplies the rate after every step. Values up to 1 mean 2.
+	Growth float64
+	// Workers is the fan-out of the first step. It grows along with the
+	// rate up to MaxWorkers. Zero means 1.
+	Workers int
+	// MaxWorkers caps the fan-out. Values below Workers keep the fan-out
+	// at Workers.
+	MaxWorkers int
+	// Step is how long every rate is held. Zero means one second.
+	Step time.Duration
+	// Steps is the largest number of steps taken. Zero means 20.
+	Steps int
+	// MaxLatency is the 99th percentile of the time from an item being due
+	// to be fed to its result coming out above which the pipeline is
+	// considered broken. Zero means one second.
+	MaxLatency time.Duration
+	// MaxErrorRate is the share of failed items above which the pipeline
+	// is considered broken. Zero means any failure breaks it.
+	MaxErrorRate float64
+}
+
+// A RampTarget starts the pipeline under test with the given fan-out over the
+// items received from in, and sends the outcome of every item on the channel
+// it returns, which it closes once in has been closed. For RampUp to tell
+// which stage saturated first, the stages must report to m by having their
+// work functions wrapped with pipeline.InstrumentFunc or
+// pipeline.InstrumentEnvelope.
+type RampTarget[Out any] func(ctx context.Context, in <-chan pipeline.Envelope[int], workers int, m *pipeline.Metrics) <-chan pipeline.Result[pipeline.Envelope[Out]]
+
+// A RampStep is the outcome of a step of RampUp.
+type RampStep struct {
+	Rate    float64
+	Workers int
+	// Sent is the number of items fed to the pipeline, Done the number of
+	// successful results and Failed the number of failed ones. Items
+	// without a result by the end of the step are neither.
+	Sent, Done, Failed int
+	// Latency is the 99th percentile of the time from an item being due to
+	// be fed to its result, counting the items without a result as
+	// infinitely late.
+	Latency time.Duration
+	// Stages are the stats of the stages reported to the Metrics of the
+	// step.
+	Stages []pipeline.StageStats
+	// Utilization is, for every stage, the share of the step its workers
+	// spent busy, counting as many workers as were seen busy at once.
+	Utilization map[string]float64
+}
+
+// A RampReport is the outcome of RampUp.
+type RampReport struct {
+	// Capacity is the highest rate, in items per second, that the pipeline
+	// kept up with, and Workers the fan-out it did so with. Capacity is
+	// zero if the pipeline broke in the first step.
+	Capacity float64
+	Workers  int
+	// Broken tells why the ramp stopped, or is empty if the pipeline held up
+	// until the last step.
+	Broken string
+	// Saturated is the name of the stage with the highest utilization in
+	// the step the pipeline broke in, which is the first to have run out
+	// of capacity.
+	Saturated string
+	Steps     []RampStep
+}
+
+// RampUp stress tests the pipeline started by target: it feeds items at a
+// rate that it raises step by step, along with the fan-out, until the 99th
+// percentile latency or the error rate of a step exceeds its threshold. Every
+// step starts a fresh pipeline, and gives it up to r.MaxLatency after the last
+// item has been fed to deliver the remaining results. RampUp returns the
+// capacity measured and the stage that saturated first, or the context's error
+// if it is cancelled.
+func RampUp[Out any](ctx context.Context, r Ramp, target RampTarget[Out]) (RampReport, error) {
+	if r.Rate <= 0 {
+		r.Rate = 10
+	}
+	if r.Growth <= 1 {
+		r.Growth = 2
+	}
+	if r.Workers < 1 {
+		r.Workers = 1
+	}
+	if r.MaxWorkers < r.Workers {
+		r.MaxWorkers = r.Workers
+	}
+	if r.Step <= 0 {
+		r.Step = time.Second
+	}
+	if r.Steps <= 0 {
+		r.Steps = 20
+	}
+	if r.MaxLatency <= 0 {
+		r.MaxLatency = time.Second
+	}
+
+	var report RampReport
+	rate, workers := r.Rate, float64(r.Workers)
+	for i := 0; i < r.Steps; i++ {
+		s := rampStep(ctx, r, target, rate, int(math.Min(workers, float64(r.MaxWorkers))))
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		report.Steps = append(report.Steps, s)
+		switch {
+		case s.Latency > r.MaxLatency:
+			report.Broken = fmt.Sprintf("99th percentile latency %v above %v at %.1f items/s", s.Latency, r.MaxLatency, s.Rate)
+		case float64(s.Failed) > r.MaxErrorRate*float64(s.Sent):
+			report.Broken = fmt.Sprintf("%d of %d items failed at %.1f items/s", s.Failed, s.Sent, s.Rate)
+		}
+		if report.Broken != "" {
+			report.Saturated = saturated(s.Utilization)
+			return report, nil
+		}
+		report.Capacity, report.Workers = s.Rate, s.Workers
+		rate *= r.Growth
+		workers *= r.Growth
+	}
+	return report, nil
+}
+
+// rampStep runs a single step of RampUp.
+func rampStep[Out any](ctx context.Context, r Ramp, target RampTarget[Out], rate float64, workers int) RampStep {
+	s := RampStep{Rate: rate, Workers: workers}
+	m := pipeline.NewMetrics()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	in := make(chan pipeline.Envelope[int])
+	out := target(ctx, in, workers, m)
+
+	n := int(rate * r.Step.Seconds())
+	if n < 1 {
+		n = 1
+	}
+	start := time.Now()
+	go func() {
+		defer close(in)
+		for i := 0; i < n; i++ {
+			// Items are stamped with the time they are due rather than
+			// the time they are sent, so that a pipeline pushing back on
+			// the feed shows as latency.
+			due := start.Add(time.Duration(float64(i) / rate * float64(time.Second)))
+			if d := time.Until(due); d > 0 {
+				t := time.NewTimer(d)
+				select {
+				case <-t.C:
+				case <-ctx.Done():
+					t.Stop()
+					return
+				}
+			}
+			select {
+			case in <- pipeline.Envelope[int]{Item: i, Source: "ramp", Ingested: due}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	deadline := time.NewTimer(r.Step + r.MaxLatency)
+	defer deadline.Stop()
+	// The number of workers of every stage is taken to be the most seen
+	// busy at once, sampled throughout the step.
+	sample := time.NewTicker(r.Step / 50)
+	defer sample.Stop()
+	peak := make(map[string]int64)
+	latencies := make([]time.Duration, 0, n)
+collect:
+	for {
+		select {
+		case res, ok := <-out:
+			if !ok {
+				break collect
+			}
+			if res.Err != nil {
+				s.Failed++
+				continue
+			}
+			s.Done++
+			latencies = append(latencies, res.Value.Age())
+		case <-sample.C:
+			for _, st := range m.Metrics() {
+				if st.Active > peak[st.Name] {
+					peak[st.Name] = st.Active
+				}
+			}
+		case <-deadline.C:
+			break collect
+		case <-ctx.Done():
+			break collect
+		}
+	}
+	s.Stages = m.Metrics()
+	elapsed := time.Since(start)
+	s.Utilization = make(map[string]float64, len(s.Stages))
+	for _, st := range s.Stages {
+		if p := peak[st.Name]; p > 0 {
+			s.Utilization[st.Name] = float64(st.Busy) / float64(elapsed) / float64(p)
+		}
+	}
+	cancel()
+	for range out {
+	}
+
+	s.Sent = n
+	// The items that got no result count as slower than all the others.
+	for len(latencies) < n-s.Failed {
+		latencies = append(latencies, math.MaxInt64)
+	}
+	if len(latencies) > 0 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		s.Latency = latencies[(len(latencies)*99-1)/100]
+	}
+	return s
+}
+
+// saturated returns the name of the stage with the highest utilization, or an
+// empty string if no stage was seen busy.
+func saturated(utilization map[string]float64) string {
+	name, highest := "", 0.0
+	for n, u := range utilization {
+		if u > highest || u == highest && n < name {
+			name, highest = n, u
+		}
+	}
+	return name
+}
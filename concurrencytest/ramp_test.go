@@ -0,0 +1,111 @@
+package concurrencytest
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"concurrency/pipeline"
+)
+
+// sleepy returns a ramp target with a fast stage followed by one that takes
+// 10ms per item in every worker.
+func sleepy(fail func(int) bool) RampTarget[int] {
+	return func(ctx context.Context, in <-chan pipeline.Envelope[int], workers int, m *pipeline.Metrics) <-chan pipeline.Result[pipeline.Envelope[int]] {
+		fast := pipeline.InstrumentEnvelope(m, "fast", func(_ context.Context, e pipeline.Envelope[int]) (pipeline.Envelope[int], error) {
+			return e, nil
+		})
+		slow := pipeline.InstrumentEnvelope(m, "slow", func(_ context.Context, e pipeline.Envelope[int]) (pipeline.Envelope[int], error) {
+			time.Sleep(10 * time.Millisecond)
+			if fail(e.Item) {
+				return e, errors.New("failed")
+			}
+			return e, nil
+		})
+		mid := pipeline.Map(ctx, in, func(e pipeline.Envelope[int]) pipeline.Envelope[int] {
+			e, _ = fast(ctx, e)
+			return e
+		})
+		return pipeline.FanOut(ctx, mid, workers, func(ctx context.Context, in <-chan pipeline.Envelope[int]) <-chan pipeline.Result[pipeline.Envelope[int]] {
+			return pipeline.TryMap(ctx, in, slow)
+		})
+	}
+}
+
+func TestRampUpLatency(t *testing.T) {
+	AssertNoLeaks(t)
+	// A single worker at 10ms per item keeps up with 100 items per second
+	// at most.
+	report, err := RampUp(context.Background(), Ramp{
+		Rate:       25,
+		Step:       200 * time.Millisecond,
+		MaxLatency: 100 * time.Millisecond,
+	}, sleepy(func(int) bool { return false }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Capacity < 25 || report.Capacity > 100 {
+		t.Errorf("capacity %.1f items/s, want between 25 and 100", report.Capacity)
+	}
+	if !strings.Contains(report.Broken, "latency") {
+		t.Errorf("broken because %q, want latency", report.Broken)
+	}
+	if report.Saturated != "slow" {
+		t.Errorf("saturated stage %q, want slow", report.Saturated)
+	}
+	if n := len(report.Steps); n < 2 || report.Steps[n-1].Rate <= report.Capacity {
+		t.Errorf("steps %+v do not end past the capacity", report.Steps)
+	}
+}
+
+func TestRampUpErrors(t *testing.T) {
+	AssertNoLeaks(t)
+	report, err := RampUp(context.Background(), Ramp{
+		Rate:         50,
+		Step:         100 * time.Millisecond,
+		MaxErrorRate: 0.05,
+	}, sleepy(func(i int) bool { return i%10 == 0 }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Capacity != 0 || !strings.Contains(report.Broken, "failed") {
+		t.Errorf("got capacity %.1f, broken because %q, want a failure in the first step", report.Capacity, report.Broken)
+	}
+}
+
+func TestRampUpFanOut(t *testing.T) {
+	AssertNoLeaks(t)
+	report, err := RampUp(context.Background(), Ramp{
+		Rate:       50,
+		Workers:    1,
+		MaxWorkers: 4,
+		Step:       100 * time.Millisecond,
+		Steps:      3,
+		MaxLatency: 200 * time.Millisecond,
+	}, sleepy(func(int) bool { return false }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var workers []int
+	for _, s := range report.Steps {
+		workers = append(workers, s.Workers)
+	}
+	if want := []int{1, 2, 4}; len(workers) != 3 || workers[0] != 1 || workers[1] != 2 || workers[2] != 4 {
+		t.Errorf("fan-out by step %v, want %v", workers, want)
+	}
+	if report.Broken != "" {
+		t.Errorf("broke with enough workers: %s", report.Broken)
+	}
+}
+
+func TestRampUpCancel(t *testing.T) {
+	AssertNoLeaks(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := RampUp(ctx, Ramp{Step: time.Hour}, sleepy(func(int) bool { return false }))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+}
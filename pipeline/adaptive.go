@@ -0,0 +1,147 @@
+package pipeline
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// An AdaptiveLimiter caps the number of calls to a dependency running at
+// once, and finds the cap by itself: it raises it additively while calls
+// complete within a target latency, and halves it when one takes longer or
+// fails, in the manner of TCP congestion control. The load on the dependency
+// thus follows what it can take, backing off quickly when it degrades. Share
+// a single AdaptiveLimiter between all the workers calling the same
+// dependency, and start at least as many workers as its maximum.
+type AdaptiveLimiter struct {
+	min, max float64
+	target   time.Duration
+
+	mu       sync.Mutex
+	limit    float64
+	inFlight int
+	// decreased is when the limit was last halved. Calls started before
+	// then do not halve it again, so that a burst of slow calls in flight
+	// together backs off once rather than once per call.
+	decreased time.Time
+	waiters   list.List // of chan struct{}, closed once let through
+}
+
+// NewAdaptiveLimiter returns an AdaptiveLimiter whose cap starts at lo and
+// varies between lo and hi, with calls taking longer than target counted as a
+// sign of overload.
+func NewAdaptiveLimiter(lo, hi int, target time.Duration) *AdaptiveLimiter {
+	if lo < 1 {
+		lo = 1
+	}
+	if hi < lo {
+		hi = lo
+	}
+	return &AdaptiveLimiter{min: float64(lo), max: float64(hi), target: target, limit: float64(lo)}
+}
+
+// Limit returns the current cap on the number of calls running at once.
+func (l *AdaptiveLimiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+// InFlight returns the number of calls running.
+func (l *AdaptiveLimiter) InFlight() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inFlight
+}
+
+// acquire waits for a call to be allowed to start, or for the context to be
+// cancelled, in which case it returns the context's error.
+func (l *AdaptiveLimiter) acquire(ctx context.Context) error {
+	l.mu.Lock()
+	if l.inFlight < int(l.limit) && l.waiters.Len() == 0 {
+		l.inFlight++
+		l.mu.Unlock()
+		return nil
+	}
+	ready := make(chan struct{})
+	elem := l.waiters.PushBack(ready)
+	l.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		select {
+		case <-ready:
+			// Let through just as the context was cancelled; give
+			// the slot back.
+			l.inFlight--
+			l.notify()
+		default:
+			l.waiters.Remove(elem)
+		}
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// release ends a call started at start, adjusting the limit according to
+// whether it failed or took too long.
+func (l *AdaptiveLimiter) release(start time.Time, overloaded bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+	switch {
+	case overloaded && start.After(l.decreased):
+		l.limit /= 2
+		if l.limit < l.min {
+			l.limit = l.min
+		}
+		l.decreased = time.Now()
+	case !overloaded:
+		// Grow by one for every limit calls that went well, which is
+		// about one per round of calls.
+		l.limit += 1 / l.limit
+		if l.limit > l.max {
+			l.limit = l.max
+		}
+	}
+	l.notify()
+}
+
+// notify lets the waiters at the front of the queue through for as long as
+// the limit allows. It must be called with mu held.
+func (l *AdaptiveLimiter) notify() {
+	for l.inFlight < int(l.limit) {
+		front := l.waiters.Front()
+		if front == nil {
+			return
+		}
+		l.inFlight++
+		close(l.waiters.Remove(front).(chan struct{}))
+	}
+}
+
+// WithAdaptiveLimit wraps fn so that calls to it wait for l to let them
+// through, and adjust l's cap by how long they took and whether they failed.
+// Errors caused by the context being cancelled do not count as failures.
+//
+//	limiter := NewAdaptiveLimiter(2, 64, 200*time.Millisecond)
+//	results := FanOut(ctx, urls, 64, func(ctx context.Context, in <-chan string) <-chan Result[Page] {
+//		return TryMap(ctx, in, WithAdaptiveLimit(limiter, fetch))
+//	})
+func WithAdaptiveLimit[In, Out any](l *AdaptiveLimiter, fn func(context.Context, In) (Out, error)) func(context.Context, In) (Out, error) {
+	return func(ctx context.Context, v In) (Out, error) {
+		if err := l.acquire(ctx); err != nil {
+			var zero Out
+			return zero, err
+		}
+		start := time.Now()
+		out, err := fn(ctx, v)
+		overloaded := (err != nil && ctx.Err() == nil) || time.Since(start) > l.target
+		l.release(start, overloaded)
+		return out, err
+	}
+}
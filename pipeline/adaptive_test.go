@@ -0,0 +1,149 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveLimiterGrowsAndBacksOff(t *testing.T) {
+	ctx := context.Background()
+	l := NewAdaptiveLimiter(1, 4, time.Hour)
+	fail := false
+	fn := WithAdaptiveLimit(l, func(context.Context, int) (int, error) {
+		if fail {
+			return 0, errDown
+		}
+		return 0, nil
+	})
+	fn(ctx, 0)
+	if got := l.Limit(); got != 2 {
+		t.Errorf("got limit %d after a call that went well, want 2", got)
+	}
+	for i := 0; i < 20; i++ {
+		fn(ctx, 0)
+	}
+	if got := l.Limit(); got != 4 {
+		t.Errorf("got limit %d, want it to stop at the maximum of 4", got)
+	}
+
+	fail = true
+	for _, want := range []int{2, 1, 1} {
+		if _, err := fn(ctx, 0); err != errDown {
+			t.Fatalf("got %v, want the error of the call", err)
+		}
+		if got := l.Limit(); got != want {
+			t.Errorf("got limit %d after a failure, want %d", got, want)
+		}
+	}
+}
+
+func TestAdaptiveLimiterSlowCalls(t *testing.T) {
+	ctx := context.Background()
+	l := NewAdaptiveLimiter(1, 8, time.Millisecond)
+	fast := WithAdaptiveLimit(l, func(context.Context, int) (int, error) { return 0, nil })
+	slow := WithAdaptiveLimit(l, func(context.Context, int) (int, error) {
+		time.Sleep(5 * time.Millisecond)
+		return 0, nil
+	})
+	for l.Limit() < 4 {
+		fast(ctx, 0)
+	}
+	slow(ctx, 0)
+	if got := l.Limit(); got != 2 {
+		t.Errorf("got limit %d after a slow call, want 2", got)
+	}
+}
+
+func TestAdaptiveLimiterBacksOffOncePerBurst(t *testing.T) {
+	ctx := context.Background()
+	l := NewAdaptiveLimiter(1, 4, time.Hour)
+	fail := make(chan struct{})
+	fn := WithAdaptiveLimit(l, func(_ context.Context, v int) (int, error) {
+		if v == 0 {
+			return 0, nil
+		}
+		<-fail
+		return 0, errDown
+	})
+	for l.Limit() < 4 {
+		fn(ctx, 0)
+	}
+	var wg sync.WaitGroup
+	wg.Add(3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			defer wg.Done()
+			fn(ctx, 1)
+		}()
+	}
+	for l.InFlight() < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	close(fail)
+	wg.Wait()
+	if got := l.Limit(); got != 2 {
+		t.Errorf("got limit %d after three calls in flight together failed, want 2", got)
+	}
+}
+
+func TestAdaptiveLimiterWaits(t *testing.T) {
+	l := NewAdaptiveLimiter(1, 1, time.Hour)
+	release := make(chan struct{})
+	fn := WithAdaptiveLimit(l, func(ctx context.Context, v int) (int, error) {
+		if v == 0 {
+			<-release
+		}
+		return v, ctx.Err()
+	})
+	first := make(chan error)
+	go func() {
+		_, err := fn(context.Background(), 0)
+		first <- err
+	}()
+	for l.InFlight() < 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// A second call waits for the first, until its context is cancelled.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := fn(ctx, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want the call to wait until its deadline", err)
+	}
+	if got := l.InFlight(); got != 1 {
+		t.Errorf("got %d calls in flight, want the first only", got)
+	}
+
+	// Once the first is done, the next one goes straight through.
+	second := make(chan int)
+	go func() {
+		v, _ := fn(context.Background(), 2)
+		second <- v
+	}()
+	close(release)
+	if err := <-first; err != nil {
+		t.Fatal(err)
+	}
+	if v := <-second; v != 2 {
+		t.Errorf("got %d, want 2", v)
+	}
+	if got := l.InFlight(); got != 0 {
+		t.Errorf("got %d calls in flight after all were done", got)
+	}
+}
+
+func TestAdaptiveLimiterIgnoresCancellation(t *testing.T) {
+	l := NewAdaptiveLimiter(1, 4, time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	fn := WithAdaptiveLimit(l, func(ctx context.Context, _ int) (int, error) {
+		cancel()
+		return 0, ctx.Err()
+	})
+	fn(ctx, 0)
+	if got := l.Limit(); got != 2 {
+		t.Errorf("got limit %d, want a cancelled call not counted as a failure", got)
+	}
+}
@@ -0,0 +1,114 @@
+package pipeline
+
+import (
+	"context"
+	"testing"
+)
+
+// The stages below move small values without allocating per item: these
+// tests push items one at a time through stages started beforehand, so that
+// only the allocations made for the items themselves are counted.
+
+// through sends v on in and returns what comes out of out.
+func through[In, Out any](in chan<- In, out <-chan Out, v In) Out {
+	in <- v
+	return <-out
+}
+
+func TestEnvelopeAllocs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	in := make(chan int)
+	out := Unwrap(ctx, MapEnvelope(ctx, Wrap(ctx, in, "test"), func(v int) int { return v * 2 }))
+	if n := testing.AllocsPerRun(1000, func() { through(in, out, 21) }); n != 0 {
+		t.Errorf("Wrap, MapEnvelope and Unwrap allocate %v times per item, want 0", n)
+	}
+
+	e := Envelope[int]{Item: 1, Source: "test"}
+	n := testing.AllocsPerRun(1000, func() {
+		e = Rewrap(e.Attempt(), e.Item+1)
+		_ = e.Age()
+	})
+	if n != 0 {
+		t.Errorf("Rewrap, Attempt and Age allocate %v times, want 0", n)
+	}
+}
+
+func TestStatsAllocs(t *testing.T) {
+	s := NewStats(0.01)
+	// Fill the buckets first: only a new bucket allocates.
+	for i := 1; i <= 100; i++ {
+		s.Add(float64(i))
+	}
+	x := 1.0
+	n := testing.AllocsPerRun(1000, func() {
+		s.Add(x)
+		x = float64(int(x)%100 + 1)
+	})
+	if n != 0 {
+		t.Errorf("Stats.Add allocates %v times, want 0", n)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	in := make(chan int)
+	out := Measure(ctx, in, s, func(v int) float64 { return float64(v) })
+	if n := testing.AllocsPerRun(1000, func() { through(in, out, 50) }); n != 0 {
+		t.Errorf("Measure allocates %v times per item, want 0", n)
+	}
+
+	m := NewMetrics()
+	fn := InstrumentFunc(m, "double", func(_ context.Context, v int) (int, error) { return 2 * v, nil })
+	if n := testing.AllocsPerRun(1000, func() { fn(ctx, 1) }); n != 0 {
+		t.Errorf("InstrumentFunc allocates %v times per call, want 0", n)
+	}
+}
+
+func TestMergeAllocs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ins := make([]chan int, 4)
+	cs := make([]<-chan int, len(ins))
+	for i := range ins {
+		ins[i] = make(chan int)
+		cs[i] = ins[i]
+	}
+	out := Merge(ctx, cs...)
+	i := 0
+	n := testing.AllocsPerRun(1000, func() {
+		through(ins[i%len(ins)], out, i)
+		i++
+	})
+	if n != 0 {
+		t.Errorf("Merge allocates %v times per item, want 0", n)
+	}
+}
+
+func BenchmarkEnvelope(b *testing.B) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	in := make(chan int)
+	out := Unwrap(ctx, MapEnvelope(ctx, Wrap(ctx, in, "bench"), func(v int) int { return v * 2 }))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		through(in, out, i)
+	}
+}
+
+func BenchmarkStatsAdd(b *testing.B) {
+	s := NewStats(0.01)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		s.Add(float64(i%1000 + 1))
+	}
+}
+
+func BenchmarkInstrumentFunc(b *testing.B) {
+	fn := InstrumentFunc(NewMetrics(), "double", func(_ context.Context, v int) (int, error) { return 2 * v, nil })
+	ctx := context.Background()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		fn(ctx, i)
+	}
+}
@@ -0,0 +1,122 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Autoscale runs copies of worker over in, like FanOut, but adjusts their
+// number to the load between minWorkers and maxWorkers instead of fixing it up
+// front. It starts minWorkers copies and, every interval, starts one more if
+// values have been backing up, either in the buffer of in or waiting for a
+// copy to take them for more than half the interval, or stops one if every
+// value was taken without delay. A copy is stopped by closing its input, so it
+// finishes the values it has already received. Like FanOut, it does not
+// preserve the order of the values.
+//
+// Autoscale panics if interval is not positive.
+func Autoscale[In, Out any](ctx context.Context, in <-chan In, minWorkers, maxWorkers int, interval time.Duration, worker Stage[In, Out]) <-chan Out {
+	if interval <= 0 {
+		panic(fmt.Sprintf("pipeline: Autoscale interval must be positive, got %v", interval))
+	}
+	if minWorkers < 1 {
+		minWorkers = 1
+	}
+	if maxWorkers < minWorkers {
+		maxWorkers = minWorkers
+	}
+	m := NewMerger[Out](ctx)
+	// feed is shared by the copies, each of which reads from it through a
+	// goroutine that can be told to stop.
+	feed := make(chan In)
+	var stops []chan struct{}
+	grow := func() {
+		stop := make(chan struct{})
+		stops = append(stops, stop)
+		c := make(chan In)
+		go func() {
+			defer close(c)
+			for {
+				select {
+				case v, ok := <-feed:
+					if !ok {
+						return
+					}
+					select {
+					case c <- v:
+					case <-ctx.Done():
+						return
+					}
+				case <-stop:
+					return
+				}
+			}
+		}()
+		m.Add(worker(ctx, c))
+	}
+	shrink := func() {
+		last := len(stops) - 1
+		close(stops[last])
+		stops = stops[:last]
+	}
+	for i := 0; i < minWorkers; i++ {
+		grow()
+	}
+
+	go func() {
+		defer m.Close()
+		defer close(feed)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		// waited is the time values have spent waiting for a copy since
+		// the last tick.
+		var waited time.Duration
+		scale := func() {
+			n := len(stops)
+			switch {
+			case (len(in) > 0 || waited > interval/2) && n < maxWorkers:
+				grow()
+			case len(in) == 0 && waited < interval/100 && n > minWorkers:
+				shrink()
+			default:
+				waited = 0
+				return
+			}
+			waited = 0
+			log(ctx, slog.LevelDebug, "pipeline: scaled workers", "from", n, "to", len(stops))
+		}
+		for {
+			select {
+			case v, ok := <-in:
+				if !ok {
+					return
+				}
+				start := time.Now()
+			send:
+				for {
+					select {
+					case feed <- v:
+						break send
+					case <-ticker.C:
+						// Count the wait so far, so that
+						// more copies can be started to take
+						// this very value.
+						waited += time.Since(start)
+						start = time.Now()
+						scale()
+					case <-ctx.Done():
+						return
+					}
+				}
+				waited += time.Since(start)
+			case <-ticker.C:
+				scale()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return m.Out()
+}
@@ -0,0 +1,62 @@
+package pipeline
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAutoscale(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var live, peak atomic.Int64
+	worker := func(ctx context.Context, in <-chan int) <-chan int {
+		if n := live.Add(1); n > peak.Load() {
+			peak.Store(n)
+		}
+		out := make(chan int)
+		go func() {
+			defer close(out)
+			defer live.Add(-1)
+			for v := range in {
+				time.Sleep(2 * time.Millisecond)
+				select {
+				case out <- v:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		return out
+	}
+	in := make(chan int, 200)
+	for i := 0; i < 200; i++ {
+		in <- i
+	}
+	close(in)
+
+	seen := make(map[int]bool)
+	for v := range Autoscale(ctx, in, 1, 4, 5*time.Millisecond, worker) {
+		seen[v] = true
+	}
+	if ctx.Err() != nil {
+		t.Fatal("timed out")
+	}
+	if len(seen) != 200 {
+		t.Errorf("got %d distinct values, want 200", len(seen))
+	}
+	if p := peak.Load(); p < 2 || p > 4 {
+		t.Errorf("peaked at %d copies, want more than 1 and at most 4 under a backlog", p)
+	}
+	if n := live.Load(); n != 0 {
+		t.Errorf("%d copies still running", n)
+	}
+}
+
+func TestAutoscaleRejectsNonPositiveInterval(t *testing.T) {
+	worker := func(ctx context.Context, in <-chan int) <-chan int { return in }
+	mustPanic(t, "Autoscale with a zero interval", func() {
+		Autoscale(context.Background(), make(chan int), 1, 2, 0, worker)
+	})
+}
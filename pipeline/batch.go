@@ -0,0 +1,152 @@
+package pipeline
+
+import (
+	"context"
+	"time"
+)
+
+// BatchWithin groups items into batches while adding at most budget of latency
+// to any of them, in the spirit of Nagle's algorithm. The first item of a
+// batch starts the clock; once budget has elapsed, or once the batch holds max
+// items, the batch is handed downstream. While downstream is not yet ready to
+// take it, the batch keeps growing up to max, so a busy consumer receives
+// larger batches instead of a backlog of small ones. A max of zero leaves the
+// batch size unbounded.
+//
+// A partial batch is emitted when in is closed or, without waiting for the
+// budget, when a Flusher attached to the context is flushed. Batches are never
+// reused once sent.
+func BatchWithin[T any](ctx context.Context, in <-chan T, budget time.Duration, max int) <-chan []T {
+	out := make(chan []T)
+	flushes := watchFlushes(ctx)
+	go func() {
+		defer close(out)
+		var (
+			batch   []T
+			timer   *time.Timer
+			timeout <-chan time.Time
+			ready   bool
+		)
+		defer func() {
+			if timer != nil {
+				timer.Stop()
+			}
+		}()
+		for {
+			full := max > 0 && len(batch) >= max
+			recv := in
+			if full {
+				// Stop reading to push back on upstream until the batch
+				// has been handed over.
+				recv = nil
+			}
+			var send chan<- []T
+			if ready || full {
+				send = out
+			}
+			select {
+			case v, ok := <-recv:
+				if !ok {
+					if len(batch) > 0 {
+						select {
+						case out <- batch:
+						case <-ctx.Done():
+						}
+					}
+					return
+				}
+				batch = append(batch, v)
+				if len(batch) == 1 {
+					timer = time.NewTimer(budget)
+					timeout = timer.C
+				}
+			case send <- batch:
+				timer.Stop()
+				batch, timer, timeout, ready = nil, nil, nil, false
+			case <-timeout:
+				timeout, ready = nil, true
+			case <-flushes.requested():
+				flushes.done()
+				ready = len(batch) > 0
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Batch groups items into batches of maxSize, emitting a partial batch once
+// maxWait has passed since its first item was received. Unlike BatchWithin, a
+// batch never grows past the point it became due: Batch waits for downstream
+// to take it before reading further, so batches are predictable in size and
+// age at the cost of pushing back on upstream while downstream is busy.
+//
+// A partial batch is also emitted when in is closed, and when a Flusher
+// attached to the context is flushed.
+func Batch[T any](ctx context.Context, in <-chan T, maxSize int, maxWait time.Duration) <-chan []T {
+	if maxSize < 1 {
+		maxSize = 1
+	}
+	out := make(chan []T)
+	flushes := watchFlushes(ctx)
+	go func() {
+		defer close(out)
+		var (
+			batch   []T
+			timer   *time.Timer
+			timeout <-chan time.Time
+		)
+		// emit hands the batch over and starts a new one. It returns false
+		// if the context was cancelled first.
+		emit := func() bool {
+			if timer != nil {
+				timer.Stop()
+			}
+			b := batch
+			batch, timer, timeout = nil, nil, nil
+			select {
+			case out <- b:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		defer func() {
+			if timer != nil {
+				timer.Stop()
+			}
+		}()
+		for {
+			select {
+			case v, ok := <-in:
+				if !ok {
+					if len(batch) > 0 {
+						emit()
+					}
+					return
+				}
+				batch = append(batch, v)
+				if len(batch) == 1 {
+					timer = time.NewTimer(maxWait)
+					timeout = timer.C
+				}
+				if len(batch) == maxSize && !emit() {
+					return
+				}
+			case <-timeout:
+				if !emit() {
+					return
+				}
+			case <-flushes.requested():
+				flushes.done()
+				if len(batch) > 0 && !emit() {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
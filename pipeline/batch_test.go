@@ -0,0 +1,96 @@
+package pipeline
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBatchWithinBudget(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	in := make(chan int)
+	out := BatchWithin(ctx, in, 20*time.Millisecond, 0)
+	start := time.Now()
+	in <- 1
+	in <- 2
+	b := <-out
+	if d := time.Since(start); d < 20*time.Millisecond {
+		t.Errorf("batch handed over after %v, before the budget of 20ms", d)
+	}
+	if !reflect.DeepEqual(b, []int{1, 2}) {
+		t.Errorf("got %v, want [1 2]", b)
+	}
+	in <- 3
+	close(in)
+	if b := <-out; !reflect.DeepEqual(b, []int{3}) {
+		t.Errorf("got %v, want the partial batch [3] on close", b)
+	}
+}
+
+func TestBatchWithinGrowsWhileDownstreamBusy(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	in := make(chan int)
+	out := BatchWithin(ctx, in, time.Millisecond, 0)
+	in <- 1
+	// The batch is due, but nobody takes it, so it keeps taking items.
+	time.Sleep(10 * time.Millisecond)
+	in <- 2
+	in <- 3
+	if b := <-out; !reflect.DeepEqual(b, []int{1, 2, 3}) {
+		t.Errorf("got %v, want [1 2 3]", b)
+	}
+}
+
+func TestBatchWithinMax(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	in := make(chan int)
+	out := BatchWithin(ctx, in, time.Hour, 2)
+	in <- 1
+	in <- 2
+	// A full batch pushes back on upstream until it is handed over.
+	select {
+	case in <- 3:
+		t.Fatal("took an item past max")
+	case <-time.After(20 * time.Millisecond):
+	}
+	if b := <-out; !reflect.DeepEqual(b, []int{1, 2}) {
+		t.Errorf("got %v, want [1 2] without waiting for the budget", b)
+	}
+}
+
+func TestBatchSize(t *testing.T) {
+	ctx := context.Background()
+	out := Batch(ctx, Gen(ctx, 1, 2, 3, 4, 5), 2, time.Hour)
+	want := [][]int{{1, 2}, {3, 4}, {5}}
+	if got := collect(t, out); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestBatchMaxWait(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	in := make(chan int)
+	out := Batch(ctx, in, 10, 10*time.Millisecond)
+	in <- 1
+	// Once due, the batch stops growing and pushes back on upstream until
+	// it is handed over.
+	time.Sleep(30 * time.Millisecond)
+	select {
+	case in <- 2:
+		t.Fatal("batch took an item after it became due")
+	case <-time.After(20 * time.Millisecond):
+	}
+	if b := <-out; !reflect.DeepEqual(b, []int{1}) {
+		t.Errorf("got %v, want the partial batch [1]", b)
+	}
+	in <- 2
+	close(in)
+	if b := <-out; !reflect.DeepEqual(b, []int{2}) {
+		t.Errorf("got %v, want [2] on close", b)
+	}
+}
@@ -0,0 +1,109 @@
+package pipeline
+
+import "context"
+
+// A DeadLetter is an item that could not be processed, together with the
+// error that prevented it.
+type DeadLetter[T any] struct {
+	Item T
+	Err  error
+}
+
+// Bisect writes batch with write. If the write fails, the batch is split in
+// halves and each half is written on its own, recursively, until the items
+// that fail on their own have been isolated; those are returned as dead
+// letters while everything else is written. write must therefore leave
+// nothing behind when it fails, as a database transaction would. Bisect only
+// returns an error if the context is cancelled.
+func Bisect[T any](ctx context.Context, batch []T, write func(context.Context, []T) error) ([]DeadLetter[T], error) {
+	if len(batch) == 0 {
+		return nil, nil
+	}
+	err := write(ctx, batch)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err == nil {
+		return nil, nil
+	}
+	if len(batch) == 1 {
+		return []DeadLetter[T]{{batch[0], err}}, nil
+	}
+	half := len(batch) / 2
+	left, err := Bisect(ctx, batch[:half], write)
+	if err != nil {
+		return nil, err
+	}
+	right, err := Bisect(ctx, batch[half:], write)
+	if err != nil {
+		return nil, err
+	}
+	return append(left, right...), nil
+}
+
+// WriteBatches writes every batch received from in with write, using Bisect
+// to isolate the items that make a batch fail. Only those items are sent on
+// the returned dead-letter channel; the rest of their batch is written. The
+// dead-letter channel must be drained, and is closed once in is closed or the
+// context is cancelled.
+func WriteBatches[T any](ctx context.Context, in <-chan []T, write func(context.Context, []T) error) <-chan DeadLetter[T] {
+	out := make(chan DeadLetter[T])
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case batch, ok := <-in:
+				if !ok {
+					return
+				}
+				dead, err := Bisect(ctx, batch, write)
+				if err != nil {
+					return
+				}
+				for _, d := range dead {
+					select {
+					case out <- d:
+					case <-ctx.Done():
+						return
+					}
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// MapWithDeadLetters applies fn to every value received from in, like TryMap,
+// but keeps hold of the value fn failed on: results are sent on the first
+// channel, and values fn failed on are sent on the dead-letter channel along
+// with the error, so that they can be logged, persisted or reprocessed rather
+// than lost. Wrap fn with Retry to send only the values that failed every
+// attempt. Both channels are closed once in is closed or the context is
+// cancelled, and must be drained concurrently.
+func MapWithDeadLetters[In, Out any](ctx context.Context, in <-chan In, fn func(context.Context, In) (Out, error)) (<-chan Out, <-chan DeadLetter[In]) {
+	out := make(chan Out)
+	dead := make(chan DeadLetter[In])
+	go func() {
+		defer close(out)
+		defer close(dead)
+		for v := range in {
+			r, err := fn(ctx, v)
+			if err != nil {
+				select {
+				case dead <- DeadLetter[In]{v, err}:
+				case <-ctx.Done():
+					return
+				}
+				continue
+			}
+			select {
+			case out <- r:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, dead
+}
@@ -0,0 +1,99 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// rejecting returns a write function that fails any batch holding one of
+// poison, recording the batches it accepted in written.
+func rejecting(written *[][]int, poison ...int) func(context.Context, []int) error {
+	return func(_ context.Context, batch []int) error {
+		for _, v := range batch {
+			for _, p := range poison {
+				if v == p {
+					return fmt.Errorf("cannot write %d", p)
+				}
+			}
+		}
+		*written = append(*written, batch)
+		return nil
+	}
+}
+
+func TestBisect(t *testing.T) {
+	var written [][]int
+	dead, err := Bisect(context.Background(), []int{1, 2, 3, 4, 5, 6, 7, 8}, rejecting(&written, 3, 8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 2 || dead[0].Item != 3 || dead[1].Item != 8 {
+		t.Fatalf("got dead letters %v, want 3 and 8", dead)
+	}
+	if dead[0].Err.Error() != "cannot write 3" {
+		t.Errorf("got %v, want the error of the item on its own", dead[0].Err)
+	}
+	// Everything else was written, in the largest batches that succeed.
+	want := [][]int{{1, 2}, {4}, {5, 6}, {7}}
+	if !reflect.DeepEqual(written, want) {
+		t.Errorf("wrote %v, want %v", written, want)
+	}
+}
+
+func TestBisectCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	write := func(context.Context, []int) error {
+		calls++
+		cancel()
+		return errors.New("interrupted")
+	}
+	if _, err := Bisect(ctx, []int{1, 2, 3, 4}, write); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+	if calls != 1 {
+		t.Errorf("wrote %d times, want no split after cancelling", calls)
+	}
+}
+
+func TestWriteBatches(t *testing.T) {
+	ctx := context.Background()
+	var written [][]int
+	dead := WriteBatches(ctx, Gen(ctx, []int{1, 2}, []int{3, 4}), rejecting(&written, 4))
+	got := collect(t, dead)
+	if len(got) != 1 || got[0].Item != 4 {
+		t.Errorf("got dead letters %v, want only 4", got)
+	}
+	if want := [][]int{{1, 2}, {3}}; !reflect.DeepEqual(written, want) {
+		t.Errorf("wrote %v, want %v", written, want)
+	}
+}
+
+func TestMapWithDeadLetters(t *testing.T) {
+	ctx := context.Background()
+	atoi := func(_ context.Context, s string) (int, error) { return strconv.Atoi(s) }
+	out, dead := MapWithDeadLetters(ctx, Gen(ctx, "1", "x", "2", "y"), atoi)
+	letters := make(chan []DeadLetter[string])
+	go func() {
+		var ds []DeadLetter[string]
+		for d := range dead {
+			ds = append(ds, d)
+		}
+		letters <- ds
+	}()
+	if got := collect(t, out); !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Errorf("got %v, want [1 2]", got)
+	}
+	ds := <-letters
+	if len(ds) != 2 || ds[0].Item != "x" || ds[1].Item != "y" {
+		t.Fatalf("got dead letters %v, want x and y", ds)
+	}
+	var numErr *strconv.NumError
+	if !errors.As(ds[0].Err, &numErr) {
+		t.Errorf("got %v, want the parse error kept with the item", ds[0].Err)
+	}
+}
@@ -0,0 +1,169 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned for items short-circuited by an open
+// CircuitBreaker.
+var ErrCircuitOpen = errors.New("pipeline: circuit open")
+
+// BreakerState is the state of a CircuitBreaker.
+type BreakerState int
+
+// The states of a CircuitBreaker.
+const (
+	// BreakerClosed lets every item through.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen fails every item straight away.
+	BreakerOpen
+	// BreakerHalfOpen lets a single trial item through, to find out
+	// whether the failures are over.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// A CircuitBreaker stops a worker from calling a dependency that keeps
+// failing. It opens after a number of consecutive failures, failing every item
+// with ErrCircuitOpen without calling the worker, and half-opens after a
+// cooldown to let one trial item through: if it succeeds the breaker closes,
+// and if it fails the breaker opens again for another cooldown. A breaker can
+// be shared by the workers of several stages that call the same dependency.
+type CircuitBreaker struct {
+	failures int
+	cooldown time.Duration
+
+	mu          sync.Mutex
+	state       BreakerState
+	consecutive int
+	openedAt    time.Time
+	trial       bool // a trial item is in flight while half-open
+	// gen counts the changes of state. Items carry the generation they
+	// were let through in, so that the outcome of an item that outlived
+	// its state, such as a slow success arriving after the breaker has
+	// opened, is ignored rather than taken for news about the current one.
+	gen uint64
+}
+
+// NewCircuitBreaker returns a closed CircuitBreaker that opens after failures
+// consecutive failures and half-opens after cooldown.
+func NewCircuitBreaker(failures int, cooldown time.Duration) *CircuitBreaker {
+	if failures < 1 {
+		failures = 1
+	}
+	return &CircuitBreaker{failures: failures, cooldown: cooldown}
+}
+
+// State returns the current state of the breaker.
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.advance()
+	return b.state
+}
+
+// advance half-opens an open breaker whose cooldown has passed. It must be
+// called with mu held.
+func (b *CircuitBreaker) advance() {
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.cooldown {
+		b.set(BreakerHalfOpen)
+	}
+}
+
+// set moves the breaker to state, starting a new generation. It must be called
+// with mu held.
+func (b *CircuitBreaker) set(state BreakerState) {
+	b.state, b.trial = state, false
+	b.gen++
+}
+
+// allow reports whether an item may be let through, and the generation it is
+// let through in. A half-open breaker lets only its trial item through.
+func (b *CircuitBreaker) allow() (uint64, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.advance()
+	switch b.state {
+	case BreakerClosed:
+		return b.gen, true
+	case BreakerHalfOpen:
+		if b.trial {
+			return 0, false
+		}
+		b.trial = true
+		return b.gen, true
+	default:
+		return 0, false
+	}
+}
+
+// record accounts for the outcome of an item let through in generation gen.
+// Outcomes from earlier generations are ignored.
+func (b *CircuitBreaker) record(gen uint64, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if gen != b.gen {
+		return
+	}
+	if err == nil {
+		b.consecutive = 0
+		if b.state != BreakerClosed {
+			b.set(BreakerClosed)
+		}
+		return
+	}
+	b.consecutive++
+	if b.state == BreakerHalfOpen || b.consecutive >= b.failures {
+		b.set(BreakerOpen)
+		b.openedAt = time.Now()
+	}
+}
+
+// abandon gives up the trial let through in generation gen, if it still is
+// the one in flight, without judging it.
+func (b *CircuitBreaker) abandon(gen uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if gen == b.gen && b.state == BreakerHalfOpen {
+		b.trial = false
+	}
+}
+
+// WithBreaker wraps fn so that it is only called while b lets items through.
+// Items arriving while b is open fail with ErrCircuitOpen; used with TryMap,
+// they go down the error stream straight away instead of waiting on a
+// dependency that is down. Errors caused by the context being cancelled do
+// not count as failures.
+func WithBreaker[In, Out any](b *CircuitBreaker, fn func(context.Context, In) (Out, error)) func(context.Context, In) (Out, error) {
+	return func(ctx context.Context, v In) (Out, error) {
+		gen, ok := b.allow()
+		if !ok {
+			var zero Out
+			return zero, ErrCircuitOpen
+		}
+		out, err := fn(ctx, v)
+		if err != nil && ctx.Err() != nil {
+			// Not the dependency's fault; release a trial without
+			// judging it.
+			b.abandon(gen)
+			return out, err
+		}
+		b.record(gen, err)
+		return out, err
+	}
+}
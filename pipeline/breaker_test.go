@@ -0,0 +1,120 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var errDown = errors.New("down")
+
+// A call is an item of a work function that blocks until told how to end.
+type call struct {
+	started chan struct{}
+	ok      chan bool
+}
+
+// startCall calls fn in a new goroutine and returns once the call has been let
+// through by the breaker and has started. The returned function ends the
+// call, successfully or not, and waits for it to return.
+func startCall(t *testing.T, fn func(context.Context, call) (int, error)) (finish func(ok bool)) {
+	t.Helper()
+	c := call{make(chan struct{}), make(chan bool)}
+	done := make(chan error, 1)
+	go func() {
+		_, err := fn(context.Background(), c)
+		done <- err
+	}()
+	select {
+	case <-c.started:
+	case err := <-done:
+		t.Fatalf("call rejected: %v", err)
+	}
+	return func(ok bool) {
+		c.ok <- ok
+		<-done
+	}
+}
+
+func blocking(_ context.Context, c call) (int, error) {
+	close(c.started)
+	if !<-c.ok {
+		return 0, errDown
+	}
+	return 1, nil
+}
+
+func fails(context.Context, call) (int, error) { return 0, errDown }
+
+func TestCircuitBreaker(t *testing.T) {
+	b := NewCircuitBreaker(2, 20*time.Millisecond)
+	called := 0
+	fn := WithBreaker(b, func(_ context.Context, ok bool) (int, error) {
+		called++
+		if !ok {
+			return 0, errDown
+		}
+		return 1, nil
+	})
+	ctx := context.Background()
+
+	fn(ctx, false)
+	if s := b.State(); s != BreakerClosed {
+		t.Fatalf("%v after one failure, want closed", s)
+	}
+	fn(ctx, false)
+	if s := b.State(); s != BreakerOpen {
+		t.Fatalf("%v after two failures, want open", s)
+	}
+	if _, err := fn(ctx, true); !errors.Is(err, ErrCircuitOpen) || called != 2 {
+		t.Fatalf("got %v after %d calls, want %v without calling", err, called, ErrCircuitOpen)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if s := b.State(); s != BreakerHalfOpen {
+		t.Fatalf("%v after the cooldown, want half-open", s)
+	}
+	if _, err := fn(ctx, true); err != nil {
+		t.Fatal(err)
+	}
+	if s := b.State(); s != BreakerClosed {
+		t.Fatalf("%v after a successful trial, want closed", s)
+	}
+}
+
+func TestCircuitBreakerLateSuccess(t *testing.T) {
+	b := NewCircuitBreaker(1, time.Hour)
+	finish := startCall(t, WithBreaker(b, blocking))
+	WithBreaker(b, fails)(context.Background(), call{})
+	if s := b.State(); s != BreakerOpen {
+		t.Fatalf("%v after a failure, want open", s)
+	}
+	finish(true)
+	if s := b.State(); s != BreakerOpen {
+		t.Fatalf("%v after a success let through before opening, want open", s)
+	}
+}
+
+func TestCircuitBreakerLateFailure(t *testing.T) {
+	b := NewCircuitBreaker(1, 10*time.Millisecond)
+	fn := WithBreaker(b, blocking)
+	finishLate := startCall(t, fn)
+	WithBreaker(b, fails)(context.Background(), call{})
+	time.Sleep(20 * time.Millisecond)
+
+	finishTrial := startCall(t, fn)
+	if s := b.State(); s != BreakerHalfOpen {
+		t.Fatalf("%v with a trial in flight, want half-open", s)
+	}
+	finishLate(false)
+	if s := b.State(); s != BreakerHalfOpen {
+		t.Fatalf("%v after a failure let through before opening, want half-open", s)
+	}
+	if _, err := fn(context.Background(), call{}); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("got %v with the trial still in flight, want %v", err, ErrCircuitOpen)
+	}
+	finishTrial(true)
+	if s := b.State(); s != BreakerClosed {
+		t.Fatalf("%v after a successful trial, want closed", s)
+	}
+}
@@ -0,0 +1,36 @@
+package pipeline
+
+import "context"
+
+// Bridge flattens a stream of channels into a single stream of values,
+// draining each channel received from chans in turn before moving on to the
+// next. It suits generators that produce a channel of results per unit of
+// work, such as a channel of product links per category, when the results of
+// one unit should stay together. To interleave the channels instead, use a
+// Merger.
+func Bridge[T any](ctx context.Context, chans <-chan (<-chan T)) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for {
+			var c <-chan T
+			select {
+			case next, ok := <-chans:
+				if !ok {
+					return
+				}
+				c = next
+			case <-ctx.Done():
+				return
+			}
+			for v := range OrDone(ctx, c) {
+				select {
+				case out <- v:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
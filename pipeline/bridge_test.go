@@ -0,0 +1,41 @@
+package pipeline
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBridge(t *testing.T) {
+	ctx := context.Background()
+	chans := make(chan (<-chan int))
+	slow := make(chan int)
+	go func() {
+		defer close(chans)
+		chans <- slow
+		chans <- Gen(ctx, 3, 4)
+	}()
+	go func() {
+		defer close(slow)
+		slow <- 1
+		// The second channel is ready long before the first is drained,
+		// but its values still come after.
+		time.Sleep(10 * time.Millisecond)
+		slow <- 2
+	}()
+	if got := collect(t, Bridge(ctx, chans)); !reflect.DeepEqual(got, []int{1, 2, 3, 4}) {
+		t.Errorf("got %v, want the channels drained in turn", got)
+	}
+}
+
+func TestBridgeCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	chans := make(chan (<-chan int), 1)
+	// The inner channel is never closed.
+	inner := make(chan int)
+	chans <- inner
+	out := Bridge(ctx, chans)
+	cancel()
+	collect(t, out)
+}
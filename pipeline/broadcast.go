@@ -0,0 +1,142 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+)
+
+// A Broadcaster sends every value received from its input to each of its
+// subscribers. Consumers can subscribe and unsubscribe while the pipeline
+// runs; a value is delivered to the subscribers present when it is received.
+// Delivery waits for every subscriber in turn, so the slowest subscriber sets
+// the pace, cushioned by its buffer.
+type Broadcaster[T any] struct {
+	// wake nudges the broadcasting goroutine to close the channels of
+	// subscribers that have left.
+	wake chan struct{}
+
+	mu   sync.Mutex
+	subs map[<-chan T]*subscriber[T]
+	// snapshot lists the subscribers in subs for the broadcasting goroutine
+	// to iterate over without holding mu. It is rebuilt only when subs
+	// changes, so broadcasting a value does not allocate.
+	snapshot []*subscriber[T]
+	removed  []*subscriber[T]
+	done     bool
+}
+
+type subscriber[T any] struct {
+	c    chan T
+	gone chan struct{}
+}
+
+// NewBroadcaster returns a Broadcaster of the values received from in. The
+// channels of all subscribers are closed once in is closed or the context is
+// cancelled.
+func NewBroadcaster[T any](ctx context.Context, in <-chan T) *Broadcaster[T] {
+	b := &Broadcaster[T]{
+		wake: make(chan struct{}, 1),
+		subs: make(map[<-chan T]*subscriber[T]),
+	}
+	go b.run(ctx, in)
+	return b
+}
+
+func (b *Broadcaster[T]) run(ctx context.Context, in <-chan T) {
+	defer func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.done = true
+		for c, s := range b.subs {
+			delete(b.subs, c)
+			close(s.c)
+		}
+		b.closeRemoved()
+	}()
+	for {
+		select {
+		case v, ok := <-in:
+			if !ok {
+				return
+			}
+			if !b.send(ctx, v) {
+				return
+			}
+		case <-b.wake:
+		case <-ctx.Done():
+			return
+		}
+		b.mu.Lock()
+		b.closeRemoved()
+		b.mu.Unlock()
+	}
+}
+
+// send delivers v to every current subscriber. It reports false if the
+// context was cancelled in the meantime.
+func (b *Broadcaster[T]) send(ctx context.Context, v T) bool {
+	b.mu.Lock()
+	if b.snapshot == nil {
+		b.snapshot = make([]*subscriber[T], 0, len(b.subs))
+		for _, s := range b.subs {
+			b.snapshot = append(b.snapshot, s)
+		}
+	}
+	subs := b.snapshot
+	b.mu.Unlock()
+	for _, s := range subs {
+		select {
+		case s.c <- v:
+		case <-s.gone:
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
+
+// closeRemoved closes the channels of the subscribers that have left. Only
+// the broadcasting goroutine sends on those channels, so only it may close
+// them. b.mu must be held.
+func (b *Broadcaster[T]) closeRemoved() {
+	for _, s := range b.removed {
+		close(s.c)
+	}
+	b.removed = nil
+}
+
+// Subscribe returns a new channel, with the given buffer size, that receives
+// every value broadcast from now on. Subscribing after the input has been
+// closed returns a closed channel.
+func (b *Broadcaster[T]) Subscribe(buffer int) <-chan T {
+	s := &subscriber[T]{c: make(chan T, buffer), gone: make(chan struct{})}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.done {
+		close(s.c)
+	} else {
+		b.subs[s.c] = s
+		b.snapshot = nil
+	}
+	return s.c
+}
+
+// Unsubscribe detaches the subscriber receiving on c. Its channel is closed
+// shortly afterwards; values still buffered in it can be drained or dropped.
+// The remaining subscribers are not affected.
+func (b *Broadcaster[T]) Unsubscribe(c <-chan T) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s, ok := b.subs[c]
+	if !ok {
+		return
+	}
+	delete(b.subs, c)
+	b.snapshot = nil
+	close(s.gone)
+	b.removed = append(b.removed, s)
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,102 @@
+package pipeline
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBroadcaster(t *testing.T) {
+	ctx := context.Background()
+	in := make(chan int)
+	b := NewBroadcaster(ctx, in)
+	first, second := b.Subscribe(0), b.Subscribe(0)
+
+	results := make(chan []int, 2)
+	for _, c := range []<-chan int{first, second} {
+		go func(c <-chan int) {
+			var vs []int
+			for v := range c {
+				vs = append(vs, v)
+			}
+			results <- vs
+		}(c)
+	}
+	for i := 1; i <= 3; i++ {
+		in <- i
+	}
+	close(in)
+	for i := 0; i < 2; i++ {
+		if got := <-results; !reflect.DeepEqual(got, []int{1, 2, 3}) {
+			t.Errorf("subscriber got %v, want [1 2 3]", got)
+		}
+	}
+
+	if _, ok := <-b.Subscribe(1); ok {
+		t.Error("subscribing after the input closed returned an open channel")
+	}
+}
+
+func TestBroadcasterUnsubscribe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	in := make(chan int)
+	b := NewBroadcaster(ctx, in)
+	stalled, active := b.Subscribe(0), b.Subscribe(1)
+
+	// The stalled subscriber never reads, and holds up the broadcast, so
+	// that the second value is not taken, until it leaves.
+	sent := make(chan struct{})
+	go func() {
+		in <- 1
+		in <- 2
+		close(sent)
+	}()
+	select {
+	case <-sent:
+		t.Fatal("broadcast did not wait for the stalled subscriber")
+	case <-time.After(20 * time.Millisecond):
+	}
+	b.Unsubscribe(stalled)
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatal("broadcast still waiting for a subscriber that left")
+	}
+	select {
+	case _, ok := <-stalled:
+		if ok {
+			t.Error("got a value after unsubscribing")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("channel of a subscriber that left not closed")
+	}
+
+	for want := 1; want <= 2; want++ {
+		if v := <-active; v != want {
+			t.Errorf("remaining subscriber got %d, want %d", v, want)
+		}
+	}
+}
+
+func TestBroadcasterLateSubscriber(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	in := make(chan int)
+	b := NewBroadcaster(ctx, in)
+	early := b.Subscribe(1)
+	in <- 1
+	<-early
+	// A subscriber only receives the values broadcast after it joined.
+	late := b.Subscribe(1)
+	in <- 2
+	if v := <-late; v != 2 {
+		t.Errorf("late subscriber got %d, want 2", v)
+	}
+	cancel()
+	for range late {
+	}
+	for range early {
+	}
+}
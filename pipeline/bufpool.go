@@ -0,0 +1,113 @@
+package pipeline
+
+import (
+	"math/bits"
+	"sync"
+	"sync/atomic"
+)
+
+// Buffer sizes are rounded up to a power of two between 1<<minClassShift and
+// 1<<maxClassShift bytes. Larger buffers are allocated individually and not
+// recycled.
+const (
+	minClassShift = 9
+	maxClassShift = 24
+	// slabSize is the amount of memory carved into buffers at once for the
+	// size classes smaller than it.
+	slabSize = 1 << 20
+)
+
+// A BufferPool hands out byte buffers for item payloads and takes them back
+// once the items have been consumed, cutting the garbage a byte-heavy
+// streaming job generates. Buffers of the smaller size classes are carved
+// out of large slabs, so that allocating them is rare and cheap as well.
+//
+// Using a BufferPool is opt-in and safe by default: a buffer that is never
+// released is simply collected by the garbage collector, and releasing a
+// buffer twice panics instead of letting two items share memory.
+type BufferPool struct {
+	classes [maxClassShift - minClassShift + 1]sizeClass
+}
+
+type sizeClass struct {
+	// pool holds the memory of released buffers. The Buffers themselves
+	// are not recycled, so that releasing a stale one twice cannot free the
+	// memory of whoever got it next.
+	pool sync.Pool
+
+	mu   sync.Mutex
+	size int
+	slab []byte
+}
+
+// A Buffer is a byte buffer obtained from a BufferPool.
+type Buffer struct {
+	// B holds the payload. Its capacity may exceed the requested size.
+	B []byte
+
+	mem      *bufferMem
+	class    *sizeClass
+	released atomic.Bool
+}
+
+// bufferMem is the memory of a pooled buffer, held by pointer so that putting
+// it back into the pool does not allocate.
+type bufferMem struct {
+	b []byte
+}
+
+// NewBufferPool returns an empty BufferPool.
+func NewBufferPool() *BufferPool {
+	p := &BufferPool{}
+	for i := range p.classes {
+		c := &p.classes[i]
+		c.size = 1 << (minClassShift + i)
+		c.pool.New = func() any { return c.carve() }
+	}
+	return p
+}
+
+// carve returns new buffer memory taken from the current slab of c, starting a
+// new slab when the current one is used up.
+func (c *sizeClass) carve() any {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.slab) < c.size {
+		c.slab = make([]byte, max(c.size, slabSize))
+	}
+	// The three-index slice stops appends to one buffer from spilling into
+	// its neighbour.
+	b := c.slab[:c.size:c.size]
+	c.slab = c.slab[c.size:]
+	return &bufferMem{b: b}
+}
+
+// Get returns a buffer whose B field has length n. The contents of the buffer
+// are not zeroed. The buffer should be released with Release once the item it
+// carries has been consumed, typically at the sink.
+func (p *BufferPool) Get(n int) *Buffer {
+	shift := minClassShift
+	if n > 1<<minClassShift {
+		shift = bits.Len(uint(n - 1))
+	}
+	if shift > maxClassShift {
+		return &Buffer{B: make([]byte, n)}
+	}
+	c := &p.classes[shift-minClassShift]
+	mem := c.pool.Get().(*bufferMem)
+	return &Buffer{B: mem.b[:n], mem: mem, class: c}
+}
+
+// Release returns b to its pool. b must not be used afterwards; its B field is
+// cleared to make accidental use fail loudly. Release panics if b has already
+// been released.
+func (b *Buffer) Release() {
+	if b.released.Swap(true) {
+		panic("pipeline: Buffer released twice")
+	}
+	b.B = nil
+	if b.mem != nil {
+		b.class.pool.Put(b.mem)
+		b.mem = nil
+	}
+}
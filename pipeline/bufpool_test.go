@@ -0,0 +1,64 @@
+package pipeline
+
+import "testing"
+
+func TestBufferPoolGet(t *testing.T) {
+	p := NewBufferPool()
+	for _, tt := range []struct {
+		n, cap int
+	}{
+		{0, 512},
+		{100, 512},
+		{513, 1024},
+		{1 << 20, 1 << 20},
+		{1<<24 + 1, 1<<24 + 1},
+	} {
+		b := p.Get(tt.n)
+		if len(b.B) != tt.n || cap(b.B) != tt.cap {
+			t.Errorf("Get(%d): len %d, cap %d, want len %d, cap %d", tt.n, len(b.B), cap(b.B), tt.n, tt.cap)
+		}
+		b.Release()
+		if b.B != nil {
+			t.Errorf("Get(%d): B not cleared by Release", tt.n)
+		}
+	}
+}
+
+func TestBufferPoolNoSharing(t *testing.T) {
+	p := NewBufferPool()
+	a, b := p.Get(100), p.Get(100)
+	a.B = append(a.B[:0], make([]byte, 512)...)
+	for i := range a.B {
+		a.B[i] = 1
+	}
+	for _, v := range b.B[:cap(b.B)] {
+		if v == 1 {
+			t.Fatal("writing to one buffer changed another")
+		}
+	}
+}
+
+func TestBufferStaleRelease(t *testing.T) {
+	p := NewBufferPool()
+	stale := p.Get(100)
+	stale.Release()
+	// fresh most likely gets the memory stale had.
+	fresh := p.Get(100)
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("releasing a buffer twice did not panic")
+			}
+		}()
+		stale.Release()
+	}()
+	if fresh.B == nil {
+		t.Fatal("releasing a stale buffer cleared the one handed out since")
+	}
+	// The memory of fresh must not have gone back to the pool.
+	other := p.Get(100)
+	fresh.B[0], other.B[0] = 1, 2
+	if fresh.B[0] != 1 {
+		t.Error("a stale release let the memory of a live buffer be handed out again")
+	}
+}
@@ -0,0 +1,362 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/pprof"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// A Builder assembles a pipeline whose values are of type T stage by stage,
+// as an alternative to nesting stage calls:
+//
+//	out, wait := From(gen).Then(parse).FanOut(4).Then(fetch).Merge().Run(ctx)
+//	for v := range out {
+//		...
+//	}
+//	if err := wait(); err != nil {
+//		...
+//	}
+//
+// Nothing is started until Run is called. Every method returns a new Builder
+// and leaves the one it was called on unchanged, so a partly built pipeline
+// can be shared between several others. Stages added with Then keep the type
+// of the values; use the stage functions directly to change it.
+type Builder[T any] struct {
+	// build starts the pipeline built so far and returns its outputs, one
+	// per branch of a fan-out.
+	build func(ctx context.Context, r *run) []<-chan T
+	// fanOut is the number of copies the next stage is started in.
+	fanOut int
+	// nodes describe the stages added so far, for DOT.
+	nodes []node
+}
+
+// A node describes a stage of a Builder.
+type node struct {
+	name string
+	// copies is the number of copies the stage is started in per branch,
+	// and branches the number of branches it is continued on.
+	copies, branches int
+	// buffer is the capacity of the stage's output channel, if known.
+	buffer int
+}
+
+// add returns a Builder built by build, with n added to the description of
+// the stages.
+func (b *Builder[T]) add(build func(ctx context.Context, r *run) []<-chan T, n node) *Builder[T] {
+	nodes := make([]node, len(b.nodes), len(b.nodes)+1)
+	copy(nodes, b.nodes)
+	return &Builder[T]{build: build, fanOut: 1, nodes: append(nodes, n)}
+}
+
+// branches returns the number of channels the pipeline built so far ends in.
+func (b *Builder[T]) branches() int {
+	if len(b.nodes) == 0 {
+		return 1
+	}
+	return b.nodes[len(b.nodes)-1].branches
+}
+
+// run is the state shared by the stages of a running pipeline.
+type run struct {
+	cancel context.CancelFunc
+	// wg tracks the goroutines that watch for errors, so that none is
+	// missed once the output has been closed.
+	wg sync.WaitGroup
+
+	mu  sync.Mutex
+	err error
+
+	// emitted, delivered and dropped count the values that left the source,
+	// came out of the pipeline and were dropped by Filter, for Collect.
+	// total is the number of values the source holds, or -1 if unknown.
+	emitted, delivered, dropped atomic.Int64
+	total                       int64
+
+	// names are the names of the stages of the Builder being run, as set
+	// by As, indexed like its nodes.
+	names []string
+}
+
+// fail records the first error of the pipeline and cancels it.
+func (r *run) fail(err error) {
+	r.mu.Lock()
+	if r.err == nil {
+		r.err = err
+	}
+	r.mu.Unlock()
+	r.cancel()
+}
+
+// From starts a Builder whose values come from src.
+func From[T any](src func(ctx context.Context) <-chan T) *Builder[T] {
+	return (&Builder[T]{}).add(func(ctx context.Context, r *run) []<-chan T {
+		return []<-chan T{countEmitted(ctx, r, src(ctx))}
+	}, node{name: "source", copies: 1, branches: 1})
+}
+
+// FromValues starts a Builder whose values are vs, as emitted by Gen.
+func FromValues[T any](vs ...T) *Builder[T] {
+	return (&Builder[T]{}).add(func(ctx context.Context, r *run) []<-chan T {
+		r.total = int64(len(vs))
+		return []<-chan T{countEmitted(ctx, r, Gen(ctx, vs...))}
+	}, node{name: "source", copies: 1, branches: 1})
+}
+
+// FromErr starts a Builder whose values come from a source that reports its
+// result on an error channel, such as FromStdin. An error from the source
+// fails the pipeline.
+func FromErr[T any](src func(ctx context.Context) (<-chan T, <-chan error)) *Builder[T] {
+	return (&Builder[T]{}).add(func(ctx context.Context, r *run) []<-chan T {
+		out, errc := src(ctx)
+		out = countEmitted(ctx, r, out)
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			if err := <-errc; err != nil && ctx.Err() == nil {
+				r.fail(err)
+			}
+		}()
+		return []<-chan T{out}
+	}, node{name: "source", copies: 1, branches: 1})
+}
+
+// Then adds stage to the pipeline. After FanOut, the stage is started as many
+// times as requested, all reading from the same channel.
+func (b *Builder[T]) Then(stage Stage[T, T]) *Builder[T] {
+	return b.then("stage", func(ctx context.Context, r *run, in <-chan T) <-chan T {
+		return stage(ctx, in)
+	})
+}
+
+// Try adds a stage that applies fn to every value. The first error fn
+// returns fails the pipeline; wrap fn with Retry or WithTimeout to handle
+// errors item by item first. After FanOut, the stage is started in as many
+// copies as requested.
+func (b *Builder[T]) Try(fn func(context.Context, T) (T, error)) *Builder[T] {
+	return b.then("try", func(ctx context.Context, r *run, in <-chan T) <-chan T {
+		vals, errs := Split(ctx, TryMap(ctx, in, fn))
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			for err := range errs {
+				r.fail(err)
+			}
+		}()
+		return vals
+	})
+}
+
+// Filter adds a stage that drops the values for which keep returns false.
+func (b *Builder[T]) Filter(keep func(T) bool) *Builder[T] {
+	return b.then("filter", func(ctx context.Context, r *run, in <-chan T) <-chan T {
+		return Filter(ctx, in, func(v T) bool {
+			if !keep(v) {
+				r.dropped.Add(1)
+				return false
+			}
+			return true
+		})
+	})
+}
+
+// Buffer adds a buffer of n values on every branch, which lets the stages
+// before it run ahead of the ones after it.
+func (b *Builder[T]) Buffer(n int) *Builder[T] {
+	nb := b.then("buffer", func(ctx context.Context, r *run, in <-chan T) <-chan T {
+		out := make(chan T, n)
+		go func() {
+			defer close(out)
+			for v := range in {
+				select {
+				case out <- v:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		return out
+	})
+	nb.nodes[len(nb.nodes)-1].buffer = n
+	return nb
+}
+
+// As names the last stage added, for DOT and for the pprof label its
+// goroutines carry.
+func (b *Builder[T]) As(name string) *Builder[T] {
+	nb := *b
+	nb.nodes = append([]node(nil), b.nodes...)
+	if len(nb.nodes) > 0 {
+		nb.nodes[len(nb.nodes)-1].name = name
+	}
+	return &nb
+}
+
+// then adds a stage that has access to the state of the running pipeline. The
+// goroutines of the stage carry the pprof label "stage" set to its name, as
+// with Labeled.
+func (b *Builder[T]) then(name string, stage func(ctx context.Context, r *run, in <-chan T) <-chan T) *Builder[T] {
+	prev, n, index := b.build, b.fanOut, len(b.nodes)
+	return b.add(func(ctx context.Context, r *run) []<-chan T {
+		ins := prev(ctx, r)
+		// The stage may have been renamed with As after it was added.
+		label := name
+		if index < len(r.names) {
+			label = r.names[index]
+		}
+		var outs []<-chan T
+		pprof.Do(ctx, pprof.Labels("stage", label), func(ctx context.Context) {
+			for _, c := range ins {
+				for i := 0; i < n; i++ {
+					outs = append(outs, stage(ctx, r, c))
+				}
+			}
+		})
+		return outs
+	}, node{name: name, copies: n, branches: b.branches() * n})
+}
+
+// FanOut makes the next stage start in n copies that share its input between
+// them. The copies stay separate branches, each continued by the stages that
+// follow, until Merge joins them.
+func (b *Builder[T]) FanOut(n int) *Builder[T] {
+	if n < 1 {
+		n = 1
+	}
+	return &Builder[T]{build: b.build, fanOut: n, nodes: b.nodes}
+}
+
+// Merge joins the branches of a fan-out into a single channel.
+func (b *Builder[T]) Merge() *Builder[T] {
+	if b.branches() == 1 {
+		return &Builder[T]{build: b.build, fanOut: 1, nodes: b.nodes}
+	}
+	prev := b.build
+	return b.add(func(ctx context.Context, r *run) []<-chan T {
+		return []<-chan T{Merge(ctx, prev(ctx, r)...)}
+	}, node{name: "merge", copies: 1, branches: 1})
+}
+
+// Run starts the pipeline and returns its output, merged if it has several
+// branches, along with a function that waits for the output to be closed and
+// returns the first error of the pipeline, or the context's error if the
+// context was cancelled. The output must be drained, or the context
+// cancelled, for the wait function to return. The pipeline is cancelled along
+// with ctx, and as soon as any stage fails.
+func (b *Builder[T]) Run(ctx context.Context) (<-chan T, func() error) {
+	out, wait, _ := b.start(ctx)
+	return out, wait
+}
+
+// start starts the pipeline like Run, also returning the state of the run.
+func (b *Builder[T]) start(ctx context.Context) (<-chan T, func() error, *run) {
+	log(ctx, slog.LevelDebug, "pipeline: started", "stages", len(b.nodes))
+	names := make([]string, len(b.nodes))
+	for i, n := range b.nodes {
+		names[i] = n.name
+	}
+	return start(ctx, func(ctx context.Context, r *run) <-chan T {
+		r.names = names
+		return b.Merge().build(ctx, r)[0]
+	})
+}
+
+// start starts the pipeline built by build under a context of its own,
+// cancelled as soon as any stage fails, and returns its output and the
+// function waiting for it to finish, as documented on Builder.Run, along with
+// the state of the run.
+func start[T any](ctx context.Context, build func(ctx context.Context, r *run) <-chan T) (<-chan T, func() error, *run) {
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	r := &run{cancel: cancel, total: -1}
+	in := build(ctx, r)
+
+	out := make(chan T)
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		defer close(out)
+		for v := range in {
+			select {
+			case out <- v:
+				r.delivered.Add(1)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, func() error {
+		<-finished
+		cancel()
+		r.wg.Wait()
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		switch {
+		case r.err != nil:
+			log(parent, slog.LevelError, "pipeline: failed", "err", r.err)
+			return r.err
+		case parent.Err() != nil:
+			log(parent, slog.LevelInfo, "pipeline: cancelled", "err", parent.Err())
+			return parent.Err()
+		}
+		log(parent, slog.LevelDebug, "pipeline: finished")
+		return nil
+	}, r
+}
+
+// countEmitted passes on the values received from in, counting them in r as
+// emitted by the source.
+func countEmitted[T any](ctx context.Context, r *run, in <-chan T) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for v := range in {
+			select {
+			case out <- v:
+				r.emitted.Add(1)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// DOT returns a description of the pipeline in the Graphviz DOT language,
+// showing its stages in order, the number of copies each is started in and
+// the capacity of its buffers. Render it with, for example,
+// "dot -Tsvg pipeline.dot > pipeline.svg".
+func (b *Builder[T]) DOT() string {
+	var sb strings.Builder
+	sb.WriteString("digraph pipeline {\n\trankdir=LR;\n\tnode [shape=box];\n")
+	for i, n := range b.nodes {
+		label := n.name
+		if n.copies > 1 {
+			label += fmt.Sprintf("\n× %d", n.copies)
+		}
+		if n.branches > 1 && n.branches != n.copies {
+			label += fmt.Sprintf("\n%d branches", n.branches)
+		}
+		if n.buffer > 0 {
+			label += fmt.Sprintf("\ncapacity %d", n.buffer)
+		}
+		attrs := ""
+		if n.copies > 1 || n.branches > 1 {
+			attrs = ", peripheries=2"
+		}
+		fmt.Fprintf(&sb, "\tn%d [label=%q%s];\n", i, label, attrs)
+	}
+	for i := 1; i < len(b.nodes); i++ {
+		if c := b.nodes[i].copies; c > 1 {
+			fmt.Fprintf(&sb, "\tn%d -> n%d [label=\"fan out %d\"];\n", i-1, i, c)
+		} else {
+			fmt.Fprintf(&sb, "\tn%d -> n%d;\n", i-1, i)
+		}
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
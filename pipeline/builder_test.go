@@ -0,0 +1,116 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// runAll runs b to completion and returns its values and error.
+func runAll[T any](t *testing.T, ctx context.Context, b *Builder[T]) ([]T, error) {
+	t.Helper()
+	out, wait := b.Run(ctx)
+	vs := collect(t, out)
+	return vs, wait()
+}
+
+func TestBuilder(t *testing.T) {
+	double := func(ctx context.Context, in <-chan int) <-chan int {
+		return Map(ctx, in, func(v int) int { return 2 * v })
+	}
+	inc := func(_ context.Context, v int) (int, error) { return v + 1, nil }
+	b := FromValues(1, 2, 3, 4, 5, 6).Then(double).FanOut(3).Try(inc).Merge()
+	vs, err := runAll(t, context.Background(), b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sortedInts(vs); !reflect.DeepEqual(got, []int{3, 5, 7, 9, 11, 13}) {
+		t.Errorf("got %v, want every value doubled and incremented once", got)
+	}
+}
+
+func TestBuilderTryFails(t *testing.T) {
+	boom := errors.New("boom")
+	fail3 := func(_ context.Context, v int) (int, error) {
+		if v == 3 {
+			return 0, boom
+		}
+		return v, nil
+	}
+	// The source never ends, so only the failure stops the pipeline.
+	src := func(ctx context.Context) <-chan int { return count(ctx, make(chan struct{})) }
+	vs, err := runAll(t, context.Background(), From(src).Try(fail3))
+	if err != boom {
+		t.Errorf("got %v, want the stage's error", err)
+	}
+	// The output was closed, so the failure cancelled the pipeline; the
+	// failed value itself never came out.
+	for _, v := range vs {
+		if v == 3 {
+			t.Errorf("got %v, want 3 dropped", vs)
+		}
+	}
+}
+
+func TestBuilderCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	src := func(ctx context.Context) <-chan int { return count(ctx, make(chan struct{})) }
+	out, wait := From(src).Run(ctx)
+	<-out
+	cancel()
+	collect(t, out)
+	if err := wait(); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+}
+
+func TestBuilderIsImmutable(t *testing.T) {
+	ctx := context.Background()
+	shared := FromValues(1, 2, 3, 4)
+	evens := shared.Filter(func(v int) bool { return v%2 == 0 })
+	if vs, _ := runAll(t, ctx, evens); !reflect.DeepEqual(vs, []int{2, 4}) {
+		t.Errorf("got %v, want [2 4]", vs)
+	}
+	// Adding the filter left the shared Builder as it was, and it can be
+	// run again.
+	for i := 0; i < 2; i++ {
+		if vs, _ := runAll(t, ctx, shared); !reflect.DeepEqual(vs, []int{1, 2, 3, 4}) {
+			t.Errorf("run %d: got %v, want [1 2 3 4]", i, vs)
+		}
+	}
+}
+
+func TestBuilderDOT(t *testing.T) {
+	pass := func(ctx context.Context, in <-chan int) <-chan int { return in }
+	b := FromValues(1).FanOut(3).Then(pass).As("fetch").Buffer(4)
+	want := `digraph pipeline {
+	rankdir=LR;
+	node [shape=box];
+	n0 [label="source"];
+	n1 [label="fetch\n× 3", peripheries=2];
+	n2 [label="buffer\n3 branches\ncapacity 4", peripheries=2];
+	n3 [label="merge"];
+	n0 -> n1 [label="fan out 3"];
+	n1 -> n2;
+	n2 -> n3;
+}
+`
+	if got := b.Merge().DOT(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	// Without Merge, the branches are left separate.
+	if got := b.DOT(); strings.Contains(got, "merge") {
+		t.Errorf("unmerged pipeline has a merge stage:\n%s", got)
+	}
+	// Naming a stage leaves the Builder it was called on as it was.
+	if got := FromValues(1).Then(pass).DOT(); !strings.Contains(got, `n1 [label="stage"];`) {
+		t.Errorf("unnamed stage not labelled stage:\n%s", got)
+	}
+	unnamed := FromValues(1).Then(pass)
+	unnamed.As("parse")
+	if got := unnamed.DOT(); strings.Contains(got, "parse") {
+		t.Errorf("As changed the Builder it was called on:\n%s", got)
+	}
+}
@@ -0,0 +1,106 @@
+package pipeline
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// OverflowPolicy decides what a Bulkhead does with a branch whose buffer is
+// full.
+type OverflowPolicy int
+
+const (
+	// DropItems skips the item for the branch whose buffer is full; the
+	// branch gets the items that follow once it has caught up.
+	DropItems OverflowPolicy = iota
+	// IsolateBranch closes the output of the branch whose buffer is full
+	// and stops feeding it, leaving it to fail on its own.
+	IsolateBranch
+)
+
+// A Bulkhead copies a stream onto several branches, like Broadcast, but gives
+// every branch a bounded buffer of its own, so that a branch that stalls
+// cannot hold up the others: once its buffer is full, its items are dropped
+// or it is cut off, as decided by the OverflowPolicy, while the other
+// branches keep flowing.
+type Bulkhead[T any] struct {
+	outs     []chan T
+	dropped  []atomic.Int64
+	isolated []atomic.Bool
+}
+
+// NewBulkhead starts copying the values received from in onto n branches with
+// buffers of the given size. The outputs of the branches are closed once in
+// is closed or the context is cancelled.
+func NewBulkhead[T any](ctx context.Context, in <-chan T, n, buffer int, policy OverflowPolicy) *Bulkhead[T] {
+	b := &Bulkhead[T]{
+		outs:     make([]chan T, n),
+		dropped:  make([]atomic.Int64, n),
+		isolated: make([]atomic.Bool, n),
+	}
+	for i := range b.outs {
+		b.outs[i] = make(chan T, buffer)
+	}
+	go func() {
+		defer func() {
+			for i, out := range b.outs {
+				if !b.isolated[i].Load() {
+					close(out)
+				}
+			}
+		}()
+		for {
+			var v T
+			select {
+			case w, ok := <-in:
+				if !ok {
+					return
+				}
+				v = w
+			case <-ctx.Done():
+				return
+			}
+			for i, out := range b.outs {
+				if b.isolated[i].Load() {
+					continue
+				}
+				select {
+				case out <- v:
+				default:
+					b.dropped[i].Add(1)
+					if policy == IsolateBranch {
+						b.isolated[i].Store(true)
+						close(out)
+					}
+				}
+			}
+		}
+	}()
+	return b
+}
+
+// Branch returns the output of the i-th branch.
+func (b *Bulkhead[T]) Branch(i int) <-chan T {
+	return b.outs[i]
+}
+
+// Branches returns the outputs of all the branches.
+func (b *Bulkhead[T]) Branches() []<-chan T {
+	outs := make([]<-chan T, len(b.outs))
+	for i, out := range b.outs {
+		outs[i] = out
+	}
+	return outs
+}
+
+// Dropped returns the number of items the i-th branch missed because its
+// buffer was full.
+func (b *Bulkhead[T]) Dropped(i int) int64 {
+	return b.dropped[i].Load()
+}
+
+// Isolated reports whether the i-th branch has been cut off under the
+// IsolateBranch policy.
+func (b *Bulkhead[T]) Isolated(i int) bool {
+	return b.isolated[i].Load()
+}
@@ -0,0 +1,67 @@
+package pipeline
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+// feedBulkhead sends vs to b through in, taking every item from the first
+// branch before sending the next, so that the first branch keeps up while the
+// others are not read.
+func feedBulkhead(t *testing.T, b *Bulkhead[int], in chan<- int, vs ...int) {
+	t.Helper()
+	for _, v := range vs {
+		in <- v
+		if got := <-b.Branch(0); got != v {
+			t.Fatalf("branch that keeps up got %d, want %d", got, v)
+		}
+	}
+	close(in)
+	if got := collect(t, b.Branch(0)); len(got) != 0 {
+		t.Errorf("branch that keeps up got %v more", got)
+	}
+}
+
+func TestBulkheadDropItems(t *testing.T) {
+	in := make(chan int)
+	b := NewBulkhead(context.Background(), in, 2, 2, DropItems)
+	feedBulkhead(t, b, in, 1, 2, 3, 4)
+	if got := collect(t, b.Branch(1)); !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Errorf("slow branch got %v, want what fit in its buffer", got)
+	}
+	if b.Dropped(0) != 0 || b.Dropped(1) != 2 || b.Isolated(1) {
+		t.Errorf("dropped %d and %d, isolated %v; want 0 and 2, not isolated", b.Dropped(0), b.Dropped(1), b.Isolated(1))
+	}
+}
+
+func TestBulkheadDropItemsCatchesUp(t *testing.T) {
+	in := make(chan int)
+	b := NewBulkhead(context.Background(), in, 2, 1, DropItems)
+	slow := b.Branches()[1]
+	in <- 1
+	<-b.Branch(0)
+	in <- 2
+	<-b.Branch(0)
+	// The slow branch missed 2, and gets the items after it once it reads.
+	if v := <-slow; v != 1 {
+		t.Fatalf("got %d, want 1", v)
+	}
+	feedBulkhead(t, b, in, 3)
+	if got := collect(t, slow); !reflect.DeepEqual(got, []int{3}) {
+		t.Errorf("got %v, want [3] after catching up", got)
+	}
+}
+
+func TestBulkheadIsolateBranch(t *testing.T) {
+	in := make(chan int)
+	b := NewBulkhead(context.Background(), in, 2, 1, IsolateBranch)
+	feedBulkhead(t, b, in, 1, 2, 3, 4)
+	// The slow branch was cut off on the first item that did not fit.
+	if got := collect(t, b.Branch(1)); !reflect.DeepEqual(got, []int{1}) {
+		t.Errorf("isolated branch got %v, want only [1]", got)
+	}
+	if !b.Isolated(1) || b.Isolated(0) || b.Dropped(1) != 1 {
+		t.Errorf("isolated %v and %v, dropped %d; want only the slow branch isolated after 1 drop", b.Isolated(0), b.Isolated(1), b.Dropped(1))
+	}
+}
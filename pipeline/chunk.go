@@ -0,0 +1,56 @@
+package pipeline
+
+import "context"
+
+// Chunk groups the values received from in into slices of n, in order, for
+// stages that work on batches. The last slice holds whatever is left when in
+// is closed and may be shorter. Unlike Batch, Chunk waits as long as it takes
+// for a slice to fill up. Slices are never reused once sent.
+func Chunk[T any](ctx context.Context, in <-chan T, n int) <-chan []T {
+	if n < 1 {
+		n = 1
+	}
+	out := make(chan []T)
+	go func() {
+		defer close(out)
+		chunk := make([]T, 0, n)
+		for v := range in {
+			chunk = append(chunk, v)
+			if len(chunk) < n {
+				continue
+			}
+			select {
+			case out <- chunk:
+			case <-ctx.Done():
+				return
+			}
+			chunk = make([]T, 0, n)
+		}
+		if len(chunk) > 0 {
+			select {
+			case out <- chunk:
+			case <-ctx.Done():
+			}
+		}
+	}()
+	return out
+}
+
+// Flatten sends on every value of the slices received from in, one at a time
+// and in order, undoing Chunk or Batch for the stages that follow.
+func Flatten[T any](ctx context.Context, in <-chan []T) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for vs := range in {
+			for _, v := range vs {
+				select {
+				case out <- v:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
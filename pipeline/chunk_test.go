@@ -0,0 +1,45 @@
+package pipeline
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestChunk(t *testing.T) {
+	ctx := context.Background()
+	got := collect(t, Chunk(ctx, Gen(ctx, 1, 2, 3, 4, 5), 2))
+	if want := [][]int{{1, 2}, {3, 4}, {5}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// Slices are not reused once sent.
+	got[0][0] = 9
+	if got[1][0] != 3 {
+		t.Errorf("chunks share memory: %v", got)
+	}
+	if got := collect(t, Chunk(ctx, Gen(ctx, 1, 2), 0)); !reflect.DeepEqual(got, [][]int{{1}, {2}}) {
+		t.Errorf("got %v, want chunks of one for n < 1", got)
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	ctx := context.Background()
+	got := collect(t, Flatten(ctx, Chunk(ctx, Gen(ctx, 1, 2, 3, 4, 5), 2)))
+	if !reflect.DeepEqual(got, []int{1, 2, 3, 4, 5}) {
+		t.Errorf("got %v, want the values as they went into Chunk", got)
+	}
+	if got := collect(t, Flatten(ctx, Gen(ctx, []int{}, nil, []int{6}))); !reflect.DeepEqual(got, []int{6}) {
+		t.Errorf("got %v, want empty slices skipped", got)
+	}
+}
+
+func TestFlattenCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan []int, 1)
+	in <- []int{1, 2, 3}
+	out := Flatten(ctx, in)
+	<-out
+	cancel()
+	close(in)
+	collect(t, out)
+}
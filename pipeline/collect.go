@@ -0,0 +1,43 @@
+package pipeline
+
+import "context"
+
+// A Summary tells how far a pipeline run got.
+type Summary struct {
+	// Done is the number of values that came out of the pipeline.
+	Done int64
+	// InFlight is the number of values that had left the source but were
+	// still being worked on or queued between stages when the run ended.
+	InFlight int64
+	// Dropped is the number of values removed by Filter.
+	Dropped int64
+	// Pending is the number of values the source had yet to emit. Only
+	// FromValues knows how many values it holds; for other sources Pending
+	// is -1.
+	Pending int64
+}
+
+// Collect runs the pipeline and gathers its output. If the run fails, or ctx
+// is cancelled or reaches its deadline, Collect still returns the values
+// collected so far along with the error, so that callers can make use of
+// partial results; the Summary tells how much of the input they cover.
+func (b *Builder[T]) Collect(ctx context.Context) ([]T, Summary, error) {
+	out, wait, r := b.start(ctx)
+	var vs []T
+	for v := range out {
+		vs = append(vs, v)
+	}
+	err := wait()
+
+	s := Summary{
+		Done:    r.delivered.Load(),
+		Dropped: r.dropped.Load(),
+		Pending: -1,
+	}
+	emitted := r.emitted.Load()
+	s.InFlight = emitted - s.Done - s.Dropped
+	if r.total >= 0 {
+		s.Pending = r.total - emitted
+	}
+	return vs, s, err
+}
@@ -0,0 +1,60 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCollect(t *testing.T) {
+	vs, s, err := FromValues(1, 2, 3, 4, 5, 6).
+		Filter(func(v int) bool { return v%2 == 0 }).
+		Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{2, 4, 6}; !reflect.DeepEqual(vs, want) {
+		t.Errorf("got %v, want %v", vs, want)
+	}
+	if want := (Summary{Done: 3, Dropped: 3}); s != want {
+		t.Errorf("got %+v, want %+v", s, want)
+	}
+}
+
+func TestCollectDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	// The stage passes 1 and 2 on and gets stuck on 3.
+	stuck := func(ctx context.Context, v int) (int, error) {
+		if v == 3 {
+			<-ctx.Done()
+			return 0, ctx.Err()
+		}
+		return v, nil
+	}
+	vs, s, err := FromValues(1, 2, 3, 4, 5, 6, 7, 8).Try(stuck).Collect(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	if want := []int{1, 2}; !reflect.DeepEqual(vs, want) {
+		t.Errorf("got %v, want %v", vs, want)
+	}
+	if s.Done != 2 || s.Dropped != 0 {
+		t.Errorf("got %+v, want 2 done and none dropped", s)
+	}
+	if s.InFlight < 1 || s.Pending < 1 || s.Done+s.InFlight+s.Pending != 8 {
+		t.Errorf("got %+v, want the 6 values not done both in flight and pending", s)
+	}
+}
+
+func TestCollectUnknownSource(t *testing.T) {
+	_, s, err := From(func(ctx context.Context) <-chan int { return Gen(ctx, 1, 2) }).Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Summary{Done: 2, Pending: -1}); s != want {
+		t.Errorf("got %+v, want %+v", s, want)
+	}
+}
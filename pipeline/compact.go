@@ -0,0 +1,85 @@
+package pipeline
+
+import (
+	"context"
+	"time"
+)
+
+// Compact collapses items that share the same key so that only the latest of
+// them is sent downstream. Items are held in a buffer until window has elapsed
+// since the first of them arrived or until the buffer holds size distinct keys,
+// whichever happens first. The buffer is then emitted in the order in which
+// each key was first seen. A zero window disables the time limit and a zero
+// size disables the size limit. Whatever is still buffered when in is closed
+// is emitted before the output channel is closed, and the buffer can be
+// emitted early through a Flusher attached to the context.
+func Compact[T any, K comparable](ctx context.Context, in <-chan T, key func(T) K, window time.Duration, size int) <-chan T {
+	out := make(chan T)
+	flushes := watchFlushes(ctx)
+	go func() {
+		defer close(out)
+		var (
+			items   []T
+			index   = make(map[K]int)
+			timer   *time.Timer
+			timeout <-chan time.Time
+		)
+		// flush sends the buffered items downstream and resets the buffer. It
+		// reports false if the context was cancelled in the meantime.
+		flush := func() bool {
+			if timer != nil {
+				timer.Stop()
+				timer, timeout = nil, nil
+			}
+			for _, v := range items {
+				select {
+				case out <- v:
+				case <-ctx.Done():
+					return false
+				}
+			}
+			// Reuse the buffer and the index rather than allocating new
+			// ones for every window.
+			clear(items)
+			items = items[:0]
+			clear(index)
+			return true
+		}
+		for {
+			select {
+			case <-flushes.requested():
+				flushes.done()
+				if !flush() {
+					return
+				}
+			case v, ok := <-in:
+				if !ok {
+					flush()
+					return
+				}
+				k := key(v)
+				if i, ok := index[k]; ok {
+					items[i] = v
+					continue
+				}
+				index[k] = len(items)
+				items = append(items, v)
+				if len(items) == 1 && window > 0 {
+					timer = time.NewTimer(window)
+					timeout = timer.C
+				}
+				if size > 0 && len(items) >= size && !flush() {
+					return
+				}
+			case <-timeout:
+				timer, timeout = nil, nil
+				if !flush() {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,75 @@
+package pipeline
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type update struct {
+	key   string
+	value int
+}
+
+func updateKey(u update) string { return u.key }
+
+func TestCompact(t *testing.T) {
+	ctx := context.Background()
+	in := Gen(ctx, update{"a", 1}, update{"b", 1}, update{"a", 2}, update{"c", 1}, update{"b", 2}, update{"a", 3})
+	got := collect(t, Compact(ctx, in, updateKey, 0, 0))
+	// The latest update of every key, in the order the keys were first
+	// seen.
+	want := []update{{"a", 3}, {"b", 2}, {"c", 1}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestCompactSize(t *testing.T) {
+	ctx := context.Background()
+	in := Gen(ctx, update{"a", 1}, update{"a", 2}, update{"b", 1}, update{"b", 2}, update{"c", 1})
+	got := collect(t, Compact(ctx, in, updateKey, 0, 2))
+	// The buffer is emitted as soon as it holds two keys, so the second
+	// update of b starts a buffer of its own.
+	want := []update{{"a", 2}, {"b", 1}, {"b", 2}, {"c", 1}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestCompactWindow(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	in := make(chan update)
+	out := Compact(ctx, in, updateKey, 20*time.Millisecond, 0)
+	start := time.Now()
+	in <- update{"a", 1}
+	in <- update{"a", 2}
+	select {
+	case u := <-out:
+		if u != (update{"a", 2}) {
+			t.Errorf("got %v, want {a 2}", u)
+		}
+		if d := time.Since(start); d < 20*time.Millisecond {
+			t.Errorf("emitted after %v, before the window of 20ms", d)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("buffer not emitted once the window elapsed")
+	}
+
+	// A new window starts with the next update.
+	in <- update{"a", 3}
+	select {
+	case u := <-out:
+		if u != (update{"a", 3}) {
+			t.Errorf("got %v, want {a 3}", u)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("second buffer not emitted")
+	}
+	close(in)
+	if _, ok := <-out; ok {
+		t.Error("output not closed after the input")
+	}
+}
@@ -0,0 +1,140 @@
+// Package config builds pipelines from a declarative description of their
+// stages, so that operators can retune the worker counts, buffers and rates of
+// a pipeline by editing a file rather than recompiling it.
+//
+// A description lists the stages in order, naming for each the Go function
+// doing its work, as given to Build or registered with pipeline.Register:
+//
+//	stages:
+//	  - name: parse
+//	    func: parse-product
+//	  - name: fetch
+//	    workers: 8
+//	    buffer: 32
+//	    rate: 50
+//	    burst: 10
+//
+// The same description can be given as JSON, with the same field names.
+package config
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+
+	"concurrency/pipeline"
+)
+
+// Config describes a pipeline as a chain of stages.
+type Config struct {
+	Stages []Stage `yaml:"stages" json:"stages"`
+}
+
+// Stage describes a stage of a pipeline.
+type Stage struct {
+	// Name names the stage, in DOT output and errors.
+	Name string `yaml:"name" json:"name"`
+	// Func is the name of the function doing the work of the stage.
+	// Empty means the same as Name.
+	Func string `yaml:"func" json:"func"`
+	// Workers is the number of copies of the stage that work on items
+	// in parallel. Zero means 1.
+	Workers int `yaml:"workers" json:"workers"`
+	// Buffer is the number of results of the stage that can wait for the
+	// next one.
+	Buffer int `yaml:"buffer" json:"buffer"`
+	// Rate, if positive, caps the number of items the stage starts on per
+	// second, across all its workers, allowing bursts of up to Burst.
+	Rate  float64 `yaml:"rate" json:"rate"`
+	Burst int     `yaml:"burst" json:"burst"`
+}
+
+// Parse parses a description of a pipeline in YAML or JSON. Fields it does
+// not know are reported as errors, to catch typos.
+func Parse(data []byte) (*Config, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	var c Config
+	if err := dec.Decode(&c); err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Load reads and parses the description of a pipeline in the named file.
+func Load(name string) (*Config, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	return Parse(data)
+}
+
+func (c *Config) validate() error {
+	if len(c.Stages) == 0 {
+		return errors.New("config: no stages")
+	}
+	for i, s := range c.Stages {
+		switch {
+		case s.Name == "":
+			return fmt.Errorf("config: stage %d has no name", i+1)
+		case s.Workers < 0:
+			return fmt.Errorf("config: stage %q: negative workers", s.Name)
+		case s.Buffer < 0:
+			return fmt.Errorf("config: stage %q: negative buffer", s.Name)
+		case s.Rate < 0:
+			return fmt.Errorf("config: stage %q: negative rate", s.Name)
+		}
+	}
+	return nil
+}
+
+// Build adds the stages described by c to b, looking up the function of every
+// stage by name in funcs, which may be nil, and then among those registered
+// with pipeline.Register. It fails if one of them is missing.
+//
+//	c, err := config.Load("pipeline.yaml")
+//	...
+//	b, err := config.Build(c, pipeline.From(source), map[string]func(context.Context, Product) (Product, error){
+//		"parse-product": parse,
+//		"fetch":         fetch,
+//	})
+//	...
+//	out, wait := b.Run(ctx)
+func Build[T any](c *Config, b *pipeline.Builder[T], funcs map[string]func(context.Context, T) (T, error)) (*pipeline.Builder[T], error) {
+	for _, s := range c.Stages {
+		name := s.Func
+		if name == "" {
+			name = s.Name
+		}
+		fn, ok := funcs[name]
+		if !ok {
+			var err error
+			if fn, err = pipeline.Lookup[T, T](name); err != nil {
+				return nil, fmt.Errorf("config: stage %q: %w", s.Name, err)
+			}
+		}
+		if s.Rate > 0 {
+			bucket := pipeline.NewTokenBucket(s.Rate, s.Burst)
+			b = b.Then(func(ctx context.Context, in <-chan T) <-chan T {
+				return pipeline.RateLimit(ctx, in, bucket)
+			}).As(s.Name + " rate")
+		}
+		workers := s.Workers
+		if workers < 1 {
+			workers = 1
+		}
+		b = b.FanOut(workers).Try(fn).As(s.Name).Merge()
+		if s.Buffer > 0 {
+			b = b.Buffer(s.Buffer).As(s.Name + " buffer")
+		}
+	}
+	return b, nil
+}
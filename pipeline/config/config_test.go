@@ -0,0 +1,164 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"concurrency/pipeline"
+)
+
+const description = `
+stages:
+  - name: parse
+    func: parse-number
+  - name: square
+    workers: 4
+    buffer: 8
+    rate: 1000
+    burst: 10
+`
+
+func TestParse(t *testing.T) {
+	want := &Config{Stages: []Stage{
+		{Name: "parse", Func: "parse-number"},
+		{Name: "square", Workers: 4, Buffer: 8, Rate: 1000, Burst: 10},
+	}}
+	c, err := Parse([]byte(description))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("got %+v, want %+v", c, want)
+	}
+
+	// JSON is YAML too, with the same field names.
+	c, err = Parse([]byte(`{"stages": [{"name": "parse", "func": "parse-number"},
+		{"name": "square", "workers": 4, "buffer": 8, "rate": 1000, "burst": 10}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("JSON: got %+v, want %+v", c, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, tt := range []struct {
+		data, err string
+	}{
+		{"stages: []", "config: no stages"},
+		{"stages:\n  - workers: 2", "config: stage 1 has no name"},
+		{"stages:\n  - name: a\n    workers: -1", `config: stage "a": negative workers`},
+		{"stages:\n  - name: a\n    buffer: -1", `config: stage "a": negative buffer`},
+		{"stages:\n  - name: a\n    rate: -1", `config: stage "a": negative rate`},
+		// A typo is caught rather than ignored.
+		{"stages:\n  - name: a\n    worker: 2", "field worker not found"},
+	} {
+		if _, err := Parse([]byte(tt.data)); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("Parse(%q): got %v, want %q", tt.data, err, tt.err)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "pipeline.yaml")
+	if err := os.WriteFile(name, []byte(description), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := Load(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Stages) != 2 {
+		t.Errorf("got %+v, want two stages", c)
+	}
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("loaded a missing file")
+	}
+}
+
+func TestBuild(t *testing.T) {
+	c, err := Parse([]byte(description))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Build(c, pipeline.FromValues(1, 2, 3), map[string]func(context.Context, int) (int, error){
+		"parse-number": func(_ context.Context, v int) (int, error) { return v + 1, nil },
+		"square":       func(_ context.Context, v int) (int, error) { return v * v, nil },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, wait := b.Run(context.Background())
+	var got []int
+	for v := range out {
+		got = append(got, v)
+	}
+	if err := wait(); err != nil {
+		t.Fatal(err)
+	}
+	sort.Ints(got)
+	if !reflect.DeepEqual(got, []int{4, 9, 16}) {
+		t.Errorf("got %v, want [4 9 16]", got)
+	}
+	want := `digraph pipeline {
+	rankdir=LR;
+	node [shape=box];
+	n0 [label="source"];
+	n1 [label="parse"];
+	n2 [label="square rate"];
+	n3 [label="square\n× 4", peripheries=2];
+	n4 [label="merge"];
+	n5 [label="square buffer\ncapacity 8"];
+	n0 -> n1;
+	n1 -> n2;
+	n2 -> n3 [label="fan out 4"];
+	n3 -> n4;
+	n4 -> n5;
+}
+`
+	if dot := b.DOT(); dot != want {
+		t.Errorf("got DOT\n%s\nwant\n%s", dot, want)
+	}
+}
+
+func TestBuildMissingFunc(t *testing.T) {
+	c := &Config{Stages: []Stage{{Name: "nowhere"}}}
+	_, err := Build(c, pipeline.FromValues(1), nil)
+	if err == nil || !strings.HasPrefix(err.Error(), `config: stage "nowhere": `) {
+		t.Errorf("got %v, want the stage without a function reported", err)
+	}
+}
+
+// registerNegate registers config-test-negate, once however many times the
+// tests are run.
+var registerNegate sync.Once
+
+func TestBuildRegistered(t *testing.T) {
+	registerNegate.Do(func() {
+		pipeline.Register("config-test-negate", func(_ context.Context, v int) (int, error) { return -v, nil })
+	})
+	c, err := Parse([]byte("stages:\n  - name: negate\n    func: config-test-negate\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Build(c, pipeline.FromValues(1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, wait := b.Run(context.Background())
+	if v := <-out; v != -1 {
+		t.Errorf("got %d, want -1 from the registered function", v)
+	}
+	for range out {
+	}
+	if err := wait(); err != nil {
+		t.Fatal(err)
+	}
+}
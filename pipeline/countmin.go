@@ -0,0 +1,100 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sync/atomic"
+)
+
+// A CountMin counts how often keys occur in a stream in a fixed amount of
+// memory, however many distinct keys there are, at the cost of the counts
+// being approximate: a count is never under the true one, and is over it by
+// at most a small fraction of the total of all counts, with high
+// probability. That is accurate enough to find the hot keys of a stream with
+// too many keys to count exactly. It is safe for concurrent use.
+type CountMin struct {
+	width uint64
+	rows  [][]atomic.Uint64
+	total atomic.Uint64
+}
+
+// NewCountMin returns an empty CountMin whose counts are over the true ones
+// by at most epsilon times the total count, with a probability of 1-delta.
+// Its memory is proportional to 1/epsilon times log(1/delta): 0.001 and 0.01
+// take about 100KB. NewCountMin panics if epsilon or delta is not positive,
+// as no amount of memory would do.
+func NewCountMin(epsilon, delta float64) *CountMin {
+	if !(epsilon > 0) || !(delta > 0) {
+		panic(fmt.Sprintf("pipeline: NewCountMin epsilon and delta must be positive, got %v and %v", epsilon, delta))
+	}
+	width := uint64(math.Ceil(math.E / epsilon))
+	depth := int(math.Ceil(math.Log(1 / delta)))
+	if width < 1 {
+		width = 1
+	}
+	if depth < 1 {
+		depth = 1
+	}
+	rows := make([][]atomic.Uint64, depth)
+	for i := range rows {
+		rows[i] = make([]atomic.Uint64, width)
+	}
+	return &CountMin{width: width, rows: rows}
+}
+
+// cells calls fn with the cell of key in every row.
+func (c *CountMin) cells(key string, fn func(*atomic.Uint64)) {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	// Derive a hash per row from the two halves of a single one.
+	h1, h2 := sum&math.MaxUint32, sum>>32|1
+	for i, row := range c.rows {
+		fn(&row[(h1+uint64(i)*h2)%c.width])
+	}
+}
+
+// Add adds n occurrences of key.
+func (c *CountMin) Add(key string, n uint64) {
+	c.total.Add(n)
+	c.cells(key, func(cell *atomic.Uint64) { cell.Add(n) })
+}
+
+// Count returns the estimated number of occurrences of key.
+func (c *CountMin) Count(key string) uint64 {
+	least := uint64(math.MaxUint64)
+	c.cells(key, func(cell *atomic.Uint64) {
+		if n := cell.Load(); n < least {
+			least = n
+		}
+	})
+	return least
+}
+
+// Total returns the number of occurrences of all keys added.
+func (c *CountMin) Total() uint64 {
+	return c.total.Load()
+}
+
+// CountKeys passes on the values received from in unchanged, counting their
+// keys in c, which can be queried for the hot keys while the stream flows:
+//
+//	counts := NewCountMin(0.001, 0.01)
+//	out := CountKeys(ctx, in, counts, func(r Request) string { return r.Host })
+func CountKeys[T any](ctx context.Context, in <-chan T, c *CountMin, key func(T) string) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for v := range in {
+			c.Add(key(v), 1)
+			select {
+			case out <- v:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,67 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestCountMin(t *testing.T) {
+	c := NewCountMin(0.001, 0.01)
+	// A few hot keys among many cold ones.
+	for i := 0; i < 10000; i++ {
+		c.Add(fmt.Sprintf("cold-%d", i), 1)
+	}
+	for i := 0; i < 5; i++ {
+		c.Add(fmt.Sprintf("hot-%d", i), uint64(1000*(i+1)))
+	}
+	if got, want := c.Total(), uint64(10000+15000); got != want {
+		t.Errorf("total %d, want %d", got, want)
+	}
+	slack := uint64(math.Ceil(0.001 * float64(c.Total())))
+	for i := 0; i < 5; i++ {
+		want := uint64(1000 * (i + 1))
+		if got := c.Count(fmt.Sprintf("hot-%d", i)); got < want || got > want+slack {
+			t.Errorf("hot-%d: got %d, want %d to %d", i, got, want, want+slack)
+		}
+	}
+	over := 0
+	for i := 0; i < 10000; i++ {
+		got := c.Count(fmt.Sprintf("cold-%d", i))
+		if got < 1 {
+			t.Fatalf("cold-%d: got %d, under the true count", i, got)
+		}
+		if got > 1+slack {
+			over++
+		}
+	}
+	// The bound holds with a probability of 99%.
+	if over > 200 {
+		t.Errorf("%d of 10000 cold keys overcounted by more than %d", over, slack)
+	}
+	if got := c.Count("absent"); got > slack {
+		t.Errorf("absent key: got %d, want at most %d", got, slack)
+	}
+}
+
+func TestCountKeys(t *testing.T) {
+	ctx := context.Background()
+	c := NewCountMin(0.01, 0.01)
+	var got []string
+	for v := range CountKeys(ctx, Gen(ctx, "a", "b", "a", "a"), c, func(s string) string { return s }) {
+		got = append(got, v)
+	}
+	if len(got) != 4 {
+		t.Errorf("got %v, want the 4 values passed on", got)
+	}
+	if c.Count("a") != 3 || c.Count("b") != 1 {
+		t.Errorf("got counts a=%d b=%d, want 3 and 1", c.Count("a"), c.Count("b"))
+	}
+}
+
+func TestNewCountMinRejectsNonPositive(t *testing.T) {
+	mustPanic(t, "NewCountMin(0, 0.01)", func() { NewCountMin(0, 0.01) })
+	mustPanic(t, "NewCountMin(0.01, -1)", func() { NewCountMin(0.01, -1) })
+	mustPanic(t, "NewCountMin(NaN, 0.01)", func() { NewCountMin(math.NaN(), 0.01) })
+}
@@ -0,0 +1,103 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"concurrency/pipeline"
+)
+
+// Admin is an http.Handler controlling a pipeline through its
+// pipeline.Shutdown, for operators and tools such as cmd/pipetop. A POST with
+// "?action=pause" holds the input of the pipeline back, "?action=resume" lets
+// it carry on and "?action=drain" starts draining it. Every request, GET
+// included, gets the state of the pipeline as JSON.
+//
+// Admin changes the running pipeline, so mount it only where operators can
+// reach it, typically next to the dashboard:
+//
+//	http.Handle("/debug/pipeline", dashboard.New(metrics))
+//	http.Handle("/debug/pipeline/admin", dashboard.NewAdmin(shutdown))
+type Admin struct {
+	shutdown *pipeline.Shutdown
+	// DrainTimeout is the time given to a drain before the pipeline is
+	// cancelled. Zero means 30 seconds.
+	DrainTimeout time.Duration
+
+	mu       sync.Mutex
+	draining bool
+	drained  bool
+	drainErr error
+}
+
+// NewAdmin returns an Admin for the pipeline stopped by s.
+func NewAdmin(s *pipeline.Shutdown) *Admin {
+	return &Admin{shutdown: s}
+}
+
+// adminState is the state of the pipeline reported by Admin.
+type adminState struct {
+	Paused   bool   `json:"paused"`
+	Draining bool   `json:"draining"`
+	Drained  bool   `json:"drained"`
+	Error    string `json:"drain_error,omitempty"`
+}
+
+// ServeHTTP implements http.Handler.
+func (a *Admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPost:
+		switch action := r.URL.Query().Get("action"); action {
+		case "pause":
+			a.shutdown.Pause()
+		case "resume":
+			a.shutdown.Resume()
+		case "drain":
+			a.drain()
+		default:
+			http.Error(w, "unknown action "+action, http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	a.mu.Lock()
+	state := adminState{
+		Paused:   a.shutdown.Paused(),
+		Draining: a.draining,
+		Drained:  a.drained,
+	}
+	if a.drainErr != nil {
+		state.Error = a.drainErr.Error()
+	}
+	a.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state)
+}
+
+// drain starts draining the pipeline, unless it already is. The request
+// does not wait for the drain, which takes as long as the items in flight
+// do.
+func (a *Admin) drain() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.draining || a.drained {
+		return
+	}
+	a.draining = true
+	timeout := a.DrainTimeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	go func() {
+		err := a.shutdown.Drain(timeout)
+		a.mu.Lock()
+		a.draining, a.drained, a.drainErr = false, true, err
+		a.mu.Unlock()
+	}()
+}
@@ -0,0 +1,85 @@
+package dashboard
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"concurrency/pipeline"
+)
+
+// do sends a request to a and returns its status and the state it reported.
+func do(t *testing.T, a *Admin, method, target string) (int, adminState) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	a.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+	var state adminState
+	if rec.Code == 200 {
+		if err := json.NewDecoder(rec.Body).Decode(&state); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec.Code, state
+}
+
+func TestAdmin(t *testing.T) {
+	s, ctx := pipeline.NewShutdown(context.Background())
+	in := make(chan int)
+	out := pipeline.Output(s, pipeline.Input(s, in))
+	a := NewAdmin(s)
+
+	if code, state := do(t, a, "POST", "/?action=pause"); code != 200 || !state.Paused {
+		t.Fatalf("got %d %+v, want paused", code, state)
+	}
+	if code, state := do(t, a, "GET", "/"); code != 200 || !state.Paused {
+		t.Fatalf("got %d %+v, want paused", code, state)
+	}
+	if code, state := do(t, a, "POST", "/?action=resume"); code != 200 || state.Paused {
+		t.Fatalf("got %d %+v, want running", code, state)
+	}
+	go func() {
+		select {
+		case in <- 1:
+		case <-ctx.Done():
+		}
+	}()
+	if v := <-out; v != 1 {
+		t.Fatalf("got %d, want 1", v)
+	}
+
+	if code, _ := do(t, a, "POST", "/?action=drain"); code != 200 {
+		t.Fatalf("got %d, want 200", code)
+	}
+	for range out {
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		_, state := do(t, a, "GET", "/")
+		if state.Drained {
+			if state.Draining || state.Error != "" {
+				t.Errorf("got %+v, want a clean drain", state)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %+v, want drained", state)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAdminBadRequests(t *testing.T) {
+	s, _ := pipeline.NewShutdown(context.Background())
+	defer s.Cancel()
+	a := NewAdmin(s)
+	if code, _ := do(t, a, "POST", "/?action=explode"); code != 400 {
+		t.Errorf("got %d for an unknown action, want 400", code)
+	}
+	rec := httptest.NewRecorder()
+	a.ServeHTTP(rec, httptest.NewRequest("DELETE", "/", nil))
+	if rec.Code != 405 || rec.Header().Get("Allow") == "" {
+		t.Errorf("got %d allowing %q, want 405", rec.Code, rec.Header().Get("Allow"))
+	}
+}
@@ -0,0 +1,131 @@
+// Package dashboard serves a web page showing the state of a running
+// pipeline, for finding out where a pipeline that appears hung is stuck.
+package dashboard
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"time"
+
+	"concurrency/pipeline"
+)
+
+// Handler is an http.Handler rendering the counters of a pipeline.Metrics:
+// the items every stage has processed, the items backed up in it, how busy
+// its workers are and its latest errors. The page refreshes itself every few
+// seconds. Requests with "?format=json" get the same data as JSON, for
+// scripts.
+type Handler struct {
+	metrics *pipeline.Metrics
+	// Refresh is how often the page reloads itself. Zero means 5 seconds.
+	Refresh time.Duration
+}
+
+// New returns a Handler for m. Mount it wherever suits the program, such as
+// under /debug/pipeline next to the pprof handlers.
+func New(m *pipeline.Metrics) *Handler {
+	return &Handler{metrics: m}
+}
+
+// stage is the view of a stage on the page.
+type stage struct {
+	Name        string        `json:"name"`
+	In          int64         `json:"in"`
+	Out         int64         `json:"out"`
+	Errors      int64         `json:"errors"`
+	Backlog     int64         `json:"backlog"`
+	Active      int64         `json:"active_workers"`
+	AverageBusy float64       `json:"average_busy_workers"`
+	MaxLatency  time.Duration `json:"max_latency_ns"`
+	Recent      []stageError  `json:"recent_errors"`
+}
+
+type stageError struct {
+	Time  time.Time `json:"time"`
+	Error string    `json:"error"`
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	uptime := time.Since(h.metrics.Started)
+	var stages []stage
+	for _, s := range h.metrics.Metrics() {
+		v := stage{
+			Name:       s.Name,
+			In:         s.In,
+			Out:        s.Out,
+			Errors:     s.Errors,
+			Backlog:    s.Backlog(),
+			Active:     s.Active,
+			MaxLatency: s.MaxLatency,
+		}
+		if uptime > 0 {
+			// The time spent on items over the time elapsed is the
+			// average number of workers that were busy.
+			v.AverageBusy = float64(s.Busy) / float64(uptime)
+		}
+		// Newest first.
+		for i := len(s.RecentErrors) - 1; i >= 0; i-- {
+			e := s.RecentErrors[i]
+			v.Recent = append(v.Recent, stageError{e.Time, e.Err.Error()})
+		}
+		stages = append(stages, v)
+	}
+
+	if r.URL.Query().Get("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Uptime time.Duration `json:"uptime_ns"`
+			Stages []stage       `json:"stages"`
+		}{uptime, stages})
+		return
+	}
+	refresh := h.Refresh
+	if refresh <= 0 {
+		refresh = 5 * time.Second
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	page.Execute(w, struct {
+		Refresh int
+		Uptime  time.Duration
+		Now     time.Time
+		Stages  []stage
+	}{int(refresh.Seconds() + 0.5), uptime.Round(time.Second), time.Now(), stages})
+}
+
+var page = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"ago": func(now, t time.Time) time.Duration { return now.Sub(t).Round(time.Millisecond) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="{{.Refresh}}">
+<title>Pipeline</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { padding: 0.3em 0.8em; text-align: right; border-bottom: 1px solid #ddd; }
+th:first-child, td:first-child { text-align: left; }
+.errors td { text-align: left; color: #a00; font-family: monospace; border: none; }
+</style>
+</head>
+<body>
+<h1>Pipeline</h1>
+<p>Up for {{.Uptime}}.</p>
+{{if .Stages}}
+<table>
+<tr><th>Stage</th><th>In</th><th>Out</th><th>Backlog</th><th>Busy workers</th><th>Average busy</th><th>Max latency</th><th>Errors</th></tr>
+{{range .Stages}}
+<tr><td>{{.Name}}</td><td>{{.In}}</td><td>{{.Out}}</td><td>{{.Backlog}}</td><td>{{.Active}}</td><td>{{printf "%.2f" .AverageBusy}}</td><td>{{.MaxLatency}}</td><td>{{.Errors}}</td></tr>
+{{$now := $.Now}}{{range .Recent}}
+<tr class="errors"><td></td><td colspan="7">{{ago $now .Time}} ago: {{.Error}}</td></tr>
+{{end}}
+{{end}}
+</table>
+{{else}}
+<p>No stages have reported yet.</p>
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,86 @@
+package dashboard
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"concurrency/pipeline"
+)
+
+// metrics returns Metrics for a stage that has processed three items and
+// failed on two of them.
+func metrics() *pipeline.Metrics {
+	m := pipeline.NewMetrics()
+	fn := pipeline.InstrumentFunc(m, "parse", func(_ context.Context, v int) (int, error) {
+		if v > 1 {
+			return 0, fmt.Errorf("bad <item> %d", v)
+		}
+		return v, nil
+	})
+	ctx := context.Background()
+	for v := 1; v <= 3; v++ {
+		fn(ctx, v)
+	}
+	return m
+}
+
+func TestHandlerJSON(t *testing.T) {
+	rec := httptest.NewRecorder()
+	New(metrics()).ServeHTTP(rec, httptest.NewRequest("GET", "/?format=json", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("got content type %q", ct)
+	}
+	var got struct {
+		Uptime time.Duration `json:"uptime_ns"`
+		Stages []struct {
+			Name   string `json:"name"`
+			Errors int64  `json:"errors"`
+			Recent []struct {
+				Error string `json:"error"`
+			} `json:"recent_errors"`
+		} `json:"stages"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Uptime <= 0 {
+		t.Errorf("got uptime %v", got.Uptime)
+	}
+	if len(got.Stages) != 1 || got.Stages[0].Name != "parse" || got.Stages[0].Errors != 2 {
+		t.Fatalf("got stages %+v, want parse with 2 errors", got.Stages)
+	}
+	// The latest error comes first.
+	recent := got.Stages[0].Recent
+	if len(recent) != 2 || recent[0].Error != "bad <item> 3" || recent[1].Error != "bad <item> 2" {
+		t.Errorf("got recent errors %+v, want newest first", recent)
+	}
+}
+
+func TestHandlerHTML(t *testing.T) {
+	h := New(metrics())
+	h.Refresh = 2 * time.Second
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		`<meta http-equiv="refresh" content="2">`,
+		"<td>parse</td><td>0</td><td>0</td>",
+		// Error messages are escaped.
+		"ago: bad &lt;item&gt; 3",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("page lacks %s:\n%s", want, body)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	New(pipeline.NewMetrics()).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if !strings.Contains(rec.Body.String(), "No stages have reported yet.") {
+		t.Errorf("empty page:\n%s", rec.Body.String())
+	}
+}
@@ -0,0 +1,106 @@
+package pipeline
+
+import (
+	"container/list"
+	"context"
+	"time"
+)
+
+// Debounce passes on only the last of every burst of values received from in:
+// a value is held back until window has passed without another one arriving,
+// and is dropped if another one does. It suits sources that emit the same
+// thing several times in quick succession, of which only the latest matters.
+//
+// When in is closed, or a Flusher attached to the context is flushed, the
+// value held back is sent on straight away.
+func Debounce[T any](ctx context.Context, in <-chan T, window time.Duration) <-chan T {
+	return DebounceKeyed(ctx, in, window, func(T) struct{} { return struct{}{} })
+}
+
+// DebounceKeyed is like Debounce, but debounces the values of every key
+// separately: a value is only dropped for a later one with the same key, so
+// that a burst of one key does not hold up the values of the others.
+//
+//	urls := DebounceKeyed(ctx, found, time.Second, func(u string) string { return u })
+func DebounceKeyed[T any, K comparable](ctx context.Context, in <-chan T, window time.Duration, key func(T) K) <-chan T {
+	type pending struct {
+		key K
+		v   T
+		at  time.Time
+	}
+	out := make(chan T)
+	flushes := watchFlushes(ctx)
+	go func() {
+		defer close(out)
+		held := make(map[K]*list.Element)
+		// quiet orders the held values from the least to the most
+		// recently received, which is the order they are due in.
+		var quiet list.List
+		timer := time.NewTimer(window)
+		timer.Stop()
+		defer timer.Stop()
+
+		// emit sends the value of e on and forgets it.
+		emit := func(e *list.Element) bool {
+			p := quiet.Remove(e).(*pending)
+			delete(held, p.key)
+			select {
+			case out <- p.v:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		// arm sets the timer for the value due first.
+		arm := func() {
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			if e := quiet.Front(); e != nil {
+				timer.Reset(time.Until(e.Value.(*pending).at.Add(window)))
+			}
+		}
+		emitAll := func() bool {
+			for e := quiet.Front(); e != nil; e = quiet.Front() {
+				if !emit(e) {
+					return false
+				}
+			}
+			return true
+		}
+		for {
+			select {
+			case v, ok := <-in:
+				if !ok {
+					emitAll()
+					return
+				}
+				k := key(v)
+				if e, ok := held[k]; ok {
+					quiet.Remove(e)
+				}
+				held[k] = quiet.PushBack(&pending{k, v, time.Now()})
+				arm()
+			case <-timer.C:
+				now := time.Now()
+				for e := quiet.Front(); e != nil && !now.Before(e.Value.(*pending).at.Add(window)); e = quiet.Front() {
+					if !emit(e) {
+						return
+					}
+				}
+				arm()
+			case <-flushes.requested():
+				flushes.done()
+				if !emitAll() {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
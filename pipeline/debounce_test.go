@@ -0,0 +1,64 @@
+package pipeline
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDebounce(t *testing.T) {
+	ctx := context.Background()
+	in := make(chan int)
+	out := Debounce(ctx, in, 30*time.Millisecond)
+	start := time.Now()
+	in <- 1
+	in <- 2
+	in <- 3
+	if v := <-out; v != 3 {
+		t.Errorf("got %d, want the last of the burst", v)
+	}
+	if d := time.Since(start); d < 30*time.Millisecond {
+		t.Errorf("got the value after %v, want it held back for the window", d)
+	}
+	// Closing the input sends the value held back straight away.
+	in <- 4
+	close(in)
+	if got := collect(t, out); !reflect.DeepEqual(got, []int{4}) {
+		t.Errorf("got %v, want [4]", got)
+	}
+}
+
+func TestDebounceKeyed(t *testing.T) {
+	ctx := context.Background()
+	in := make(chan string)
+	out := DebounceKeyed(ctx, in, time.Hour, func(s string) byte { return s[0] })
+	in <- "a1"
+	in <- "b1"
+	in <- "a2"
+	close(in)
+	// Only a later a replaces a1, and the held values come out in the order
+	// they are due.
+	if got := collect(t, out); !reflect.DeepEqual(got, []string{"b1", "a2"}) {
+		t.Errorf("got %v, want [b1 a2]", got)
+	}
+}
+
+func TestDebounceFlush(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	f := NewFlusher()
+	in := make(chan int)
+	out := Debounce(WithFlusher(ctx, f), in, time.Hour)
+	in <- 1
+	in <- 2
+	f.Flush()
+	select {
+	case v := <-out:
+		if v != 2 {
+			t.Errorf("got %d, want 2", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("flush did not send the value held back")
+	}
+}
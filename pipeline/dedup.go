@@ -0,0 +1,46 @@
+package pipeline
+
+import (
+	"container/list"
+	"context"
+)
+
+// Dedup drops the values received from in whose key has been seen before. If
+// size is positive, only the size most recently seen keys are remembered, so
+// that memory stays bounded on long streams, at the cost of letting through a
+// duplicate whose key has been forgotten; a size of zero remembers every key.
+func Dedup[T any, K comparable](ctx context.Context, in <-chan T, key func(T) K, size int) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		seen := make(map[K]*list.Element)
+		// recent orders the remembered keys from most to least recently
+		// seen, when size is bounded.
+		var recent list.List
+		for v := range in {
+			k := key(v)
+			if e, ok := seen[k]; ok {
+				if size > 0 {
+					recent.MoveToFront(e)
+				}
+				continue
+			}
+			if size > 0 {
+				seen[k] = recent.PushFront(k)
+				if recent.Len() > size {
+					oldest := recent.Back()
+					recent.Remove(oldest)
+					delete(seen, oldest.Value.(K))
+				}
+			} else {
+				seen[k] = nil
+			}
+			select {
+			case out <- v:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,29 @@
+package pipeline
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDedup(t *testing.T) {
+	ctx := context.Background()
+	in := Gen(ctx, "a", "B", "b", "c", "A", "a")
+	out := Dedup(ctx, in, strings.ToLower, 0)
+	// The first value with every key is kept.
+	if got := collect(t, out); !reflect.DeepEqual(got, []string{"a", "B", "c"}) {
+		t.Errorf("got %q, want [a B c]", got)
+	}
+}
+
+func TestDedupBounded(t *testing.T) {
+	ctx := context.Background()
+	id := func(v int) int { return v }
+	// With room for two keys, seeing 1 again keeps it remembered while 2
+	// is forgotten once 3 arrives.
+	in := Gen(ctx, 1, 2, 1, 3, 1, 2)
+	if got := collect(t, Dedup(ctx, in, id, 2)); !reflect.DeepEqual(got, []int{1, 2, 3, 2}) {
+		t.Errorf("got %v, want [1 2 3 2]", got)
+	}
+}
@@ -0,0 +1,10 @@
+// Package pipeline provides reusable stages for building concurrent pipelines
+// out of channels.
+//
+// A stage is a function that receives values from an inbound channel, does
+// some work on them and sends the results on an outbound channel that it
+// returns. Every stage takes a context.Context; when the context is cancelled
+// the stage stops sending, releases its goroutines and closes its outbound
+// channel, so that a consumer that stops reading early never leaks upstream
+// goroutines.
+package pipeline
@@ -0,0 +1,163 @@
+package pipeline
+
+import (
+	"context"
+	"time"
+)
+
+// An Envelope carries an item through a pipeline together with its
+// provenance: where it came from, when it entered the pipeline, how many times
+// it has been attempted and any metadata stages have attached to it along the
+// way. Sinks and dead-letter handlers can use it to tell exactly where an item
+// originated.
+//
+// Envelopes are values. Metadata is copied on write by With, so envelopes
+// sent down different branches of a pipeline never share changes.
+type Envelope[T any] struct {
+	Item     T
+	Source   string
+	Ingested time.Time
+	Attempts int
+	meta     map[string]string
+	// handed is the time a stage wrapped with InstrumentEnvelope last
+	// finished with the envelope.
+	handed time.Time
+}
+
+// Wrap puts every item received from in into an envelope stamped with the
+// name of its source and the time it was received.
+func Wrap[T any](ctx context.Context, in <-chan T, source string) <-chan Envelope[T] {
+	out := make(chan Envelope[T])
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case v, ok := <-in:
+				if !ok {
+					return
+				}
+				select {
+				case out <- Envelope[T]{Item: v, Source: source, Ingested: time.Now()}:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Unwrap sends on the item carried by every envelope received from in,
+// discarding the provenance.
+func Unwrap[T any](ctx context.Context, in <-chan Envelope[T]) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case e, ok := <-in:
+				if !ok {
+					return
+				}
+				select {
+				case out <- e.Item:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// MapEnvelope applies fn to the item of every envelope received from in and
+// sends the result on in an envelope with the same provenance.
+func MapEnvelope[In, Out any](ctx context.Context, in <-chan Envelope[In], fn func(In) Out) <-chan Envelope[Out] {
+	out := make(chan Envelope[Out])
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case e, ok := <-in:
+				if !ok {
+					return
+				}
+				select {
+				case out <- Rewrap(e, fn(e.Item)):
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Rewrap returns an envelope carrying v with the provenance of e. Stages that
+// turn one item into another use it to pass the provenance on.
+func Rewrap[In, Out any](e Envelope[In], v Out) Envelope[Out] {
+	return Envelope[Out]{
+		Item:     v,
+		Source:   e.Source,
+		Ingested: e.Ingested,
+		Attempts: e.Attempts,
+		meta:     e.meta,
+		handed:   e.handed,
+	}
+}
+
+// With returns a copy of e with the metadata key set to value.
+func (e Envelope[T]) With(key, value string) Envelope[T] {
+	meta := make(map[string]string, len(e.meta)+1)
+	for k, v := range e.meta {
+		meta[k] = v
+	}
+	meta[key] = value
+	e.meta = meta
+	return e
+}
+
+// Get returns the metadata value stored under key and whether it was set.
+func (e Envelope[T]) Get(key string) (string, bool) {
+	v, ok := e.meta[key]
+	return v, ok
+}
+
+// Metadata returns a copy of all the metadata attached to e.
+func (e Envelope[T]) Metadata() map[string]string {
+	meta := make(map[string]string, len(e.meta))
+	for k, v := range e.meta {
+		meta[k] = v
+	}
+	return meta
+}
+
+// Attempt returns a copy of e with its attempt count incremented. Stages that
+// retry an item call it before every attempt.
+func (e Envelope[T]) Attempt() Envelope[T] {
+	e.Attempts++
+	return e
+}
+
+// Age returns how long ago e entered the pipeline.
+func (e Envelope[T]) Age() time.Duration {
+	return time.Since(e.Ingested)
+}
+
+// waited returns how long e has been waiting for the next stage, and false if
+// that is unknown because e carries no timestamps.
+func (e Envelope[T]) waited() (time.Duration, bool) {
+	switch {
+	case !e.handed.IsZero():
+		return time.Since(e.handed), true
+	case !e.Ingested.IsZero():
+		return e.Age(), true
+	}
+	return 0, false
+}
@@ -0,0 +1,59 @@
+package pipeline
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestEnvelopeProvenance(t *testing.T) {
+	ctx := context.Background()
+	before := time.Now()
+	envs := collect(t, MapEnvelope(ctx, Wrap(ctx, Gen(ctx, 1, 2), "numbers"), strconv.Itoa))
+	if len(envs) != 2 {
+		t.Fatalf("got %d envelopes, want 2", len(envs))
+	}
+	for i, e := range envs {
+		if want := strconv.Itoa(i + 1); e.Item != want {
+			t.Errorf("got item %q, want %q", e.Item, want)
+		}
+		if e.Source != "numbers" {
+			t.Errorf("got source %q, want numbers", e.Source)
+		}
+		if e.Ingested.Before(before) || e.Ingested.After(time.Now()) {
+			t.Errorf("ingested at %v, outside of the test", e.Ingested)
+		}
+	}
+
+	unwrapped := collect(t, Unwrap(ctx, Gen(ctx, envs...)))
+	if want := []string{"1", "2"}; !reflect.DeepEqual(unwrapped, want) {
+		t.Errorf("unwrapped %v, want %v", unwrapped, want)
+	}
+}
+
+func TestEnvelopeMetadataIsCopiedOnWrite(t *testing.T) {
+	e := Envelope[int]{Item: 1}.With("tenant", "acme")
+	branch := e.With("tenant", "other").With("region", "eu")
+	if v, _ := e.Get("tenant"); v != "acme" {
+		t.Errorf("original envelope changed by a copy: tenant %q", v)
+	}
+	if _, ok := e.Get("region"); ok {
+		t.Error("original envelope got metadata set on a copy")
+	}
+	if want := map[string]string{"tenant": "other", "region": "eu"}; !reflect.DeepEqual(branch.Metadata(), want) {
+		t.Errorf("got %v, want %v", branch.Metadata(), want)
+	}
+
+	meta := e.Metadata()
+	meta["tenant"] = "mutated"
+	if v, _ := e.Get("tenant"); v != "acme" {
+		t.Errorf("Metadata returned the envelope's own map: tenant %q", v)
+	}
+
+	r := Rewrap(e.Attempt().Attempt(), "one")
+	if v, _ := r.Get("tenant"); v != "acme" || r.Attempts != 2 {
+		t.Errorf("rewrapped envelope has tenant %q and %d attempts, want acme and 2", v, r.Attempts)
+	}
+}
@@ -0,0 +1,130 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Command describes the external command that Exec runs for every item.
+type Command[T any] struct {
+	// Name is the program to run. It is looked up in PATH if it contains no
+	// path separators.
+	Name string
+	// Args are text/template templates, each executed with the item as its
+	// data to produce one argument.
+	Args []string
+	// Stdin, if not nil, returns the data written to the standard input of
+	// the command run for an item.
+	Stdin func(T) []byte
+	// Timeout bounds how long a single run may take. Zero means no limit.
+	Timeout time.Duration
+}
+
+// ExecResult is the outcome of running a Command for a single item.
+type ExecResult[T any] struct {
+	Item   T
+	Output []byte
+	Err    error
+}
+
+// Exec runs cmd once for every item received from in, with at most workers
+// commands running at a time, and sends the standard output of each run on
+// the returned channel. Results arrive in the order the commands finish. A
+// command that fails, or runs longer than cmd.Timeout, is reported through
+// the Err field of its result.
+//
+// Commands are started in their own process group where the platform supports
+// it, and the whole group is killed when the command times out or the context
+// is cancelled, so that no grandchild processes are left behind.
+//
+// Exec fails if an argument template does not parse or workers is below 1.
+func Exec[T any](ctx context.Context, in <-chan T, cmd Command[T], workers int) (<-chan ExecResult[T], error) {
+	if workers < 1 {
+		return nil, fmt.Errorf("pipeline: Exec needs at least one worker, got %d", workers)
+	}
+	args := make([]*template.Template, len(cmd.Args))
+	for i, a := range cmd.Args {
+		t, err := template.New(fmt.Sprintf("arg%d", i)).Parse(a)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = t
+	}
+
+	out := make(chan ExecResult[T])
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for v := range in {
+				output, err := cmd.run(ctx, args, v)
+				select {
+				case out <- ExecResult[T]{v, output, err}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out, nil
+}
+
+func (cmd *Command[T]) run(ctx context.Context, args []*template.Template, v T) ([]byte, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	argv := make([]string, len(args))
+	for i, t := range args {
+		var b strings.Builder
+		if err := t.Execute(&b, v); err != nil {
+			return nil, err
+		}
+		argv[i] = b.String()
+	}
+
+	// Keep the context bounded by cmd.Timeout apart from ctx, so that
+	// a deadline of ctx is not mistaken for the command timing out.
+	runCtx := ctx
+	if cmd.Timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, cmd.Timeout)
+		defer cancel()
+	}
+	c := exec.CommandContext(runCtx, cmd.Name, argv...)
+	setProcessGroup(c)
+	// Do not wait forever for output pipes held open by orphaned
+	// grandchildren once the command has been killed.
+	c.WaitDelay = time.Second
+	if cmd.Stdin != nil {
+		c.Stdin = bytes.NewReader(cmd.Stdin(v))
+	}
+	output, err := c.Output()
+	if runCtx.Err() != nil {
+		// The command may have exited on its own before the cancellation,
+		// leaving background children behind; reap them as well.
+		killProcessGroup(c)
+	}
+	if err != nil && ctx.Err() != nil {
+		return output, ctx.Err()
+	}
+	if errors.Is(runCtx.Err(), context.DeadlineExceeded) {
+		return output, fmt.Errorf("pipeline: %s timed out after %v", cmd.Name, cmd.Timeout)
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+		return output, fmt.Errorf("pipeline: %s: %v: %s", cmd.Name, err, bytes.TrimSpace(exitErr.Stderr))
+	}
+	return output, err
+}
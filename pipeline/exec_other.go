@@ -0,0 +1,12 @@
+//go:build !unix
+
+package pipeline
+
+import "os/exec"
+
+// setProcessGroup is a no-op on platforms without Unix process groups;
+// cancellation kills only the command itself.
+func setProcessGroup(c *exec.Cmd) {}
+
+// killProcessGroup is a no-op on platforms without Unix process groups.
+func killProcessGroup(c *exec.Cmd) error { return nil }
@@ -0,0 +1,107 @@
+//go:build unix
+
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+)
+
+func TestExec(t *testing.T) {
+	ctx := context.Background()
+	out, err := Exec(ctx, Gen(ctx, "a", "b", "c"), Command[string]{
+		Name:  "sh",
+		Args:  []string{"-c", `printf '%s-{{.}}' "$(cat)"`},
+		Stdin: func(s string) []byte { return []byte(strings.ToUpper(s)) },
+	}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for r := range out {
+		if r.Err != nil {
+			t.Fatalf("%s: %v", r.Item, r.Err)
+		}
+		got = append(got, string(r.Output))
+	}
+	sort.Strings(got)
+	if strings.Join(got, " ") != "A-a B-b C-c" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestExecFailures(t *testing.T) {
+	ctx := context.Background()
+	out, err := Exec(ctx, Gen(ctx, "1", "0.01"), Command[string]{
+		Name:    "sh",
+		Args:    []string{"-c", "sleep {{.}}; echo oops >&2; exit 3"},
+		Timeout: 300 * time.Millisecond,
+	}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := make(map[string]string)
+	for r := range out {
+		if r.Err == nil {
+			t.Fatalf("%s: no error", r.Item)
+		}
+		errs[r.Item] = r.Err.Error()
+	}
+	if !strings.Contains(errs["1"], "timed out") {
+		t.Errorf("slow command: %s, want a timeout", errs["1"])
+	}
+	if !strings.Contains(errs["0.01"], "oops") {
+		t.Errorf("failing command: %s, want its stderr", errs["0.01"])
+	}
+}
+
+func TestExecRejectsBadArguments(t *testing.T) {
+	ctx := context.Background()
+	for _, tt := range []struct {
+		name    string
+		args    []string
+		workers int
+	}{
+		{"no workers", nil, 0},
+		{"negative workers", nil, -1},
+		{"bad template", []string{"{{.Nope"}, 1},
+	} {
+		in := make(chan string)
+		if _, err := Exec(ctx, in, Command[string]{Name: "true", Args: tt.args}, tt.workers); err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+}
+
+func TestExecCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan string, 1)
+	in <- "x"
+	close(in)
+	out, err := Exec(ctx, in, Command[string]{Name: "sh", Args: []string{"-c", "sleep 10 & sleep 10"}}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	for range out {
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("output closed %v after cancelling", d)
+	}
+}
+
+func TestExecParentDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	cmd := Command[string]{Name: "sleep", Args: []string{"{{.}}"}, Timeout: time.Minute}
+	_, err := cmd.run(ctx, []*template.Template{template.Must(template.New("arg0").Parse("{{.}}"))}, "10")
+	if !errors.Is(err, context.DeadlineExceeded) || strings.Contains(err.Error(), "timed out") {
+		t.Errorf("got %v, want the deadline of the context rather than a timeout", err)
+	}
+}
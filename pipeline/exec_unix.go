@@ -0,0 +1,23 @@
+//go:build unix
+
+package pipeline
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup starts c in a process group of its own and makes
+// cancellation kill the whole group rather than just c.
+func setProcessGroup(c *exec.Cmd) {
+	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	c.Cancel = func() error { return killProcessGroup(c) }
+}
+
+// killProcessGroup kills every process left in the process group of c.
+func killProcessGroup(c *exec.Cmd) error {
+	if c.Process == nil {
+		return nil
+	}
+	return syscall.Kill(-c.Process.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,38 @@
+package pipeline
+
+import "expvar"
+
+// Publish makes the counters of m available through the expvar package, as a
+// variable named prefix holding an object with an entry per stage. Programs
+// serving http.DefaultServeMux then expose them at /debug/vars without any
+// further setup. Like expvar.Publish, it panics if a variable named prefix
+// has already been published.
+//
+// Publishing is a method rather than an option of the pipeline because
+// metrics are already opt-in: only the stages instrumented with m report to
+// it, and a program that never calls Publish leaves the expvar namespace
+// untouched.
+func (m *Metrics) Publish(prefix string) {
+	expvar.Publish(prefix, expvar.Func(func() any {
+		return m.vars()
+	}))
+}
+
+// vars returns the counters of m as an object with an entry per stage, as
+// published by Publish.
+func (m *Metrics) vars() map[string]any {
+	stages := make(map[string]any)
+	for _, s := range m.Metrics() {
+		stages[s.Name] = map[string]any{
+			"in":             s.In,
+			"out":            s.Out,
+			"errors":         s.Errors,
+			"backlog":        s.Backlog(),
+			"active_workers": s.Active,
+			"busy_seconds":   s.Busy.Seconds(),
+			"allocs_per_op":  s.AllocsPerOp(),
+			"bytes_per_op":   s.BytesPerOp(),
+		}
+	}
+	return stages
+}
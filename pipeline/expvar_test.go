@@ -0,0 +1,39 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"testing"
+)
+
+func TestPublish(t *testing.T) {
+	m := NewMetrics()
+	m.Publish("pipeline_test_publish")
+	ctx := context.Background()
+	fn := InstrumentFunc(m, "parse", func(_ context.Context, n int) (int, error) {
+		if n < 0 {
+			return 0, errors.New("negative")
+		}
+		return n, nil
+	})
+	stage := Instrument(m, "parse", func(ctx context.Context, in <-chan int) <-chan Result[int] {
+		return TryMap(ctx, in, fn)
+	})
+	for range stage(ctx, Gen(ctx, 1, 2, -1)) {
+	}
+
+	var vars map[string]struct {
+		In     int64 `json:"in"`
+		Out    int64 `json:"out"`
+		Errors int64 `json:"errors"`
+	}
+	if err := json.Unmarshal([]byte(expvar.Get("pipeline_test_publish").String()), &vars); err != nil {
+		t.Fatal(err)
+	}
+	got := vars["parse"]
+	if got.In != 3 || got.Out != 3 || got.Errors != 1 {
+		t.Errorf("got in %d, out %d, errors %d, want 3, 3, 1", got.In, got.Out, got.Errors)
+	}
+}
@@ -0,0 +1,17 @@
+package pipeline
+
+import "context"
+
+// FanOut starts n copies of worker over in, so that they share its values
+// between them, and merges what they send into a single channel. Like Merge,
+// it does not preserve the order of the values.
+func FanOut[In, Out any](ctx context.Context, in <-chan In, n int, worker Stage[In, Out]) <-chan Out {
+	if n < 1 {
+		n = 1
+	}
+	cs := make([]<-chan Out, n)
+	for i := range cs {
+		cs[i] = worker(ctx, in)
+	}
+	return Merge(ctx, cs...)
+}
@@ -0,0 +1,48 @@
+package pipeline
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestFanOut(t *testing.T) {
+	ctx := context.Background()
+	const n = 3
+	// Every worker holds its first value until all n have one, which only
+	// happens if they really run side by side.
+	var arrived sync.WaitGroup
+	arrived.Add(n)
+	worker := func(ctx context.Context, in <-chan int) <-chan int {
+		out := make(chan int)
+		go func() {
+			defer close(out)
+			first := true
+			for v := range in {
+				if first {
+					first = false
+					arrived.Done()
+					arrived.Wait()
+				}
+				out <- v * 10
+			}
+		}()
+		return out
+	}
+	out := FanOut(ctx, Gen(ctx, 1, 2, 3, 4, 5, 6), n, worker)
+	if got := sortedInts(collect(t, out)); !reflect.DeepEqual(got, []int{10, 20, 30, 40, 50, 60}) {
+		t.Errorf("got %v, want every value once", got)
+	}
+}
+
+func TestFanOutAtLeastOneWorker(t *testing.T) {
+	ctx := context.Background()
+	double := func(ctx context.Context, in <-chan int) <-chan int {
+		return Map(ctx, in, func(v int) int { return 2 * v })
+	}
+	out := FanOut(ctx, Gen(ctx, 1, 2), 0, double)
+	if got := collect(t, out); !reflect.DeepEqual(got, []int{2, 4}) {
+		t.Errorf("got %v, want [2 4]", got)
+	}
+}
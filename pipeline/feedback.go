@@ -0,0 +1,116 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+)
+
+// Feedback runs a workload in which doing a piece of work can turn up more
+// work of the same kind, such as crawling, where fetching a page finds links
+// to more pages. It starts n workers calling fn on the values received from
+// seeds and on every value fn returns as new work in turn, and sends the
+// outcome of every call on the returned channel.
+//
+// key identifies the values, so that each is worked on only once however
+// many times it turns up, which is what stops the loop from going round for
+// ever on cycles such as pages linking to each other. If maxDepth is
+// positive, the values turned up by work maxDepth steps away from a seed are
+// dropped as well. The new work fn returns along with an error is still
+// followed.
+//
+// The output is closed once seeds has been closed and all the work has been
+// done, or once the context is cancelled. Work waiting for a worker is queued
+// in memory without bound, so the visited set and depth limit must keep the
+// workload finite.
+//
+//	pages := Feedback(ctx, Gen(ctx, root), 8, URL.String, 3, fetch)
+func Feedback[T, Out any, K comparable](ctx context.Context, seeds <-chan T, n int, key func(T) K, maxDepth int, fn func(context.Context, T) (Out, []T, error)) <-chan Result[Out] {
+	if n < 1 {
+		n = 1
+	}
+	type task struct {
+		v     T
+		depth int
+	}
+	type found struct {
+		more  []T
+		depth int
+	}
+	out := make(chan Result[Out])
+	tasks := make(chan task)
+	done := make(chan found)
+
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			for t := range tasks {
+				var r Result[Out]
+				var more []T
+				r.Value, more, r.Err = fn(ctx, t.v)
+				select {
+				case out <- r:
+				case <-ctx.Done():
+					return
+				}
+				// The coordinator is always ready to take this, until
+				// the context is cancelled.
+				select {
+				case done <- found{more, t.depth + 1}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	go func() {
+		defer close(tasks)
+		visited := make(map[K]bool)
+		var queue []task
+		inFlight := 0
+		enqueue := func(v T, depth int) {
+			k := key(v)
+			if visited[k] {
+				return
+			}
+			visited[k] = true
+			queue = append(queue, task{v, depth})
+		}
+		for seeds != nil || len(queue) > 0 || inFlight > 0 {
+			var next task
+			var send chan<- task
+			if len(queue) > 0 {
+				next, send = queue[0], tasks
+			}
+			select {
+			case v, ok := <-seeds:
+				if !ok {
+					seeds = nil
+					continue
+				}
+				enqueue(v, 0)
+			case send <- next:
+				queue[0] = task{}
+				queue = queue[1:]
+				inFlight++
+			case f := <-done:
+				inFlight--
+				if maxDepth > 0 && f.depth > maxDepth {
+					continue
+				}
+				for _, v := range f.more {
+					enqueue(v, f.depth)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
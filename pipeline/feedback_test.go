@@ -0,0 +1,63 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// links is a small web of pages, with a cycle between 1 and 2.
+var links = map[int][]int{1: {2, 3}, 2: {1, 4}, 3: {4}, 4: {5}, 5: nil}
+
+func crawl(_ context.Context, page int) (int, []int, error) {
+	if page == 2 {
+		return page, links[page], errors.New("page 2 is broken")
+	}
+	return page, links[page], nil
+}
+
+// crawled returns the sorted pages of rs, and the number of errors among
+// them.
+func crawled(rs []Result[int]) ([]int, int) {
+	var pages []int
+	failed := 0
+	for _, r := range rs {
+		pages = append(pages, r.Value)
+		if r.Err != nil {
+			failed++
+		}
+	}
+	sort.Ints(pages)
+	return pages, failed
+}
+
+func TestFeedback(t *testing.T) {
+	ctx := context.Background()
+	identity := func(v int) int { return v }
+	pages, failed := crawled(collect(t, Feedback(ctx, Gen(ctx, 1, 3), 3, identity, 0, crawl)))
+	// Every page is visited once, despite the cycle and 4 being linked
+	// twice, and the links of the broken page are still followed.
+	if !reflect.DeepEqual(pages, []int{1, 2, 3, 4, 5}) || failed != 1 {
+		t.Errorf("got pages %v with %d errors, want 1 to 5 with one error", pages, failed)
+	}
+}
+
+func TestFeedbackMaxDepth(t *testing.T) {
+	ctx := context.Background()
+	identity := func(v int) int { return v }
+	pages, _ := crawled(collect(t, Feedback(ctx, Gen(ctx, 1), 2, identity, 2, crawl)))
+	if !reflect.DeepEqual(pages, []int{1, 2, 3, 4}) {
+		t.Errorf("got pages %v, want those up to two links away", pages)
+	}
+}
+
+func TestFeedbackCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	endless := func(_ context.Context, v int) (int, []int, error) { return v, []int{v + 1}, nil }
+	out := Feedback(ctx, Gen(ctx, 0), 2, func(v int) int { return v }, 0, endless)
+	<-out
+	cancel()
+	collect(t, out)
+}
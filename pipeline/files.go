@@ -0,0 +1,64 @@
+package pipeline
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path/filepath"
+)
+
+// ToFiles renders every item received from in through t into a file of its
+// own, named by path. Both *text/template.Template and *html/template.Template
+// can be used. Missing parent directories are created, and each file is
+// written under a temporary name and renamed into place once complete, so a
+// reader never sees a partially rendered file. To render all items into a
+// single stream instead, use ToWriter with a TemplateEncoder.
+//
+// ToFiles returns once in is closed, the context is cancelled or an item fails
+// to render.
+func ToFiles[T any](ctx context.Context, in <-chan T, t executor, path func(T) string) error {
+	for {
+		select {
+		case v, ok := <-in:
+			if !ok {
+				return nil
+			}
+			if err := renderFile(t, path(v), v); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func renderFile(t executor, name string, data any) error {
+	dir := filepath.Dir(name)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, "."+filepath.Base(name)+".tmp*")
+	if err != nil {
+		return err
+	}
+	// Removing the temporary file fails harmlessly once it has been renamed.
+	defer os.Remove(f.Name())
+	// CreateTemp makes files readable by the owner only.
+	if err := f.Chmod(0o644); err != nil {
+		f.Close()
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if err := t.Execute(w, data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
@@ -0,0 +1,69 @@
+package pipeline
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"text/template"
+)
+
+type page struct {
+	Slug, Title string
+}
+
+func TestToFiles(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	tmpl := template.Must(template.New("page").Parse("# {{.Title}}\n"))
+	path := func(p page) string { return filepath.Join(dir, "posts", p.Slug+".md") }
+	in := Gen(ctx, page{"first", "First post"}, page{"second", "Second post"})
+	if err := ToFiles(ctx, in, tmpl, path); err != nil {
+		t.Fatal(err)
+	}
+
+	for slug, want := range map[string]string{"first": "# First post\n", "second": "# Second post\n"} {
+		name := filepath.Join(dir, "posts", slug+".md")
+		b, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != want {
+			t.Errorf("%s: got %q, want %q", slug, b, want)
+		}
+		if fi, err := os.Stat(name); err != nil || fi.Mode().Perm() != 0o644 {
+			t.Errorf("%s: got mode %v, %v, want 0644", slug, fi.Mode(), err)
+		}
+	}
+	// No temporary files are left behind.
+	entries, err := os.ReadDir(filepath.Join(dir, "posts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("got %d files, want 2", len(entries))
+	}
+}
+
+func TestToFilesRenderError(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	name := filepath.Join(dir, "page.md")
+	if err := os.WriteFile(name, []byte("previous\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// Title is not a function, so executing the template fails after the
+	// first line has been written.
+	tmpl := template.Must(template.New("page").Parse("# {{.Title}}\n{{call .Title}}"))
+	err := ToFiles(ctx, Gen(ctx, page{"page", "New"}), tmpl, func(page) string { return name })
+	if err == nil {
+		t.Fatal("rendering error not returned")
+	}
+	// The file is left as it was rather than partially rendered.
+	if b, _ := os.ReadFile(name); string(b) != "previous\n" {
+		t.Errorf("got %q, want the previous content", b)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("got %d files, want the temporary file removed", len(entries))
+	}
+}
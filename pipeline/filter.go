@@ -0,0 +1,23 @@
+package pipeline
+
+import "context"
+
+// Filter passes on the values received from in for which keep returns true,
+// and drops the rest.
+func Filter[T any](ctx context.Context, in <-chan T, keep func(T) bool) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for v := range in {
+			if !keep(v) {
+				continue
+			}
+			select {
+			case out <- v:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,19 @@
+package pipeline
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	ctx := context.Background()
+	odd := func(v int) bool { return v%2 == 1 }
+	if got := collect(t, Filter(ctx, Gen(ctx, 1, 2, 3, 4, 5), odd)); !reflect.DeepEqual(got, []int{1, 3, 5}) {
+		t.Errorf("got %v, want [1 3 5]", got)
+	}
+	none := func(int) bool { return false }
+	if got := collect(t, Filter(ctx, Gen(ctx, 1, 2), none)); len(got) != 0 {
+		t.Errorf("got %v, want nothing kept", got)
+	}
+}
@@ -0,0 +1,97 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+)
+
+// A Flusher forces the buffering stages of a pipeline, such as Compact and
+// BatchWithin, to hand over whatever they are holding without waiting for
+// their size or time limits. Attach it to the context the stages are started
+// with using WithFlusher, then call Flush, for example at a checkpoint, during
+// a quiet period, or from an upstream stage that has received a control
+// message asking for it.
+type Flusher struct {
+	mu sync.Mutex
+	// gen counts the calls to Flush. Stages compare it with the last value
+	// they acted on, so that a flush requested while a stage was busy, for
+	// example waiting to send, is not missed.
+	gen uint64
+	// c is closed by Flush and replaced by a fresh channel, waking every
+	// stage that is waiting on it.
+	c chan struct{}
+}
+
+// NewFlusher returns a new Flusher.
+func NewFlusher() *Flusher {
+	return &Flusher{c: make(chan struct{})}
+}
+
+// Flush asks every stage watching f to flush as soon as it can. Stages that
+// hold nothing ignore it.
+func (f *Flusher) Flush() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.gen++
+	close(f.c)
+	f.c = make(chan struct{})
+}
+
+type flusherKey struct{}
+
+// WithFlusher returns a copy of ctx that makes the buffering stages started
+// with it respond to f.
+func WithFlusher(ctx context.Context, f *Flusher) context.Context {
+	return context.WithValue(ctx, flusherKey{}, f)
+}
+
+// flushed is a closed channel, returned by flushWatch.requested for a flush
+// that is already due.
+var flushed = func() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}()
+
+// A flushWatch follows the calls to Flush of a Flusher on behalf of a single
+// stage.
+type flushWatch struct {
+	f    *Flusher
+	seen uint64
+}
+
+// watchFlushes returns a flushWatch for the Flusher attached to ctx, if any.
+// Flushes that happened before the call are not reported.
+func watchFlushes(ctx context.Context) *flushWatch {
+	w := &flushWatch{}
+	if f, ok := ctx.Value(flusherKey{}).(*Flusher); ok {
+		f.mu.Lock()
+		w.f, w.seen = f, f.gen
+		f.mu.Unlock()
+	}
+	return w
+}
+
+// requested returns a channel that is closed, or already is, once Flush has
+// been called since the stage last called done. The channel is nil if there
+// is no Flusher.
+func (w *flushWatch) requested() <-chan struct{} {
+	if w.f == nil {
+		return nil
+	}
+	w.f.mu.Lock()
+	defer w.f.mu.Unlock()
+	if w.f.gen != w.seen {
+		return flushed
+	}
+	return w.f.c
+}
+
+// done records that the stage has acted on the flushes requested so far. It
+// must be called before flushing, so that a flush requested meanwhile is
+// reported again.
+func (w *flushWatch) done() {
+	w.f.mu.Lock()
+	w.seen = w.f.gen
+	w.f.mu.Unlock()
+}
@@ -0,0 +1,59 @@
+package pipeline
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFlushWhileBusy(t *testing.T) {
+	f := NewFlusher()
+	w := watchFlushes(WithFlusher(context.Background(), f))
+	if w.requested() == nil {
+		t.Fatal("no flush channel with a Flusher attached")
+	}
+	// The stage is busy, for example sending, and only asks for the flush
+	// channel again afterwards.
+	f.Flush()
+	select {
+	case <-w.requested():
+	default:
+		t.Fatal("flush requested while busy was missed")
+	}
+	w.done()
+	select {
+	case <-w.requested():
+		t.Fatal("flush reported again after done")
+	default:
+	}
+	f.Flush()
+	select {
+	case <-w.requested():
+	default:
+		t.Fatal("second flush was missed")
+	}
+
+	if w := watchFlushes(context.Background()); w.requested() != nil {
+		t.Fatal("flush channel without a Flusher")
+	}
+}
+
+func TestFlushBeforeStart(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	f := NewFlusher()
+	f.Flush()
+	in := make(chan int)
+	out := Batch(WithFlusher(ctx, f), in, 2, time.Hour)
+	in <- 1
+	select {
+	case b := <-out:
+		t.Fatalf("got %v from a flush requested before the stage started", b)
+	case <-time.After(50 * time.Millisecond):
+	}
+	f.Flush()
+	if got := <-out; !reflect.DeepEqual(got, []int{1}) {
+		t.Fatalf("got %v, want [1]", got)
+	}
+}
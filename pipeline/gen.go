@@ -0,0 +1,20 @@
+package pipeline
+
+import "context"
+
+// Gen emits vs, in order, and then closes its output channel. It is the
+// simplest source of a pipeline, useful for feeding it a fixed workload.
+func Gen[T any](ctx context.Context, vs ...T) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for _, v := range vs {
+			select {
+			case out <- v:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,238 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// A Graph assembles a pipeline whose stages form a directed acyclic graph
+// rather than a chain: every stage is named and receives the values of the
+// stages connected to it, merged, while a stage connected to several others
+// sends each of them a copy of every value, as Broadcast does.
+//
+//	g := NewGraph[Page]()
+//	g.Source("seed", seed)
+//	g.Try("fetch", fetch)
+//	g.Stage("index", index)
+//	g.Stage("archive", archive)
+//	g.Connect("seed", "fetch").Connect("fetch", "index").Connect("fetch", "archive")
+//	out, wait := g.Run(ctx)
+//
+// The stages without any stage connected after them are the outputs of the
+// graph. A Graph is not safe for concurrent use while it is being declared.
+type Graph[T any] struct {
+	nodes map[string]*graphNode[T]
+	// names are the names of the nodes in the order they were declared.
+	names []string
+	// errs are the mistakes made declaring the graph, reported by
+	// Validate.
+	errs []error
+}
+
+// A graphNode is a stage of a Graph. Exactly one of source and stage is set.
+type graphNode[T any] struct {
+	source func(ctx context.Context, r *run) <-chan T
+	stage  func(ctx context.Context, r *run, in <-chan T) <-chan T
+	// next and prev are the names of the nodes connected after and before
+	// the node.
+	next, prev []string
+}
+
+// NewGraph returns an empty Graph.
+func NewGraph[T any]() *Graph[T] {
+	return &Graph[T]{nodes: make(map[string]*graphNode[T])}
+}
+
+func (g *Graph[T]) add(name string, n *graphNode[T]) *Graph[T] {
+	if _, ok := g.nodes[name]; ok {
+		g.errs = append(g.errs, fmt.Errorf("pipeline: stage %q declared twice", name))
+		return g
+	}
+	g.nodes[name] = n
+	g.names = append(g.names, name)
+	return g
+}
+
+// Source declares a stage named name whose values come from src. Sources
+// cannot have stages connected before them.
+func (g *Graph[T]) Source(name string, src func(ctx context.Context) <-chan T) *Graph[T] {
+	return g.add(name, &graphNode[T]{source: func(ctx context.Context, r *run) <-chan T {
+		return src(ctx)
+	}})
+}
+
+// Stage declares a stage named name.
+func (g *Graph[T]) Stage(name string, stage Stage[T, T]) *Graph[T] {
+	return g.add(name, &graphNode[T]{stage: func(ctx context.Context, r *run, in <-chan T) <-chan T {
+		return stage(ctx, in)
+	}})
+}
+
+// Try declares a stage named name that applies fn to every value. As with
+// Builder.Try, the first error fn returns fails the whole graph.
+func (g *Graph[T]) Try(name string, fn func(context.Context, T) (T, error)) *Graph[T] {
+	return g.add(name, &graphNode[T]{stage: func(ctx context.Context, r *run, in <-chan T) <-chan T {
+		vals, errs := Split(ctx, TryMap(ctx, in, fn))
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			for err := range errs {
+				r.fail(fmt.Errorf("pipeline: stage %q: %w", name, err))
+			}
+		}()
+		return vals
+	}})
+}
+
+// Connect feeds the values of the stage named from into the one named to.
+// Both must have been declared.
+func (g *Graph[T]) Connect(from, to string) *Graph[T] {
+	f, ok := g.nodes[from]
+	if !ok {
+		g.errs = append(g.errs, fmt.Errorf("pipeline: edge %q -> %q: no stage %q", from, to, from))
+		return g
+	}
+	t, ok := g.nodes[to]
+	if !ok {
+		g.errs = append(g.errs, fmt.Errorf("pipeline: edge %q -> %q: no stage %q", from, to, to))
+		return g
+	}
+	if t.source != nil {
+		g.errs = append(g.errs, fmt.Errorf("pipeline: edge %q -> %q: %q is a source", from, to, to))
+		return g
+	}
+	for _, n := range f.next {
+		if n == to {
+			g.errs = append(g.errs, fmt.Errorf("pipeline: edge %q -> %q declared twice", from, to))
+			return g
+		}
+	}
+	f.next = append(f.next, to)
+	t.prev = append(t.prev, from)
+	return g
+}
+
+// Validate reports the first mistake in the declaration of the graph: a stage
+// declared twice, an edge to or from an undeclared stage, a cycle, or an
+// orphan stage, one that no stage feeds or, for a source, that feeds none.
+func (g *Graph[T]) Validate() error {
+	_, err := g.sorted()
+	return err
+}
+
+// sorted validates the graph and returns the names of its nodes in an order
+// in which every node comes after the ones connected before it.
+func (g *Graph[T]) sorted() ([]string, error) {
+	if len(g.errs) > 0 {
+		return nil, g.errs[0]
+	}
+	if len(g.nodes) == 0 {
+		return nil, fmt.Errorf("pipeline: graph has no stages")
+	}
+	for _, name := range g.names {
+		n := g.nodes[name]
+		switch {
+		case n.source == nil && len(n.prev) == 0:
+			return nil, fmt.Errorf("pipeline: stage %q is fed by no stage", name)
+		case n.source != nil && len(n.next) == 0:
+			return nil, fmt.Errorf("pipeline: source %q feeds no stage", name)
+		}
+	}
+
+	// A depth-first search, which finds a cycle as a node reached again
+	// while it is still on the path being followed.
+	const (
+		unvisited = iota
+		onPath
+		done
+	)
+	state := make(map[string]int)
+	var path, order []string
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case onPath:
+			i := len(path) - 1
+			for path[i] != name {
+				i--
+			}
+			cycle := append(append([]string(nil), path[i:]...), name)
+			return fmt.Errorf("pipeline: graph has a cycle: %s", strings.Join(cycle, " -> "))
+		case done:
+			return nil
+		}
+		state[name] = onPath
+		path = append(path, name)
+		for _, next := range g.nodes[name].next {
+			if err := visit(next); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = done
+		order = append(order, name)
+		return nil
+	}
+	for _, name := range g.names {
+		if err := visit(name); err != nil {
+			return nil, err
+		}
+	}
+	// The search lists every node after the ones it feeds.
+	for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
+		order[i], order[j] = order[j], order[i]
+	}
+	return order, nil
+}
+
+// Run validates the graph, starts it and returns the values of its output
+// stages, merged, along with a function that waits for the output to be
+// closed and returns the first error of the graph, or the context's error if
+// the context was cancelled. All the stages share a single context, which is
+// cancelled along with ctx and as soon as any stage fails. If the graph is
+// not valid, the output is closed at once and the wait function returns the
+// error Validate would.
+func (g *Graph[T]) Run(ctx context.Context) (<-chan T, func() error) {
+	order, err := g.sorted()
+	if err != nil {
+		out := make(chan T)
+		close(out)
+		return out, func() error { return err }
+	}
+	log(ctx, slog.LevelDebug, "pipeline: graph started", "stages", len(order))
+	out, wait, _ := start(ctx, func(ctx context.Context, r *run) <-chan T {
+		// inputs collects the channels feeding every node, filled in
+		// by the nodes before it.
+		inputs := make(map[string][]<-chan T)
+		var outputs []<-chan T
+		for _, name := range order {
+			n := g.nodes[name]
+			var c <-chan T
+			if n.source != nil {
+				c = n.source(ctx, r)
+			} else {
+				in := inputs[name]
+				if len(in) > 1 {
+					in = []<-chan T{Merge(ctx, in...)}
+				}
+				c = Labeled(name, func(ctx context.Context, in <-chan T) <-chan T {
+					return n.stage(ctx, r, in)
+				})(ctx, in[0])
+			}
+			switch len(n.next) {
+			case 0:
+				outputs = append(outputs, c)
+			case 1:
+				inputs[n.next[0]] = append(inputs[n.next[0]], c)
+			default:
+				for i, branch := range TeeN(ctx, c, len(n.next)) {
+					inputs[n.next[i]] = append(inputs[n.next[i]], branch)
+				}
+			}
+		}
+		return Merge(ctx, outputs...)
+	})
+	return out, wait
+}
@@ -0,0 +1,105 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// adding returns a stage adding n to every value.
+func adding(n int) Stage[int, int] {
+	return func(ctx context.Context, in <-chan int) <-chan int {
+		return Map(ctx, in, func(v int) int { return v + n })
+	}
+}
+
+func TestGraph(t *testing.T) {
+	ctx := context.Background()
+	g := NewGraph[int]()
+	g.Source("odd", func(ctx context.Context) <-chan int { return Gen(ctx, 1, 3) })
+	g.Source("even", func(ctx context.Context) <-chan int { return Gen(ctx, 2) })
+	g.Try("scale", func(_ context.Context, v int) (int, error) { return 10 * v, nil })
+	g.Stage("index", adding(1))
+	g.Stage("archive", adding(2))
+	// Both sources are merged into scale, whose values are copied to index
+	// and archive, the two outputs.
+	g.Connect("odd", "scale").Connect("even", "scale").
+		Connect("scale", "index").Connect("scale", "archive")
+	out, wait := g.Run(ctx)
+	got := sortedInts(collect(t, out))
+	if err := wait(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{11, 12, 21, 22, 31, 32}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestGraphTryFails(t *testing.T) {
+	ctx := context.Background()
+	g := NewGraph[int]()
+	g.Source("in", func(ctx context.Context) <-chan int { return Gen(ctx, 1, 2, 3) })
+	g.Try("check", func(_ context.Context, v int) (int, error) {
+		if v == 2 {
+			return 0, errDown
+		}
+		return v, nil
+	})
+	g.Connect("in", "check")
+	out, wait := g.Run(ctx)
+	for range out {
+	}
+	if err := wait(); !errors.Is(err, errDown) || err.Error() != `pipeline: stage "check": down` {
+		t.Errorf("got %v, want the error of check", err)
+	}
+}
+
+func TestGraphValidate(t *testing.T) {
+	src := func(ctx context.Context) <-chan int { return Gen(ctx, 1) }
+	for _, tt := range []struct {
+		name    string
+		declare func(g *Graph[int])
+		err     string
+	}{
+		{"empty", func(g *Graph[int]) {}, "pipeline: graph has no stages"},
+		{"declared twice", func(g *Graph[int]) {
+			g.Source("s", src).Stage("s", adding(1))
+		}, `pipeline: stage "s" declared twice`},
+		{"undeclared", func(g *Graph[int]) {
+			g.Source("s", src).Connect("s", "t")
+		}, `pipeline: edge "s" -> "t": no stage "t"`},
+		{"into a source", func(g *Graph[int]) {
+			g.Source("s", src).Source("t", src).Connect("s", "t")
+		}, `pipeline: edge "s" -> "t": "t" is a source`},
+		{"edge twice", func(g *Graph[int]) {
+			g.Source("s", src).Stage("a", adding(1)).Connect("s", "a").Connect("s", "a")
+		}, `pipeline: edge "s" -> "a" declared twice`},
+		{"unfed", func(g *Graph[int]) {
+			g.Source("s", src).Stage("a", adding(1)).Stage("b", adding(1)).Connect("s", "a")
+		}, `pipeline: stage "b" is fed by no stage`},
+		{"unused source", func(g *Graph[int]) {
+			g.Source("s", src)
+		}, `pipeline: source "s" feeds no stage`},
+		{"cycle", func(g *Graph[int]) {
+			g.Source("s", src).Stage("a", adding(1)).Stage("b", adding(1)).
+				Connect("s", "a").Connect("a", "b").Connect("b", "a")
+		}, `pipeline: graph has a cycle: a -> b -> a`},
+	} {
+		g := NewGraph[int]()
+		tt.declare(g)
+		if err := g.Validate(); err == nil || err.Error() != tt.err {
+			t.Errorf("%s: got %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestGraphRunInvalid(t *testing.T) {
+	out, wait := NewGraph[int]().Run(context.Background())
+	if _, ok := <-out; ok {
+		t.Error("output of an invalid graph not closed")
+	}
+	if err := wait(); err == nil {
+		t.Error("invalid graph ran without an error")
+	}
+}
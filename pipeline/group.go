@@ -0,0 +1,123 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+)
+
+// A Group runs the goroutines that make up a pipeline and waits for them,
+// in the manner of golang.org/x/sync/errgroup. The first of them to fail
+// cancels the context the Group was created with, which stops the rest of
+// the pipeline.
+type Group struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	sem    chan struct{}
+
+	errOnce sync.Once
+	err     error
+}
+
+// NewGroup returns a new Group and a context derived from ctx that is
+// cancelled when a goroutine of the group fails or when Wait returns. Start
+// the stages of the pipeline with that context.
+func NewGroup(ctx context.Context) (*Group, context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	return &Group{ctx: ctx, cancel: cancel}, ctx
+}
+
+// SetLimit limits the number of goroutines of the group that run at once to
+// n; Go blocks until one of them returns once the limit is reached. A
+// negative n removes the limit. The limit must not be changed while
+// goroutines of the group are running.
+func (g *Group) SetLimit(n int) {
+	if n < 0 {
+		g.sem = nil
+		return
+	}
+	if len(g.sem) != 0 {
+		panic("pipeline: SetLimit called while goroutines of the group are running")
+	}
+	g.sem = make(chan struct{}, n)
+}
+
+// Go runs fn in a new goroutine of the group. The first fn to return an
+// error cancels the group's context, and its error is returned by Wait.
+func (g *Group) Go(fn func() error) {
+	if g.sem != nil {
+		g.sem <- struct{}{}
+	}
+	g.start(fn)
+}
+
+// TryGo runs fn in a new goroutine of the group, like Go, unless the limit set
+// with SetLimit has been reached, in which case it returns false.
+func (g *Group) TryGo(fn func() error) bool {
+	if g.sem != nil {
+		select {
+		case g.sem <- struct{}{}:
+		default:
+			return false
+		}
+	}
+	g.start(fn)
+	return true
+}
+
+func (g *Group) start(fn func() error) {
+	g.wg.Add(1)
+	go func() {
+		defer g.done()
+		if err := fn(); err != nil {
+			g.errOnce.Do(func() {
+				g.err = err
+				g.cancel()
+			})
+		}
+	}()
+}
+
+func (g *Group) done() {
+	if g.sem != nil {
+		<-g.sem
+	}
+	g.wg.Done()
+}
+
+// Wait waits for every goroutine of the group, including the stages started
+// with Tracked, to return, then cancels the group's context and returns the
+// first error.
+func (g *Group) Wait() error {
+	g.wg.Wait()
+	g.cancel()
+	return g.err
+}
+
+// Tracked returns stage wrapped so that g waits for it: Wait does not return
+// until the stage has closed its output, which stages only do once their
+// goroutines are done. Once the group's context is cancelled, whatever the
+// stage still sends is discarded, so that the stage is never left blocked on
+// a consumer that has gone away. Tracked stages do not count towards the
+// limit set with SetLimit.
+func Tracked[In, Out any](g *Group, stage Stage[In, Out]) Stage[In, Out] {
+	return func(ctx context.Context, in <-chan In) <-chan Out {
+		c := stage(ctx, in)
+		out := make(chan Out)
+		g.wg.Add(1)
+		go func() {
+			defer g.wg.Done()
+			defer close(out)
+			for v := range c {
+				select {
+				case out <- v:
+				case <-g.ctx.Done():
+					for range c {
+					}
+					return
+				}
+			}
+		}()
+		return out
+	}
+}
@@ -0,0 +1,115 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGroup(t *testing.T) {
+	g, ctx := NewGroup(context.Background())
+	boom := errors.New("boom")
+	g.Go(func() error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	g.Go(func() error { return boom })
+	if err := g.Wait(); err != boom {
+		t.Errorf("got %v, want the first error", err)
+	}
+}
+
+func TestGroupWaitCancels(t *testing.T) {
+	g, ctx := NewGroup(context.Background())
+	g.Go(func() error { return nil })
+	if err := g.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if ctx.Err() == nil {
+		t.Error("context still live after Wait returned")
+	}
+}
+
+func TestGroupLimit(t *testing.T) {
+	g, _ := NewGroup(context.Background())
+	g.SetLimit(2)
+	var running, peak atomic.Int32
+	release := make(chan struct{})
+	work := func() error {
+		n := running.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		<-release
+		running.Add(-1)
+		return nil
+	}
+	g.Go(work)
+	g.Go(work)
+	if g.TryGo(work) {
+		t.Error("TryGo started a goroutine past the limit")
+	}
+	started := make(chan struct{})
+	go func() {
+		// Go blocks until a slot is free.
+		g.Go(work)
+		close(started)
+	}()
+	select {
+	case <-started:
+		t.Fatal("Go started a goroutine past the limit")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	<-started
+	if err := g.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if p := peak.Load(); p != 2 {
+		t.Errorf("%d goroutines ran at once, want 2", p)
+	}
+}
+
+func TestTracked(t *testing.T) {
+	g, ctx := NewGroup(context.Background())
+	var closed atomic.Bool
+	stage := func(ctx context.Context, in <-chan int) <-chan int {
+		out := make(chan int)
+		go func() {
+			defer closed.Store(true)
+			defer close(out)
+			for v := range in {
+				// Sends without watching the context, so it relies on
+				// Tracked to keep taking its output.
+				out <- v
+			}
+		}()
+		return out
+	}
+	in := make(chan int)
+	out := Tracked(g, stage)(ctx, in)
+	go func() {
+		in <- 1
+		in <- 2
+		close(in)
+	}()
+	if v := <-out; v != 1 {
+		t.Fatalf("got %d, want 1", v)
+	}
+	// Nobody reads 2, but the failure makes Tracked discard it.
+	g.Go(func() error { return errors.New("boom") })
+	if err := g.Wait(); err == nil {
+		t.Fatal("got no error")
+	}
+	if !closed.Load() {
+		t.Error("Wait returned before the tracked stage was done")
+	}
+	if got := collect(t, out); len(got) != 0 {
+		t.Errorf("got %v after the group was done, want it discarded", got)
+	}
+}
@@ -0,0 +1,76 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"time"
+)
+
+// ProfileHeap writes a heap profile to path, and the counters of every stage
+// of m at that moment, in the format of Publish, to path with ".json"
+// appended. Heap profiles cannot carry labels of their own, so the counters
+// are what tells how far the pipeline had got and which stages were backed up
+// when the profile was taken. Look at the profile with "go tool pprof path".
+func (m *Metrics) ProfileHeap(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := pprof.Lookup("heap").WriteTo(f, 0); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	stats, err := json.MarshalIndent(struct {
+		Time   time.Time      `json:"time"`
+		Uptime float64        `json:"uptime_seconds"`
+		Stages map[string]any `json:"stages"`
+	}{time.Now(), time.Since(m.Started).Seconds(), m.vars()}, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path+".json", append(stats, '\n'), 0o644)
+}
+
+// HeapSnapshots takes a heap profile with ProfileHeap every interval, to help
+// analyse how the memory of a long run grows. The profiles are written to dir,
+// named after the time they were taken, and only the latest keep of them are
+// kept; a keep below 1 keeps them all. HeapSnapshots returns when ctx is
+// cancelled, with the context's error, or with the error of the first
+// snapshot that could not be written.
+func (m *Metrics) HeapSnapshots(ctx context.Context, dir string, interval time.Duration, keep int) error {
+	if interval <= 0 {
+		return errors.New("pipeline: HeapSnapshots needs a positive interval")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	var taken []string
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case now := <-t.C:
+			path := filepath.Join(dir, "heap-"+now.UTC().Format("20060102T150405.000")+".pprof")
+			if err := m.ProfileHeap(path); err != nil {
+				return err
+			}
+			taken = append(taken, path)
+			if keep > 0 && len(taken) > keep {
+				for _, old := range taken[:len(taken)-keep] {
+					os.Remove(old)
+					os.Remove(old + ".json")
+				}
+				taken = append(taken[:0], taken[len(taken)-keep:]...)
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestProfileHeap(t *testing.T) {
+	m := NewMetrics()
+	fn := InstrumentFunc(m, "parse", func(_ context.Context, v int) (int, error) { return v, nil })
+	fn(context.Background(), 1)
+
+	path := filepath.Join(t.TempDir(), "heap.pprof")
+	if err := m.ProfileHeap(path); err != nil {
+		t.Fatal(err)
+	}
+	profile, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Profiles are gzipped protocol buffers.
+	if !bytes.HasPrefix(profile, []byte{0x1f, 0x8b}) {
+		t.Errorf("profile does not start with a gzip header: % x", profile[:min(len(profile), 8)])
+	}
+	b, err := os.ReadFile(path + ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stats struct {
+		Stages map[string]struct {
+			In  int64 `json:"in"`
+			Out int64 `json:"out"`
+		} `json:"stages"`
+	}
+	if err := json.Unmarshal(b, &stats); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := stats.Stages["parse"]; !ok {
+		t.Errorf("stats %s have no entry for the parse stage", b)
+	}
+}
+
+func TestHeapSnapshots(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "snapshots")
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	err := NewMetrics().HeapSnapshots(ctx, dir, 20*time.Millisecond, 2)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+	profiles, _ := filepath.Glob(filepath.Join(dir, "heap-*.pprof"))
+	stats, _ := filepath.Glob(filepath.Join(dir, "heap-*.pprof.json"))
+	if len(profiles) != 2 || len(stats) != 2 {
+		t.Errorf("kept profiles %v and stats %v, want the latest 2 of each", profiles, stats)
+	}
+
+	if err := NewMetrics().HeapSnapshots(context.Background(), dir, 0, 0); err == nil {
+		t.Error("no error for a zero interval")
+	}
+}
@@ -0,0 +1,83 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// MapWithHeartbeat applies fn to every value received from in, like Map, and
+// also pulses on the returned heartbeat channel every interval while it is
+// waiting for work, and after every value it has finished. The pulses come
+// from the worker goroutine itself, so they stop while fn runs: a supervisor
+// that has not heard a heartbeat for much longer than fn should take can
+// tell a stuck worker from one that is slow or has nothing to do.
+//
+// Pulses are dropped rather than queued when nobody is listening, so the
+// heartbeat channel need not be drained. Both channels are closed once in is
+// closed or the context is cancelled.
+//
+// MapWithHeartbeat panics if interval is not positive.
+func MapWithHeartbeat[In, Out any](ctx context.Context, in <-chan In, fn func(In) Out, interval time.Duration) (<-chan Out, <-chan struct{}) {
+	if interval <= 0 {
+		panic(fmt.Sprintf("pipeline: heartbeat interval must be positive, got %v", interval))
+	}
+	out := make(chan Out)
+	heartbeat := make(chan struct{}, 1)
+	go func() {
+		defer close(out)
+		defer close(heartbeat)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		pulse := func() {
+			select {
+			case heartbeat <- struct{}{}:
+			default:
+			}
+		}
+		for {
+			select {
+			case v, ok := <-in:
+				if !ok {
+					return
+				}
+				r := fn(v)
+				pulse()
+				// Keep pulsing while waiting for downstream, which
+				// is not the worker being stuck.
+				for sent := false; !sent; {
+					select {
+					case out <- r:
+						sent = true
+					case <-t.C:
+						pulse()
+					case <-ctx.Done():
+						return
+					}
+				}
+			case <-t.C:
+				pulse()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, heartbeat
+}
+
+// FanOutWithHeartbeat starts n workers applying fn to the values received from
+// in, like FanOut with Map, and merges their results. It returns the
+// heartbeat channel of every worker, as described for MapWithHeartbeat, so
+// that a supervisor can spot the one that is stuck. Like MapWithHeartbeat, it
+// panics if interval is not positive.
+func FanOutWithHeartbeat[In, Out any](ctx context.Context, in <-chan In, n int, fn func(In) Out, interval time.Duration) (<-chan Out, []<-chan struct{}) {
+	if n < 1 {
+		n = 1
+	}
+	outs := make([]<-chan Out, n)
+	heartbeats := make([]<-chan struct{}, n)
+	for i := range outs {
+		outs[i], heartbeats[i] = MapWithHeartbeat(ctx, in, fn, interval)
+	}
+	return Merge(ctx, outs...), heartbeats
+}
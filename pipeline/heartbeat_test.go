@@ -0,0 +1,81 @@
+package pipeline
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMapWithHeartbeat(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	in := make(chan int)
+	stuck := make(chan struct{})
+	out, heartbeat := MapWithHeartbeat(ctx, in, func(v int) int {
+		if v == 0 {
+			<-stuck
+		}
+		return v
+	}, 5*time.Millisecond)
+
+	// An idle worker keeps pulsing.
+	for i := 0; i < 3; i++ {
+		select {
+		case <-heartbeat:
+		case <-time.After(time.Second):
+			t.Fatal("no heartbeat from an idle worker")
+		}
+	}
+
+	// A stuck worker falls silent once the pulse left over from before it
+	// got stuck has been taken.
+	in <- 0
+	time.Sleep(10 * time.Millisecond)
+	select {
+	case <-heartbeat:
+	default:
+	}
+	select {
+	case <-heartbeat:
+		t.Fatal("heartbeat from a stuck worker")
+	case <-time.After(30 * time.Millisecond):
+	}
+
+	// It pulses again once it has finished, even while downstream does not
+	// take the result.
+	close(stuck)
+	select {
+	case <-heartbeat:
+	case <-time.After(time.Second):
+		t.Fatal("no heartbeat after finishing")
+	}
+	if v := <-out; v != 0 {
+		t.Errorf("got %d, want 0", v)
+	}
+	cancel()
+	collect(t, out)
+	collect(t, heartbeat)
+}
+
+func TestFanOutWithHeartbeat(t *testing.T) {
+	ctx := context.Background()
+	out, heartbeats := FanOutWithHeartbeat(ctx, Gen(ctx, 1, 2, 3, 4), 3, func(v int) int { return v * v }, time.Millisecond)
+	if len(heartbeats) != 3 {
+		t.Fatalf("got %d heartbeat channels, want one per worker", len(heartbeats))
+	}
+	if got := sortedInts(collect(t, out)); !reflect.DeepEqual(got, []int{1, 4, 9, 16}) {
+		t.Errorf("got %v, want [1 4 9 16]", got)
+	}
+	// The heartbeats need no draining, and close along with the workers.
+	for _, h := range heartbeats {
+		collect(t, h)
+	}
+}
+
+func TestHeartbeatRejectsNonPositiveInterval(t *testing.T) {
+	ctx := context.Background()
+	double := func(v int) int { return 2 * v }
+	mustPanic(t, "MapWithHeartbeat", func() { MapWithHeartbeat(ctx, make(chan int), double, 0) })
+	mustPanic(t, "FanOutWithHeartbeat", func() { FanOutWithHeartbeat(ctx, make(chan int), 2, double, -time.Second) })
+}
@@ -0,0 +1,110 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Hedge wraps fn so that a slow call on an item is hedged with another: if fn
+// has not returned after delay, the same item is tried again in parallel, and
+// again after every further delay, up to k calls in all. The first call to
+// succeed wins and the context of the others is cancelled. If every call
+// fails, the errors are joined. Hedging trades extra load for shorter tail
+// latency, so fn should be idempotent and delay about the latency of a
+// typical call.
+func Hedge[In, Out any](fn func(context.Context, In) (Out, error), k int, delay time.Duration) func(context.Context, In) (Out, error) {
+	if k < 1 {
+		k = 1
+	}
+	return func(ctx context.Context, v In) (Out, error) {
+		return firstSuccess(ctx, k, delay, func(ctx context.Context, i int) (Out, error) {
+			return fn(ctx, v)
+		})
+	}
+}
+
+// firstSuccess calls call n times concurrently, starting call i after i
+// delays unless an earlier call has already succeeded, and returns the result
+// of the first call to succeed, cancelling the others. If every call fails,
+// it returns their errors joined.
+func firstSuccess[T any](ctx context.Context, n int, delay time.Duration, call func(ctx context.Context, i int) (T, error)) (T, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// Buffered, so that the losers can finish after the winner has been
+	// returned.
+	results := make(chan Result[T], n)
+	start := func(i int) {
+		go func() {
+			var r Result[T]
+			r.Value, r.Err = call(ctx, i)
+			results <- r
+		}()
+	}
+
+	start(0)
+	started := 1
+	if delay <= 0 {
+		for ; started < n; started++ {
+			start(started)
+		}
+	}
+	var timer <-chan time.Time
+	var t *time.Timer
+	if started < n {
+		t = time.NewTimer(delay)
+		defer func() { t.Stop() }()
+		timer = t.C
+	}
+	var errs []error
+	for len(errs) < n {
+		select {
+		case r := <-results:
+			if r.Err == nil {
+				return r.Value, nil
+			}
+			errs = append(errs, r.Err)
+			// A failure is no reason to wait out the delay before
+			// trying the next call.
+			if started < n && started == len(errs) {
+				start(started)
+				started++
+				if started < n {
+					if !t.Stop() {
+						<-t.C
+					}
+					t.Reset(delay)
+				} else {
+					timer = nil
+				}
+			}
+		case <-timer:
+			start(started)
+			started++
+			if started < n {
+				t.Reset(delay)
+			} else {
+				timer = nil
+			}
+		case <-ctx.Done():
+			var zero T
+			return zero, ctx.Err()
+		}
+	}
+	var zero T
+	return zero, errors.Join(errs...)
+}
+
+// Race runs fns concurrently and returns the result of the first one to
+// succeed, cancelling the context of the others. If every one of them fails,
+// their errors are joined. Race returns as soon as it has a winner, without
+// waiting for the losers to notice the cancellation.
+func Race[T any](ctx context.Context, fns ...func(context.Context) (T, error)) (T, error) {
+	if len(fns) == 0 {
+		var zero T
+		return zero, errors.New("pipeline: Race called without functions")
+	}
+	return firstSuccess(ctx, len(fns), 0, func(ctx context.Context, i int) (T, error) {
+		return fns[i](ctx)
+	})
+}
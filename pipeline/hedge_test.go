@@ -0,0 +1,125 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHedgeFastCall(t *testing.T) {
+	var calls atomic.Int32
+	fn := Hedge(func(_ context.Context, v int) (int, error) {
+		calls.Add(1)
+		return v, nil
+	}, 3, 20*time.Millisecond)
+	if v, err := fn(context.Background(), 1); err != nil || v != 1 {
+		t.Fatalf("got %v, %v", v, err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := calls.Load(); n != 1 {
+		t.Errorf("made %d calls, want no hedge for a fast call", n)
+	}
+}
+
+func TestHedgeSlowCall(t *testing.T) {
+	var calls atomic.Int32
+	cancelled := make(chan struct{})
+	fn := Hedge(func(ctx context.Context, v int) (int, error) {
+		if calls.Add(1) == 1 {
+			// The first call hangs until it loses.
+			<-ctx.Done()
+			close(cancelled)
+			return 0, ctx.Err()
+		}
+		return v * 10, nil
+	}, 3, 10*time.Millisecond)
+	start := time.Now()
+	if v, err := fn(context.Background(), 1); err != nil || v != 10 {
+		t.Fatalf("got %v, %v, want the hedged call's result", v, err)
+	}
+	if d := time.Since(start); d < 10*time.Millisecond {
+		t.Errorf("hedged after %v, before the delay", d)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Error("losing call not cancelled")
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("made %d calls, want 2", n)
+	}
+}
+
+func TestHedgeFailures(t *testing.T) {
+	var calls atomic.Int32
+	fn := Hedge(func(_ context.Context, v int) (int, error) {
+		return 0, fmt.Errorf("call %d failed", calls.Add(1))
+	}, 3, time.Hour)
+	// A failure starts the next call at once instead of after the delay.
+	errc := make(chan error, 1)
+	go func() {
+		_, err := fn(context.Background(), 1)
+		errc <- err
+	}()
+	var err error
+	select {
+	case err = <-errc:
+	case <-time.After(time.Second):
+		t.Fatal("waited out the delay after a failure")
+	}
+	if err == nil {
+		t.Fatal("got no error")
+	}
+	for i := 1; i <= 3; i++ {
+		if want := fmt.Sprintf("call %d failed", i); !containsLine(err.Error(), want) {
+			t.Errorf("got %q, want every call's error joined", err)
+		}
+	}
+}
+
+// containsLine reports whether line is one of the lines of s.
+func containsLine(s, line string) bool {
+	for _, l := range strings.Split(s, "\n") {
+		if l == line {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRace(t *testing.T) {
+	ctx := context.Background()
+	slow := func(ctx context.Context) (string, error) {
+		select {
+		case <-time.After(time.Second):
+			return "slow", nil
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+	failing := func(context.Context) (string, error) { return "", errors.New("down") }
+	fast := func(context.Context) (string, error) {
+		time.Sleep(10 * time.Millisecond)
+		return "fast", nil
+	}
+	start := time.Now()
+	// A failure does not end the race while others may still succeed.
+	if v, err := Race(ctx, slow, failing, fast); err != nil || v != "fast" {
+		t.Errorf("got %q, %v, want the first success", v, err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("took %v, want no wait for the losers", d)
+	}
+
+	_, err := Race(ctx, failing, failing)
+	if err == nil || err.Error() != "down\ndown" {
+		t.Errorf("got %v, want both errors joined", err)
+	}
+	if _, err := Race[int](ctx); err == nil {
+		t.Error("got no error without functions")
+	}
+}
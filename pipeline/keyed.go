@@ -0,0 +1,44 @@
+package pipeline
+
+import (
+	"context"
+	"hash/fnv"
+)
+
+// FanOutKeyed starts n copies of worker, like FanOut, but routes every value
+// received from in to a copy chosen by hashing its key, so that values with
+// the same key always go to the same copy. Their results come out in the
+// order the values went in, while values with different keys are still
+// worked on in parallel; updates to the same record, for example, are
+// applied in order.
+//
+// A copy that falls behind holds up the routing of values to the others, so
+// keys should be spread evenly enough for no single key to dominate.
+func FanOutKeyed[In, Out any](ctx context.Context, in <-chan In, n int, key func(In) string, worker Stage[In, Out]) <-chan Out {
+	if n < 1 {
+		n = 1
+	}
+	parts := make([]chan In, n)
+	outs := make([]<-chan Out, n)
+	for i := range parts {
+		parts[i] = make(chan In)
+		outs[i] = worker(ctx, parts[i])
+	}
+	go func() {
+		defer func() {
+			for _, p := range parts {
+				close(p)
+			}
+		}()
+		for v := range in {
+			h := fnv.New32a()
+			h.Write([]byte(key(v)))
+			select {
+			case parts[h.Sum32()%uint32(n)] <- v:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return Merge(ctx, outs...)
+}
@@ -0,0 +1,63 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFanOutKeyed(t *testing.T) {
+	ctx := context.Background()
+	type edit struct {
+		key string
+		seq int
+	}
+	type result struct {
+		edit
+		copy int64
+	}
+	var inputs []edit
+	for seq := 0; seq < 10; seq++ {
+		for k := 0; k < 5; k++ {
+			inputs = append(inputs, edit{fmt.Sprintf("k%d", k), seq})
+		}
+	}
+	var copies atomic.Int64
+	// Every copy takes a random while on each value, which would reorder
+	// the values of a key spread over several copies.
+	worker := func(ctx context.Context, in <-chan edit) <-chan result {
+		id := copies.Add(1)
+		r := rand.New(rand.NewSource(id))
+		return Map(ctx, in, func(u edit) result {
+			time.Sleep(time.Duration(r.Intn(500)) * time.Microsecond)
+			return result{u, id}
+		})
+	}
+	out := FanOutKeyed(ctx, Gen(ctx, inputs...), 3, func(u edit) string { return u.key }, worker)
+
+	last := make(map[string]int)
+	owner := make(map[string]int64)
+	used := make(map[int64]bool)
+	n := 0
+	for r := range out {
+		n++
+		if prev, ok := last[r.key]; ok && r.seq != prev+1 {
+			t.Errorf("%s: got edit %d after %d", r.key, r.seq, prev)
+		}
+		last[r.key] = r.seq
+		if o, ok := owner[r.key]; ok && o != r.copy {
+			t.Errorf("%s: handled by copies %d and %d", r.key, o, r.copy)
+		}
+		owner[r.key] = r.copy
+		used[r.copy] = true
+	}
+	if n != len(inputs) {
+		t.Errorf("got %d results, want %d", n, len(inputs))
+	}
+	if copies.Load() != 3 || len(used) < 2 {
+		t.Errorf("started %d copies and used %d, want the keys spread over 3", copies.Load(), len(used))
+	}
+}
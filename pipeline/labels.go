@@ -0,0 +1,23 @@
+package pipeline
+
+import (
+	"context"
+	"runtime/pprof"
+)
+
+// Labeled wraps stage so that the goroutines it starts carry the pprof label
+// "stage" set to name, and CPU and goroutine profiles of a busy pipeline
+// attribute their samples to the stage rather than to anonymous closures.
+// Labels are inherited by the goroutines a goroutine starts, so the
+// goroutines started by the stages of this package, and by stages built on
+// them, are all covered. The label is also set on the context passed to
+// stage, so that pprof.Do and the like can build on it.
+func Labeled[In, Out any](name string, stage Stage[In, Out]) Stage[In, Out] {
+	return func(ctx context.Context, in <-chan In) <-chan Out {
+		var out <-chan Out
+		pprof.Do(ctx, pprof.Labels("stage", name), func(ctx context.Context) {
+			out = stage(ctx, in)
+		})
+		return out
+	}
+}
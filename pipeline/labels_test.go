@@ -0,0 +1,89 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"runtime/pprof"
+	"sync"
+	"testing"
+)
+
+// labelOf returns a stage that passes its input on and records the "stage"
+// label of the context it is started with under key.
+func labelOf(mu *sync.Mutex, seen map[string]string, key string) Stage[int, int] {
+	return func(ctx context.Context, in <-chan int) <-chan int {
+		label, _ := pprof.Label(ctx, "stage")
+		mu.Lock()
+		seen[key] = label
+		mu.Unlock()
+		return Map(ctx, in, func(v int) int { return v })
+	}
+}
+
+func TestLabeled(t *testing.T) {
+	var mu sync.Mutex
+	seen := make(map[string]string)
+	ctx := context.Background()
+	for range Labeled("parse", labelOf(&mu, seen, "stage"))(ctx, Gen(ctx, 1, 2)) {
+	}
+	if seen["stage"] != "parse" {
+		t.Errorf("got label %q, want parse", seen["stage"])
+	}
+}
+
+func TestBuilderLabels(t *testing.T) {
+	var mu sync.Mutex
+	seen := make(map[string]string)
+	shared := FromValues(1, 2, 3).Then(labelOf(&mu, seen, "first"))
+	b := shared.As("parse").
+		FanOut(2).Then(labelOf(&mu, seen, "second")).As("fetch").Merge().
+		Then(labelOf(&mu, seen, "third"))
+	out, wait := b.Run(context.Background())
+	for range out {
+	}
+	if err := wait(); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"first": "parse", "second": "fetch", "third": "stage"}
+	for k, w := range want {
+		if seen[k] != w {
+			t.Errorf("%s stage: got label %q, want %q", k, seen[k], w)
+		}
+	}
+
+	// Naming the stage in one pipeline leaves the Builder it was added to
+	// unchanged.
+	out, wait = shared.Run(context.Background())
+	for range out {
+	}
+	if err := wait(); err != nil {
+		t.Fatal(err)
+	}
+	if seen["first"] != "stage" {
+		t.Errorf("shared stage: got label %q, want stage", seen["first"])
+	}
+}
+
+func TestBuilderLabelsGoroutines(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	b := FromValues(1).Then(func(ctx context.Context, in <-chan int) <-chan int {
+		return Map(ctx, in, func(v int) int {
+			close(started)
+			<-release
+			return v
+		})
+	}).As("slow")
+	out, wait := b.Run(context.Background())
+	<-started
+	var profile bytes.Buffer
+	pprof.Lookup("goroutine").WriteTo(&profile, 1)
+	close(release)
+	for range out {
+	}
+	if err := wait(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(profile.Bytes(), []byte(`"stage":"slow"`)) {
+		t.Errorf("no goroutine labelled with the stage name in the profile:\n%s", profile.Bytes())
+	}
+}
@@ -0,0 +1,23 @@
+package pipeline
+
+import (
+	"context"
+	"log/slog"
+)
+
+type loggerKey struct{}
+
+// WithLogger returns a copy of ctx that makes the stages started with it
+// report their lifecycle to l: stages starting and finishing, workers
+// panicking, pipelines being drained, cancelled or failing. Without a logger
+// attached, nothing is logged.
+func WithLogger(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// log reports an event to the logger attached to ctx, if any.
+func log(ctx context.Context, level slog.Level, msg string, args ...any) {
+	if l, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		l.Log(context.WithoutCancel(ctx), level, msg, args...)
+	}
+}
@@ -0,0 +1,102 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// logBuffer is an io.Writer collecting log lines, safe for concurrent use.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// withLog returns ctx with a logger writing every level to the returned
+// buffer, without timestamps.
+func withLog(ctx context.Context) (context.Context, *logBuffer) {
+	var b logBuffer
+	h := slog.NewTextHandler(&b, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+	return WithLogger(ctx, slog.New(h)), &b
+}
+
+// wantLines fails t unless every line in want appears in log.
+func wantLines(t *testing.T, log string, want ...string) {
+	t.Helper()
+	for _, w := range want {
+		if !strings.Contains(log, w+"\n") {
+			t.Errorf("log lacks %q:\n%s", w, log)
+		}
+	}
+}
+
+func TestLogBuilder(t *testing.T) {
+	ctx, b := withLog(context.Background())
+	m := NewMetrics()
+	pass := Instrument(m, "pass", func(ctx context.Context, in <-chan int) <-chan int { return in })
+	if _, err := runAll(t, ctx, FromValues(1, 2).Then(pass)); err != nil {
+		t.Fatal(err)
+	}
+	wantLines(t, b.String(),
+		`level=DEBUG msg="pipeline: started" stages=2`,
+		`level=DEBUG msg="pipeline: stage started" stage=pass`,
+		`level=DEBUG msg="pipeline: stage drained" stage=pass in=2 out=2`,
+		`level=DEBUG msg="pipeline: finished"`,
+	)
+
+	ctx, b = withLog(context.Background())
+	fail := func(context.Context, int) (int, error) { return 0, errors.New("boom") }
+	runAll(t, ctx, FromValues(1).Try(fail))
+	wantLines(t, b.String(), `level=ERROR msg="pipeline: failed" err=boom`)
+}
+
+func TestLogRecover(t *testing.T) {
+	ctx, b := withLog(context.Background())
+	Recover(func(context.Context, int) (int, error) { panic("oops") })(ctx, 1)
+	if log := b.String(); !strings.Contains(log, `level=ERROR msg="pipeline: worker panicked" panic=oops stack=`) {
+		t.Errorf("panic not logged:\n%s", log)
+	}
+}
+
+func TestLogShutdown(t *testing.T) {
+	ctx, b := withLog(context.Background())
+	s, ctx := NewShutdown(ctx)
+	out := Output(s, Input(s, Gen(ctx, 1)))
+	go func() {
+		for range out {
+		}
+	}()
+	if err := s.Drain(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	log := b.String()
+	wantLines(t, log, `level=INFO msg="pipeline: draining" timeout=1s`)
+	if !strings.Contains(log, `level=INFO msg="pipeline: drained" took=`) {
+		t.Errorf("log lacks the end of the drain:\n%s", log)
+	}
+}
@@ -0,0 +1,21 @@
+package pipeline
+
+import "context"
+
+// Map applies fn to every value received from in and sends the results on
+// the returned channel. Map is a single worker; start several over the same
+// input channel and Merge their outputs to fan the work out.
+func Map[In, Out any](ctx context.Context, in <-chan In, fn func(In) Out) <-chan Out {
+	out := make(chan Out)
+	go func() {
+		defer close(out)
+		for v := range in {
+			select {
+			case out <- fn(v):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,29 @@
+package pipeline
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestGenMap(t *testing.T) {
+	ctx := context.Background()
+	out := Map(ctx, Gen(ctx, 1, 2, 3), strconv.Itoa)
+	if got := collect(t, out); !reflect.DeepEqual(got, []string{"1", "2", "3"}) {
+		t.Errorf("got %q, want [1 2 3] in order", got)
+	}
+}
+
+func TestGenMapCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	out := Map(ctx, Gen(ctx, 1, 2, 3), func(v int) int { return v * v })
+	if v := <-out; v != 1 {
+		t.Fatalf("got %d, want 1", v)
+	}
+	// Nobody receives the rest, so both stages are stuck sending until
+	// the context is cancelled; then they give up and close their outputs
+	// without waiting for the input to be exhausted.
+	cancel()
+	collect(t, out)
+}
@@ -0,0 +1,202 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// ErrOverCapacity is returned when admitting an item would take the memory
+// held by the items in flight beyond a MemoryBudget's limit.
+var ErrOverCapacity = errors.New("pipeline: over memory capacity")
+
+// A MemoryBudget caps the approximate amount of memory held by the items in
+// flight in a pipeline. Items are admitted at the source with Admit or
+// TryAdmit and released at the sink with Release, so that a pipeline that
+// falls behind fails fast, or slows its intake, instead of growing until the
+// process is killed for running out of memory.
+type MemoryBudget struct {
+	limit int64
+
+	mu   sync.Mutex
+	used int64
+	// freed is closed and replaced whenever memory is released, waking the
+	// callers waiting in Admit.
+	freed chan struct{}
+}
+
+// NewMemoryBudget returns a MemoryBudget of limit bytes.
+func NewMemoryBudget(limit int64) *MemoryBudget {
+	return &MemoryBudget{limit: limit, freed: make(chan struct{})}
+}
+
+// TryAdmit accounts for n more bytes in flight, or returns ErrOverCapacity if
+// that would exceed the limit.
+func (m *MemoryBudget) TryAdmit(n int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.used+n > m.limit {
+		return ErrOverCapacity
+	}
+	m.used += n
+	return nil
+}
+
+// Admit accounts for n more bytes in flight, waiting for memory to be
+// released if that would exceed the limit. It returns ErrOverCapacity straight
+// away if n alone exceeds the limit, and the context's error if the context is
+// cancelled while waiting.
+func (m *MemoryBudget) Admit(ctx context.Context, n int64) error {
+	if n > m.limit {
+		return ErrOverCapacity
+	}
+	for {
+		m.mu.Lock()
+		if m.used+n <= m.limit {
+			m.used += n
+			m.mu.Unlock()
+			return nil
+		}
+		freed := m.freed
+		m.mu.Unlock()
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Release accounts for n bytes no longer in flight.
+func (m *MemoryBudget) Release(n int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.used -= n
+	if m.used < 0 {
+		panic(fmt.Sprintf("pipeline: released %d bytes more than admitted", -m.used))
+	}
+	close(m.freed)
+	m.freed = make(chan struct{})
+}
+
+// InUse returns the number of bytes currently accounted for.
+func (m *MemoryBudget) InUse() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.used
+}
+
+// AdmitMemory passes on the items received from in after admitting their size,
+// as reported by sizeOf, to m. If wait is true an item that does not fit waits
+// for memory to be released; otherwise AdmitMemory stops, closes its output
+// and reports ErrOverCapacity on the error channel. Whoever consumes the items
+// at the end of the pipeline must call m.Release with the same size.
+//
+// SizeOf can be used as sizeOf when items have no cheaper way of telling
+// their size.
+func AdmitMemory[T any](ctx context.Context, in <-chan T, m *MemoryBudget, sizeOf func(T) int64, wait bool) (<-chan T, <-chan error) {
+	out := make(chan T)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case v, ok := <-in:
+				if !ok {
+					errc <- nil
+					return
+				}
+				n := sizeOf(v)
+				var err error
+				if wait {
+					err = m.Admit(ctx, n)
+				} else {
+					err = m.TryAdmit(n)
+				}
+				if err != nil {
+					errc <- err
+					return
+				}
+				select {
+				case out <- v:
+				case <-ctx.Done():
+					m.Release(n)
+					errc <- ctx.Err()
+					return
+				}
+			case <-ctx.Done():
+				errc <- ctx.Err()
+				return
+			}
+		}
+	}()
+	return out, errc
+}
+
+// SizeOf approximates the memory held by v in bytes: the size of the value
+// itself plus whatever it references through pointers, slices, strings, maps
+// and interfaces. Memory reachable along several paths is counted once per
+// pointer but may be counted more than once through slices and strings that
+// share backing arrays. It relies on reflection, so types that know their own
+// size should report it directly instead.
+func SizeOf[T any](v T) int64 {
+	rv := reflect.ValueOf(&v).Elem()
+	return int64(rv.Type().Size()) + sizeOfRefs(rv, make(map[uintptr]bool))
+}
+
+// sizeOfRefs returns the size of the memory referenced by v, excluding v
+// itself.
+func sizeOfRefs(v reflect.Value, seen map[uintptr]bool) int64 {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() || seen[v.Pointer()] {
+			return 0
+		}
+		seen[v.Pointer()] = true
+		e := v.Elem()
+		return int64(e.Type().Size()) + sizeOfRefs(e, seen)
+	case reflect.Interface:
+		if v.IsNil() {
+			return 0
+		}
+		e := v.Elem()
+		return int64(e.Type().Size()) + sizeOfRefs(e, seen)
+	case reflect.String:
+		return int64(v.Len())
+	case reflect.Slice:
+		if v.IsNil() {
+			return 0
+		}
+		n := int64(v.Cap()) * int64(v.Type().Elem().Size())
+		for i := 0; i < v.Len(); i++ {
+			n += sizeOfRefs(v.Index(i), seen)
+		}
+		return n
+	case reflect.Array:
+		var n int64
+		for i := 0; i < v.Len(); i++ {
+			n += sizeOfRefs(v.Index(i), seen)
+		}
+		return n
+	case reflect.Struct:
+		var n int64
+		for i := 0; i < v.NumField(); i++ {
+			n += sizeOfRefs(v.Field(i), seen)
+		}
+		return n
+	case reflect.Map:
+		if v.IsNil() {
+			return 0
+		}
+		kt, vt := v.Type().Key(), v.Type().Elem()
+		n := int64(v.Len()) * int64(kt.Size()+vt.Size())
+		it := v.MapRange()
+		for it.Next() {
+			n += sizeOfRefs(it.Key(), seen) + sizeOfRefs(it.Value(), seen)
+		}
+		return n
+	}
+	return 0
+}
@@ -0,0 +1,108 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func TestMemoryBudget(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryBudget(100)
+	if err := m.TryAdmit(60); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.TryAdmit(50); !errors.Is(err, ErrOverCapacity) {
+		t.Fatalf("admitted beyond the limit: %v", err)
+	}
+	if err := m.Admit(ctx, 101); !errors.Is(err, ErrOverCapacity) {
+		t.Fatalf("waiting for more than the limit: %v", err)
+	}
+
+	// Admit waits until enough has been released.
+	admitted := make(chan error)
+	go func() { admitted <- m.Admit(ctx, 50) }()
+	select {
+	case err := <-admitted:
+		t.Fatalf("admitted beyond the limit: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	m.Release(5)
+	select {
+	case err := <-admitted:
+		t.Fatalf("admitted with only 45 bytes free: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	m.Release(5)
+	if err := <-admitted; err != nil {
+		t.Fatal(err)
+	}
+	if got := m.InUse(); got != 100 {
+		t.Errorf("in use %d, want 100", got)
+	}
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := m.Admit(cctx, 1); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+	mustPanic(t, "releasing more than admitted", func() { m.Release(101) })
+}
+
+func TestAdmitMemoryFailsFast(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryBudget(10)
+	size := func(s string) int64 { return int64(len(s)) }
+	out, errc := AdmitMemory(ctx, Gen(ctx, "12345", "123456", "1"), m, size, false)
+	// Nobody releases the first item, so the second does not fit.
+	if got := collect(t, out); len(got) != 1 || got[0] != "12345" {
+		t.Errorf("got %v, want only the item that fit", got)
+	}
+	if err := <-errc; !errors.Is(err, ErrOverCapacity) {
+		t.Errorf("got %v, want ErrOverCapacity", err)
+	}
+}
+
+func TestAdmitMemoryWaits(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryBudget(10)
+	size := func(s string) int64 { return int64(len(s)) }
+	out, errc := AdmitMemory(ctx, Gen(ctx, "12345", "123456", "1"), m, size, true)
+	var got []string
+	for v := range out {
+		got = append(got, v)
+		m.Release(size(v))
+	}
+	if len(got) != 3 {
+		t.Errorf("got %v, want every item once released", got)
+	}
+	if err := <-errc; err != nil {
+		t.Errorf("got %v at the end of the input", err)
+	}
+}
+
+func TestSizeOf(t *testing.T) {
+	type item struct {
+		Name string
+		Tags []string
+		Next *item
+	}
+	shared := &item{Name: "shared"}
+	v := item{Name: "abcd", Tags: []string{"x", "yz"}, Next: shared}
+	str := int64(unsafe.Sizeof(""))
+	itemSize := int64(unsafe.Sizeof(item{}))
+	want := itemSize + 4 + // the item and its name
+		2*str + 3 + // the tags
+		itemSize + 6 // the item pointed to
+	if got := SizeOf(v); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	// A cycle through pointers is counted once.
+	shared.Next = shared
+	if got := SizeOf(shared); got != int64(unsafe.Sizeof(shared))+itemSize+6 {
+		t.Errorf("cycle: got %d, want %d", got, int64(unsafe.Sizeof(shared))+itemSize+6)
+	}
+}
@@ -0,0 +1,208 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+)
+
+// mergeDynamicAbove is the number of input channels above which Merge
+// switches from a goroutine per channel to MergeDynamic. On a steady stream
+// both take about 1µs per item at any number of channels, according to
+// BenchmarkMerge and BenchmarkMergeDynamic, since MergeDynamic mostly finds a
+// value ready without waiting. When values are sparse, however, every one
+// costs MergeDynamic a reflect.Select over all of the channels:
+// BenchmarkMergeDynamicIdle spends up to 4µs per channel to see up to 1024 of
+// them closed, and 8µs at 4096, against 2µs for a goroutine per channel. What
+// a goroutine per channel costs instead is its stack, about 4KB per channel
+// according to BenchmarkMergeIdle, which at 4096 channels adds up to 16MB.
+const mergeDynamicAbove = 4096
+
+// Merge sends every value received from any of cs on a single channel, which
+// is closed once all of cs have been closed. Values from different channels
+// are interleaved in no particular order.
+//
+// Merge picks between two strategies depending on the number of channels: a
+// goroutine per channel, which is the fastest, and MergeDynamic for very
+// large numbers of channels, where one goroutine per channel would cost more
+// memory than it is worth.
+func Merge[T any](ctx context.Context, cs ...<-chan T) <-chan T {
+	if len(cs) > mergeDynamicAbove {
+		return MergeDynamic(ctx, cs...)
+	}
+	return mergeEach(ctx, cs...)
+}
+
+// mergeEach merges cs by starting a goroutine for each of them.
+func mergeEach[T any](ctx context.Context, cs ...<-chan T) <-chan T {
+	var wg sync.WaitGroup
+	out := make(chan T)
+
+	// Start an output goroutine for each input channel in cs. output copies
+	// values from c to out until c is closed, then calls wg.Done.
+	output := func(c <-chan T) {
+		defer wg.Done()
+		for v := range c {
+			select {
+			case out <- v:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+
+	wg.Add(len(cs))
+	for _, c := range cs {
+		go output(c)
+	}
+
+	// Start a goroutine to close out once all the output goroutines are
+	// done. This must start after the wg.Add call.
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+// MergeDynamic merges cs like Merge, but from a single goroutine. It takes the
+// values that are ready with plain non-blocking receives, in turn from each
+// channel, and only when none is ready waits on all of them at once using
+// reflect.Select. It trades per-item speed for a constant number of
+// goroutines, which pays off when merging a very large number of mostly idle
+// channels.
+func MergeDynamic[T any](ctx context.Context, cs ...<-chan T) <-chan T {
+	return MergeRoundRobin(ctx, cs...)
+}
+
+// A Merger merges a set of channels that can change while it runs, so that
+// sources discovered during execution can be merged into a pipeline that is
+// already consuming its output.
+type Merger[T any] struct {
+	ctx     context.Context
+	out     chan T
+	closing chan struct{}
+	once    sync.Once
+	wg      sync.WaitGroup
+
+	mu     sync.Mutex
+	inputs map[<-chan T]*mergerInput
+	closed bool
+}
+
+// mergerInput is the entry of a channel being merged. Its address identifies
+// the goroutine reading the channel, so that a goroutine that is still winding
+// down after its channel was removed cannot drop the entry of a goroutine
+// started for the same channel by a later Add.
+type mergerInput struct {
+	cancel context.CancelFunc
+}
+
+// NewMerger returns a Merger that starts out merging cs. Unlike with Merge,
+// its output channel stays open when all of the channels merged so far have
+// been closed, since more may still be added; it is closed once Close has been
+// called and every remaining channel has been closed or removed, or once the
+// context is cancelled.
+func NewMerger[T any](ctx context.Context, cs ...<-chan T) *Merger[T] {
+	m := &Merger[T]{
+		ctx:     ctx,
+		out:     make(chan T),
+		closing: make(chan struct{}),
+		inputs:  make(map[<-chan T]*mergerInput),
+	}
+	for _, c := range cs {
+		m.Add(c)
+	}
+	go func() {
+		select {
+		case <-m.closing:
+		case <-ctx.Done():
+		}
+		// No channel can be added from now on, so it is safe to wait.
+		m.mu.Lock()
+		m.closed = true
+		m.mu.Unlock()
+		m.wg.Wait()
+		close(m.out)
+	}()
+	return m
+}
+
+// Out returns the channel on which the merged values are sent.
+func (m *Merger[T]) Out() <-chan T {
+	return m.out
+}
+
+// Add starts merging c. Adding a channel that is already being merged has no
+// effect, and neither does adding one after the context has been cancelled.
+// Add panics if it is called after Close.
+func (m *Merger[T]) Add(c <-chan T) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.ctx.Err() != nil {
+		return
+	}
+	if m.closed {
+		panic("pipeline: Add called on closed Merger")
+	}
+	if _, ok := m.inputs[c]; ok {
+		return
+	}
+	ctx, cancel := context.WithCancel(m.ctx)
+	in := &mergerInput{cancel}
+	m.inputs[c] = in
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer m.forget(c, in)
+		for {
+			select {
+			case v, ok := <-c:
+				if !ok {
+					return
+				}
+				// A value received before c was removed is still
+				// delivered, so only the outer context aborts the send.
+				select {
+				case m.out <- v:
+				case <-m.ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Remove stops merging c without waiting for it to be closed. Values that
+// have already been received from c are still delivered.
+func (m *Merger[T]) Remove(c <-chan T) {
+	m.forget(c, nil)
+}
+
+// forget stops the goroutine reading c and drops c from the set of inputs,
+// provided c is still merged through the entry in, or through any entry if in
+// is nil.
+func (m *Merger[T]) forget(c <-chan T, in *mergerInput) {
+	m.mu.Lock()
+	cur, ok := m.inputs[c]
+	ok = ok && (in == nil || cur == in)
+	if ok {
+		delete(m.inputs, c)
+	}
+	m.mu.Unlock()
+	if ok {
+		cur.cancel()
+	}
+}
+
+// Close tells the Merger that no more channels will be added. The output
+// channel is closed once the channels still being merged are done.
+func (m *Merger[T]) Close() {
+	// Mark m closed here rather than leaving it to the goroutine waiting
+	// for closing, so that an Add right after Close reliably panics.
+	m.mu.Lock()
+	m.closed = true
+	m.mu.Unlock()
+	m.once.Do(func() { close(m.closing) })
+}
@@ -0,0 +1,142 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestMergerRemoveThenAdd(t *testing.T) {
+	m := NewMerger[int](context.Background())
+	c := make(chan int)
+	m.Add(c)
+	// Keep the first reader of c busy sending 1, so that it is still
+	// running when c is removed and added again.
+	c <- 1
+	m.Remove(c)
+	m.Add(c)
+	busy := runtime.NumGoroutine()
+	if v := <-m.Out(); v != 1 {
+		t.Fatalf("got %d, want 1", v)
+	}
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() >= busy {
+		if time.Now().After(deadline) {
+			t.Fatal("first reader of c did not exit")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// The first reader must have left the second one alone.
+	go func() { c <- 2 }()
+	select {
+	case v := <-m.Out():
+		if v != 2 {
+			t.Fatalf("got %d, want 2", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("c is no longer merged after being added again")
+	}
+	m.Remove(c)
+	m.Close()
+	select {
+	case _, ok := <-m.Out():
+		if ok {
+			t.Fatal("got a value after Remove")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("output not closed after Remove and Close")
+	}
+}
+
+// benchmarkMerge measures the time taken per item by merge on a steady stream
+// spread evenly over n channels.
+func benchmarkMerge(b *testing.B, merge func(context.Context, ...<-chan int) <-chan int) {
+	for _, n := range []int{1, 4, 16, 64, 256, 1024} {
+		b.Run(fmt.Sprintf("channels=%d", n), func(b *testing.B) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			cs := make([]<-chan int, n)
+			for i := range cs {
+				c := make(chan int)
+				cs[i] = c
+				count := b.N / n
+				if i < b.N%n {
+					count++
+				}
+				go func() {
+					defer close(c)
+					for j := 0; j < count; j++ {
+						c <- j
+					}
+				}()
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for range merge(ctx, cs...) {
+			}
+		})
+	}
+}
+
+func BenchmarkMerge(b *testing.B) {
+	benchmarkMerge(b, mergeEach[int])
+}
+
+func BenchmarkMergeDynamic(b *testing.B) {
+	benchmarkMerge(b, MergeDynamic[int])
+}
+
+// benchmarkMergeIdle measures the memory held by merge for n channels that
+// stay idle, reported as stack bytes per channel.
+func benchmarkMergeIdle(b *testing.B, merge func(context.Context, ...<-chan int) <-chan int) {
+	for _, n := range []int{256, 1024, 4096} {
+		b.Run(fmt.Sprintf("channels=%d", n), func(b *testing.B) {
+			var stack uint64
+			for i := 0; i < b.N; i++ {
+				chans := make([]chan int, n)
+				cs := make([]<-chan int, n)
+				for i := range cs {
+					chans[i] = make(chan int)
+					cs[i] = chans[i]
+				}
+				// Collect first, so that the stacks freed by earlier
+				// iterations are not reused unnoticed.
+				runtime.GC()
+				var before, after runtime.MemStats
+				runtime.ReadMemStats(&before)
+				out := merge(context.Background(), cs...)
+				runtime.Gosched()
+				runtime.ReadMemStats(&after)
+				stack += after.StackInuse - before.StackInuse
+				// Merge returns once its inputs are closed, as they
+				// are by the stages feeding it.
+				for _, c := range chans {
+					close(c)
+				}
+				for range out {
+				}
+			}
+			b.ReportMetric(float64(stack)/float64(b.N)/float64(n), "stack-B/chan")
+		})
+	}
+}
+
+func BenchmarkMergeIdle(b *testing.B) {
+	benchmarkMergeIdle(b, mergeEach[int])
+}
+
+func BenchmarkMergeDynamicIdle(b *testing.B) {
+	benchmarkMergeIdle(b, MergeDynamic[int])
+}
+
+func TestMergerAddAfterClose(t *testing.T) {
+	m := NewMerger[int](context.Background())
+	m.Close()
+	mustPanic(t, "Add after Close", func() { m.Add(make(chan int)) })
+	if _, ok := <-m.Out(); ok {
+		t.Error("output not closed")
+	}
+}
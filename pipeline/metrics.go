@@ -0,0 +1,329 @@
+package pipeline
+
+import (
+	"context"
+	"log/slog"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LatencyBuckets are the upper bounds of the buckets in which Metrics counts
+// the time taken by every item.
+var LatencyBuckets = []time.Duration{
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// Metrics collects counters for the stages of a pipeline, to show where it is
+// bottlenecked. Stages report to it by being wrapped with Instrument or, for
+// per-item latency, by having their work function wrapped with
+// InstrumentFunc, under a name that identifies the stage. Stages may share a
+// name, in which case their counters are added up.
+type Metrics struct {
+	// Started is the time the Metrics were created.
+	Started time.Time
+
+	// allocEvery is the interval between the calls whose allocations are
+	// measured, set by SampleAllocs.
+	allocEvery atomic.Int64
+
+	mu     sync.Mutex
+	stages map[string]*stageMetrics
+}
+
+// recentErrors is the number of errors StageStats.RecentErrors keeps.
+const recentErrors = 10
+
+// A StageError is an error returned by the work function of a stage.
+type StageError struct {
+	Time time.Time
+	Err  error
+}
+
+// stageMetrics are the counters of a single stage.
+type stageMetrics struct {
+	in, out, errors atomic.Int64
+	active          atomic.Int64
+	busy            atomic.Int64 // nanoseconds spent on items
+	queued          atomic.Int64 // nanoseconds items waited for the stage
+	calls           atomic.Int64 // calls of the work function started
+	// sampled counts the calls whose allocations were measured, and
+	// allocs and allocBytes the objects and bytes they allocated.
+	sampled, allocs, allocBytes atomic.Int64
+
+	mu      sync.Mutex
+	buckets []int64 // one per LatencyBuckets, and one for the rest
+	max     time.Duration
+	waits   []int64      // like buckets, for the time items waited
+	recent  []StageError // the latest errors, oldest first
+}
+
+// StageStats is a snapshot of the counters of a stage.
+type StageStats struct {
+	Name string
+	// In and Out are the number of items the stage has received and sent.
+	In, Out int64
+	// Errors is the number of items the stage's work function failed on.
+	Errors int64
+	// Active is the number of items being worked on right now, which is
+	// the number of workers that are busy.
+	Active int64
+	// Busy is the total time spent working on items.
+	Busy time.Duration
+	// Latency counts the items by the time taken on them: Latency[i] is
+	// the number that took at most LatencyBuckets[i], and the last entry
+	// the number that took longer than all of them.
+	Latency []int64
+	// MaxLatency is the longest time taken on a single item.
+	MaxLatency time.Duration
+	// Queued is the total time items waited before the stage started on
+	// them, and QueueLatency counts the items by that time, in the same
+	// buckets as Latency. They are only recorded by InstrumentEnvelope.
+	// A stage whose items wait much longer than they take to process is
+	// short of workers rather than slow.
+	Queued       time.Duration
+	QueueLatency []int64
+	// RecentErrors are the last few errors of the work function, oldest
+	// first.
+	RecentErrors []StageError
+	// AllocSamples is the number of calls of the work function whose heap
+	// allocations were measured, as enabled by Metrics.SampleAllocs, and
+	// Allocs and AllocBytes the number of objects and bytes they allocated.
+	AllocSamples, Allocs, AllocBytes int64
+}
+
+// AllocsPerOp returns the average number of heap allocations made by a call of
+// the stage's work function, or zero if none was measured.
+func (s StageStats) AllocsPerOp() float64 {
+	if s.AllocSamples == 0 {
+		return 0
+	}
+	return float64(s.Allocs) / float64(s.AllocSamples)
+}
+
+// BytesPerOp returns the average number of bytes allocated on the heap by a
+// call of the stage's work function, or zero if none was measured.
+func (s StageStats) BytesPerOp() float64 {
+	if s.AllocSamples == 0 {
+		return 0
+	}
+	return float64(s.AllocBytes) / float64(s.AllocSamples)
+}
+
+// Backlog returns the number of items received by the stage but not yet sent
+// on, which for a stage emitting one item per input is the number queued in
+// or being worked on by it.
+func (s StageStats) Backlog() int64 {
+	return s.In - s.Out
+}
+
+// NewMetrics returns an empty Metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{Started: time.Now(), stages: make(map[string]*stageMetrics)}
+}
+
+func (m *Metrics) stage(name string) *stageMetrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.stages[name]
+	if !ok {
+		s = &stageMetrics{
+			buckets: make([]int64, len(LatencyBuckets)+1),
+			waits:   make([]int64, len(LatencyBuckets)+1),
+		}
+		m.stages[name] = s
+	}
+	return s
+}
+
+// SampleAllocs makes the work functions wrapped with InstrumentFunc measure the
+// heap allocations of every every-th call, which StageStats reports per
+// stage, so as to show which stage generates the most garbage. A measurement
+// briefly stops the world and counts the allocations of the whole program
+// during the call, including those of other goroutines, so sample sparingly
+// and take the figures as an upper bound. An every below 1 stops sampling,
+// which is the default.
+func (m *Metrics) SampleAllocs(every int) {
+	if every < 0 {
+		every = 0
+	}
+	m.allocEvery.Store(int64(every))
+}
+
+// Metrics returns a snapshot of the counters of every stage, sorted by name.
+func (m *Metrics) Metrics() []StageStats {
+	m.mu.Lock()
+	names := make([]string, 0, len(m.stages))
+	for name := range m.stages {
+		names = append(names, name)
+	}
+	m.mu.Unlock()
+	sort.Strings(names)
+
+	stats := make([]StageStats, len(names))
+	for i, name := range names {
+		s := m.stage(name)
+		stats[i] = StageStats{
+			Name:   name,
+			In:     s.in.Load(),
+			Out:    s.out.Load(),
+			Errors: s.errors.Load(),
+			Active: s.active.Load(),
+			Busy:   time.Duration(s.busy.Load()),
+			Queued: time.Duration(s.queued.Load()),
+
+			AllocSamples: s.sampled.Load(),
+			Allocs:       s.allocs.Load(),
+			AllocBytes:   s.allocBytes.Load(),
+		}
+		s.mu.Lock()
+		stats[i].Latency = append([]int64(nil), s.buckets...)
+		stats[i].MaxLatency = s.max
+		stats[i].QueueLatency = append([]int64(nil), s.waits...)
+		stats[i].RecentErrors = append([]StageError(nil), s.recent...)
+		s.mu.Unlock()
+	}
+	return stats
+}
+
+// observe records an item that took d.
+func (s *stageMetrics) observe(d time.Duration) {
+	s.busy.Add(int64(d))
+	i := sort.Search(len(LatencyBuckets), func(i int) bool { return d <= LatencyBuckets[i] })
+	s.mu.Lock()
+	s.buckets[i]++
+	if d > s.max {
+		s.max = d
+	}
+	s.mu.Unlock()
+}
+
+// wait records an item that waited d before the stage started on it.
+func (s *stageMetrics) wait(d time.Duration) {
+	s.queued.Add(int64(d))
+	i := sort.Search(len(LatencyBuckets), func(i int) bool { return d <= LatencyBuckets[i] })
+	s.mu.Lock()
+	s.waits[i]++
+	s.mu.Unlock()
+}
+
+// measureAllocs calls f and records the heap allocations made meanwhile.
+func (s *stageMetrics) measureAllocs(f func()) {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	f()
+	runtime.ReadMemStats(&after)
+	s.sampled.Add(1)
+	s.allocs.Add(int64(after.Mallocs - before.Mallocs))
+	s.allocBytes.Add(int64(after.TotalAlloc - before.TotalAlloc))
+}
+
+// fail records an error of the work function.
+func (s *stageMetrics) fail(err error) {
+	s.errors.Add(1)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.recent) == recentErrors {
+		copy(s.recent, s.recent[1:])
+		s.recent = s.recent[:recentErrors-1]
+	}
+	s.recent = append(s.recent, StageError{time.Now(), err})
+}
+
+// Instrument wraps stage so that the items it receives and sends are counted
+// in m under name. Its goroutines are labelled with name, as by Labeled.
+func Instrument[In, Out any](m *Metrics, name string, stage Stage[In, Out]) Stage[In, Out] {
+	s := m.stage(name)
+	return Labeled(name, func(ctx context.Context, in <-chan In) <-chan Out {
+		counted := make(chan In)
+		go func() {
+			defer close(counted)
+			for v := range in {
+				s.in.Add(1)
+				select {
+				case counted <- v:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		log(ctx, slog.LevelDebug, "pipeline: stage started", "stage", name)
+		c := stage(ctx, counted)
+		out := make(chan Out)
+		go func() {
+			defer close(out)
+			for v := range c {
+				select {
+				case out <- v:
+					s.out.Add(1)
+				case <-ctx.Done():
+					log(ctx, slog.LevelDebug, "pipeline: stage cancelled", "stage", name, "err", ctx.Err())
+					return
+				}
+			}
+			log(ctx, slog.LevelDebug, "pipeline: stage drained", "stage", name, "in", s.in.Load(), "out", s.out.Load())
+		}()
+		return out
+	})
+}
+
+// InstrumentFunc wraps fn so that the time it takes on every item, the items
+// it is working on and the errors it returns are counted in m under name. It
+// suits the work functions of TryMap, Retry and the like; combine it with
+// Instrument on the stage to count items in and out as well.
+func InstrumentFunc[In, Out any](m *Metrics, name string, fn func(context.Context, In) (Out, error)) func(context.Context, In) (Out, error) {
+	s := m.stage(name)
+	return func(ctx context.Context, v In) (Out, error) {
+		s.active.Add(1)
+		defer s.active.Add(-1)
+		var (
+			out Out
+			err error
+		)
+		call := func() {
+			start := time.Now()
+			out, err = fn(ctx, v)
+			s.observe(time.Since(start))
+		}
+		if every := m.allocEvery.Load(); every > 0 && s.calls.Add(1)%every == 0 {
+			s.measureAllocs(call)
+		} else {
+			call()
+		}
+		if err != nil {
+			s.fail(err)
+		}
+		return out, err
+	}
+}
+
+// InstrumentEnvelope wraps fn like InstrumentFunc and also records in m how
+// long every envelope waited before fn was called on it: since it left the
+// previous stage wrapped with InstrumentEnvelope or, for the first such stage,
+// since it entered the pipeline. Comparing this queue latency with the time
+// fn takes tells a stage that has too few workers from one whose work is
+// slow.
+func InstrumentEnvelope[In, Out any](m *Metrics, name string, fn func(context.Context, Envelope[In]) (Envelope[Out], error)) func(context.Context, Envelope[In]) (Envelope[Out], error) {
+	s := m.stage(name)
+	fn = InstrumentFunc(m, name, fn)
+	return func(ctx context.Context, e Envelope[In]) (Envelope[Out], error) {
+		if d, ok := e.waited(); ok {
+			s.wait(d)
+		}
+		out, err := fn(ctx, e)
+		out.handed = time.Now()
+		return out, err
+	}
+}
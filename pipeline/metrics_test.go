@@ -0,0 +1,183 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestInstrument(t *testing.T) {
+	m := NewMetrics()
+	ctx := context.Background()
+	evens := func(ctx context.Context, in <-chan int) <-chan int {
+		return Filter(ctx, in, func(v int) bool { return v%2 == 0 })
+	}
+	// Two copies under one name add up.
+	for i := 0; i < 2; i++ {
+		collect(t, Instrument(m, "evens", evens)(ctx, Gen(ctx, 1, 2, 3)))
+	}
+	s := m.Metrics()[0]
+	if s.Name != "evens" || s.In != 6 || s.Out != 2 || s.Backlog() != 4 {
+		t.Errorf("got %+v, want 6 in and 2 out", s)
+	}
+}
+
+func TestInstrumentFunc(t *testing.T) {
+	m := NewMetrics()
+	ctx := context.Background()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	fn := InstrumentFunc(m, "work", func(_ context.Context, v int) (int, error) {
+		if v == 0 {
+			close(started)
+			<-release
+		}
+		if v < 0 {
+			return 0, fmt.Errorf("negative: %d", v)
+		}
+		return v, nil
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn(ctx, 0)
+	}()
+	<-started
+	if got := m.Metrics()[0].Active; got != 1 {
+		t.Errorf("%d active while working on an item, want 1", got)
+	}
+	time.Sleep(30 * time.Millisecond)
+	close(release)
+	<-done
+
+	for i := 1; i <= recentErrors+2; i++ {
+		fn(ctx, -i)
+	}
+	s := m.Metrics()[0]
+	if s.Active != 0 {
+		t.Errorf("%d active when idle, want 0", s.Active)
+	}
+	if s.Errors != recentErrors+2 {
+		t.Errorf("counted %d errors, want %d", s.Errors, recentErrors+2)
+	}
+	// Only the latest errors are kept.
+	if n := len(s.RecentErrors); n != recentErrors {
+		t.Fatalf("kept %d errors, want %d", n, recentErrors)
+	}
+	if got := s.RecentErrors[0].Err.Error(); got != "negative: -3" {
+		t.Errorf("oldest kept error is %q, want negative: -3", got)
+	}
+	if got := s.RecentErrors[recentErrors-1].Err.Error(); got != "negative: -12" {
+		t.Errorf("latest error is %q, want negative: -12", got)
+	}
+	// The fast items are in the first bucket, the slow one past it.
+	var total int64
+	for _, n := range s.Latency {
+		total += n
+	}
+	if s.Latency[0] != recentErrors+2 || total != recentErrors+3 {
+		t.Errorf("got latency buckets %v, want one slow item and the rest fast", s.Latency)
+	}
+	if s.MaxLatency < 30*time.Millisecond || s.Busy < s.MaxLatency {
+		t.Errorf("got max latency %v and busy %v, want at least 30ms", s.MaxLatency, s.Busy)
+	}
+}
+
+func TestInstrumentEnvelope(t *testing.T) {
+	m := NewMetrics()
+	double := func(_ context.Context, e Envelope[int]) (Envelope[int], error) {
+		return Rewrap(e, e.Item*2), nil
+	}
+	first := InstrumentEnvelope(m, "first", double)
+	second := InstrumentEnvelope(m, "second", double)
+
+	// The first stage sees the time since the item entered the pipeline,
+	// the second only the time since the first handed it over.
+	e := Envelope[int]{Item: 1, Ingested: time.Now().Add(-2 * time.Second)}
+	e, err := first(context.Background(), e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, err = second(context.Background(), e); err != nil || e.Item != 4 {
+		t.Fatalf("got %d, %v, want 4, nil", e.Item, err)
+	}
+
+	stats := m.Metrics()
+	if got := stats[0].Queued; got < 2*time.Second || got > 3*time.Second {
+		t.Errorf("first stage queued %v, want about 2s", got)
+	}
+	if got := stats[0].QueueLatency[8]; got != 1 {
+		t.Errorf("first stage has %d items in the 2.5s bucket, want 1: %v", got, stats[0].QueueLatency)
+	}
+	if got := stats[1].Queued; got > LatencyBuckets[0] {
+		t.Errorf("second stage queued %v, want less than %v", got, LatencyBuckets[0])
+	}
+	if got := stats[1].QueueLatency[0]; got != 1 {
+		t.Errorf("second stage has %d items in the first bucket, want 1: %v", got, stats[1].QueueLatency)
+	}
+	for _, s := range stats {
+		if s.Latency[0] != 1 {
+			t.Errorf("%s: processing latency %v, want one fast item", s.Name, s.Latency)
+		}
+	}
+}
+
+func TestInstrumentEnvelopeWithoutTimestamps(t *testing.T) {
+	m := NewMetrics()
+	fn := InstrumentEnvelope(m, "stage", func(_ context.Context, e Envelope[int]) (Envelope[int], error) {
+		return e, nil
+	})
+	if _, err := fn(context.Background(), Envelope[int]{Item: 1}); err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range m.Metrics()[0].QueueLatency {
+		if n != 0 {
+			t.Fatalf("recorded the wait of an envelope that never entered the pipeline: %v", m.Metrics()[0].QueueLatency)
+		}
+	}
+}
+
+var sink []*[64]byte
+
+func TestSampleAllocs(t *testing.T) {
+	m := NewMetrics()
+	m.SampleAllocs(2)
+	ctx := context.Background()
+	garbage := InstrumentFunc(m, "garbage", func(_ context.Context, n int) (int, error) {
+		for i := 0; i < n; i++ {
+			sink = append(sink, new([64]byte))
+		}
+		sink = nil
+		return n, nil
+	})
+	clean := InstrumentFunc(m, "clean", func(_ context.Context, n int) (int, error) {
+		return n + 1, nil
+	})
+	for i := 0; i < 10; i++ {
+		garbage(ctx, 100)
+		clean(ctx, i)
+	}
+
+	stats := m.Metrics()
+	c, g := stats[0], stats[1]
+	if g.AllocSamples != 5 {
+		t.Errorf("sampled %d calls, want every other of 10", g.AllocSamples)
+	}
+	if got := g.AllocsPerOp(); got < 100 {
+		t.Errorf("garbage: %.1f allocs/op, want at least 100", got)
+	}
+	if got := g.BytesPerOp(); got < 6400 {
+		t.Errorf("garbage: %.1f B/op, want at least 6400", got)
+	}
+	if got := c.AllocsPerOp(); got > 1 {
+		t.Errorf("clean: %.1f allocs/op, want none", got)
+	}
+
+	m.SampleAllocs(0)
+	garbage(ctx, 100)
+	if n := m.Metrics()[1].AllocSamples; n != 5 {
+		t.Errorf("sampled %d calls after sampling was stopped, want 5", n)
+	}
+}
@@ -0,0 +1,209 @@
+// Package notify reports the lifecycle of unattended pipeline runs (started,
+// finished, failed, error rate too high) to a webhook, so that a job that runs
+// without anyone watching still reports its fate.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Kind identifies a lifecycle event.
+type Kind string
+
+// The lifecycle events reported by Run and ErrorRate.
+const (
+	Started           Kind = "started"
+	Finished          Kind = "finished"
+	Failed            Kind = "failed"
+	ErrorRateBreached Kind = "error_rate_breached"
+)
+
+// Event describes something that happened to a pipeline run.
+type Event struct {
+	Kind     Kind      `json:"kind"`
+	Pipeline string    `json:"pipeline"`
+	Time     time.Time `json:"time"`
+	// Error is set for Failed events.
+	Error string `json:"error,omitempty"`
+	// ErrorRate is set for ErrorRateBreached events.
+	ErrorRate float64 `json:"error_rate,omitempty"`
+}
+
+// Text returns a one-line human readable description of e.
+func (e Event) Text() string {
+	switch e.Kind {
+	case Failed:
+		return fmt.Sprintf("pipeline %s failed: %s", e.Pipeline, e.Error)
+	case ErrorRateBreached:
+		return fmt.Sprintf("pipeline %s error rate is %.1f%%", e.Pipeline, e.ErrorRate*100)
+	}
+	return fmt.Sprintf("pipeline %s %s", e.Pipeline, e.Kind)
+}
+
+// A Notifier delivers events.
+type Notifier interface {
+	Notify(ctx context.Context, e Event) error
+}
+
+// Webhook is a Notifier that posts every event as JSON to URL. Besides the
+// fields of Event, the payload carries a "text" field with Event.Text, which
+// makes it compatible with Slack incoming webhooks.
+type Webhook struct {
+	URL string
+	// Client is used to send the requests. If nil, http.DefaultClient is
+	// used.
+	Client *http.Client
+	// Attempts is the number of times a delivery is tried before giving up.
+	// If zero, 3 attempts are made.
+	Attempts int
+	// Backoff is the wait before the first retry; it doubles after every
+	// failed attempt. If zero, one second is used.
+	Backoff time.Duration
+}
+
+// Notify posts e to the webhook, retrying network errors and 429 and 5xx
+// responses.
+func (w *Webhook) Notify(ctx context.Context, e Event) error {
+	body, err := json.Marshal(struct {
+		Event
+		Text string `json:"text"`
+	}{e, e.Text()})
+	if err != nil {
+		return err
+	}
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	attempts := w.Attempts
+	if attempts <= 0 {
+		attempts = 3
+	}
+	backoff := w.Backoff
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+	for i := 1; ; i++ {
+		retry, err := w.post(ctx, client, body)
+		if err == nil || !retry || i == attempts {
+			return err
+		}
+		t := time.NewTimer(backoff)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+// post makes a single delivery attempt and reports whether a failure is worth
+// retrying.
+func (w *Webhook) post(ctx context.Context, client *http.Client, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("notify: webhook responded %s", resp.Status)
+}
+
+// Run notifies n that the pipeline called name has started, calls fn, and
+// then notifies n that the run has finished or failed, depending on the error
+// fn returns. Run returns the error from fn; a failure to deliver a
+// notification is only returned if fn succeeded.
+func Run(ctx context.Context, n Notifier, name string, fn func(context.Context) error) error {
+	nerr := n.Notify(ctx, Event{Kind: Started, Pipeline: name, Time: time.Now()})
+	err := fn(ctx)
+	e := Event{Kind: Finished, Pipeline: name, Time: time.Now()}
+	if err != nil {
+		e.Kind, e.Error = Failed, err.Error()
+	}
+	// Report the outcome even if the run was cancelled.
+	if ferr := n.Notify(context.WithoutCancel(ctx), e); nerr == nil {
+		nerr = ferr
+	}
+	if err != nil {
+		return err
+	}
+	return nerr
+}
+
+// ErrorRate watches the outcomes of the most recent items of a pipeline and
+// sends an ErrorRateBreached event when the share of failures among them
+// reaches a threshold. It notifies once per breach: another event is only sent
+// after the rate has dropped below the threshold and risen again.
+type ErrorRate struct {
+	n         Notifier
+	name      string
+	threshold float64
+
+	mu       sync.Mutex
+	outcomes []bool // ring of the most recent outcomes, true for a failure
+	next     int
+	full     bool
+	failures int
+	breached bool
+}
+
+// NewErrorRate returns an ErrorRate that reports to n when at least threshold
+// (between 0 and 1) of the last window items of the pipeline called name have
+// failed. A window below 1 means 100 items.
+func NewErrorRate(n Notifier, name string, window int, threshold float64) *ErrorRate {
+	if window < 1 {
+		window = 100
+	}
+	return &ErrorRate{
+		n:         n,
+		name:      name,
+		threshold: threshold,
+		outcomes:  make([]bool, window),
+	}
+}
+
+// Observe records the outcome of a single item, err being nil for a success.
+// It returns the error from delivering a notification, if one was sent.
+func (r *ErrorRate) Observe(ctx context.Context, err error) error {
+	r.mu.Lock()
+	if r.outcomes[r.next] {
+		r.failures--
+	}
+	r.outcomes[r.next] = err != nil
+	if err != nil {
+		r.failures++
+	}
+	r.next = (r.next + 1) % len(r.outcomes)
+	if r.next == 0 {
+		r.full = true
+	}
+	if !r.full {
+		r.mu.Unlock()
+		return nil
+	}
+	rate := float64(r.failures) / float64(len(r.outcomes))
+	notify := rate >= r.threshold && !r.breached
+	r.breached = rate >= r.threshold
+	r.mu.Unlock()
+
+	if !notify {
+		return nil
+	}
+	return r.n.Notify(ctx, Event{Kind: ErrorRateBreached, Pipeline: r.name, Time: time.Now(), ErrorRate: rate})
+}
@@ -0,0 +1,128 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder is a Notifier that keeps the events it is sent.
+type recorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (r *recorder) Notify(ctx context.Context, e Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+	return nil
+}
+
+func (r *recorder) kinds() []Kind {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var ks []Kind
+	for _, e := range r.events {
+		ks = append(ks, e.Kind)
+	}
+	return ks
+}
+
+func TestWebhookRetries(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		statuses []int
+		wantErr  bool
+		requests int
+	}{
+		{"ok", []int{200}, false, 1},
+		{"retried 503", []int{503, 503, 204}, false, 3},
+		{"retried 429", []int{429, 200}, false, 2},
+		{"gives up", []int{500, 500, 500, 200}, true, 3},
+		{"client error not retried", []int{400, 200}, true, 1},
+	} {
+		var mu sync.Mutex
+		var bodies []map[string]any
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var body map[string]any
+			json.NewDecoder(r.Body).Decode(&body)
+			mu.Lock()
+			bodies = append(bodies, body)
+			status := tt.statuses[len(bodies)-1]
+			mu.Unlock()
+			w.WriteHeader(status)
+		}))
+		w := &Webhook{URL: srv.URL, Backoff: time.Millisecond}
+		err := w.Notify(context.Background(), Event{Kind: Failed, Pipeline: "p", Error: "boom"})
+		srv.Close()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if len(bodies) != tt.requests {
+			t.Errorf("%s: %d requests, want %d", tt.name, len(bodies), tt.requests)
+		}
+		if bodies[0]["text"] != "pipeline p failed: boom" || bodies[0]["kind"] != "failed" {
+			t.Errorf("%s: payload %v", tt.name, bodies[0])
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	var r recorder
+	if err := Run(context.Background(), &r, "p", func(context.Context) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	boom := errors.New("boom")
+	if err := Run(context.Background(), &r, "p", func(context.Context) error { return boom }); err != boom {
+		t.Fatalf("got %v, want the error of fn", err)
+	}
+	want := []Kind{Started, Finished, Started, Failed}
+	if got := r.kinds(); !reflect.DeepEqual(got, want) {
+		t.Errorf("events %v, want %v", got, want)
+	}
+	if r.events[3].Error != "boom" {
+		t.Errorf("failure reported as %q", r.events[3].Error)
+	}
+}
+
+func TestErrorRate(t *testing.T) {
+	var r recorder
+	rate := NewErrorRate(&r, "p", 4, 0.5)
+	fail := errors.New("fail")
+	ctx := context.Background()
+	// The rate is only judged once the window is full, then notified once
+	// per breach.
+	for i, err := range []error{fail, fail, fail, nil, fail, nil, nil, nil, nil, fail, fail} {
+		rate.Observe(ctx, err)
+		if i == 2 && len(r.kinds()) != 0 {
+			t.Fatalf("notified before the window was full")
+		}
+	}
+	got := r.kinds()
+	if !reflect.DeepEqual(got, []Kind{ErrorRateBreached, ErrorRateBreached}) {
+		t.Fatalf("events %v, want two breaches", got)
+	}
+	if r.events[0].ErrorRate != 0.75 {
+		t.Errorf("first breach at rate %v, want 0.75", r.events[0].ErrorRate)
+	}
+}
+
+func TestErrorRateDefaultWindow(t *testing.T) {
+	var r recorder
+	for _, window := range []int{0, -1} {
+		rate := NewErrorRate(&r, "p", window, 1)
+		for i := 0; i < 100; i++ {
+			rate.Observe(context.Background(), errors.New("fail"))
+		}
+	}
+	if n := len(r.kinds()); n != 2 {
+		t.Errorf("%d breaches, want one per ErrorRate", n)
+	}
+}
@@ -0,0 +1,73 @@
+package pipeline
+
+import "context"
+
+// FanOutOrdered applies fn to every value received from in using n workers,
+// like FanOut with Map, but sends the results in the order the values were
+// received rather than the order they were finished in.
+//
+// At most n values are worked on or waiting to be sent at any time, so a
+// slow value holds up the results behind it: the workers stall until it is
+// done rather than buffering an unbounded number of results.
+func FanOutOrdered[In, Out any](ctx context.Context, in <-chan In, n int, fn func(In) Out) <-chan Out {
+	if n < 1 {
+		n = 1
+	}
+	type job struct {
+		v      In
+		result chan Out
+	}
+	jobs := make(chan job)
+	// pending holds the result channel of every job in input order, which
+	// is the order the results are sent in. Its capacity, plus the one job
+	// whose result is being waited for, bounds the number of jobs in
+	// flight.
+	pending := make(chan chan Out, n-1)
+
+	go func() {
+		defer close(jobs)
+		defer close(pending)
+		for v := range in {
+			j := job{v, make(chan Out, 1)}
+			select {
+			case pending <- j.result:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case jobs <- j:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < n; i++ {
+		go func() {
+			for j := range jobs {
+				// No select needed for this send, since result is
+				// buffered.
+				j.result <- fn(j.v)
+			}
+		}()
+	}
+
+	out := make(chan Out)
+	go func() {
+		defer close(out)
+		for result := range pending {
+			var v Out
+			select {
+			case v = <-result:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case out <- v:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
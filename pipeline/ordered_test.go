@@ -0,0 +1,57 @@
+package pipeline
+
+import (
+	"context"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFanOutOrdered(t *testing.T) {
+	ctx := context.Background()
+	vs := make([]int, 20)
+	for i := range vs {
+		vs[i] = i
+	}
+	// Earlier values take longer, so they finish after the ones behind
+	// them.
+	out := FanOutOrdered(ctx, Gen(ctx, vs...), 4, func(v int) int {
+		time.Sleep(time.Duration(20-v) * time.Millisecond / 10)
+		return v * v
+	})
+	got := collect(t, out)
+	for i, v := range got {
+		if v != i*i {
+			t.Fatalf("got %v, want the squares in input order", got)
+		}
+	}
+	if len(got) != len(vs) {
+		t.Errorf("got %d results, want %d", len(got), len(vs))
+	}
+}
+
+func TestFanOutOrderedBoundsInFlight(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	const n = 3
+	var started atomic.Int32
+	release := make(chan struct{})
+	out := FanOutOrdered(ctx, Gen(ctx, 0, 1, 2, 3, 4, 5, 6, 7), n, func(v int) int {
+		started.Add(1)
+		if v == 0 {
+			<-release
+		}
+		return v
+	})
+	// The first value holds up the results behind it, so the workers stall
+	// once n values are in flight.
+	time.Sleep(50 * time.Millisecond)
+	if got := started.Load(); got != n {
+		t.Errorf("started %d values while the first was stuck, want %d", got, n)
+	}
+	close(release)
+	if got := collect(t, out); !reflect.DeepEqual(got, []int{0, 1, 2, 3, 4, 5, 6, 7}) {
+		t.Errorf("got %v, want every value in order", got)
+	}
+}
@@ -0,0 +1,35 @@
+package pipeline
+
+import "context"
+
+// OrDone passes on the values received from in until in is closed or the
+// context is cancelled, whichever comes first. Ranging over its output lets a
+// consumer write a plain for range loop that still stops on cancellation:
+//
+//	for v := range OrDone(ctx, in) {
+//		...
+//	}
+//
+// A value received from in just as the context is cancelled may be dropped.
+func OrDone[T any](ctx context.Context, in <-chan T) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case v, ok := <-in:
+				if !ok {
+					return
+				}
+				select {
+				case out <- v:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,29 @@
+package pipeline
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestOrDone(t *testing.T) {
+	ctx := context.Background()
+	if got := collect(t, OrDone(ctx, Gen(ctx, 1, 2, 3))); !reflect.DeepEqual(got, []int{1, 2, 3}) {
+		t.Errorf("got %v, want [1 2 3]", got)
+	}
+}
+
+func TestOrDoneCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	// in is never closed, so only cancellation ends the loop.
+	in := make(chan int)
+	out := OrDone(ctx, in)
+	go func() { in <- 1 }()
+	if v := <-out; v != 1 {
+		t.Fatalf("got %d, want 1", v)
+	}
+	cancel()
+	if got := collect(t, out); len(got) != 0 {
+		t.Errorf("got %v after cancelling, want nothing", got)
+	}
+}
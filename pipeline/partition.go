@@ -0,0 +1,56 @@
+package pipeline
+
+import "context"
+
+// Partition splits the values received from in in two: those for which pred
+// returns true are sent on the first output, and the rest on the second. Both
+// outputs must be consumed concurrently, since a value waiting to be taken
+// from one holds up the other. Both are closed once in is closed or the
+// context is cancelled.
+//
+//	valid, invalid := Partition(ctx, records, Record.Valid)
+func Partition[T any](ctx context.Context, in <-chan T, pred func(T) bool) (<-chan T, <-chan T) {
+	outs, rest := Route(ctx, in, pred)
+	return outs[0], rest
+}
+
+// Route sends every value received from in on to one of several outputs,
+// depending on its content: it goes to the i-th of the returned outputs if
+// routes[i] is the first route to return true for it, and to the default
+// output, returned last, if none does. All the outputs must be consumed
+// concurrently, and are closed once in is closed or the context is cancelled.
+//
+//	outs, other := Route(ctx, pages, isHTML, isImage)
+//	html, images := outs[0], outs[1]
+func Route[T any](ctx context.Context, in <-chan T, routes ...func(T) bool) ([]<-chan T, <-chan T) {
+	outs := make([]chan T, len(routes))
+	res := make([]<-chan T, len(routes))
+	for i := range outs {
+		outs[i] = make(chan T)
+		res[i] = outs[i]
+	}
+	rest := make(chan T)
+	go func() {
+		defer func() {
+			for _, out := range outs {
+				close(out)
+			}
+			close(rest)
+		}()
+		for v := range in {
+			out := rest
+			for i, match := range routes {
+				if match(v) {
+					out = outs[i]
+					break
+				}
+			}
+			select {
+			case out <- v:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return res, rest
+}
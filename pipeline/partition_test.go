@@ -0,0 +1,51 @@
+package pipeline
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestPartition(t *testing.T) {
+	ctx := context.Background()
+	even, odd := Partition(ctx, Gen(ctx, 1, 2, 3, 4, 5), func(v int) bool { return v%2 == 0 })
+	got := drainAll(t, []<-chan int{even, odd})
+	if want := [][]int{{2, 4}, {1, 3, 5}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestPartitionCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan int, 1)
+	in <- 1
+	even, odd := Partition(ctx, in, func(v int) bool { return v%2 == 0 })
+	// Nobody takes the odd value, so only cancelling frees the stage.
+	cancel()
+	collect(t, even)
+	collect(t, odd)
+}
+
+func TestRoute(t *testing.T) {
+	ctx := context.Background()
+	small := func(v int) bool { return v < 10 }
+	even := func(v int) bool { return v%2 == 0 }
+	outs, rest := Route(ctx, Gen(ctx, 1, 12, 4, 15, 20, 7), small, even)
+	got := drainAll(t, append(outs, rest))
+	// A value goes to the first route it matches, so 4 is small rather than
+	// even.
+	if want := [][]int{{1, 4, 7}, {12, 20}, {15}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestRouteNoRoutes(t *testing.T) {
+	ctx := context.Background()
+	outs, rest := Route(ctx, Gen(ctx, 1, 2))
+	if len(outs) != 0 {
+		t.Errorf("got %d outputs, want none", len(outs))
+	}
+	if got := collect(t, rest); !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Errorf("got %v, want everything on the default output", got)
+	}
+}
@@ -0,0 +1,101 @@
+// Package pipemetric exports the metrics of a pipeline through the
+// OpenTelemetry metric API, so that they reach whatever backend the program
+// has configured its meter provider with.
+package pipemetric
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"concurrency/pipeline"
+)
+
+// An Exporter reports the counters of a pipeline.Metrics as OpenTelemetry
+// instruments, with the stage as a "pipeline.stage" attribute:
+//
+//   - pipeline.stage.items.in, pipeline.stage.items.out and
+//     pipeline.stage.errors, the items received, sent on and failed on, as
+//     observable counters;
+//   - pipeline.stage.backlog and pipeline.stage.active_workers, as
+//     observable gauges;
+//   - pipeline.stage.item.duration, the time taken on every item by the work
+//     functions wrapped with Func, as a histogram in seconds.
+//
+// The observable instruments are read from the Metrics whenever the meter
+// provider collects.
+type Exporter struct {
+	duration metric.Float64Histogram
+	reg      metric.Registration
+}
+
+// New returns an Exporter reporting the counters of m through meter.
+func New(m *pipeline.Metrics, meter metric.Meter) (*Exporter, error) {
+	in, err := meter.Int64ObservableCounter("pipeline.stage.items.in",
+		metric.WithDescription("Items received by the stage."), metric.WithUnit("{item}"))
+	if err != nil {
+		return nil, err
+	}
+	out, err := meter.Int64ObservableCounter("pipeline.stage.items.out",
+		metric.WithDescription("Items sent on by the stage."), metric.WithUnit("{item}"))
+	if err != nil {
+		return nil, err
+	}
+	errs, err := meter.Int64ObservableCounter("pipeline.stage.errors",
+		metric.WithDescription("Items the stage failed on."), metric.WithUnit("{item}"))
+	if err != nil {
+		return nil, err
+	}
+	backlog, err := meter.Int64ObservableGauge("pipeline.stage.backlog",
+		metric.WithDescription("Items received by the stage but not yet sent on."), metric.WithUnit("{item}"))
+	if err != nil {
+		return nil, err
+	}
+	active, err := meter.Int64ObservableGauge("pipeline.stage.active_workers",
+		metric.WithDescription("Workers of the stage busy with an item."), metric.WithUnit("{worker}"))
+	if err != nil {
+		return nil, err
+	}
+	duration, err := meter.Float64Histogram("pipeline.stage.item.duration",
+		metric.WithDescription("Time taken by the stage on an item."), metric.WithUnit("s"))
+	if err != nil {
+		return nil, err
+	}
+
+	reg, err := meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		for _, s := range m.Metrics() {
+			attrs := metric.WithAttributes(attribute.String("pipeline.stage", s.Name))
+			o.ObserveInt64(in, s.In, attrs)
+			o.ObserveInt64(out, s.Out, attrs)
+			o.ObserveInt64(errs, s.Errors, attrs)
+			o.ObserveInt64(backlog, s.Backlog(), attrs)
+			o.ObserveInt64(active, s.Active, attrs)
+		}
+		return nil
+	}, in, out, errs, backlog, active)
+	if err != nil {
+		return nil, err
+	}
+	return &Exporter{duration: duration, reg: reg}, nil
+}
+
+// Close stops reporting the counters of the Metrics.
+func (e *Exporter) Close() error {
+	return e.reg.Unregister()
+}
+
+// Func wraps fn so that the time it takes on every item is recorded in the
+// item duration histogram of e under the stage name. Combine it with
+// pipeline.InstrumentFunc under the same name to count errors and workers as
+// well.
+func Func[In, Out any](e *Exporter, name string, fn func(context.Context, In) (Out, error)) func(context.Context, In) (Out, error) {
+	attrs := metric.WithAttributes(attribute.String("pipeline.stage", name))
+	return func(ctx context.Context, v In) (Out, error) {
+		start := time.Now()
+		out, err := fn(ctx, v)
+		e.duration.Record(ctx, time.Since(start).Seconds(), attrs)
+		return out, err
+	}
+}
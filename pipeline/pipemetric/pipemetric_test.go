@@ -0,0 +1,106 @@
+package pipemetric
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+
+	"concurrency/pipeline"
+)
+
+func collect(t *testing.T, r *sdkmetric.ManualReader) map[string]metricdata.Aggregation {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := r.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]metricdata.Aggregation)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			got[m.Name] = m.Data
+		}
+	}
+	return got
+}
+
+// value returns the value of the data point of stage in an int64 sum or
+// gauge.
+func value(t *testing.T, data metricdata.Aggregation, stage string) int64 {
+	t.Helper()
+	var points []metricdata.DataPoint[int64]
+	switch d := data.(type) {
+	case metricdata.Sum[int64]:
+		points = d.DataPoints
+	case metricdata.Gauge[int64]:
+		points = d.DataPoints
+	default:
+		t.Fatalf("unexpected aggregation %T", data)
+	}
+	for _, p := range points {
+		if v, _ := p.Attributes.Value("pipeline.stage"); v.AsString() == stage {
+			return p.Value
+		}
+	}
+	t.Fatalf("no data point for stage %q", stage)
+	return 0
+}
+
+func TestExporter(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	meter := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter("test")
+	m := pipeline.NewMetrics()
+	e, err := New(m, meter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	fn := Func(e, "fetch", pipeline.InstrumentFunc(m, "fetch", func(_ context.Context, v int) (int, error) {
+		time.Sleep(time.Millisecond)
+		if v < 0 {
+			return 0, errors.New("negative")
+		}
+		return v, nil
+	}))
+	stage := pipeline.Instrument(m, "fetch", func(ctx context.Context, in <-chan int) <-chan pipeline.Result[int] {
+		return pipeline.TryMap(ctx, in, fn)
+	})
+	for range stage(ctx, pipeline.Gen(ctx, 1, -2, 3, -4, 5)) {
+	}
+
+	got := collect(t, reader)
+	for name, want := range map[string]int64{
+		"pipeline.stage.items.in":       5,
+		"pipeline.stage.items.out":      5,
+		"pipeline.stage.errors":         2,
+		"pipeline.stage.backlog":        0,
+		"pipeline.stage.active_workers": 0,
+	} {
+		if v := value(t, got[name], "fetch"); v != want {
+			t.Errorf("%s = %d, want %d", name, v, want)
+		}
+	}
+	h, ok := got["pipeline.stage.item.duration"].(metricdata.Histogram[float64])
+	if !ok || len(h.DataPoints) != 1 {
+		t.Fatalf("duration histogram: %#v", got["pipeline.stage.item.duration"])
+	}
+	p := h.DataPoints[0]
+	if p.Count != 5 || p.Sum < 0.005 {
+		t.Errorf("duration histogram has %d items summing to %vs, want 5 of at least 1ms", p.Count, p.Sum)
+	}
+	if v, _ := p.Attributes.Value("pipeline.stage"); v.AsString() != "fetch" {
+		t.Errorf("duration recorded for stage %q", v.AsString())
+	}
+
+	// Once closed, the counters are no longer observed.
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := collect(t, reader)["pipeline.stage.items.in"]; ok {
+		t.Error("counters still reported after Close")
+	}
+}
@@ -0,0 +1,138 @@
+// Package pipetest provides helpers for testing pipelines and the stages they
+// are built from.
+package pipetest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"concurrency/pipeline"
+)
+
+// update makes Golden rewrite golden files instead of comparing against them.
+// The flag is prefixed with the package name, so that it does not clash with
+// an -update flag the test package importing pipetest may define itself.
+var update = flag.Bool("pipetest.update", false, "update pipetest golden files")
+
+// Timeout bounds how long the helpers in this package wait for a stage
+// before failing the test.
+var Timeout = 10 * time.Second
+
+// Golden runs stage over inputs and compares its outputs with the golden file
+// testdata/name.golden. Every output is encoded as a line of JSON and the
+// lines are sorted before comparing, so the check does not depend on the order
+// in which concurrent stages emit their results. Run the tests with
+// -pipetest.update to write the current outputs to the golden file instead.
+func Golden[In, Out any](t testing.TB, name string, stage pipeline.Stage[In, Out], inputs []In) {
+	t.Helper()
+	outs, err := Run(stage, inputs)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	lines := make([]string, len(outs))
+	for i, v := range outs {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("%s: encoding output: %v", name, err)
+		}
+		lines[i] = string(b)
+	}
+	sort.Strings(lines)
+	var got bytes.Buffer
+	for _, l := range lines {
+		got.WriteString(l)
+		got.WriteByte('\n')
+	}
+
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%s: %v (run with -pipetest.update to create it)", name, err)
+	}
+	if diff := diffLines(string(want), got.String()); diff != "" {
+		t.Errorf("%s: output differs from %s (-want +got):\n%s", name, path, diff)
+	}
+}
+
+// Run feeds inputs to stage, collects everything it emits and returns it in
+// the order it was emitted. It fails if the stage does not close its output
+// within Timeout.
+func Run[In, Out any](stage pipeline.Stage[In, Out], inputs []In) ([]Out, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+	in := make(chan In)
+	go func() {
+		defer close(in)
+		for _, v := range inputs {
+			select {
+			case in <- v:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	var outs []Out
+	out := stage(ctx, in)
+	for {
+		select {
+		case v, ok := <-out:
+			if !ok {
+				if ctx.Err() != nil {
+					return outs, fmt.Errorf("stage did not finish within %v", Timeout)
+				}
+				return outs, nil
+			}
+			outs = append(outs, v)
+		case <-ctx.Done():
+			return outs, fmt.Errorf("stage did not close its output within %v", Timeout)
+		}
+	}
+}
+
+// diffLines compares two lists of lines, regardless of their order, and
+// describes the lines missing from got with a leading "-" and the unexpected
+// ones with a "+". It returns an empty string if the lists are equal.
+func diffLines(want, got string) string {
+	w := strings.Split(strings.TrimSuffix(want, "\n"), "\n")
+	g := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+	if want == "" {
+		w = nil
+	}
+	if got == "" {
+		g = nil
+	}
+	sort.Strings(w)
+	sort.Strings(g)
+	var b strings.Builder
+	for len(w) > 0 || len(g) > 0 {
+		switch {
+		case len(g) == 0 || len(w) > 0 && w[0] < g[0]:
+			fmt.Fprintf(&b, "-%s\n", w[0])
+			w = w[1:]
+		case len(w) == 0 || g[0] < w[0]:
+			fmt.Fprintf(&b, "+%s\n", g[0])
+			g = g[1:]
+		default:
+			w, g = w[1:], g[1:]
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,50 @@
+package pipetest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"concurrency/pipeline"
+)
+
+type doubled struct{ N int }
+
+func double(ctx context.Context, in <-chan int) <-chan doubled {
+	// Two workers, so that the outputs may come in any order.
+	return pipeline.Merge(ctx,
+		pipeline.Map(ctx, in, func(v int) doubled { return doubled{2 * v} }),
+		pipeline.Map(ctx, in, func(v int) doubled { return doubled{2 * v} }),
+	)
+}
+
+func TestGolden(t *testing.T) {
+	Golden(t, "double", double, []int{3, 1, 2})
+}
+
+func TestRunTimeout(t *testing.T) {
+	defer func(d time.Duration) { Timeout = d }(Timeout)
+	Timeout = 10 * time.Millisecond
+	never := func(ctx context.Context, in <-chan int) <-chan int {
+		return make(chan int)
+	}
+	if _, err := Run[int, int](never, []int{1}); err == nil {
+		t.Fatal("no error from a stage that never closes its output")
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	for _, tt := range []struct {
+		want, got, diff string
+	}{
+		{"a\nb\n", "b\na\n", ""},
+		{"", "", ""},
+		{"a\nb\n", "a\n", "-b\n"},
+		{"a\n", "c\na\n", "+c\n"},
+		{"a\nb\n", "a\nc\n", "-b\n+c\n"},
+	} {
+		if diff := diffLines(tt.want, tt.got); diff != tt.diff {
+			t.Errorf("diffLines(%q, %q) = %q, want %q", tt.want, tt.got, diff, tt.diff)
+		}
+	}
+}
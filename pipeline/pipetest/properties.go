@@ -0,0 +1,201 @@
+package pipetest
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"testing"
+	"time"
+
+	"concurrency/pipeline"
+)
+
+// Properties configures CheckStage.
+type Properties[In, Out any] struct {
+	// Gen returns a random input item.
+	Gen func(r *rand.Rand) In
+	// Want, if not nil, returns the outputs the stage should produce for
+	// inputs, in any order. If nil, the outputs of runs with randomized
+	// scheduling are compared with the outputs of an undisturbed run over the
+	// same inputs instead.
+	Want func(inputs []In) []Out
+	// Runs is the number of runs with randomized scheduling. Zero means 20.
+	Runs int
+	// Size is the largest number of inputs fed to the stage in a single run.
+	// Zero means 100.
+	Size int
+	// Seed seeds the random source; zero picks a seed from the clock. The
+	// seed is reported when a check fails, so that the failure can be
+	// reproduced.
+	Seed int64
+	// CancelTimeout bounds how long the stage may take to close its output
+	// after its context has been cancelled. Zero means one second.
+	CancelTimeout time.Duration
+}
+
+// CheckStage checks that stage behaves as every stage should:
+//
+//   - its output is closed once its input has been closed;
+//   - its output is closed promptly after its context is cancelled, even
+//     while nobody is reading it and its input is still open;
+//   - under randomized scheduling of its producer and consumer it neither
+//     loses nor duplicates items.
+//
+// Outputs are compared by their %#v representation.
+func CheckStage[In, Out any](t *testing.T, stage pipeline.Stage[In, Out], p Properties[In, Out]) {
+	t.Helper()
+	if p.Runs == 0 {
+		p.Runs = 20
+	}
+	if p.Size == 0 {
+		p.Size = 100
+	}
+	if p.Seed == 0 {
+		p.Seed = time.Now().UnixNano()
+	}
+	if p.CancelTimeout == 0 {
+		p.CancelTimeout = time.Second
+	}
+	r := rand.New(rand.NewSource(p.Seed))
+	inputs := func() []In {
+		in := make([]In, r.Intn(p.Size+1))
+		for i := range in {
+			in[i] = p.Gen(r)
+		}
+		return in
+	}
+
+	t.Run("ClosesAfterInput", func(t *testing.T) {
+		if _, err := Run(stage, inputs()); err != nil {
+			t.Errorf("seed %d: %v", p.Seed, err)
+		}
+	})
+
+	t.Run("ClosesOnCancel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		in := make(chan In)
+		defer close(in)
+		out := stage(ctx, in)
+		// Feed a few items without reading, so that the stage is likely
+		// to be blocked sending when the context is cancelled.
+		for i := 0; i < 3; i++ {
+			select {
+			case in <- p.Gen(r):
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+		cancel()
+		timeout := time.After(p.CancelTimeout)
+		for {
+			select {
+			case _, ok := <-out:
+				if !ok {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("seed %d: output not closed within %v of cancellation", p.Seed, p.CancelTimeout)
+			}
+		}
+	})
+
+	t.Run("NoLossOrDuplication", func(t *testing.T) {
+		for run := 0; run < p.Runs; run++ {
+			in := inputs()
+			var want []Out
+			if p.Want != nil {
+				want = p.Want(in)
+			} else {
+				var err error
+				if want, err = Run(stage, in); err != nil {
+					t.Fatalf("seed %d: %v", p.Seed, err)
+				}
+			}
+			got, err := runJittered(stage, in, r.Int63())
+			if err != nil {
+				t.Fatalf("seed %d: %v", p.Seed, err)
+			}
+			if diff := diffMultisets(want, got); diff != "" {
+				t.Fatalf("seed %d, run %d: outputs differ (-want +got):\n%s", p.Seed, run, diff)
+			}
+		}
+	})
+}
+
+// runJittered is like Run, but randomly delays the producer and the consumer
+// of the stage between items to shake out scheduling-dependent bugs.
+func runJittered[In, Out any](stage pipeline.Stage[In, Out], inputs []In, seed int64) ([]Out, error) {
+	jitter := func(r *rand.Rand) {
+		switch r.Intn(4) {
+		case 0:
+			time.Sleep(time.Duration(r.Intn(100)) * time.Microsecond)
+		case 1:
+			runtime.Gosched()
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+	in := make(chan In)
+	go func() {
+		defer close(in)
+		r := rand.New(rand.NewSource(seed))
+		for _, v := range inputs {
+			jitter(r)
+			select {
+			case in <- v:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	r := rand.New(rand.NewSource(seed + 1))
+	var outs []Out
+	out := stage(ctx, in)
+	for {
+		jitter(r)
+		select {
+		case v, ok := <-out:
+			if !ok {
+				if ctx.Err() != nil {
+					return outs, fmt.Errorf("stage did not finish within %v", Timeout)
+				}
+				return outs, nil
+			}
+			outs = append(outs, v)
+		case <-ctx.Done():
+			return outs, fmt.Errorf("stage did not close its output within %v", Timeout)
+		}
+	}
+}
+
+// diffMultisets describes the values of want missing from got with a leading
+// "-" and the values of got not in want with a "+", counting repeated values.
+// It returns an empty string if both hold the same values.
+func diffMultisets[T any](want, got []T) string {
+	counts := make(map[string]int)
+	var keys []string
+	for _, v := range want {
+		k := fmt.Sprintf("%#v", v)
+		if counts[k] == 0 {
+			keys = append(keys, k)
+		}
+		counts[k]++
+	}
+	for _, v := range got {
+		k := fmt.Sprintf("%#v", v)
+		if _, ok := counts[k]; !ok {
+			keys = append(keys, k)
+		}
+		counts[k]--
+	}
+	var diff string
+	for _, k := range keys {
+		switch n := counts[k]; {
+		case n > 0:
+			diff += fmt.Sprintf("-%s (x%d)\n", k, n)
+		case n < 0:
+			diff += fmt.Sprintf("+%s (x%d)\n", k, -n)
+		}
+	}
+	return diff
+}
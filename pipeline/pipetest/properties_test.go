@@ -0,0 +1,91 @@
+package pipetest
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+
+	"concurrency/pipeline"
+)
+
+func TestCheckStage(t *testing.T) {
+	CheckStage(t, double, Properties[int, doubled]{
+		Gen: func(r *rand.Rand) int { return r.Intn(1000) },
+		Want: func(inputs []int) []doubled {
+			out := make([]doubled, len(inputs))
+			for i, v := range inputs {
+				out[i] = doubled{2 * v}
+			}
+			return out
+		},
+		Runs: 5,
+	})
+}
+
+func TestCheckStageAgainstUndisturbedRun(t *testing.T) {
+	evens := func(ctx context.Context, in <-chan int) <-chan int {
+		return pipeline.Filter(ctx, in, func(v int) bool { return v%2 == 0 })
+	}
+	CheckStage(t, evens, Properties[int, int]{
+		Gen:  func(r *rand.Rand) int { return r.Intn(10) },
+		Runs: 5,
+		Seed: 1,
+	})
+}
+
+func TestRunJitteredFindsLostItems(t *testing.T) {
+	// A stage that drops the next item whenever none is ready loses items
+	// depending on how its producer is scheduled.
+	impatient := func(ctx context.Context, in <-chan int) <-chan int {
+		out := make(chan int)
+		go func() {
+			defer close(out)
+			for {
+				select {
+				case v, ok := <-in:
+					if !ok {
+						return
+					}
+					out <- v
+				default:
+					if _, ok := <-in; !ok {
+						return
+					}
+				}
+			}
+		}()
+		return out
+	}
+	inputs := make([]int, 50)
+	for i := range inputs {
+		inputs[i] = i
+	}
+	lost := false
+	for seed := int64(0); seed < 20 && !lost; seed++ {
+		got, err := runJittered(impatient, inputs, seed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lost = diffMultisets(inputs, got) != ""
+	}
+	if !lost {
+		t.Error("jittered runs never exposed the lost items")
+	}
+}
+
+func TestDiffMultisets(t *testing.T) {
+	for _, tt := range []struct {
+		want, got []int
+		diff      string
+	}{
+		{[]int{1, 2, 2}, []int{2, 1, 2}, ""},
+		{nil, nil, ""},
+		{[]int{1, 2, 2}, []int{1, 2}, "-2 (x1)\n"},
+		{[]int{1}, []int{1, 3, 3}, "+3 (x2)\n"},
+		{[]int{1, 2}, []int{1, 4}, "-2 (x1)\n+4 (x1)\n"},
+	} {
+		if diff := diffMultisets(tt.want, tt.got); diff != tt.diff {
+			t.Errorf("diffMultisets(%v, %v) = %q, want %q", tt.want, tt.got, diff, tt.diff)
+		}
+	}
+}
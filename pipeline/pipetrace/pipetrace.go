@@ -0,0 +1,70 @@
+// Package pipetrace traces items through a pipeline with OpenTelemetry.
+//
+// Every item is carried in a pipeline.Envelope, whose metadata holds the
+// trace context in W3C Trace Context form. Each traced stage starts a span as
+// a child of the span of the stage before it and stores its own span in the
+// envelope it sends on, so that the spans of a single item, from the stage
+// that generated it to the one that stored it, form a single trace showing
+// its end-to-end latency.
+package pipetrace
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"concurrency/pipeline"
+)
+
+// propagator encodes trace contexts into envelope metadata. It is fixed
+// rather than taken from the global propagator, so that the metadata written
+// by one stage can always be read by the next.
+var propagator = propagation.TraceContext{}
+
+// Func wraps fn so that every item it works on is traced by a span called
+// name, started with tracer. The span is a child of the span recorded in the
+// item's envelope, if any, or else of the span in the context fn is called
+// with, so that a pipeline started within a request is traced as part of it.
+// The span records the error fn returns, and the envelope sent on carries it
+// for the next stage. Use the wrapped function with pipeline.TryMap or
+// pipeline.MapWithDeadLetters:
+//
+//	pages := pipeline.TryMap(ctx, hrefs, pipetrace.Func(tracer, "fetch", fetch))
+func Func[In, Out any](tracer trace.Tracer, name string, fn func(context.Context, In) (Out, error)) func(context.Context, pipeline.Envelope[In]) (pipeline.Envelope[Out], error) {
+	return func(ctx context.Context, e pipeline.Envelope[In]) (pipeline.Envelope[Out], error) {
+		ctx = Extract(ctx, e)
+		ctx, span := tracer.Start(ctx, name,
+			trace.WithAttributes(
+				attribute.String("pipeline.source", e.Source),
+				attribute.Int("pipeline.attempts", e.Attempts),
+			),
+		)
+		defer span.End()
+		v, err := fn(ctx, e.Item)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		return Inject(ctx, pipeline.Rewrap(e, v)), err
+	}
+}
+
+// Extract returns ctx with the span recorded in e as its current span, if e
+// carries one.
+func Extract[T any](ctx context.Context, e pipeline.Envelope[T]) context.Context {
+	return propagator.Extract(ctx, propagation.MapCarrier(e.Metadata()))
+}
+
+// Inject returns a copy of e recording the current span of ctx, so that the
+// stages it is sent to continue its trace.
+func Inject[T any](ctx context.Context, e pipeline.Envelope[T]) pipeline.Envelope[T] {
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+	for k, v := range carrier {
+		e = e.With(k, v)
+	}
+	return e
+}
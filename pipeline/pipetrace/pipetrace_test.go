@@ -0,0 +1,84 @@
+package pipetrace
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"concurrency/pipeline"
+)
+
+func TestFunc(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)).Tracer("test")
+	ctx := context.Background()
+
+	parse := Func(tracer, "parse", func(_ context.Context, s string) (int, error) { return strconv.Atoi(s) })
+	store := Func(tracer, "store", func(_ context.Context, v int) (int, error) {
+		if v < 0 {
+			return 0, errors.New("negative")
+		}
+		return v, nil
+	})
+
+	e, err := parse(ctx, pipeline.Envelope[string]{Item: "-1", Source: "stdin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := e.Metadata()["traceparent"]; !ok {
+		t.Fatalf("envelope metadata %v does not carry the trace", e.Metadata())
+	}
+	if _, err := store(ctx, e); err == nil {
+		t.Fatal("got no error")
+	}
+
+	spans := rec.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want one per stage", len(spans))
+	}
+	first, second := spans[0], spans[1]
+	if first.Name() != "parse" || second.Name() != "store" {
+		t.Fatalf("got spans %s and %s", first.Name(), second.Name())
+	}
+	// Both stages belong to the item's trace, the second as a child of the
+	// first.
+	if second.SpanContext().TraceID() != first.SpanContext().TraceID() {
+		t.Error("stages traced in different traces")
+	}
+	if second.Parent().SpanID() != first.SpanContext().SpanID() {
+		t.Error("second stage's span is not a child of the first's")
+	}
+	if first.Status().Code == codes.Error || second.Status().Code != codes.Error || second.Status().Description != "negative" {
+		t.Errorf("got statuses %v and %v, want only the second failed", first.Status(), second.Status())
+	}
+	var source string
+	for _, a := range first.Attributes() {
+		if a.Key == "pipeline.source" {
+			source = a.Value.AsString()
+		}
+	}
+	if source != "stdin" {
+		t.Errorf("got source %q, want stdin", source)
+	}
+}
+
+func TestFuncContinuesRequestTrace(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)).Tracer("test")
+	ctx, request := tracer.Start(context.Background(), "request")
+	fn := Func(tracer, "stage", func(_ context.Context, v int) (int, error) { return v, nil })
+	// An envelope without a trace of its own joins the one in the context.
+	if _, err := fn(ctx, pipeline.Envelope[int]{Item: 1}); err != nil {
+		t.Fatal(err)
+	}
+	request.End()
+	spans := rec.Ended()
+	if len(spans) != 2 || spans[0].Parent().SpanID() != request.SpanContext().SpanID() {
+		t.Errorf("stage span not a child of the request span")
+	}
+}
@@ -0,0 +1,164 @@
+package pipeline
+
+import (
+	"context"
+	"reflect"
+	"sort"
+)
+
+// A Prioritized is an input of MergePriority along with its priority. Higher
+// priorities are served first.
+type Prioritized[T any] struct {
+	C        <-chan T
+	Priority int
+}
+
+// MergePriority merges the channels of cs, preferring higher-priority ones:
+// when values are ready on several channels, the one with the highest
+// priority is sent on first. So that a busy high-priority channel cannot
+// starve the others, every fair-th value is instead taken from the channels
+// in turn, regardless of their priority; a fair of zero disables this. The
+// output channel is closed once all of cs have been closed.
+func MergePriority[T any](ctx context.Context, fair int, cs ...Prioritized[T]) <-chan T {
+	cs = append([]Prioritized[T](nil), cs...)
+	sort.SliceStable(cs, func(i, j int) bool { return cs[i].Priority > cs[j].Priority })
+	chans := make([]<-chan T, len(cs))
+	for i, c := range cs {
+		chans[i] = c.C
+	}
+
+	// sent counts the values sent, and turn is the channel the next fair
+	// pick starts from.
+	sent, turn := 0, 0
+	return mergeScan(ctx, chans, func(int) int {
+		first := 0
+		if fair > 0 && sent%fair == fair-1 {
+			first = turn
+			turn = (turn + 1) % len(chans)
+		}
+		sent++
+		return first
+	})
+}
+
+// MergeRoundRobin merges cs, serving them in turn: after a value from one
+// channel, the next channel that has a value ready goes next, so that a fast
+// producer cannot monopolize the output while others are waiting. The output
+// channel is closed once all of cs have been closed.
+func MergeRoundRobin[T any](ctx context.Context, cs ...<-chan T) <-chan T {
+	chans := append([]<-chan T(nil), cs...)
+	return mergeScan(ctx, chans, func(last int) int {
+		return last + 1
+	})
+}
+
+// mergeScan merges chans from a single goroutine. Before receiving every
+// value, it asks first where to start looking for a ready channel, given the
+// index of the channel the last value came from, and takes the first ready
+// channel from there on, wrapping around. If no channel is ready, it waits
+// for any of them.
+func mergeScan[T any](ctx context.Context, chans []<-chan T, first func(last int) int) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		// cases is used to wait for any channel when none is ready: the
+		// first case is the context, the rest are chans in order, with
+		// closed channels replaced by receives from a nil channel, which
+		// never proceed.
+		cases := make([]reflect.SelectCase, len(chans)+1)
+		cases[0] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())}
+		for i, c := range chans {
+			cases[i+1] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(c)}
+		}
+		open := len(chans)
+		last := -1
+
+		for open > 0 {
+			start := first(last)
+			var (
+				v      T
+				ok     bool
+				chosen = -1
+			)
+			for k := 0; k < len(chans) && chosen < 0; k++ {
+				i := (start + k) % len(chans)
+				if chans[i] == nil {
+					continue
+				}
+				select {
+				case v, ok = <-chans[i]:
+					chosen = i
+				default:
+				}
+			}
+			if chosen < 0 {
+				i, rv, rok := reflect.Select(cases)
+				if i == 0 {
+					return
+				}
+				chosen, ok = i-1, rok
+				if ok {
+					v = rv.Interface().(T)
+				}
+			}
+			if !ok {
+				chans[chosen] = nil
+				cases[chosen+1] = reflect.SelectCase{Dir: reflect.SelectRecv}
+				open--
+				continue
+			}
+			last = chosen
+			select {
+			case out <- v:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// A Weighted is an input of MergeWeighted along with its weight.
+type Weighted[T any] struct {
+	C      <-chan T
+	Weight int
+}
+
+// MergeWeighted merges the channels of cs in proportion to their weights:
+// while all of them have values ready, a channel of weight 3 gets three
+// values sent for every one of a channel of weight 1, evenly interleaved. A
+// channel that has nothing ready is skipped rather than waited for, and its
+// share goes to the others. The output channel is closed once all of cs have
+// been closed.
+func MergeWeighted[T any](ctx context.Context, cs ...Weighted[T]) <-chan T {
+	chans := make([]<-chan T, len(cs))
+	weights := make([]int, len(cs))
+	for i, c := range cs {
+		chans[i] = c.C
+		weights[i] = c.Weight
+		if weights[i] < 1 {
+			weights[i] = 1
+		}
+	}
+	// Smooth weighted round-robin: every turn, each open channel earns
+	// its weight in credit, and the one with the most credit goes first
+	// and pays back the total.
+	credit := make([]int, len(cs))
+	return mergeScan(ctx, chans, func(int) int {
+		best, total := 0, 0
+		for i, c := range chans {
+			// chans is shared with mergeScan, which clears the
+			// channels that have been closed.
+			if c == nil {
+				continue
+			}
+			credit[i] += weights[i]
+			total += weights[i]
+			if chans[best] == nil || credit[i] > credit[best] {
+				best = i
+			}
+		}
+		credit[best] -= total
+		return best
+	})
+}
@@ -0,0 +1,159 @@
+package pipeline
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+)
+
+// collect returns every value received from c, failing t if c is not closed
+// within a second.
+func collect[T any](t *testing.T, c <-chan T) []T {
+	t.Helper()
+	var vs []T
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case v, ok := <-c:
+			if !ok {
+				return vs
+			}
+			vs = append(vs, v)
+		case <-timeout:
+			t.Fatalf("output not closed, got %v so far", vs)
+		}
+	}
+}
+
+// sortedInts returns a sorted copy of vs.
+func sortedInts(vs []int) []int {
+	vs = append([]int(nil), vs...)
+	sort.Ints(vs)
+	return vs
+}
+
+// idleThenSend returns a channel that stays idle until the returned function
+// is called, then sends vs and is closed.
+func idleThenSend(vs ...int) (<-chan int, func()) {
+	c := make(chan int)
+	start := make(chan struct{})
+	go func() {
+		defer close(c)
+		<-start
+		for _, v := range vs {
+			c <- v
+		}
+	}()
+	return c, func() { close(start) }
+}
+
+func TestMergePriorityEarlyClose(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// The empty channel is closed right away, while nothing is ready on
+	// the other, so that the merge is waiting on both when it closes.
+	empty := make(chan int)
+	close(empty)
+	late, start := idleThenSend(1, 2, 3)
+	out := MergePriority(ctx, 0, Prioritized[int]{empty, 2}, Prioritized[int]{late, 1})
+	time.Sleep(10 * time.Millisecond)
+	start()
+	got := collect(t, out)
+	if len(got) != 3 || got[0] != 1 || got[1] != 2 || got[2] != 3 {
+		t.Errorf("got %v, want [1 2 3]", got)
+	}
+}
+
+func TestMergePriority(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	high, low := make(chan int, 3), make(chan int, 3)
+	for i := 0; i < 3; i++ {
+		high <- 10 + i
+		low <- i
+	}
+	close(high)
+	close(low)
+	got := collect(t, MergePriority(ctx, 0, Prioritized[int]{low, 1}, Prioritized[int]{high, 2}))
+	want := []int{10, 11, 12, 0, 1, 2}
+	for i := range want {
+		if i >= len(got) || got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestMergeRoundRobinEarlyClose(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	short, start := idleThenSend(1)
+	long, startLong := idleThenSend(2, 3, 4)
+	out := MergeRoundRobin(ctx, short, long)
+	start()
+	// short is closed after its only value, while the merge is waiting.
+	time.Sleep(10 * time.Millisecond)
+	startLong()
+	if got := sortedInts(collect(t, out)); len(got) != 4 || got[0] != 1 || got[3] != 4 {
+		t.Errorf("got %v, want [1 2 3 4]", got)
+	}
+}
+
+func TestMergeRoundRobin(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a, b := make(chan int, 3), make(chan int, 3)
+	for i := 0; i < 3; i++ {
+		a <- i
+		b <- 10 + i
+	}
+	close(a)
+	close(b)
+	got := collect(t, MergeRoundRobin(ctx, a, b))
+	// Both are ready throughout, so they alternate.
+	want := []int{0, 10, 1, 11, 2, 12}
+	for i := range want {
+		if i >= len(got) || got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestMergeWeightedEarlyClose(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	empty := make(chan int)
+	close(empty)
+	late, start := idleThenSend(1, 2)
+	out := MergeWeighted(ctx, Weighted[int]{empty, 3}, Weighted[int]{late, 1})
+	time.Sleep(10 * time.Millisecond)
+	start()
+	if got := collect(t, out); len(got) != 2 || got[0] != 1 || got[1] != 2 {
+		t.Errorf("got %v, want [1 2]", got)
+	}
+}
+
+func TestMergeWeighted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	heavy, light := make(chan int, 6), make(chan int, 6)
+	for i := 0; i < 6; i++ {
+		heavy <- 1
+		light <- 0
+	}
+	close(heavy)
+	close(light)
+	got := collect(t, MergeWeighted(ctx, Weighted[int]{heavy, 3}, Weighted[int]{light, 1}))
+	if len(got) != 12 {
+		t.Fatalf("got %d values, want 12", len(got))
+	}
+	// While both have values ready, heavy gets three for every one of
+	// light's.
+	n := 0
+	for _, v := range got[:8] {
+		n += v
+	}
+	if n != 6 {
+		t.Errorf("got %v, want 6 of the first 8 values from the heavy channel", got)
+	}
+}
@@ -0,0 +1,76 @@
+// Package prom exports the metrics of a pipeline to Prometheus.
+package prom
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"concurrency/pipeline"
+)
+
+// Collector is a prometheus.Collector exporting the counters of a
+// pipeline.Metrics, labelled by stage. Throughput and error rates are
+// obtained by taking the rate of the counters in queries.
+type Collector struct {
+	metrics *pipeline.Metrics
+
+	in, out, errors *prometheus.Desc
+	backlog, active *prometheus.Desc
+	duration, queue *prometheus.Desc
+}
+
+// NewCollector returns a Collector for m. The names of the metrics start with
+// namespace, followed by "_stage_", unless namespace is empty. Register the
+// Collector with prometheus.MustRegister or a registry of your own.
+func NewCollector(m *pipeline.Metrics, namespace string) *Collector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "stage", name), help, []string{"stage"}, nil)
+	}
+	return &Collector{
+		metrics:  m,
+		in:       desc("items_in_total", "Items received by the stage."),
+		out:      desc("items_out_total", "Items sent on by the stage."),
+		errors:   desc("errors_total", "Items the stage failed on."),
+		backlog:  desc("backlog", "Items received by the stage but not yet sent on."),
+		active:   desc("active_workers", "Workers of the stage busy with an item."),
+		duration: desc("item_duration_seconds", "Time taken by the stage on an item."),
+		queue:    desc("item_queue_seconds", "Time an item waited before the stage started on it."),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{c.in, c.out, c.errors, c.backlog, c.active, c.duration, c.queue} {
+		ch <- d
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	for _, s := range c.metrics.Metrics() {
+		ch <- prometheus.MustNewConstMetric(c.in, prometheus.CounterValue, float64(s.In), s.Name)
+		ch <- prometheus.MustNewConstMetric(c.out, prometheus.CounterValue, float64(s.Out), s.Name)
+		ch <- prometheus.MustNewConstMetric(c.errors, prometheus.CounterValue, float64(s.Errors), s.Name)
+		ch <- prometheus.MustNewConstMetric(c.backlog, prometheus.GaugeValue, float64(s.Backlog()), s.Name)
+		ch <- prometheus.MustNewConstMetric(c.active, prometheus.GaugeValue, float64(s.Active), s.Name)
+		ch <- histogram(c.duration, s.Latency, s.Busy, s.Name)
+		ch <- histogram(c.queue, s.QueueLatency, s.Queued, s.Name)
+	}
+}
+
+// histogram returns a histogram of the counts of a StageStats latency, which
+// sum up to sum.
+func histogram(desc *prometheus.Desc, counts []int64, sum time.Duration, stage string) prometheus.Metric {
+	// Prometheus buckets are cumulative, and the count includes the items
+	// slower than the last bucket.
+	buckets := make(map[float64]uint64, len(pipeline.LatencyBuckets))
+	var count uint64
+	for i, n := range counts {
+		count += uint64(n)
+		if i < len(pipeline.LatencyBuckets) {
+			buckets[pipeline.LatencyBuckets[i].Seconds()] = count
+		}
+	}
+	return prometheus.MustNewConstHistogram(desc, count, sum.Seconds(), buckets, stage)
+}
@@ -0,0 +1,84 @@
+package prom
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"concurrency/pipeline"
+)
+
+func TestCollector(t *testing.T) {
+	m := pipeline.NewMetrics()
+	ctx := context.Background()
+	work := pipeline.InstrumentFunc(m, "parse", func(_ context.Context, v int) (int, error) {
+		if v == 3 {
+			return 0, errors.New("bad item")
+		}
+		if v == 2 {
+			time.Sleep(30 * time.Millisecond)
+		}
+		return v, nil
+	})
+	stage := pipeline.Instrument(m, "parse", func(ctx context.Context, in <-chan int) <-chan pipeline.Result[int] {
+		return pipeline.TryMap(ctx, in, work)
+	})
+	for range stage(ctx, pipeline.Gen(ctx, 1, 2, 3)) {
+	}
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(NewCollector(m, "crawler"))
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[string]float64)
+	for _, f := range families {
+		for _, metric := range f.GetMetric() {
+			if l := metric.GetLabel(); len(l) != 1 || l[0].GetName() != "stage" || l[0].GetValue() != "parse" {
+				t.Errorf("%s: got labels %v, want stage=parse", f.GetName(), l)
+			}
+			switch {
+			case metric.Counter != nil:
+				values[f.GetName()] = metric.GetCounter().GetValue()
+			case metric.Gauge != nil:
+				values[f.GetName()] = metric.GetGauge().GetValue()
+			case metric.Histogram != nil:
+				h := metric.GetHistogram()
+				values[f.GetName()] = float64(h.GetSampleCount())
+				if f.GetName() != "crawler_stage_item_duration_seconds" {
+					continue
+				}
+				// Buckets are cumulative: the fast items are in the
+				// first one, and all three in the last.
+				b := h.GetBucket()
+				if len(b) != len(pipeline.LatencyBuckets) || b[0].GetUpperBound() != 0.005 ||
+					b[0].GetCumulativeCount() != 2 || b[len(b)-1].GetCumulativeCount() != 3 {
+					t.Errorf("got buckets %v", b)
+				}
+				if h.GetSampleSum() < 0.03 {
+					t.Errorf("got sum %vs, want at least the slow item's 30ms", h.GetSampleSum())
+				}
+			}
+		}
+	}
+	for name, want := range map[string]float64{
+		"crawler_stage_items_in_total":        3,
+		"crawler_stage_items_out_total":       3,
+		"crawler_stage_errors_total":          1,
+		"crawler_stage_backlog":               0,
+		"crawler_stage_active_workers":        0,
+		"crawler_stage_item_duration_seconds": 3,
+		"crawler_stage_item_queue_seconds":    0,
+	} {
+		got, ok := values[name]
+		if !ok {
+			t.Errorf("%s not exported", name)
+		} else if got != want {
+			t.Errorf("%s = %v, want %v", name, got, want)
+		}
+	}
+}
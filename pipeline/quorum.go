@@ -0,0 +1,61 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrNoQuorum is returned by Quorum when too many of its sources failed for
+// it to collect the values it needs.
+var ErrNoQuorum = errors.New("pipeline: no quorum")
+
+// Quorum waits for the first result of each of cs and returns once n of them
+// have delivered a value, for querying several equivalent sources and
+// going ahead as soon as enough of them agree to answer. A source fails if it
+// delivers an error, or closes without delivering anything; once so many have
+// failed that n values can no longer be collected, Quorum returns
+// ErrNoQuorum joined with their errors. Only the first result of every source
+// is used; the sources are not drained, so start them with a context that is
+// cancelled once Quorum returns.
+func Quorum[T any](ctx context.Context, n int, cs ...<-chan Result[T]) ([]T, error) {
+	if n > len(cs) {
+		return nil, fmt.Errorf("%w: need %d values from %d sources", ErrNoQuorum, n, len(cs))
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// Buffered, so that the sources still being read once Quorum has
+	// returned can finish.
+	results := make(chan Result[T], len(cs))
+	for _, c := range cs {
+		go func(c <-chan Result[T]) {
+			select {
+			case r, ok := <-c:
+				if !ok {
+					r.Err = errors.New("pipeline: source closed without a result")
+				}
+				results <- r
+			case <-ctx.Done():
+			}
+		}(c)
+	}
+
+	vals := make([]T, 0, n)
+	errs := []error{ErrNoQuorum}
+	for len(vals) < n {
+		select {
+		case r := <-results:
+			if r.Err != nil {
+				errs = append(errs, r.Err)
+				if len(cs)-(len(errs)-1) < n {
+					return vals, errors.Join(errs...)
+				}
+				continue
+			}
+			vals = append(vals, r.Value)
+		case <-ctx.Done():
+			return vals, ctx.Err()
+		}
+	}
+	return vals, nil
+}
@@ -0,0 +1,69 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// source returns a channel delivering rs and then closed.
+func source(rs ...Result[int]) <-chan Result[int] {
+	c := make(chan Result[int], len(rs))
+	for _, r := range rs {
+		c <- r
+	}
+	close(c)
+	return c
+}
+
+func TestQuorum(t *testing.T) {
+	ctx := context.Background()
+	// The third source never answers, which does not matter once two
+	// have.
+	hung := make(chan Result[int])
+	vals, err := Quorum(ctx, 2, source(Result[int]{Value: 1}), hung, source(Result[int]{Value: 2}, Result[int]{Value: 3}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the first result of every source counts.
+	if got := sortedInts(vals); len(got) != 2 || got[0] != 1 || got[1] != 2 {
+		t.Errorf("got %v, want 1 and 2", vals)
+	}
+}
+
+func TestQuorumFails(t *testing.T) {
+	ctx := context.Background()
+	hung := make(chan Result[int])
+	vals, err := Quorum(ctx, 3,
+		source(Result[int]{Value: 1}),
+		source(Result[int]{Err: errors.New("timeout")}),
+		source(),
+		hung,
+	)
+	// Two failures out of four leave too few sources for three values,
+	// without waiting for the one that hangs.
+	if !errors.Is(err, ErrNoQuorum) {
+		t.Fatalf("got %v, want ErrNoQuorum", err)
+	}
+	for _, want := range []string{"timeout", "source closed without a result"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("got %q, want the error %q joined", err, want)
+		}
+	}
+	if len(vals) > 1 {
+		t.Errorf("got %v, want at most the value collected so far", vals)
+	}
+
+	if _, err := Quorum(ctx, 3, source(), source()); !errors.Is(err, ErrNoQuorum) {
+		t.Errorf("got %v for more values than sources, want ErrNoQuorum", err)
+	}
+}
+
+func TestQuorumCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Quorum(ctx, 1, make(chan Result[int])); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,184 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// A Limiter decides how fast items may pass. Wait blocks until the next item
+// may pass, or returns an error if the context is cancelled first or the
+// limiter cannot let it through. *rate.Limiter from golang.org/x/time/rate
+// satisfies it.
+type Limiter interface {
+	Wait(ctx context.Context) error
+}
+
+// PerSecond returns a Limiter that lets n items through per second, evenly
+// spaced. It is safe for concurrent use, so a single limiter can be shared by
+// several stages to cap their combined rate. An infinite n lets every item
+// through at once, and an n that is not positive lets none through: Wait
+// fails straight away.
+func PerSecond(n float64) Limiter {
+	if !(n > 0) {
+		return closedLimiter{fmt.Errorf("pipeline: a rate of %v per second lets nothing through", n)}
+	}
+	return &interval{every: time.Duration(float64(time.Second) / n)}
+}
+
+// closedLimiter is a Limiter that lets nothing through.
+type closedLimiter struct {
+	err error
+}
+
+func (l closedLimiter) Wait(context.Context) error {
+	return l.err
+}
+
+// interval is a Limiter that lets an item through every so often.
+type interval struct {
+	every time.Duration
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+func (l *interval) Wait(ctx context.Context) error {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	at := l.next
+	l.next = l.next.Add(l.every)
+	l.mu.Unlock()
+
+	d := time.Until(at)
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// RateLimit passes the values received from in through to its output no
+// faster than limiter allows. The output channel is closed once in has been
+// closed, or once the limiter fails, which it does when the context is
+// cancelled.
+func RateLimit[T any](ctx context.Context, in <-chan T, limiter Limiter) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for v := range in {
+			if err := limiter.Wait(ctx); err != nil {
+				return
+			}
+			select {
+			case out <- v:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// A TokenBucket is a Limiter that lets items through at an average rate while
+// allowing bursts: it holds up to burst tokens, refilled at rate per second,
+// and every item takes one. Share a single TokenBucket between the stages of
+// a pipeline to keep their combined rate under a global budget, whatever the
+// rates of the individual stages.
+type TokenBucket struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket returns a full TokenBucket refilled at rate tokens per second
+// and holding at most burst of them. A rate that is not positive never refills
+// the bucket: once its tokens are spent, Wait fails straight away rather than
+// wait forever. An infinite rate keeps it full.
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	if !(rate > 0) {
+		rate = 0
+	}
+	return &TokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// refill adds the tokens accrued since the last call. It must be called with
+// mu held.
+func (b *TokenBucket) refill(now time.Time) {
+	if math.IsInf(b.rate, 1) {
+		b.tokens = b.burst
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+	}
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// Allow takes a token if one is available without waiting, and reports
+// whether it did.
+func (b *TokenBucket) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Wait takes a token, waiting for one to accrue if there is none. Waiters
+// reserve their token on arrival, so they are let through in the order they
+// came. If the context is cancelled first, the reserved token is returned to
+// the bucket.
+func (b *TokenBucket) Wait(ctx context.Context) error {
+	b.mu.Lock()
+	b.refill(time.Now())
+	b.tokens--
+	var d time.Duration
+	if b.tokens < 0 {
+		if b.rate == 0 {
+			b.tokens++
+			b.mu.Unlock()
+			return errors.New("pipeline: TokenBucket with a rate of zero is out of tokens")
+		}
+		d = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.mu.Unlock()
+	if d == 0 {
+		return nil
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		b.mu.Lock()
+		b.refill(time.Now())
+		b.tokens++
+		b.mu.Unlock()
+		return ctx.Err()
+	}
+}
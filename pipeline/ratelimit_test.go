@@ -0,0 +1,114 @@
+package pipeline
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPerSecond(t *testing.T) {
+	ctx := context.Background()
+	l := PerSecond(100)
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		if err := l.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The first item goes through at once, the next five 10ms apart.
+	if d := time.Since(start); d < 50*time.Millisecond || d > 500*time.Millisecond {
+		t.Errorf("6 items at 100/s took %v, want about 50ms", d)
+	}
+}
+
+func TestPerSecondBadRates(t *testing.T) {
+	ctx := context.Background()
+	for _, n := range []float64{0, -1, math.NaN()} {
+		if err := PerSecond(n).Wait(ctx); err == nil {
+			t.Errorf("PerSecond(%v) let an item through", n)
+		}
+	}
+	l := PerSecond(math.Inf(1))
+	start := time.Now()
+	for i := 0; i < 1000; i++ {
+		if err := l.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Errorf("an infinite rate held 1000 items for %v", d)
+	}
+}
+
+func TestRateLimitStopsOnFailingLimiter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	in := make(chan int, 1)
+	in <- 1
+	if _, ok := <-RateLimit(ctx, in, PerSecond(0)); ok {
+		t.Error("item let through by a zero rate")
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	ctx := context.Background()
+	b := NewTokenBucket(100, 3)
+	for i := 0; i < 3; i++ {
+		if !b.Allow() {
+			t.Fatalf("burst token %d not allowed", i)
+		}
+	}
+	if b.Allow() {
+		t.Fatal("allowed past the burst")
+	}
+	start := time.Now()
+	if err := b.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 200*time.Millisecond {
+		t.Errorf("waited %v for a token refilled at 100/s", d)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	slow := NewTokenBucket(0.001, 1)
+	slow.Allow()
+	if err := slow.Wait(ctx); err == nil {
+		t.Fatal("no error from a cancelled Wait")
+	}
+}
+
+func TestTokenBucketBadRates(t *testing.T) {
+	ctx := context.Background()
+	for _, rate := range []float64{0, -5, math.NaN()} {
+		b := NewTokenBucket(rate, 2)
+		if err := b.Wait(ctx); err != nil {
+			t.Fatalf("rate %v: burst token refused: %v", rate, err)
+		}
+		b.Allow()
+		done := make(chan error, 1)
+		go func() { done <- b.Wait(ctx) }()
+		select {
+		case err := <-done:
+			if err == nil {
+				t.Errorf("rate %v: token let through once the burst was spent", rate)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("rate %v: Wait blocked instead of failing", rate)
+		}
+		if b.Allow() {
+			t.Errorf("rate %v: failed Wait left a token behind", rate)
+		}
+	}
+
+	b := NewTokenBucket(math.Inf(1), 1)
+	for i := 0; i < 1000; i++ {
+		if !b.Allow() {
+			t.Fatalf("infinite rate refused token %d", i)
+		}
+	}
+	if err := b.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
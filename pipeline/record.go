@@ -0,0 +1,129 @@
+package pipeline
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+)
+
+// recorded is the on-disk form of an item captured by Record: one JSON object
+// per line.
+type recorded[T any] struct {
+	Time time.Time `json:"time"`
+	Item T         `json:"item"`
+}
+
+// Record passes every item received from in through unchanged, while writing
+// it to w together with the time it was seen, so that the stream can later be
+// fed back into a pipeline with Replay. Placed right after a source, it
+// captures production input for reproducing bugs locally.
+//
+// A failure to write the recording does not disturb the stream: recording
+// stops and the error is sent on the error channel once the output channel has
+// been closed. Output is buffered and flushed whenever in has no further item
+// immediately available.
+func Record[T any](ctx context.Context, in <-chan T, w io.Writer) (<-chan T, <-chan error) {
+	out := make(chan T)
+	errc := make(chan error, 1)
+	go func() {
+		bw := bufio.NewWriter(w)
+		enc := json.NewEncoder(bw)
+		var err error
+		// Registered first, so that the error is sent once out has been
+		// closed.
+		defer func() {
+			if err == nil {
+				err = bw.Flush()
+			}
+			// No select needed for this send, since errc is buffered.
+			errc <- err
+		}()
+		defer close(out)
+		for {
+			var (
+				v  T
+				ok bool
+			)
+			select {
+			case v, ok = <-in:
+			default:
+				if err == nil {
+					err = bw.Flush()
+				}
+				select {
+				case v, ok = <-in:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if !ok {
+				return
+			}
+			if err == nil {
+				err = enc.Encode(recorded[T]{time.Now(), v})
+			}
+			select {
+			case out <- v:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, errc
+}
+
+// Replay emits the items of a recording made by Record, read from r. The items
+// are spaced out as they were when recorded, sped up by a factor of speed: 1
+// replays in real time, 10 ten times faster. A speed of zero or less replays
+// the items as fast as the pipeline accepts them. The error channel receives
+// the result of reading the recording once the output channel has been
+// closed.
+func Replay[T any](ctx context.Context, r io.Reader, speed float64) (<-chan T, <-chan error) {
+	out := make(chan T)
+	errc := make(chan error, 1)
+	go func() {
+		var err error
+		// Registered first, so that the error is sent once out has been
+		// closed. No select needed for this send, since errc is buffered.
+		defer func() { errc <- err }()
+		defer close(out)
+		dec := json.NewDecoder(bufio.NewReader(r))
+		var first time.Time
+		start := time.Now()
+		for {
+			var rec recorded[T]
+			if err = dec.Decode(&rec); err != nil {
+				if errors.Is(err, io.EOF) {
+					err = nil
+				}
+				return
+			}
+			if first.IsZero() {
+				first = rec.Time
+			}
+			if speed > 0 {
+				at := start.Add(time.Duration(float64(rec.Time.Sub(first)) / speed))
+				if d := time.Until(at); d > 0 {
+					t := time.NewTimer(d)
+					select {
+					case <-t.C:
+					case <-ctx.Done():
+						t.Stop()
+						err = ctx.Err()
+						return
+					}
+				}
+			}
+			select {
+			case out <- rec.Item:
+			case <-ctx.Done():
+				err = ctx.Err()
+				return
+			}
+		}
+	}()
+	return out, errc
+}
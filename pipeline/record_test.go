@@ -0,0 +1,74 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordReplay(t *testing.T) {
+	ctx := context.Background()
+	var rec bytes.Buffer
+	out, errc := Record(ctx, Gen(ctx, point{1, 2}, point{3, 4}), &rec)
+	passed := collect(t, out)
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	want := []point{{1, 2}, {3, 4}}
+	if !reflect.DeepEqual(passed, want) {
+		t.Errorf("passed on %v, want %v", passed, want)
+	}
+
+	replayed, errc := Replay[point](ctx, &rec, 0)
+	if got := collect(t, replayed); !reflect.DeepEqual(got, want) {
+		t.Errorf("replayed %v, want %v", got, want)
+	}
+	if err := <-errc; err != nil {
+		t.Errorf("replay: %v", err)
+	}
+}
+
+func TestRecordWriteError(t *testing.T) {
+	ctx := context.Background()
+	out, errc := Record(ctx, Gen(ctx, 1, 2, 3), failingWriter{})
+	// A failing recording does not disturb the stream.
+	if got := collect(t, out); !reflect.DeepEqual(got, []int{1, 2, 3}) {
+		t.Errorf("got %v, want [1 2 3]", got)
+	}
+	if err := <-errc; err == nil || err.Error() != "disk full" {
+		t.Errorf("got %v, want the write error", err)
+	}
+}
+
+func TestReplaySpeed(t *testing.T) {
+	ctx := context.Background()
+	recording := `{"time":"2024-01-01T00:00:00Z","item":1}
+{"time":"2024-01-01T00:00:00.2Z","item":2}
+`
+	start := time.Now()
+	out, errc := Replay[int](ctx, strings.NewReader(recording), 10)
+	if got := collect(t, out); !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Errorf("got %v, want [1 2]", got)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	// 200ms sped up ten times.
+	if d := time.Since(start); d < 20*time.Millisecond || d > 150*time.Millisecond {
+		t.Errorf("replay took %v, want about 20ms", d)
+	}
+}
+
+func TestReplayCorrupt(t *testing.T) {
+	ctx := context.Background()
+	out, errc := Replay[int](ctx, strings.NewReader(`{"time":"2024-01-01T00:00:00Z","item":1}`+"\n{not json"), 0)
+	if got := collect(t, out); !reflect.DeepEqual(got, []int{1}) {
+		t.Errorf("got %v, want the items before the corruption", got)
+	}
+	if err := <-errc; err == nil {
+		t.Errorf("got %v, want a decoding error", err)
+	}
+}
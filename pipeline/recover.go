@@ -0,0 +1,46 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+)
+
+// A PanicError is a panic recovered by Recover, turned into an error.
+type PanicError struct {
+	// Value is the value the function panicked with.
+	Value any
+	// Stack is the stack trace of the goroutine at the time of the panic.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("pipeline: panic: %v\n\n%s", e.Value, e.Stack)
+}
+
+// Unwrap returns the value the function panicked with if it is an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// Recover wraps fn so that a panic while it works on an item is recovered and
+// returned as a *PanicError for that item, instead of crashing the process.
+// Used with TryMap, the panic ends up on the result stream like any other
+// failure and the stage carries on with the next item:
+//
+//	results := TryMap(ctx, pages, Recover(parse))
+func Recover[In, Out any](fn func(context.Context, In) (Out, error)) func(context.Context, In) (Out, error) {
+	return func(ctx context.Context, v In) (out Out, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				var zero Out
+				pe := &PanicError{Value: r, Stack: debug.Stack()}
+				log(ctx, slog.LevelError, "pipeline: worker panicked", "panic", r, "stack", string(pe.Stack))
+				out, err = zero, pe
+			}
+		}()
+		return fn(ctx, v)
+	}
+}
@@ -0,0 +1,43 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRecover(t *testing.T) {
+	ctx := context.Background()
+	parse := func(_ context.Context, s string) (int, error) {
+		switch s {
+		case "panic":
+			panic("cannot parse")
+		case "eof":
+			panic(io.EOF)
+		}
+		return len(s), nil
+	}
+	vals, errs, err := Collect(ctx, TryMap(ctx, Gen(ctx, "a", "panic", "bb", "eof"), Recover(parse)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The stage carries on after the panics.
+	if !reflect.DeepEqual(vals, []int{1, 2}) {
+		t.Errorf("got values %v, want [1 2]", vals)
+	}
+	if len(errs) != 2 {
+		t.Fatalf("got errors %v, want one per panic", errs)
+	}
+	var pe *PanicError
+	if !errors.As(errs[0], &pe) || pe.Value != "cannot parse" {
+		t.Errorf("got %v, want a PanicError holding the panic value", errs[0])
+	} else if !strings.Contains(string(pe.Stack), "TestRecover") {
+		t.Errorf("stack trace does not lead back to the panic:\n%s", pe.Stack)
+	}
+	if !errors.Is(errs[1], io.EOF) {
+		t.Errorf("got %v, want a panic with an error to unwrap to it", errs[1])
+	}
+}
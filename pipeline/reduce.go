@@ -0,0 +1,24 @@
+package pipeline
+
+import "context"
+
+// Reduce drains in, folding every value into an accumulator that starts out
+// as initial, and returns the final accumulator once in is closed. If the
+// context is cancelled first, it returns the accumulator so far along with
+// the context's error.
+//
+//	sum, err := Reduce(ctx, nums, 0, func(acc, n int) int { return acc + n })
+func Reduce[T, A any](ctx context.Context, in <-chan T, initial A, fn func(A, T) A) (A, error) {
+	acc := initial
+	for {
+		select {
+		case v, ok := <-in:
+			if !ok {
+				return acc, nil
+			}
+			acc = fn(acc, v)
+		case <-ctx.Done():
+			return acc, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestReduce(t *testing.T) {
+	ctx := context.Background()
+	got, err := Reduce(ctx, Gen(ctx, "a", "bb", "ccc"), 0, func(acc int, s string) int { return acc + len(s) })
+	if err != nil || got != 6 {
+		t.Errorf("got %v, %v, want 6", got, err)
+	}
+	if got, err := Reduce(ctx, Gen[int](ctx), 42, sum); err != nil || got != 42 {
+		t.Errorf("got %v, %v for an empty input, want the initial value", got, err)
+	}
+}
+
+func TestReduceCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan int)
+	go func() {
+		in <- 1
+		in <- 2
+		// The input is never closed.
+		cancel()
+	}()
+	got, err := Reduce(ctx, in, 0, sum)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+	if got != 3 {
+		t.Errorf("got %d, want the sum so far", got)
+	}
+}
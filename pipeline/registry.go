@@ -0,0 +1,64 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]any)
+)
+
+// Register makes the work function fn available under name, for programs and
+// the config package to look it up by name with Lookup. Libraries of stages
+// register their functions in init functions, so that importing them is
+// enough to make them available:
+//
+//	func init() {
+//		pipeline.Register("parse-product", parseProduct)
+//	}
+//
+// Register panics if a function is already registered under name.
+func Register[In, Out any](name string, fn func(context.Context, In) (Out, error)) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if fn == nil {
+		panic("pipeline: Register of nil function " + name)
+	}
+	if _, ok := registry[name]; ok {
+		panic("pipeline: Register called twice for " + name)
+	}
+	registry[name] = fn
+}
+
+// Lookup returns the work function registered under name. It fails if there
+// is none, or if the function registered does not take In and return Out.
+func Lookup[In, Out any](name string) (func(context.Context, In) (Out, error), error) {
+	registryMu.RLock()
+	f, ok := registry[name]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("pipeline: no function registered as %q", name)
+	}
+	fn, ok := f.(func(context.Context, In) (Out, error))
+	if !ok {
+		var want func(context.Context, In) (Out, error)
+		return nil, fmt.Errorf("pipeline: function registered as %q is a %T, not a %T", name, f, want)
+	}
+	return fn, nil
+}
+
+// Registered returns the names of the registered functions, sorted.
+func Registered() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
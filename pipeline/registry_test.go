@@ -0,0 +1,46 @@
+package pipeline
+
+import (
+	"context"
+	"strconv"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	t.Cleanup(func() {
+		registryMu.Lock()
+		delete(registry, "registry-test-itoa")
+		registryMu.Unlock()
+	})
+	Register("registry-test-itoa", func(_ context.Context, v int) (string, error) { return strconv.Itoa(v), nil })
+	fn, err := Lookup[int, string]("registry-test-itoa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := fn(context.Background(), 42); s != "42" {
+		t.Errorf("got %q from the registered function, want 42", s)
+	}
+	found := false
+	for _, name := range Registered() {
+		found = found || name == "registry-test-itoa"
+	}
+	if !found {
+		t.Errorf("registered function not in %v", Registered())
+	}
+
+	_, err = Lookup[string, string]("registry-test-itoa")
+	want := `pipeline: function registered as "registry-test-itoa" is a func(context.Context, int) (string, error), not a func(context.Context, string) (string, error)`
+	if err == nil || err.Error() != want {
+		t.Errorf("got %v, want %q", err, want)
+	}
+	if _, err := Lookup[int, string]("registry-test-missing"); err == nil {
+		t.Error("looked up a function never registered")
+	}
+
+	mustPanic(t, "registering a name twice", func() {
+		Register("registry-test-itoa", func(_ context.Context, v int) (string, error) { return "", nil })
+	})
+	mustPanic(t, "registering nil", func() {
+		Register[int, int]("registry-test-nil", nil)
+	})
+}
@@ -0,0 +1,342 @@
+// Package remote ships the items of a pipeline stage to worker processes over
+// gRPC streams, letting CPU-heavy stages scale beyond one machine while the
+// rest of the pipeline stays unchanged.
+//
+// Worker processes register the stage's function on a gRPC server with
+// Register. The pipeline replaces the local stage with Stage, which spreads
+// items over one stream per worker connection, limits the number of items in
+// flight on each, checks that workers are alive with heartbeats and hands the
+// items of a worker that fails over to the others. Items and results travel
+// as JSON.
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// Options tune Stage. The zero value gives sensible defaults.
+type Options struct {
+	// Window is the largest number of items in flight on a single worker.
+	// Zero means 16.
+	Window int
+	// Heartbeat is the interval between heartbeats. A worker that has not
+	// been heard from for three intervals is considered dead. Zero means
+	// five seconds.
+	Heartbeat time.Duration
+	// Reconnect is the wait before a failed worker stream is opened again.
+	// Zero means one second.
+	Reconnect time.Duration
+	// MaxAttempts is the number of workers an item is tried on before it is
+	// given up, with an error, because its workers kept failing. Zero means
+	// 3.
+	MaxAttempts int
+}
+
+// Result is the outcome of processing a single item remotely.
+type Result[In, Out any] struct {
+	Item  In
+	Value Out
+	Err   error
+}
+
+// ErrWorkersFailed is the error of a result whose item was in flight on, or
+// waiting for, Options.MaxAttempts workers that all failed.
+var ErrWorkersFailed = errors.New("remote: item failed on every worker attempted")
+
+type task[In any] struct {
+	item     In
+	attempts int
+}
+
+// Stage sends every item received from in to one of the workers reachable
+// through conns and sends the results on the returned channel, in the order
+// they complete. An error returned by the worker's function is reported in
+// the Err field of the item's result. The output channel is closed once in is
+// closed and every item has a result, or once the context is cancelled.
+//
+// A worker whose stream cannot be opened charges an attempt to the next item
+// waiting to be handed out, so that items are given up on, rather than kept
+// waiting, when no worker can be reached. Stage fails if conns is empty.
+func Stage[In, Out any](ctx context.Context, in <-chan In, conns []grpc.ClientConnInterface, opt Options) (<-chan Result[In, Out], error) {
+	if len(conns) == 0 {
+		return nil, errors.New("remote: Stage needs at least one connection")
+	}
+	if opt.Window <= 0 {
+		opt.Window = 16
+	}
+	if opt.Heartbeat <= 0 {
+		opt.Heartbeat = 5 * time.Second
+	}
+	if opt.Reconnect <= 0 {
+		opt.Reconnect = time.Second
+	}
+	if opt.MaxAttempts <= 0 {
+		opt.MaxAttempts = 3
+	}
+	d := &dispatcher[In, Out]{
+		opt:      opt,
+		out:      make(chan Result[In, Out]),
+		tasks:    make(chan task[In]),
+		requeue:  make(chan task[In]),
+		done:     make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+	var wg sync.WaitGroup
+	wg.Add(len(conns))
+	for _, conn := range conns {
+		go func(conn grpc.ClientConnInterface) {
+			defer wg.Done()
+			d.work(ctx, conn)
+		}(conn)
+	}
+	go d.dispatch(ctx, in)
+	go func() {
+		wg.Wait()
+		close(d.out)
+	}()
+	return d.out, nil
+}
+
+type dispatcher[In, Out any] struct {
+	opt Options
+	out chan Result[In, Out]
+	// tasks hands items to the workers that have room for them. It is
+	// closed once there is nothing left to do.
+	tasks chan task[In]
+	// requeue takes back the items that were in flight on a failed worker.
+	requeue chan task[In]
+	// done receives a value for every item that has got its result.
+	done chan struct{}
+	// finished is closed along with tasks, so that workers waiting to
+	// reconnect learn that there is no more work.
+	finished chan struct{}
+}
+
+// dispatch queues the items received from in, and those taken back from
+// failed workers, and hands them out to workers.
+func (d *dispatcher[In, Out]) dispatch(ctx context.Context, in <-chan In) {
+	var (
+		queue       []task[In]
+		outstanding int
+	)
+	for in != nil || len(queue) > 0 || outstanding > 0 {
+		var (
+			send chan<- task[In]
+			next task[In]
+		)
+		if len(queue) > 0 {
+			send, next = d.tasks, queue[0]
+		}
+		select {
+		case v, ok := <-in:
+			if !ok {
+				in = nil
+				continue
+			}
+			queue = append(queue, task[In]{item: v})
+		case send <- next:
+			queue = queue[1:]
+			outstanding++
+		case t := <-d.requeue:
+			outstanding--
+			queue = append(queue, t)
+		case <-d.done:
+			outstanding--
+		case <-ctx.Done():
+			return
+		}
+	}
+	close(d.tasks)
+	close(d.finished)
+}
+
+// work keeps a stream to conn open, reopening it after failures, and
+// processes items on it until there are no more.
+func (d *dispatcher[In, Out]) work(ctx context.Context, conn grpc.ClientConnInterface) {
+	for {
+		finished := d.session(ctx, conn)
+		if finished || ctx.Err() != nil {
+			return
+		}
+		t := time.NewTimer(d.opt.Reconnect)
+		select {
+		case <-t.C:
+		case <-d.finished:
+			t.Stop()
+			return
+		case <-ctx.Done():
+			t.Stop()
+			return
+		}
+	}
+}
+
+// session processes items on a single stream. It reports true once there are
+// no more items to process, and false if the stream failed, in which case the
+// items in flight on it have been handed back to the dispatcher.
+func (d *dispatcher[In, Out]) session(ctx context.Context, conn grpc.ClientConnInterface) bool {
+	// The stream has a context of its own, so that a failed stream can be
+	// torn down while its items are handed back under the outer context.
+	sctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := conn.NewStream(sctx, &grpc.StreamDesc{
+		StreamName:    methodName,
+		ServerStreams: true,
+		ClientStreams: true,
+	}, fullMethod, grpc.CallContentSubtype(codecName))
+	if err != nil {
+		// Charge the failure to the next item, as if it had been sent on
+		// the stream, so that it is given up on once no worker can be
+		// reached.
+		select {
+		case t, ok := <-d.tasks:
+			if !ok {
+				return true
+			}
+			d.retry(ctx, t)
+		case <-ctx.Done():
+		}
+		return false
+	}
+
+	// The receiving goroutine records when the worker was last heard from
+	// itself, so that a slow consumer of the results is not mistaken for a
+	// dead worker.
+	var lastSeen atomic.Int64
+	lastSeen.Store(time.Now().UnixNano())
+	recv := make(chan *message)
+	go func() {
+		defer close(recv)
+		for {
+			m := new(message)
+			if err := stream.RecvMsg(m); err != nil {
+				return
+			}
+			lastSeen.Store(time.Now().UnixNano())
+			if m.ID == 0 {
+				continue
+			}
+			select {
+			case recv <- m:
+			case <-sctx.Done():
+				return
+			}
+		}
+	}()
+
+	heartbeat := time.NewTicker(d.opt.Heartbeat)
+	defer heartbeat.Stop()
+	pending := make(map[uint64]task[In])
+	var id uint64
+	tasks := d.tasks
+	// fail hands the items in flight back to the dispatcher, or gives up on
+	// those that have failed too often.
+	fail := func() bool {
+		cancel()
+		for _, t := range pending {
+			if !d.retry(ctx, t) {
+				return false
+			}
+		}
+		return false
+	}
+
+	for {
+		taskc := tasks
+		if len(pending) >= d.opt.Window {
+			taskc = nil
+		}
+		select {
+		case t, ok := <-taskc:
+			if !ok {
+				tasks = nil
+				if len(pending) == 0 {
+					stream.CloseSend()
+					return true
+				}
+				continue
+			}
+			payload, err := json.Marshal(t.item)
+			if err != nil {
+				if !d.deliver(ctx, Result[In, Out]{Item: t.item, Err: err}) {
+					return false
+				}
+				continue
+			}
+			id++
+			pending[id] = t
+			if err := stream.SendMsg(&message{ID: id, Payload: payload}); err != nil {
+				return fail()
+			}
+		case m, ok := <-recv:
+			if !ok {
+				return fail()
+			}
+			t, ok := pending[m.ID]
+			if !ok {
+				continue
+			}
+			delete(pending, m.ID)
+			r := Result[In, Out]{Item: t.item}
+			if m.Error != "" {
+				r.Err = errors.New(m.Error)
+			} else if err := json.Unmarshal(m.Payload, &r.Value); err != nil {
+				r.Err = err
+			}
+			if !d.deliver(ctx, r) {
+				return false
+			}
+			if tasks == nil && len(pending) == 0 {
+				stream.CloseSend()
+				return true
+			}
+		case <-heartbeat.C:
+			if time.Since(time.Unix(0, lastSeen.Load())) > 3*d.opt.Heartbeat {
+				return fail()
+			}
+			if err := stream.SendMsg(&message{}); err != nil {
+				return fail()
+			}
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// retry counts a failed attempt at t and hands it back to the dispatcher, or
+// gives up on it if it has failed too often. It reports false if the context
+// was cancelled.
+func (d *dispatcher[In, Out]) retry(ctx context.Context, t task[In]) bool {
+	t.attempts++
+	if t.attempts >= d.opt.MaxAttempts {
+		return d.deliver(ctx, Result[In, Out]{Item: t.item, Err: ErrWorkersFailed})
+	}
+	select {
+	case d.requeue <- t:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// deliver sends r downstream and tells the dispatcher that its item is done.
+func (d *dispatcher[In, Out]) deliver(ctx context.Context, r Result[In, Out]) bool {
+	select {
+	case d.out <- r:
+	case <-ctx.Done():
+		return false
+	}
+	select {
+	case d.done <- struct{}{}:
+	case <-ctx.Done():
+		return false
+	}
+	return true
+}
@@ -0,0 +1,136 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// worker starts an in-process worker running fn and returns a connection to
+// it.
+func worker(t *testing.T, fn func(context.Context, int) (string, error)) grpc.ClientConnInterface {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	Register(s, fn, 4)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// unreachable is a connection on which no stream can be opened.
+type unreachable struct{ grpc.ClientConnInterface }
+
+func (unreachable) NewStream(context.Context, *grpc.StreamDesc, string, ...grpc.CallOption) (grpc.ClientStream, error) {
+	return nil, errors.New("connection refused")
+}
+
+func feed(n int) <-chan int {
+	in := make(chan int)
+	go func() {
+		defer close(in)
+		for i := 0; i < n; i++ {
+			in <- i
+		}
+	}()
+	return in
+}
+
+func TestStage(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	conn := worker(t, func(_ context.Context, v int) (string, error) {
+		if v == 3 {
+			return "", errors.New("three")
+		}
+		return strconv.Itoa(v * 2), nil
+	})
+	out, err := Stage[int, string](ctx, feed(10), []grpc.ClientConnInterface{conn}, Options{Window: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for r := range out {
+		switch {
+		case r.Item == 3:
+			if r.Err == nil || r.Err.Error() != "three" {
+				t.Errorf("item 3: got error %v, want three", r.Err)
+			}
+		case r.Err != nil:
+			t.Errorf("item %d: %v", r.Item, r.Err)
+		case r.Value != strconv.Itoa(r.Item*2):
+			t.Errorf("item %d: got %q", r.Item, r.Value)
+		default:
+			got = append(got, r.Value)
+		}
+	}
+	if len(got) != 9 {
+		t.Errorf("got %d successful results, want 9", len(got))
+	}
+}
+
+func TestStageNoConns(t *testing.T) {
+	if _, err := Stage[int, string](context.Background(), feed(1), nil, Options{}); err == nil {
+		t.Error("Stage without connections succeeded")
+	}
+}
+
+func TestStageUnreachable(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	conns := []grpc.ClientConnInterface{unreachable{}, unreachable{}}
+	out, err := Stage[int, string](ctx, feed(5), conns, Options{Reconnect: time.Millisecond, MaxAttempts: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var items []int
+	for r := range out {
+		if !errors.Is(r.Err, ErrWorkersFailed) {
+			t.Errorf("item %d: got error %v, want ErrWorkersFailed", r.Item, r.Err)
+		}
+		items = append(items, r.Item)
+	}
+	if ctx.Err() != nil {
+		t.Fatal("items were not given up on")
+	}
+	sort.Ints(items)
+	if len(items) != 5 || items[0] != 0 || items[4] != 4 {
+		t.Errorf("got results for %v, want 0 to 4", items)
+	}
+}
+
+func TestStageFailover(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	conn := worker(t, func(_ context.Context, v int) (string, error) { return strconv.Itoa(v), nil })
+	conns := []grpc.ClientConnInterface{unreachable{}, conn}
+	out, err := Stage[int, string](ctx, feed(20), conns, Options{Reconnect: time.Millisecond, MaxAttempts: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for r := range out {
+		if r.Err != nil {
+			t.Errorf("item %d: %v", r.Item, r.Err)
+		}
+		n++
+	}
+	if n != 20 {
+		t.Errorf("got %d results, want 20", n)
+	}
+}
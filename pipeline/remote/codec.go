@@ -0,0 +1,37 @@
+package remote
+
+import (
+	"encoding/json"
+
+	"google.golang.org/grpc/encoding"
+)
+
+// codecName is the gRPC content subtype under which messages are exchanged.
+// Items travel as JSON, so that any JSON-encodable type can be shipped
+// without generated protobuf code.
+const codecName = "pipeline-json"
+
+type codec struct{}
+
+func (codec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (codec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+func (codec) Name() string                       { return codecName }
+
+func init() {
+	encoding.RegisterCodec(codec{})
+}
+
+// message is exchanged in both directions of a work stream. A message with a
+// zero ID is a heartbeat: the client sends one at every heartbeat interval and
+// the worker answers it with one of its own.
+type message struct {
+	ID      uint64          `json:"id,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+const (
+	serviceName = "pipeline.remote.Worker"
+	methodName  = "Work"
+	fullMethod  = "/" + serviceName + "/" + methodName
+)
@@ -0,0 +1,90 @@
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"google.golang.org/grpc"
+)
+
+// workerServer is the handler type of the service; the handler itself is
+// carried by the closure in the service description.
+type workerServer interface{}
+
+// Register registers a worker service on s that runs fn for every item sent to
+// it by a Stage, with at most concurrency items being processed at once on
+// each stream. Start the server as usual with s.Serve.
+func Register[In, Out any](s *grpc.Server, fn func(context.Context, In) (Out, error), concurrency int) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	s.RegisterService(&grpc.ServiceDesc{
+		ServiceName: serviceName,
+		HandlerType: (*workerServer)(nil),
+		Streams: []grpc.StreamDesc{{
+			StreamName:    methodName,
+			ServerStreams: true,
+			ClientStreams: true,
+			Handler: func(_ any, stream grpc.ServerStream) error {
+				return serve(stream, fn, concurrency)
+			},
+		}},
+	}, struct{}{})
+}
+
+// serve processes the items sent on a single stream until the client closes
+// it or goes away.
+func serve[In, Out any](stream grpc.ServerStream, fn func(context.Context, In) (Out, error), concurrency int) error {
+	ctx := stream.Context()
+	var (
+		sendMu sync.Mutex
+		wg     sync.WaitGroup
+		sem    = make(chan struct{}, concurrency)
+	)
+	send := func(m *message) error {
+		sendMu.Lock()
+		defer sendMu.Unlock()
+		return stream.SendMsg(m)
+	}
+	// Wait for the items in progress before returning: the stream must
+	// not be used once the handler has returned.
+	defer wg.Wait()
+	for {
+		var m message
+		if err := stream.RecvMsg(&m); err != nil {
+			// io.EOF means the client has sent everything; the results
+			// of the items in progress are still delivered.
+			return nil
+		}
+		if m.ID == 0 {
+			if err := send(&message{}); err != nil {
+				return err
+			}
+			continue
+		}
+		// The semaphore is acquired in the item's goroutine, so that the
+		// receive loop keeps answering heartbeats while the worker is
+		// saturated. The client's window bounds the number of goroutines.
+		wg.Add(1)
+		go func(m message) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			defer func() { <-sem }()
+			res := &message{ID: m.ID}
+			var v In
+			if err := json.Unmarshal(m.Payload, &v); err != nil {
+				res.Error = err.Error()
+			} else if out, err := fn(ctx, v); err != nil {
+				res.Error = err.Error()
+			} else if res.Payload, err = json.Marshal(out); err != nil {
+				res.Error = err.Error()
+			}
+			send(res)
+		}(m)
+	}
+}
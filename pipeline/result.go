@@ -0,0 +1,88 @@
+package pipeline
+
+import "context"
+
+// A Result is the outcome of a piece of work that can fail: either a value or
+// the error that prevented it. Stages whose work can fail send Results rather
+// than bare values, so that failures travel down the pipeline alongside
+// successes instead of being dropped.
+type Result[T any] struct {
+	Value T
+	Err   error
+}
+
+// TryMap applies fn to every value received from in and sends its outcome on
+// the returned channel as a Result. It is Map for work that can fail; fn is
+// given the stage's context, so that it can give up on a value once the
+// pipeline is cancelled.
+func TryMap[In, Out any](ctx context.Context, in <-chan In, fn func(context.Context, In) (Out, error)) <-chan Result[Out] {
+	out := make(chan Result[Out])
+	go func() {
+		defer close(out)
+		for v := range in {
+			var r Result[Out]
+			r.Value, r.Err = fn(ctx, v)
+			select {
+			case out <- r:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Split separates a stream of Results into the values of the successful ones
+// and the errors of the failed ones. Both channels are closed once in has
+// been closed or the context has been cancelled. They must be drained
+// concurrently: a value or an error nobody receives holds up the other
+// channel too.
+func Split[T any](ctx context.Context, in <-chan Result[T]) (<-chan T, <-chan error) {
+	vals := make(chan T)
+	errs := make(chan error)
+	go func() {
+		defer close(vals)
+		defer close(errs)
+		for r := range in {
+			if r.Err != nil {
+				select {
+				case errs <- r.Err:
+				case <-ctx.Done():
+					return
+				}
+				continue
+			}
+			select {
+			case vals <- r.Value:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return vals, errs
+}
+
+// Collect drains in and returns the values of the successful Results along
+// with the errors of the failed ones, in the order they were received. It
+// returns early with the context's error if the context is cancelled.
+func Collect[T any](ctx context.Context, in <-chan Result[T]) ([]T, []error, error) {
+	var (
+		vals []T
+		errs []error
+	)
+	for {
+		select {
+		case r, ok := <-in:
+			if !ok {
+				return vals, errs, nil
+			}
+			if r.Err != nil {
+				errs = append(errs, r.Err)
+			} else {
+				vals = append(vals, r.Value)
+			}
+		case <-ctx.Done():
+			return vals, errs, ctx.Err()
+		}
+	}
+}
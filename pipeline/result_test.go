@@ -0,0 +1,81 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestTryMapCollect(t *testing.T) {
+	ctx := context.Background()
+	results := TryMap(ctx, Gen(ctx, "1", "x", "3"), func(_ context.Context, s string) (int, error) {
+		return strconv.Atoi(s)
+	})
+	vals, errs, err := Collect(ctx, results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(vals, []int{1, 3}) {
+		t.Errorf("got values %v, want [1 3]", vals)
+	}
+	var numErr *strconv.NumError
+	if len(errs) != 1 || !errors.As(errs[0], &numErr) || numErr.Num != "x" {
+		t.Errorf("got errors %v, want the failure to parse x", errs)
+	}
+}
+
+func TestCollectCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan Result[int], 1)
+	in <- Result[int]{Value: 1}
+	go func() {
+		// Let Collect take the first result, then give up on the rest.
+		in <- Result[int]{Value: 2}
+		cancel()
+	}()
+	vals, _, err := Collect(ctx, in)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+	if len(vals) == 0 || vals[0] != 1 {
+		t.Errorf("got %v, want the values received before cancelling", vals)
+	}
+}
+
+func TestSplit(t *testing.T) {
+	ctx := context.Background()
+	boom := errors.New("boom")
+	vals, errs := Split(ctx, Gen(ctx,
+		Result[int]{Value: 1},
+		Result[int]{Err: boom},
+		Result[int]{Value: 2},
+	))
+	var (
+		got     []int
+		gotErrs []error
+	)
+	for vals != nil || errs != nil {
+		select {
+		case v, ok := <-vals:
+			if !ok {
+				vals = nil
+				continue
+			}
+			got = append(got, v)
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			gotErrs = append(gotErrs, err)
+		}
+	}
+	if !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Errorf("got values %v, want [1 2]", got)
+	}
+	if len(gotErrs) != 1 || gotErrs[0] != boom {
+		t.Errorf("got errors %v, want [boom]", gotErrs)
+	}
+}
@@ -0,0 +1,68 @@
+package pipeline
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// A RetryPolicy describes how often and how patiently Retry tries an item.
+type RetryPolicy struct {
+	// Attempts is the number of times an item is tried, including the
+	// first. Zero means 3.
+	Attempts int
+	// Backoff is the wait before the first retry; it doubles after every
+	// attempt. Zero means 100 milliseconds.
+	Backoff time.Duration
+	// MaxBackoff caps the wait between attempts. Zero leaves it uncapped.
+	MaxBackoff time.Duration
+	// Jitter is the fraction of every wait that is randomized, between 0
+	// and 1, so that workers failing together do not retry together.
+	Jitter float64
+	// Retryable reports whether an error is worth retrying. If nil, every
+	// error is.
+	Retryable func(error) bool
+}
+
+// Retry wraps fn so that an item it fails on is tried again according to p.
+// The wrapped function returns the last error once the attempts are
+// exhausted or the error is not retryable, and the context's error if the
+// context is cancelled while waiting to retry. Use it with TryMap to retry
+// every item of a stage on its own:
+//
+//	results := TryMap(ctx, urls, Retry(fetch, RetryPolicy{Attempts: 5}))
+func Retry[In, Out any](fn func(context.Context, In) (Out, error), p RetryPolicy) func(context.Context, In) (Out, error) {
+	attempts := p.Attempts
+	if attempts <= 0 {
+		attempts = 3
+	}
+	initial := p.Backoff
+	if initial <= 0 {
+		initial = 100 * time.Millisecond
+	}
+	return func(ctx context.Context, v In) (Out, error) {
+		backoff := initial
+		for i := 1; ; i++ {
+			out, err := fn(ctx, v)
+			if err == nil || i == attempts || (p.Retryable != nil && !p.Retryable(err)) {
+				return out, err
+			}
+			wait := backoff
+			if p.Jitter > 0 {
+				wait -= time.Duration(p.Jitter * rand.Float64() * float64(wait))
+			}
+			t := time.NewTimer(wait)
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+				var zero Out
+				return zero, ctx.Err()
+			}
+			backoff *= 2
+			if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+				backoff = p.MaxBackoff
+			}
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// flaky returns a function failing the first failures calls, and a pointer to
+// the number of calls made.
+func flaky(failures int) (func(context.Context, int) (int, error), *int) {
+	calls := 0
+	return func(_ context.Context, v int) (int, error) {
+		calls++
+		if calls <= failures {
+			return 0, fmt.Errorf("attempt %d failed", calls)
+		}
+		return v, nil
+	}, &calls
+}
+
+func TestRetry(t *testing.T) {
+	ctx := context.Background()
+	fn, calls := flaky(2)
+	start := time.Now()
+	v, err := Retry(fn, RetryPolicy{Attempts: 3, Backoff: 10 * time.Millisecond})(ctx, 7)
+	if err != nil || v != 7 {
+		t.Fatalf("got %v, %v, want 7 on the third attempt", v, err)
+	}
+	if *calls != 3 {
+		t.Errorf("made %d calls, want 3", *calls)
+	}
+	// The backoff doubles: 10ms, then 20ms.
+	if d := time.Since(start); d < 30*time.Millisecond {
+		t.Errorf("retried within %v, want at least 30ms of backoff", d)
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	ctx := context.Background()
+	fn, calls := flaky(5)
+	_, err := Retry(fn, RetryPolicy{Attempts: 3, Backoff: time.Millisecond})(ctx, 1)
+	if err == nil || err.Error() != "attempt 3 failed" {
+		t.Errorf("got %v, want the last attempt's error", err)
+	}
+	if *calls != 3 {
+		t.Errorf("made %d calls, want 3", *calls)
+	}
+}
+
+func TestRetryNotRetryable(t *testing.T) {
+	ctx := context.Background()
+	fn, calls := flaky(5)
+	permanent := func(error) bool { return false }
+	if _, err := Retry(fn, RetryPolicy{Retryable: permanent})(ctx, 1); err == nil {
+		t.Error("got no error")
+	}
+	if *calls != 1 {
+		t.Errorf("made %d calls, want a permanent error not retried", *calls)
+	}
+}
+
+func TestRetryMaxBackoff(t *testing.T) {
+	ctx := context.Background()
+	fn, _ := flaky(3)
+	start := time.Now()
+	p := RetryPolicy{Attempts: 4, Backoff: 10 * time.Millisecond, MaxBackoff: 10 * time.Millisecond}
+	if _, err := Retry(fn, p)(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	// Three waits of 10ms rather than 10, 20 and 40ms.
+	if d := time.Since(start); d < 30*time.Millisecond || d > 60*time.Millisecond {
+		t.Errorf("retried within %v, want about 30ms", d)
+	}
+}
+
+func TestRetryCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	fn, calls := flaky(5)
+	time.AfterFunc(10*time.Millisecond, cancel)
+	_, err := Retry(fn, RetryPolicy{Attempts: 5, Backoff: time.Hour})(ctx, 1)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+	if *calls != 1 {
+		t.Errorf("made %d calls, want none after cancelling", *calls)
+	}
+}
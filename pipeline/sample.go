@@ -0,0 +1,46 @@
+package pipeline
+
+import (
+	"context"
+	"math/rand"
+)
+
+// Sample drains in, keeping a uniformly random sample of k of the values
+// received from it, and sends the sample on once in is closed. Every value
+// is equally likely to be in the sample, however long the stream, while only
+// k of them are held in memory. A stream of fewer than k values is sent on
+// whole. The values of the sample come out in no particular order.
+func Sample[T any](ctx context.Context, in <-chan T, k int) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		var reservoir []T
+		n := 0
+		for {
+			select {
+			case v, ok := <-in:
+				if !ok {
+					for _, v := range reservoir {
+						select {
+						case out <- v:
+						case <-ctx.Done():
+							return
+						}
+					}
+					return
+				}
+				n++
+				if len(reservoir) < k {
+					reservoir = append(reservoir, v)
+				} else if i := rand.Intn(n); i < k {
+					// The nth value replaces one in the sample
+					// with a probability of k/n.
+					reservoir[i] = v
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
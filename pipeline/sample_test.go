@@ -0,0 +1,40 @@
+package pipeline
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestSampleShortStream(t *testing.T) {
+	ctx := context.Background()
+	if got := sortedInts(collect(t, Sample(ctx, Gen(ctx, 3, 1, 2), 5))); !reflect.DeepEqual(got, []int{1, 2, 3}) {
+		t.Errorf("got %v, want the whole stream", got)
+	}
+}
+
+func TestSampleUniform(t *testing.T) {
+	ctx := context.Background()
+	const runs, n, k = 2000, 10, 2
+	counts := make([]int, n)
+	for r := 0; r < runs; r++ {
+		values := make([]int, n)
+		for i := range values {
+			values[i] = i
+		}
+		got := collect(t, Sample(ctx, Gen(ctx, values...), k))
+		if len(got) != k || got[0] == got[1] {
+			t.Fatalf("got sample %v, want %d distinct values", got, k)
+		}
+		for _, v := range got {
+			counts[v]++
+		}
+	}
+	// Each value is expected in runs*k/n = 400 samples; the bounds are more
+	// than five standard deviations away.
+	for v, c := range counts {
+		if c < 300 || c > 500 {
+			t.Errorf("value %d sampled %d times, want about 400: %v", v, c, counts)
+		}
+	}
+}
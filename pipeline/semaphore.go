@@ -0,0 +1,110 @@
+package pipeline
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+)
+
+// A Semaphore caps the total weight of the operations running at once, such
+// as the number of open connections, across every stage that shares it.
+// Waiters are served in the order they arrived, so a large acquisition is not
+// starved by a stream of small ones.
+type Semaphore struct {
+	size int64
+
+	mu      sync.Mutex
+	cur     int64
+	waiters list.List // of *semWaiter
+}
+
+type semWaiter struct {
+	n     int64
+	ready chan struct{} // closed when the weight has been acquired
+}
+
+// NewSemaphore returns a Semaphore with a total weight of n.
+func NewSemaphore(n int64) *Semaphore {
+	return &Semaphore{size: n}
+}
+
+// Acquire acquires a weight of n, waiting until it is available or the
+// context is cancelled, in which case it returns the context's error and
+// acquires nothing. Acquiring more than the total weight fails only once the
+// context is cancelled.
+func (s *Semaphore) Acquire(ctx context.Context, n int64) error {
+	s.mu.Lock()
+	if s.size-s.cur >= n && s.waiters.Len() == 0 {
+		s.cur += n
+		s.mu.Unlock()
+		return nil
+	}
+	w := &semWaiter{n: n, ready: make(chan struct{})}
+	elem := s.waiters.PushBack(w)
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		select {
+		case <-w.ready:
+			// Acquired just as the context was cancelled; give it
+			// back.
+			s.cur -= n
+			s.notify()
+		default:
+			front := s.waiters.Front() == elem
+			s.waiters.Remove(elem)
+			// A waiter behind the one leaving may now fit.
+			if front && s.size > s.cur {
+				s.notify()
+			}
+		}
+		s.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// TryAcquire acquires a weight of n if it is available without waiting, and
+// reports whether it did.
+func (s *Semaphore) TryAcquire(n int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.size-s.cur >= n && s.waiters.Len() == 0 {
+		s.cur += n
+		return true
+	}
+	return false
+}
+
+// Release releases a weight of n.
+func (s *Semaphore) Release(n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cur -= n
+	if s.cur < 0 {
+		panic(fmt.Sprintf("pipeline: released %d more than acquired", -s.cur))
+	}
+	s.notify()
+}
+
+// notify hands the available weight to the waiters at the front of the
+// queue, in order, for as long as they fit. It must be called with mu held.
+func (s *Semaphore) notify() {
+	for {
+		front := s.waiters.Front()
+		if front == nil {
+			return
+		}
+		w := front.Value.(*semWaiter)
+		if s.size-s.cur < w.n {
+			return
+		}
+		s.cur += w.n
+		s.waiters.Remove(front)
+		close(w.ready)
+	}
+}
@@ -0,0 +1,87 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// acquireAsync calls s.Acquire in a new goroutine and returns the channel its
+// result is sent on.
+func acquireAsync(ctx context.Context, s *Semaphore, n int64) <-chan error {
+	c := make(chan error, 1)
+	go func() { c <- s.Acquire(ctx, n) }()
+	return c
+}
+
+// waiting fails t if c delivers within a short while.
+func waiting(t *testing.T, c <-chan error, what string) {
+	t.Helper()
+	select {
+	case err := <-c:
+		t.Fatalf("%s acquired early: %v", what, err)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestSemaphore(t *testing.T) {
+	ctx := context.Background()
+	s := NewSemaphore(3)
+	if err := s.Acquire(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	if s.TryAcquire(2) {
+		t.Fatal("acquired beyond the total weight")
+	}
+	big := acquireAsync(ctx, s, 3)
+	waiting(t, big, "large acquisition")
+	// A small acquisition that would fit queues behind the large one
+	// rather than starving it.
+	if s.TryAcquire(1) {
+		t.Fatal("TryAcquire jumped the queue")
+	}
+	small := acquireAsync(ctx, s, 1)
+	waiting(t, small, "small acquisition")
+
+	s.Release(2)
+	if err := <-big; err != nil {
+		t.Fatal(err)
+	}
+	waiting(t, small, "small acquisition")
+	s.Release(3)
+	if err := <-small; err != nil {
+		t.Fatal(err)
+	}
+	s.Release(1)
+	mustPanic(t, "releasing more than acquired", func() { s.Release(1) })
+}
+
+func TestSemaphoreCancel(t *testing.T) {
+	s := NewSemaphore(2)
+	s.Acquire(context.Background(), 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	big := acquireAsync(ctx, s, 2)
+	waiting(t, big, "acquisition beyond the free weight")
+	small := acquireAsync(context.Background(), s, 1)
+	waiting(t, small, "acquisition behind a waiter")
+
+	// Once the waiter at the front gives up, the one behind it fits.
+	cancel()
+	if err := <-big; !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+	select {
+	case err := <-small:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiter not served after the one in front of it left")
+	}
+	// The cancelled waiter acquired nothing.
+	s.Release(2)
+	if !s.TryAcquire(2) {
+		t.Error("weight leaked by a cancelled acquisition")
+	}
+}
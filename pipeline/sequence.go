@@ -0,0 +1,92 @@
+package pipeline
+
+import (
+	"context"
+	"sort"
+)
+
+// A Numbered is an item tagged with its position in a stream.
+type Numbered[T any] struct {
+	Seq  uint64
+	Item T
+}
+
+// Number tags every value received from in with its position in the stream,
+// starting at 0.
+func Number[T any](ctx context.Context, in <-chan T) <-chan Numbered[T] {
+	out := make(chan Numbered[T])
+	go func() {
+		defer close(out)
+		var seq uint64
+		for v := range in {
+			select {
+			case out <- Numbered[T]{seq, v}:
+				seq++
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Resequence restores the order of items tagged by Number that have been
+// shuffled, for example by an unordered fan-out whose workers carry the
+// sequence number over from every input to its result. Items are held back
+// until every item before them has arrived and then sent on in sequence
+// order, without their tags. Every sequence number must therefore arrive
+// exactly once: an item dropped along the way stalls all the items after it
+// until in is closed, when whatever is held back is sent in order.
+//
+// The items held back are unbounded; the further the fan-out reorders them,
+// the more are held. FanOutOrdered bounds them at the cost of stalling its
+// workers.
+func Resequence[T any](ctx context.Context, in <-chan Numbered[T]) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		var next uint64
+		held := make(map[uint64]T)
+		send := func(v T) bool {
+			select {
+			case out <- v:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		for n := range in {
+			if n.Seq != next {
+				held[n.Seq] = n.Item
+				continue
+			}
+			if !send(n.Item) {
+				return
+			}
+			next++
+			for {
+				v, ok := held[next]
+				if !ok {
+					break
+				}
+				delete(held, next)
+				if !send(v) {
+					return
+				}
+				next++
+			}
+		}
+		// Whatever is left is after a gap.
+		seqs := make([]uint64, 0, len(held))
+		for seq := range held {
+			seqs = append(seqs, seq)
+		}
+		sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+		for _, seq := range seqs {
+			if !send(held[seq]) {
+				return
+			}
+		}
+	}()
+	return out
+}
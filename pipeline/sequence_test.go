@@ -0,0 +1,48 @@
+package pipeline
+
+import (
+	"context"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNumberResequence(t *testing.T) {
+	ctx := context.Background()
+	numbered := collect(t, Number(ctx, Gen(ctx, "a", "b", "c", "d", "e")))
+	for i, n := range numbered {
+		if n.Seq != uint64(i) {
+			t.Fatalf("got %v, want sequence numbers from 0", numbered)
+		}
+	}
+	rand.New(rand.NewSource(1)).Shuffle(len(numbered), func(i, j int) {
+		numbered[i], numbered[j] = numbered[j], numbered[i]
+	})
+	if got := collect(t, Resequence(ctx, Gen(ctx, numbered...))); !reflect.DeepEqual(got, []string{"a", "b", "c", "d", "e"}) {
+		t.Errorf("got %q from %v, want the original order", got, numbered)
+	}
+}
+
+func TestResequenceGap(t *testing.T) {
+	ctx := context.Background()
+	in := make(chan Numbered[int])
+	out := Resequence(ctx, in)
+	in <- Numbered[int]{3, 3}
+	in <- Numbered[int]{0, 0}
+	if v := <-out; v != 0 {
+		t.Fatalf("got %d, want 0", v)
+	}
+	// 1 never arrives, so the items after it are held back until the
+	// input is closed, then sent in order.
+	in <- Numbered[int]{2, 2}
+	select {
+	case v := <-out:
+		t.Fatalf("got %d past the gap before the input was closed", v)
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(in)
+	if got := collect(t, out); !reflect.DeepEqual(got, []int{2, 3}) {
+		t.Errorf("got %v, want [2 3]", got)
+	}
+}
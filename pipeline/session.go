@@ -0,0 +1,111 @@
+package pipeline
+
+import (
+	"container/list"
+	"context"
+	"time"
+)
+
+// A Session is the aggregate of a burst of items sharing a key. Start is the
+// time the first of them was received and End the time the last one was.
+type Session[K comparable, A any] struct {
+	Key K
+	Window[A]
+}
+
+// SessionWindow aggregates the values received from in by key into sessions:
+// a session of a key starts with the first value of that key and goes on for
+// as long as further values of the key keep arriving less than gap apart.
+// Every value is folded into the aggregate of its session with add, starting
+// from initial(), and the session is sent on once gap has passed without a
+// value of its key. Sessions are sent on in the order they close.
+//
+// When in is closed, or a Flusher attached to the context is flushed, every
+// open session is sent on straight away.
+func SessionWindow[T any, K comparable, A any](ctx context.Context, in <-chan T, key func(T) K, gap time.Duration, initial func() A, add func(A, T) A) <-chan Session[K, A] {
+	out := make(chan Session[K, A])
+	flushes := watchFlushes(ctx)
+	go func() {
+		defer close(out)
+		open := make(map[K]*list.Element)
+		// idle orders the open sessions from least to most recently
+		// active. Since every value extends its session by the same gap,
+		// that is also the order in which they expire.
+		var idle list.List
+		timer := time.NewTimer(gap)
+		timer.Stop()
+		defer timer.Stop()
+
+		// emit sends on the session of e and forgets it.
+		emit := func(e *list.Element) bool {
+			s := idle.Remove(e).(*Session[K, A])
+			delete(open, s.Key)
+			select {
+			case out <- *s:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		// arm sets the timer for the least recently active session.
+		arm := func() {
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			if e := idle.Front(); e != nil {
+				timer.Reset(time.Until(e.Value.(*Session[K, A]).End.Add(gap)))
+			}
+		}
+		emitAll := func() bool {
+			for e := idle.Front(); e != nil; e = idle.Front() {
+				if !emit(e) {
+					return false
+				}
+			}
+			return true
+		}
+		for {
+			select {
+			case v, ok := <-in:
+				if !ok {
+					emitAll()
+					return
+				}
+				now := time.Now()
+				k := key(v)
+				e, ok := open[k]
+				if ok {
+					idle.MoveToBack(e)
+				} else {
+					e = idle.PushBack(&Session[K, A]{Key: k, Window: Window[A]{Start: now, Value: initial()}})
+					open[k] = e
+				}
+				s := e.Value.(*Session[K, A])
+				s.End = now
+				s.Value = add(s.Value, v)
+				s.Count++
+				arm()
+			case <-timer.C:
+				now := time.Now()
+				for e := idle.Front(); e != nil && !now.Before(e.Value.(*Session[K, A]).End.Add(gap)); e = idle.Front() {
+					if !emit(e) {
+						return
+					}
+				}
+				arm()
+			case <-flushes.requested():
+				flushes.done()
+				if !emitAll() {
+					return
+				}
+				arm()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,70 @@
+package pipeline
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSessionWindow(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	type click struct {
+		user string
+		n    int
+	}
+	in := make(chan click)
+	user := func(c click) string { return c.user }
+	add := func(a int, c click) int { return a + c.n }
+	out := SessionWindow(ctx, in, user, 30*time.Millisecond, zero, add)
+
+	in <- click{"a", 1}
+	in <- click{"b", 10}
+	time.Sleep(15 * time.Millisecond)
+	// a stays active, which keeps its session open past b's.
+	in <- click{"a", 2}
+
+	b := <-out
+	if b.Key != "b" || b.Count != 1 || b.Value != 10 {
+		t.Errorf("got %+v, want b's session closing first", b)
+	}
+	a := <-out
+	if a.Key != "a" || a.Count != 2 || a.Value != 3 {
+		t.Errorf("got %+v, want a's two clicks", a)
+	}
+	if d := a.End.Sub(a.Start); d < 15*time.Millisecond {
+		t.Errorf("a's session lasted %v, want it to span both clicks", d)
+	}
+
+	// A click after the gap starts a new session, sent on when the input
+	// closes.
+	in <- click{"a", 5}
+	close(in)
+	if s := <-out; s.Key != "a" || s.Count != 1 || s.Value != 5 {
+		t.Errorf("got %+v, want a new session for a", s)
+	}
+	if _, ok := <-out; ok {
+		t.Error("output not closed after the input")
+	}
+}
+
+func TestSessionWindowFlush(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	f := NewFlusher()
+	in := make(chan int)
+	parity := func(v int) int { return v % 2 }
+	out := SessionWindow(WithFlusher(ctx, f), in, parity, time.Hour, zero, sum)
+	in <- 1
+	in <- 2
+	in <- 3
+	f.Flush()
+	got := map[int]int{}
+	for i := 0; i < 2; i++ {
+		s := <-out
+		got[s.Key] = s.Value
+	}
+	if got[0] != 2 || got[1] != 4 {
+		t.Errorf("flushed sessions %v, want evens summing to 2 and odds to 4", got)
+	}
+}
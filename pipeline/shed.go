@@ -0,0 +1,59 @@
+package pipeline
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// A Shedder passes a stream on without ever holding it up: items that would
+// have to wait, because the stage downstream has not caught up or because
+// they come faster than a rate allows, are dropped instead, and counted. It
+// suits pipelines for which fresh items matter more than getting every one of
+// them through, such as those feeding a live view.
+type Shedder[T any] struct {
+	out  chan T
+	shed atomic.Int64
+}
+
+// NewShedder starts passing the values received from in through to its
+// output, which buffers up to buffer of them for the stage downstream. A
+// value arriving when the buffer is full is dropped. If bucket is not nil,
+// values are also dropped when it has no token for them, which caps the rate
+// at which they are passed on. The output is closed once in is closed or the
+// context is cancelled.
+func NewShedder[T any](ctx context.Context, in <-chan T, buffer int, bucket *TokenBucket) *Shedder[T] {
+	s := &Shedder[T]{out: make(chan T, buffer)}
+	go func() {
+		defer close(s.out)
+		for {
+			select {
+			case v, ok := <-in:
+				if !ok {
+					return
+				}
+				if bucket != nil && !bucket.Allow() {
+					s.shed.Add(1)
+					continue
+				}
+				select {
+				case s.out <- v:
+				default:
+					s.shed.Add(1)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return s
+}
+
+// Out returns the output of s.
+func (s *Shedder[T]) Out() <-chan T {
+	return s.out
+}
+
+// Shed returns the number of items dropped so far.
+func (s *Shedder[T]) Shed() int64 {
+	return s.shed.Load()
+}
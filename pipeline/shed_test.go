@@ -0,0 +1,45 @@
+package pipeline
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestShedderBuffer(t *testing.T) {
+	ctx := context.Background()
+	s := NewShedder(ctx, Gen(ctx, 1, 2, 3, 4, 5), 2, nil)
+	// Nothing reads the output until the rest has been dropped, so only
+	// what fits in the buffer gets through.
+	deadline := time.Now().Add(time.Second)
+	for s.Shed() < 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got := collect(t, s.Out()); !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Errorf("got %v, want the buffered values", got)
+	}
+	if got := s.Shed(); got != 3 {
+		t.Errorf("shed %d, want 3", got)
+	}
+}
+
+func TestShedderRate(t *testing.T) {
+	ctx := context.Background()
+	// The bucket never refills, so only its burst gets through, however
+	// fast the output is read.
+	s := NewShedder(ctx, Gen(ctx, 1, 2, 3, 4, 5), 10, NewTokenBucket(0, 3))
+	if got := collect(t, s.Out()); !reflect.DeepEqual(got, []int{1, 2, 3}) {
+		t.Errorf("got %v, want the first three", got)
+	}
+	if got := s.Shed(); got != 2 {
+		t.Errorf("shed %d, want 2", got)
+	}
+}
+
+func TestShedderCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := NewShedder(ctx, make(chan int), 1, nil)
+	cancel()
+	collect(t, s.Out())
+}
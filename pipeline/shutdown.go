@@ -0,0 +1,195 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// ErrDrainTimeout is returned by Shutdown.Drain when the pipeline did not
+// finish draining in time and had to be cancelled.
+var ErrDrainTimeout = errors.New("pipeline: drain timed out")
+
+// A Shutdown stops a pipeline in one of two ways: Cancel stops every stage at
+// once, dropping the items in flight, while Drain only stops the input and
+// lets the items already in the pipeline flow through to the end. Pause holds
+// the input back for a while, until Resume.
+//
+// Pass the input of the pipeline through Input and its output through
+// Output, and start its stages with the context returned by NewShutdown:
+//
+//	s, ctx := NewShutdown(ctx)
+//	in := Input(s, gen(ctx))
+//	out := Output(s, FanOut(ctx, in, 4, sq))
+//	...
+//	err := s.Drain(10 * time.Second)
+type Shutdown struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	stop     chan struct{}
+	stopOnce sync.Once
+	finished chan struct{}
+	outputs  sync.WaitGroup
+	once     sync.Once
+
+	mu sync.Mutex
+	// resume is closed while the pipeline runs, and replaced with an open
+	// channel while it is paused.
+	resume chan struct{}
+	paused bool
+}
+
+// NewShutdown returns a new Shutdown and the context the stages of its
+// pipeline should be started with, derived from ctx.
+func NewShutdown(ctx context.Context) (*Shutdown, context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	resume := make(chan struct{})
+	close(resume)
+	return &Shutdown{
+		ctx:      ctx,
+		cancel:   cancel,
+		stop:     make(chan struct{}),
+		finished: make(chan struct{}),
+		resume:   resume,
+	}, ctx
+}
+
+// Input passes on the values received from in until s starts draining, at
+// which point it closes its output without reading further from in, so that
+// the stages downstream finish their work and close in turn. The values left
+// in in are not read; the source feeding it is released when the context is
+// cancelled, which Drain does once it is done. While s is paused, Input
+// stops reading from in.
+func Input[T any](s *Shutdown, in <-chan T) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-s.resumed():
+			case <-s.stop:
+				return
+			}
+			// Check for a drain first, so that it wins over a ready
+			// input.
+			select {
+			case <-s.stop:
+				return
+			default:
+			}
+			select {
+			case v, ok := <-in:
+				if !ok {
+					return
+				}
+				select {
+				case out <- v:
+				case <-s.stop:
+					return
+				}
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Output passes on the values received from c and tells s once c has been
+// closed, which is how Drain knows the pipeline has emptied. A pipeline with
+// several outputs passes each of them through Output; Drain then waits for all
+// of them. Output must be called before Drain.
+func Output[T any](s *Shutdown, c <-chan T) <-chan T {
+	s.outputs.Add(1)
+	out := make(chan T)
+	go func() {
+		defer s.outputs.Done()
+		defer close(out)
+		for v := range c {
+			select {
+			case out <- v:
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Pause stops the input of the pipeline from taking in new items until
+// Resume is called, while the items already in the pipeline carry on through
+// it. Draining or cancelling a paused pipeline stops it as usual.
+func (s *Shutdown) Pause() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.paused {
+		return
+	}
+	log(s.ctx, slog.LevelInfo, "pipeline: paused")
+	s.paused = true
+	s.resume = make(chan struct{})
+}
+
+// Resume lets the input of a paused pipeline take in new items again.
+func (s *Shutdown) Resume() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.paused {
+		return
+	}
+	log(s.ctx, slog.LevelInfo, "pipeline: resumed")
+	s.paused = false
+	close(s.resume)
+}
+
+// Paused reports whether the pipeline is paused.
+func (s *Shutdown) Paused() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.paused
+}
+
+// resumed returns a channel that is closed once the pipeline is not paused.
+func (s *Shutdown) resumed() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.resume
+}
+
+// Cancel stops the pipeline at once, cancelling the context its stages were
+// started with. Items in flight are dropped.
+func (s *Shutdown) Cancel() {
+	log(s.ctx, slog.LevelInfo, "pipeline: cancelled")
+	s.stopOnce.Do(func() { close(s.stop) })
+	s.cancel()
+}
+
+// Drain stops the input of the pipeline and waits for the items already in it
+// to come out of every output, which must keep being consumed meanwhile. If
+// that takes longer than timeout, the pipeline is cancelled and Drain returns
+// ErrDrainTimeout. Either way, the context of the pipeline is cancelled once
+// Drain returns.
+func (s *Shutdown) Drain(timeout time.Duration) error {
+	log(s.ctx, slog.LevelInfo, "pipeline: draining", "timeout", timeout)
+	start := time.Now()
+	s.stopOnce.Do(func() { close(s.stop) })
+	s.once.Do(func() {
+		go func() {
+			s.outputs.Wait()
+			close(s.finished)
+		}()
+	})
+	defer s.cancel()
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-s.finished:
+		log(s.ctx, slog.LevelInfo, "pipeline: drained", "took", time.Since(start))
+		return nil
+	case <-t.C:
+		log(s.ctx, slog.LevelWarn, "pipeline: drain timed out, cancelling", "timeout", timeout)
+		return ErrDrainTimeout
+	}
+}
@@ -0,0 +1,129 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestShutdownDrain(t *testing.T) {
+	s, ctx := NewShutdown(context.Background())
+	stopped := make(chan struct{})
+	in := Input(s, count(ctx, stopped))
+	slow := Map(ctx, in, func(v int) int {
+		time.Sleep(5 * time.Millisecond)
+		return v
+	})
+	out := Output(s, slow)
+	for i := 0; i < 2; i++ {
+		<-out
+	}
+	drained := make(chan error)
+	go func() { drained <- s.Drain(time.Second) }()
+	// Every value that made it into the pipeline comes out, in order, with
+	// none dropped.
+	next := 2
+	for v := range out {
+		if v != next {
+			t.Fatalf("got %d, want %d", v, next)
+		}
+		next++
+	}
+	if err := <-drained; err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Error("source not released after draining")
+	}
+	if ctx.Err() == nil {
+		t.Error("context still live after Drain returned")
+	}
+}
+
+func TestShutdownDrainTimeout(t *testing.T) {
+	s, ctx := NewShutdown(context.Background())
+	stuck := func(ctx context.Context, in <-chan int) <-chan int {
+		out := make(chan int)
+		go func() {
+			defer close(out)
+			<-in
+			<-ctx.Done()
+		}()
+		return out
+	}
+	out := Output(s, stuck(ctx, Input(s, Gen(ctx, 1, 2))))
+	start := time.Now()
+	if err := s.Drain(20 * time.Millisecond); !errors.Is(err, ErrDrainTimeout) {
+		t.Errorf("got %v, want ErrDrainTimeout", err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("Drain took %v, want about 20ms", d)
+	}
+	// The pipeline was cancelled instead.
+	collect(t, out)
+}
+
+func TestShutdownCancel(t *testing.T) {
+	s, ctx := NewShutdown(context.Background())
+	stopped := make(chan struct{})
+	out := Output(s, Input(s, count(ctx, stopped)))
+	<-out
+	s.Cancel()
+	collect(t, out)
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Error("source not released after cancelling")
+	}
+	// Draining after cancelling has nothing left to wait for.
+	if err := s.Drain(time.Second); err != nil {
+		t.Errorf("got %v draining a cancelled pipeline", err)
+	}
+}
+
+func TestShutdownPause(t *testing.T) {
+	s, ctx := NewShutdown(context.Background())
+	stopped := make(chan struct{})
+	out := Output(s, Input(s, count(ctx, stopped)))
+	<-out
+	s.Pause()
+	if !s.Paused() {
+		t.Fatal("not paused")
+	}
+	// The values Input and Output may already hold still go through, and
+	// then nothing.
+	last := -1
+	for done := false; !done; {
+		select {
+		case last = <-out:
+		case <-time.After(30 * time.Millisecond):
+			done = true
+		}
+	}
+	if last > 2 {
+		t.Errorf("got values up to %d while paused", last)
+	}
+
+	s.Resume()
+	if s.Paused() {
+		t.Fatal("still paused after Resume")
+	}
+	select {
+	case <-out:
+	case <-time.After(time.Second):
+		t.Fatal("nothing came out after resuming")
+	}
+
+	// A paused pipeline still drains.
+	s.Pause()
+	drained := make(chan error)
+	go func() { drained <- s.Drain(time.Second) }()
+	for range out {
+	}
+	if err := <-drained; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,66 @@
+package pipeline
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"os"
+)
+
+// maxRecord is the longest record FromReader accepts.
+const maxRecord = 1 << 20
+
+// FromReader emits the records read from r, split into tokens by split, which
+// is typically bufio.ScanLines or ScanNUL. The error channel receives the
+// result of reading r once the output channel has been closed: nil at the end
+// of the input, the read error, or the context's error if the context was
+// cancelled. A read that is blocked on r cannot be interrupted, so the
+// goroutine started by FromReader only notices the cancellation once the read
+// returns.
+func FromReader(ctx context.Context, r io.Reader, split bufio.SplitFunc) (<-chan string, <-chan error) {
+	out := make(chan string)
+	errc := make(chan error, 1)
+	go func() {
+		var err error
+		// Registered first, so that the error is sent once out has been
+		// closed. No select needed for this send, since errc is buffered.
+		defer func() { errc <- err }()
+		defer close(out)
+		sc := bufio.NewScanner(r)
+		sc.Buffer(nil, maxRecord)
+		sc.Split(split)
+		for sc.Scan() {
+			select {
+			case out <- sc.Text():
+			case <-ctx.Done():
+				err = ctx.Err()
+				return
+			}
+		}
+		err = sc.Err()
+	}()
+	return out, errc
+}
+
+// FromStdin emits the lines read from the standard input, so that programs
+// built on this package compose with ordinary Unix pipelines. Use FromReader
+// with ScanNUL to read the NUL-delimited output of tools like find -print0.
+func FromStdin(ctx context.Context) (<-chan string, <-chan error) {
+	return FromReader(ctx, os.Stdin, bufio.ScanLines)
+}
+
+// ScanNUL is a bufio.SplitFunc that splits its input into NUL-terminated
+// records. The final record need not be terminated.
+func ScanNUL(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
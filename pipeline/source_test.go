@@ -0,0 +1,64 @@
+package pipeline
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestFromReaderLines(t *testing.T) {
+	ctx := context.Background()
+	out, errc := FromReader(ctx, strings.NewReader("one\ntwo\r\n\nthree"), bufio.ScanLines)
+	got := collect(t, out)
+	if want := []string{"one", "two", "", "three"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if err := <-errc; err != nil {
+		t.Errorf("got error %v at the end of the input", err)
+	}
+}
+
+func TestFromReaderNUL(t *testing.T) {
+	ctx := context.Background()
+	out, errc := FromReader(ctx, strings.NewReader("a b\x00c\nd\x00last"), ScanNUL)
+	got := collect(t, out)
+	if want := []string{"a b", "c\nd", "last"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if err := <-errc; err != nil {
+		t.Errorf("got error %v at the end of the input", err)
+	}
+}
+
+func TestFromReaderError(t *testing.T) {
+	ctx := context.Background()
+	broken := errors.New("connection reset")
+	r := io.MultiReader(strings.NewReader("one\n"), iotest.ErrReader(broken))
+	out, errc := FromReader(ctx, r, bufio.ScanLines)
+	if got := collect(t, out); !reflect.DeepEqual(got, []string{"one"}) {
+		t.Errorf("got %q, want the record read before the error", got)
+	}
+	if err := <-errc; !errors.Is(err, broken) {
+		t.Errorf("got %v, want the read error", err)
+	}
+}
+
+func TestFromReaderCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	out, errc := FromReader(ctx, strings.NewReader("one\ntwo\nthree\n"), bufio.ScanLines)
+	<-out
+	// With nobody receiving, the cancellation is all the source can act
+	// on.
+	cancel()
+	if err := <-errc; !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+	if _, ok := <-out; ok {
+		t.Error("output not closed after the cancellation")
+	}
+}
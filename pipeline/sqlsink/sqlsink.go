@@ -0,0 +1,327 @@
+// Package sqlsink writes the output of a pipeline to a database/sql database
+// in transactions, with exactly-once delivery when used together with a
+// pipeline.WAL.
+package sqlsink
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"concurrency/pipeline"
+)
+
+// Sink is a pipeline.TransactionalSink that writes every batch in a single
+// database transaction. Prepare writes the rows along with a marker row
+// recording the transaction id, and keeps the database transaction open until
+// Commit or Abort. The marker makes Commit idempotent across crashes: a
+// transaction whose database commit went through is recognized by its marker,
+// and one that never reached the database is reported as
+// pipeline.ErrUnknownTransaction, so that its items are replayed.
+//
+// A row that fails to be written does not fail the whole batch. The batch is
+// written under a savepoint; when a row fails, the batch is rolled back to
+// the savepoint and bisected with pipeline.Bisect, every half being retried
+// under a savepoint of its own, until the failing rows are isolated. Those
+// are skipped and reported to OnPoison.
+type Sink[T any] struct {
+	// DB is the database written to.
+	DB *sql.DB
+	// Exec writes a single item within tx.
+	Exec func(ctx context.Context, tx *sql.Tx, item T) error
+	// OnPoison, if not nil, is called with every item that could not be
+	// written and the error writing it returned.
+	OnPoison func(item T, err error)
+	// Retryable reports whether an error is transient, in which case the
+	// whole batch is retried rather than split. If nil, only
+	// driver.ErrBadConn is considered transient.
+	Retryable func(error) bool
+	// Attempts is the number of times a batch is tried when it fails with
+	// a transient error. Zero means 3.
+	Attempts int
+	// Backoff is the wait before the first retry; it doubles after every
+	// attempt. Zero means 100 milliseconds.
+	Backoff time.Duration
+	// MarkStmt inserts the marker row for a transaction id and CheckStmt
+	// counts the marker rows for one. The defaults use "?" placeholders and
+	// the table created by CreateMarkerTable; drivers with other placeholder
+	// styles, such as PostgreSQL's "$1", need their own.
+	MarkStmt  string
+	CheckStmt string
+
+	mu  sync.Mutex
+	txs map[string]*sql.Tx
+}
+
+// Default statements for the marker table.
+const (
+	DefaultMarkStmt  = "INSERT INTO pipeline_transactions (id) VALUES (?)"
+	DefaultCheckStmt = "SELECT COUNT(*) FROM pipeline_transactions WHERE id = ?"
+)
+
+// CreateMarkerTable creates the table the default statements record
+// transaction ids in, unless it already exists.
+func CreateMarkerTable(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS pipeline_transactions (id VARCHAR(64) PRIMARY KEY)")
+	return err
+}
+
+// New returns a Sink writing items to db with exec.
+func New[T any](db *sql.DB, exec func(ctx context.Context, tx *sql.Tx, item T) error) *Sink[T] {
+	return &Sink[T]{DB: db, Exec: exec}
+}
+
+// Prepare implements pipeline.TransactionalSink.
+func (s *Sink[T]) Prepare(ctx context.Context, id string, batch []T) error {
+	attempts := s.Attempts
+	if attempts <= 0 {
+		attempts = 3
+	}
+	backoff := s.Backoff
+	if backoff <= 0 {
+		backoff = 100 * time.Millisecond
+	}
+	for i := 1; ; i++ {
+		tx, err := s.prepare(ctx, id, batch)
+		if err == nil {
+			s.mu.Lock()
+			if s.txs == nil {
+				s.txs = make(map[string]*sql.Tx)
+			}
+			s.txs[id] = tx
+			s.mu.Unlock()
+			return nil
+		}
+		if i == attempts || !s.retryable(err) {
+			return err
+		}
+		t := time.NewTimer(backoff)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+func (s *Sink[T]) retryable(err error) bool {
+	if s.Retryable != nil {
+		return s.Retryable(err)
+	}
+	return errors.Is(err, driver.ErrBadConn)
+}
+
+// prepare makes a single attempt at writing batch in a new transaction.
+func (s *Sink[T]) prepare(ctx context.Context, id string, batch []T) (*sql.Tx, error) {
+	// The transaction must outlive ctx, which may be cancelled between
+	// Prepare and Commit; database/sql rolls back a transaction whose
+	// context is done.
+	tx, err := s.DB.BeginTx(context.WithoutCancel(ctx), nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.write(ctx, tx, batch); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, s.markStmt(), id); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return tx, nil
+}
+
+// write writes the rows of batch within tx, using pipeline.Bisect to isolate
+// the rows that fail: every attempt runs under a savepoint, which a failed
+// attempt rolls back to. The rows that fail on their own are reported as
+// poison and skipped. Only errors that should abort the whole transaction are
+// returned.
+func (s *Sink[T]) write(ctx context.Context, tx *sql.Tx, batch []T) error {
+	// An error that is transient, or leaves the transaction unusable, must
+	// not be bisected around; it stops the bisection by cancelling its
+	// context.
+	bctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		fatal      error
+		savepoints int
+	)
+	abort := func(err error) error {
+		fatal = err
+		cancel()
+		return err
+	}
+	dead, err := pipeline.Bisect(bctx, batch, func(ctx context.Context, items []T) error {
+		savepoints++
+		sp := fmt.Sprintf("pipeline_sp%d", savepoints)
+		if _, err := tx.ExecContext(ctx, "SAVEPOINT "+sp); err != nil {
+			return abort(err)
+		}
+		var failed error
+		for _, item := range items {
+			if err := s.Exec(ctx, tx, item); err != nil {
+				failed = err
+				break
+			}
+		}
+		if failed == nil {
+			if _, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT "+sp); err != nil {
+				return abort(err)
+			}
+			return nil
+		}
+		if s.retryable(failed) || ctx.Err() != nil {
+			return abort(failed)
+		}
+		if _, err := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+sp); err != nil {
+			return abort(err)
+		}
+		if _, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT "+sp); err != nil {
+			return abort(err)
+		}
+		return failed
+	})
+	if fatal != nil {
+		return fatal
+	}
+	if err != nil {
+		return err
+	}
+	if s.OnPoison != nil {
+		for _, d := range dead {
+			s.OnPoison(d.Item, d.Err)
+		}
+	}
+	return nil
+}
+
+// Commit implements pipeline.TransactionalSink.
+func (s *Sink[T]) Commit(ctx context.Context, id string) error {
+	s.mu.Lock()
+	tx, ok := s.txs[id]
+	delete(s.txs, id)
+	s.mu.Unlock()
+	if ok {
+		err := tx.Commit()
+		if err == nil {
+			return nil
+		}
+		// The commit may have gone through even though its outcome was
+		// lost; the marker tells.
+		if committed, cerr := s.committed(ctx, id); cerr == nil && committed {
+			return nil
+		}
+		return err
+	}
+	committed, err := s.committed(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !committed {
+		return pipeline.ErrUnknownTransaction
+	}
+	return nil
+}
+
+func (s *Sink[T]) committed(ctx context.Context, id string) (bool, error) {
+	var n int
+	if err := s.DB.QueryRowContext(ctx, s.checkStmt(), id).Scan(&n); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// Abort implements pipeline.TransactionalSink.
+func (s *Sink[T]) Abort(ctx context.Context, id string) error {
+	s.mu.Lock()
+	tx, ok := s.txs[id]
+	delete(s.txs, id)
+	s.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return tx.Rollback()
+}
+
+func (s *Sink[T]) markStmt() string {
+	if s.MarkStmt != "" {
+		return s.MarkStmt
+	}
+	return DefaultMarkStmt
+}
+
+func (s *Sink[T]) checkStmt() string {
+	if s.CheckStmt != "" {
+		return s.CheckStmt
+	}
+	return DefaultCheckStmt
+}
+
+// Batches groups the entries received from in into batches for a Sink. A
+// batch is emitted once it holds count entries, once the sizes of its items,
+// as reported by sizeOf, add up to bytes, or once latency has elapsed since
+// its first entry arrived, whichever comes first. A zero count or bytes
+// disables that limit; sizeOf may be nil if bytes is zero. A partial batch is
+// emitted when in is closed.
+func Batches[T any](ctx context.Context, in <-chan pipeline.WALEntry[T], count, bytes int, sizeOf func(T) int, latency time.Duration) <-chan []pipeline.WALEntry[T] {
+	out := make(chan []pipeline.WALEntry[T])
+	go func() {
+		defer close(out)
+		var (
+			batch   []pipeline.WALEntry[T]
+			size    int
+			timer   *time.Timer
+			timeout <-chan time.Time
+		)
+		flush := func() bool {
+			if timer != nil {
+				timer.Stop()
+				timer, timeout = nil, nil
+			}
+			if len(batch) == 0 {
+				return true
+			}
+			select {
+			case out <- batch:
+				batch, size = nil, 0
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		for {
+			select {
+			case e, ok := <-in:
+				if !ok {
+					flush()
+					return
+				}
+				batch = append(batch, e)
+				if bytes > 0 {
+					size += sizeOf(e.Item)
+				}
+				if len(batch) == 1 && latency > 0 {
+					timer = time.NewTimer(latency)
+					timeout = timer.C
+				}
+				if (count > 0 && len(batch) >= count || bytes > 0 && size >= bytes) && !flush() {
+					return
+				}
+			case <-timeout:
+				timer, timeout = nil, nil
+				if !flush() {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
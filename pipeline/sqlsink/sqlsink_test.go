@@ -0,0 +1,286 @@
+package sqlsink
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"concurrency/pipeline"
+)
+
+// fakeDB is an in-memory database understanding just the statements the Sink
+// issues: savepoints, the marker statements and "INSERT", which stores its
+// argument as a row and fails for arguments starting with "bad".
+type fakeDB struct {
+	mu      sync.Mutex
+	rows    []string
+	markers []string
+}
+
+func (db *fakeDB) Open(string) (driver.Conn, error)             { return &fakeConn{db: db}, nil }
+func (db *fakeDB) Connect(context.Context) (driver.Conn, error) { return &fakeConn{db: db}, nil }
+func (db *fakeDB) Driver() driver.Driver                        { return db }
+
+type fakeConn struct {
+	db         *fakeDB
+	rows       []string
+	markers    []string
+	savepoints []int
+}
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c *fakeConn) Close() error                        { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error)           { return c, nil }
+
+func (c *fakeConn) Commit() error {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	c.db.rows = append(c.db.rows, c.rows...)
+	c.db.markers = append(c.db.markers, c.markers...)
+	c.rows, c.markers = nil, nil
+	return nil
+}
+
+func (c *fakeConn) Rollback() error {
+	c.rows, c.markers, c.savepoints = nil, nil, nil
+	return nil
+}
+
+func (c *fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	switch {
+	case strings.HasPrefix(query, "SAVEPOINT "):
+		c.savepoints = append(c.savepoints, len(c.rows))
+	case strings.HasPrefix(query, "RELEASE SAVEPOINT "):
+		c.savepoints = c.savepoints[:len(c.savepoints)-1]
+	case strings.HasPrefix(query, "ROLLBACK TO SAVEPOINT "):
+		c.rows = c.rows[:c.savepoints[len(c.savepoints)-1]]
+	case query == DefaultMarkStmt:
+		c.markers = append(c.markers, args[0].Value.(string))
+	case query == "INSERT":
+		row := args[0].Value.(string)
+		if strings.HasPrefix(row, "bad") {
+			return nil, errors.New("constraint violated by " + row)
+		}
+		c.rows = append(c.rows, row)
+	default:
+		return nil, errors.New("unexpected statement " + query)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (c *fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if query != DefaultCheckStmt {
+		return nil, errors.New("unexpected query " + query)
+	}
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	var n int64
+	for _, m := range c.db.markers {
+		if m == args[0].Value.(string) {
+			n++
+		}
+	}
+	return &countRows{n: n}, nil
+}
+
+type countRows struct {
+	n    int64
+	done bool
+}
+
+func (r *countRows) Columns() []string { return []string{"count"} }
+func (r *countRows) Close() error      { return nil }
+func (r *countRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = r.n
+	return nil
+}
+
+func insert(ctx context.Context, tx *sql.Tx, item string) error {
+	_, err := tx.ExecContext(ctx, "INSERT", item)
+	return err
+}
+
+func TestSinkSkipsPoison(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeDB{}
+	db := sql.OpenDB(fake)
+	defer db.Close()
+	poison := make(map[string]string)
+	s := New(db, insert)
+	s.OnPoison = func(item string, err error) { poison[item] = err.Error() }
+
+	batch := []string{"a", "b", "bad1", "c", "d", "e", "bad2", "f"}
+	if err := s.Prepare(ctx, "tx1", batch); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Commit(ctx, "tx1"); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(fake.rows)
+	if want := []string{"a", "b", "c", "d", "e", "f"}; !reflect.DeepEqual(fake.rows, want) {
+		t.Errorf("got rows %v, want %v", fake.rows, want)
+	}
+	want := map[string]string{"bad1": "constraint violated by bad1", "bad2": "constraint violated by bad2"}
+	if !reflect.DeepEqual(poison, want) {
+		t.Errorf("got poison %v, want %v", poison, want)
+	}
+
+	// The marker makes Commit recognize the transaction once it is gone
+	// from the Sink, as it is after a crash.
+	if err := New(db, insert).Commit(ctx, "tx1"); err != nil {
+		t.Errorf("Commit of a committed transaction: %v", err)
+	}
+	if err := s.Commit(ctx, "tx2"); !errors.Is(err, pipeline.ErrUnknownTransaction) {
+		t.Errorf("Commit of an unknown transaction: got %v, want ErrUnknownTransaction", err)
+	}
+}
+
+func TestSinkRetriesTransientErrors(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeDB{}
+	db := sql.OpenDB(fake)
+	defer db.Close()
+	failures := 1
+	s := New(db, func(ctx context.Context, tx *sql.Tx, item string) error {
+		if item == "flaky" && failures > 0 {
+			failures--
+			return driver.ErrBadConn
+		}
+		return insert(ctx, tx, item)
+	})
+	s.Backoff = time.Millisecond
+	s.OnPoison = func(item string, err error) { t.Errorf("%s reported as poison: %v", item, err) }
+
+	if err := s.Prepare(ctx, "tx1", []string{"a", "flaky", "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Commit(ctx, "tx1"); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a", "flaky", "b"}; !reflect.DeepEqual(fake.rows, want) {
+		t.Errorf("got rows %v, want %v", fake.rows, want)
+	}
+}
+
+func TestSinkAbort(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeDB{}
+	db := sql.OpenDB(fake)
+	defer db.Close()
+	s := New(db, insert)
+	if err := s.Prepare(ctx, "tx1", []string{"a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Abort(ctx, "tx1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.rows) != 0 {
+		t.Errorf("aborted transaction wrote %v", fake.rows)
+	}
+	if err := s.Commit(ctx, "tx1"); !errors.Is(err, pipeline.ErrUnknownTransaction) {
+		t.Errorf("Commit of an aborted transaction: got %v, want ErrUnknownTransaction", err)
+	}
+}
+
+// entries returns WAL entries numbered from 1 for items.
+func entries(items ...string) []pipeline.WALEntry[string] {
+	es := make([]pipeline.WALEntry[string], len(items))
+	for i, item := range items {
+		es[i] = pipeline.WALEntry[string]{Seq: uint64(i + 1), Item: item}
+	}
+	return es
+}
+
+// batchItems returns the items of every batch received from c.
+func batchItems(c <-chan []pipeline.WALEntry[string]) [][]string {
+	var got [][]string
+	for batch := range c {
+		var items []string
+		for _, e := range batch {
+			items = append(items, e.Item)
+		}
+		got = append(got, items)
+	}
+	return got
+}
+
+func TestBatches(t *testing.T) {
+	ctx := context.Background()
+	size := func(s string) int { return len(s) }
+	for _, tt := range []struct {
+		name         string
+		count, bytes int
+		want         [][]string
+	}{
+		{"count", 2, 0, [][]string{{"a", "bb"}, {"ccc", "d"}, {"ee"}}},
+		{"bytes", 0, 3, [][]string{{"a", "bb"}, {"ccc"}, {"d", "ee"}}},
+		{"both", 2, 4, [][]string{{"a", "bb"}, {"ccc", "d"}, {"ee"}}},
+		{"neither", 0, 0, [][]string{{"a", "bb", "ccc", "d", "ee"}}},
+	} {
+		in := pipeline.Gen(ctx, entries("a", "bb", "ccc", "d", "ee")...)
+		if got := batchItems(Batches(ctx, in, tt.count, tt.bytes, size, 0)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBatchesLatency(t *testing.T) {
+	ctx := context.Background()
+	in := make(chan pipeline.WALEntry[string])
+	out := Batches(ctx, in, 10, 0, nil, 20*time.Millisecond)
+	es := entries("a", "b", "c")
+	in <- es[0]
+	in <- es[1]
+	select {
+	case batch := <-out:
+		if len(batch) != 2 {
+			t.Errorf("got batch %v, want both entries received before the deadline", batch)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("partial batch not emitted after its latency")
+	}
+	in <- es[2]
+	close(in)
+	if got := batchItems(out); !reflect.DeepEqual(got, [][]string{{"c"}}) {
+		t.Errorf("got %v, want the rest emitted once the input is closed", got)
+	}
+}
+
+func TestSinkWithWAL(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeDB{}
+	db := sql.OpenDB(fake)
+	defer db.Close()
+	w, err := pipeline.NewWAL[string](&pipeline.MemoryWALStorage{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logged, errc := w.Log(ctx, pipeline.Gen(ctx, "a", "b", "c"))
+	if err := pipeline.WriteTransactions(ctx, Batches(ctx, logged, 2, 0, nil, 0), w, New(db, insert)); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(fake.rows, want) {
+		t.Errorf("got rows %v, want %v", fake.rows, want)
+	}
+	if want := []string{"1+2", "3+1"}; !reflect.DeepEqual(fake.markers, want) {
+		t.Errorf("got markers %v, want %v", fake.markers, want)
+	}
+	if pending, _ := w.Pending(); len(pending) != 0 {
+		t.Errorf("got pending %v after writing everything", pending)
+	}
+}
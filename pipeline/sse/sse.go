@@ -0,0 +1,172 @@
+// Package sse streams the output of a pipeline to HTTP clients as
+// Server-Sent Events.
+package sse
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type event struct {
+	seq  uint64
+	data []byte
+}
+
+// Handler is an http.Handler that streams values as Server-Sent Events. Every
+// value gets a sequence number, starting at 1, which is sent as the event ID.
+// A reconnecting client that presents a Last-Event-ID header resumes right
+// after that event, provided it is still among the recent events the Handler
+// keeps. Otherwise, and whenever a slow client falls so far behind that events
+// it has not been sent yet are dropped from history, the client is sent a
+// "gap" event, whose data is the number of events it missed, and resumes from
+// the oldest event still kept.
+type Handler[T any] struct {
+	history   int
+	heartbeat time.Duration
+
+	mu     sync.Mutex
+	seq    uint64
+	events []event
+	// notify is closed and replaced every time an event is added, waking up
+	// the clients waiting for it.
+	notify chan struct{}
+	done   bool
+}
+
+// NewHandler returns a Handler that keeps the last history events for clients
+// that reconnect or fall behind, and sends a comment line to idle clients
+// every heartbeat, so that proxies do not time the connection out. A history
+// below 1 keeps only the latest event, which is the least needed to deliver
+// it to the clients connected. A zero heartbeat disables heartbeats.
+func NewHandler[T any](history int, heartbeat time.Duration) *Handler[T] {
+	if history < 1 {
+		history = 1
+	}
+	return &Handler[T]{
+		history:   history,
+		heartbeat: heartbeat,
+		notify:    make(chan struct{}),
+	}
+}
+
+// Run sends every value received from in to the connected clients, encoded as
+// JSON. When in is closed or the context is cancelled the clients are sent the
+// remaining events and disconnected, and Run returns.
+func (h *Handler[T]) Run(ctx context.Context, in <-chan T) error {
+	defer func() {
+		h.mu.Lock()
+		h.done = true
+		close(h.notify)
+		h.mu.Unlock()
+	}()
+	for {
+		select {
+		case v, ok := <-in:
+			if !ok {
+				return nil
+			}
+			data, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			h.add(data)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (h *Handler[T]) add(data []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.seq++
+	h.events = append(h.events, event{h.seq, data})
+	if len(h.events) > h.history {
+		h.events = h.events[len(h.events)-h.history:]
+	}
+	close(h.notify)
+	h.notify = make(chan struct{})
+}
+
+// since returns the kept events with a sequence number greater than last,
+// along with the channel that is closed when the next event is added and
+// whether the stream has finished.
+func (h *Handler[T]) since(last uint64) ([]event, <-chan struct{}, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	i := len(h.events)
+	for i > 0 && h.events[i-1].seq > last {
+		i--
+	}
+	return h.events[i:len(h.events):len(h.events)], h.notify, h.done
+}
+
+// ServeHTTP streams events to the client until it disconnects or the stream
+// finishes. Once the stream has finished and the client has seen every event,
+// it replies with 204 No Content, which tells browsers to stop reconnecting.
+func (h *Handler[T]) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	h.mu.Lock()
+	last := h.seq
+	h.mu.Unlock()
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		if n, err := strconv.ParseUint(id, 10, 64); err == nil && n <= last {
+			last = n
+		}
+	}
+
+	events, notify, done := h.since(last)
+	if done && len(events) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	var heartbeat <-chan time.Time
+	if h.heartbeat > 0 {
+		t := time.NewTicker(h.heartbeat)
+		defer t.Stop()
+		heartbeat = t.C
+	}
+	for {
+		if len(events) > 0 && events[0].seq > last+1 {
+			if _, err := fmt.Fprintf(w, "event: gap\ndata: %d\n\n", events[0].seq-last-1); err != nil {
+				return
+			}
+		}
+		for _, e := range events {
+			if _, err := fmt.Fprintf(w, "id: %d\ndata: %s\n\n", e.seq, e.data); err != nil {
+				return
+			}
+			last = e.seq
+		}
+		flusher.Flush()
+		if done {
+			return
+		}
+		select {
+		case <-notify:
+		case <-heartbeat:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		events, notify, done = h.since(last)
+	}
+}
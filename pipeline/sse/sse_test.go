@@ -0,0 +1,160 @@
+package sse
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readEvents reads the events of an SSE stream until it ends, as "id=data"
+// for data events and "gap=n" for gaps. Comments are skipped.
+func readEvents(t *testing.T, resp *http.Response) []string {
+	t.Helper()
+	defer resp.Body.Close()
+	var events []string
+	var name, id, data string
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case line == "":
+			if name == "gap" {
+				events = append(events, "gap="+data)
+			} else if data != "" {
+				events = append(events, id+"="+data)
+			}
+			name, id, data = "", "", ""
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+func get(t *testing.T, url, lastID string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// run feeds vs to h and waits for Run to return.
+func run(t *testing.T, h *Handler[int], vs ...int) {
+	t.Helper()
+	in := make(chan int)
+	errc := make(chan error, 1)
+	go func() { errc <- h.Run(context.Background(), in) }()
+	for _, v := range vs {
+		in <- v
+	}
+	close(in)
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLiveClientGetsEveryEvent(t *testing.T) {
+	for _, history := range []int{-1, 0, 1, 10} {
+		h := NewHandler[int](history, 0)
+		srv := httptest.NewServer(h)
+		// Once the headers are in, the handler has settled where the
+		// client starts from, so no event sent after that is missed.
+		resp := get(t, srv.URL, "")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("history %d: status %d", history, resp.StatusCode)
+		}
+		done := make(chan []string)
+		go func() { done <- readEvents(t, resp) }()
+		run(t, h, 10, 20, 30)
+		got := <-done
+		// A client reading slower than the events arrive may be told
+		// of a gap with a history of 1, but it always sees the last.
+		if len(got) == 0 || got[len(got)-1] != "3=30" {
+			t.Errorf("history %d: got %v, want to end with 3=30", history, got)
+		}
+		if history >= 3 && !reflect.DeepEqual(got, []string{"1=10", "2=20", "3=30"}) {
+			t.Errorf("history %d: got %v", history, got)
+		}
+		srv.Close()
+	}
+}
+
+func TestResume(t *testing.T) {
+	h := NewHandler[int](3, 0)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	run(t, h, 1, 2, 3, 4, 5)
+
+	for _, tt := range []struct {
+		lastID string
+		want   []string
+	}{
+		{"3", []string{"4=4", "5=5"}},
+		{"2", []string{"3=3", "4=4", "5=5"}},
+		// Events 1 and 2 have been dropped from history.
+		{"1", []string{"gap=1", "3=3", "4=4", "5=5"}},
+		{"0", []string{"gap=2", "3=3", "4=4", "5=5"}},
+	} {
+		got := readEvents(t, get(t, srv.URL, tt.lastID))
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Last-Event-ID %s: got %v, want %v", tt.lastID, got, tt.want)
+		}
+	}
+
+	resp := get(t, srv.URL, "5")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("caught up client after the end: status %d, want 204", resp.StatusCode)
+	}
+}
+
+func TestHistoryIsBounded(t *testing.T) {
+	for _, tt := range []struct{ history, kept int }{{-5, 1}, {0, 1}, {2, 2}, {10, 6}} {
+		h := NewHandler[int](tt.history, 0)
+		for i := 1; i <= 6; i++ {
+			h.add([]byte("x"))
+		}
+		events, _, _ := h.since(0)
+		if len(events) != tt.kept || events[len(events)-1].seq != 6 {
+			t.Errorf("history %d: kept %v, want the last %d", tt.history, events, tt.kept)
+		}
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	h := NewHandler[int](1, 10*time.Millisecond)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	resp := get(t, srv.URL, "")
+	defer resp.Body.Close()
+	r := bufio.NewReader(resp.Body)
+	line, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != ": heartbeat\n" {
+		t.Errorf("got %q, want a heartbeat comment", line)
+	}
+	run(t, h)
+}
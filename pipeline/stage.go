@@ -0,0 +1,9 @@
+package pipeline
+
+import "context"
+
+// A Stage is a step of a pipeline: it receives values from in, does some work
+// on them and sends the results on the channel it returns. The returned
+// channel must be closed once in has been closed and every result has been
+// sent, or once the context has been cancelled.
+type Stage[In, Out any] func(ctx context.Context, in <-chan In) <-chan Out
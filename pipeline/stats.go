@@ -0,0 +1,189 @@
+package pipeline
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+)
+
+// Stats computes running statistics over a stream of numbers: their count,
+// sum, mean, extremes and approximate quantiles. Quantiles are estimated from
+// a histogram of logarithmically sized buckets, so that they are within a
+// fixed relative error of the true value however many numbers are added,
+// while memory grows only with the logarithm of their range. It is safe for
+// concurrent use, so that a Snapshot can be taken while a pipeline is adding
+// to it.
+type Stats struct {
+	gamma    float64 // the ratio between the bounds of a bucket
+	logGamma float64
+
+	mu       sync.Mutex
+	count    int64
+	sum      float64
+	min, max float64
+	zeros    int64
+	pos, neg map[int]int64 // bucket counts by index, of |x| for neg
+}
+
+// StatsSnapshot is the state of a Stats at a point in time.
+type StatsSnapshot struct {
+	Count         int64
+	Sum, Mean     float64
+	Min, Max      float64
+	P50, P90, P99 float64
+}
+
+// NewStats returns an empty Stats whose quantiles are accurate to within
+// the given relative error, such as 0.01 for 1%. Values outside (0, 1) mean
+// 1%.
+func NewStats(accuracy float64) *Stats {
+	if accuracy <= 0 || accuracy >= 1 {
+		accuracy = 0.01
+	}
+	gamma := (1 + accuracy) / (1 - accuracy)
+	return &Stats{
+		gamma:    gamma,
+		logGamma: math.Log(gamma),
+		pos:      make(map[int]int64),
+		neg:      make(map[int]int64),
+	}
+}
+
+// Add adds x. NaNs are ignored.
+func (s *Stats) Add(x float64) {
+	if math.IsNaN(x) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.count == 0 || x < s.min {
+		s.min = x
+	}
+	if s.count == 0 || x > s.max {
+		s.max = x
+	}
+	s.count++
+	s.sum += x
+	switch {
+	case x > 0:
+		s.pos[s.bucket(x)]++
+	case x < 0:
+		s.neg[s.bucket(-x)]++
+	default:
+		s.zeros++
+	}
+}
+
+// bucket returns the index of the bucket x > 0 falls into: the bucket i
+// holds the values in (gamma^(i-1), gamma^i].
+func (s *Stats) bucket(x float64) int {
+	return int(math.Ceil(math.Log(x) / s.logGamma))
+}
+
+// value returns the estimate of the values in bucket i, the one equally far
+// from both its bounds relative to them.
+func (s *Stats) value(i int) float64 {
+	return 2 * math.Pow(s.gamma, float64(i)) / (s.gamma + 1)
+}
+
+// Quantile returns an estimate of the q-quantile of the values added so far,
+// for q in [0, 1]: 0.5 is the median, and 0.99 the value 99% of them are at
+// most. It returns NaN if nothing has been added.
+func (s *Stats) Quantile(q float64) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.quantile(q)
+}
+
+func (s *Stats) quantile(q float64) float64 {
+	if s.count == 0 || math.IsNaN(q) {
+		return math.NaN()
+	}
+	q = math.Max(0, math.Min(1, q))
+	rank := int64(q * float64(s.count-1))
+
+	// Walk the buckets from the lowest values up: the negative ones by
+	// decreasing magnitude, then zero, then the positive ones.
+	var x float64
+	found := false
+	see := func(n int64, v float64) bool {
+		if rank < n {
+			x, found = v, true
+			return true
+		}
+		rank -= n
+		return false
+	}
+	for _, i := range sortedKeys(s.neg, true) {
+		if see(s.neg[i], -s.value(i)) {
+			break
+		}
+	}
+	if !found && !see(s.zeros, 0) {
+		for _, i := range sortedKeys(s.pos, false) {
+			if see(s.pos[i], s.value(i)) {
+				break
+			}
+		}
+	}
+	// The estimate of the bucket holding the extremes may lie beyond
+	// them.
+	return math.Max(s.min, math.Min(s.max, x))
+}
+
+func sortedKeys(m map[int]int64, desc bool) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	if desc {
+		sort.Sort(sort.Reverse(sort.IntSlice(keys)))
+	} else {
+		sort.Ints(keys)
+	}
+	return keys
+}
+
+// Snapshot returns the current statistics. The mean, extremes and quantiles
+// are NaN if nothing has been added.
+func (s *Stats) Snapshot() StatsSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.count == 0 {
+		nan := math.NaN()
+		return StatsSnapshot{Mean: nan, Min: nan, Max: nan, P50: nan, P90: nan, P99: nan}
+	}
+	return StatsSnapshot{
+		Count: s.count,
+		Sum:   s.sum,
+		Mean:  s.sum / float64(s.count),
+		Min:   s.min,
+		Max:   s.max,
+		P50:   s.quantile(0.5),
+		P90:   s.quantile(0.9),
+		P99:   s.quantile(0.99),
+	}
+}
+
+// Measure passes on the values received from in unchanged, adding the number
+// value returns for each of them to s, so that the statistics of a stream can
+// be watched through s.Snapshot while it flows.
+//
+//	stats := NewStats(0.01)
+//	out := Measure(ctx, responses, stats, func(r Response) float64 { return r.Latency.Seconds() })
+func Measure[T any](ctx context.Context, in <-chan T, s *Stats, value func(T) float64) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for v := range in {
+			s.Add(value(v))
+			select {
+			case out <- v:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,67 @@
+package pipeline
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestStatsQuantiles(t *testing.T) {
+	s := NewStats(0.01)
+	const n = 10000
+	// Added in random order, from 1 to n.
+	for _, i := range rand.New(rand.NewSource(1)).Perm(n) {
+		s.Add(float64(i + 1))
+	}
+	for _, q := range []float64{0, 0.1, 0.5, 0.9, 0.99, 1} {
+		want := 1 + q*(n-1)
+		if got := s.Quantile(q); math.Abs(got-want) > 0.01*want+1 {
+			t.Errorf("quantile %v: got %v, want %v within 1%%", q, got, want)
+		}
+	}
+	snap := s.Snapshot()
+	if snap.Count != n || snap.Sum != n*(n+1)/2 || snap.Mean != (n+1)/2.0 || snap.Min != 1 || snap.Max != n {
+		t.Errorf("got %+v", snap)
+	}
+	if math.Abs(snap.P99-0.99*n) > 0.01*n {
+		t.Errorf("got P99 %v, want about %v", snap.P99, 0.99*n)
+	}
+}
+
+func TestStatsSigns(t *testing.T) {
+	s := NewStats(0.01)
+	for _, x := range []float64{-1000, -10, 0, 0, 0, 10, 1000, math.NaN()} {
+		s.Add(x)
+	}
+	snap := s.Snapshot()
+	if snap.Count != 7 || snap.Sum != 0 {
+		t.Errorf("got count %d and sum %v, want 7 and 0 with NaN ignored", snap.Count, snap.Sum)
+	}
+	for _, tt := range []struct{ q, want float64 }{
+		{0, -1000}, {1.0 / 6, -10}, {0.5, 0}, {5.0 / 6, 10}, {1, 1000},
+	} {
+		if got := s.Quantile(tt.q); math.Abs(got-tt.want) > 0.01*math.Abs(tt.want) {
+			t.Errorf("quantile %v: got %v, want %v", tt.q, got, tt.want)
+		}
+	}
+}
+
+func TestStatsEmpty(t *testing.T) {
+	snap := NewStats(0).Snapshot()
+	if snap.Count != 0 || !math.IsNaN(snap.Mean) || !math.IsNaN(snap.P50) || !math.IsNaN(snap.Min) {
+		t.Errorf("got %+v, want NaNs for an empty Stats", snap)
+	}
+}
+
+func TestMeasure(t *testing.T) {
+	ctx := context.Background()
+	s := NewStats(0.01)
+	out := Measure(ctx, Gen(ctx, "a", "bbb", "cc"), s, func(v string) float64 { return float64(len(v)) })
+	if got := collect(t, out); len(got) != 3 || got[1] != "bbb" {
+		t.Errorf("got %q, want the values unchanged", got)
+	}
+	if snap := s.Snapshot(); snap.Count != 3 || snap.Sum != 6 || snap.Max != 3 {
+		t.Errorf("got %+v, want 3 values summing to 6", snap)
+	}
+}
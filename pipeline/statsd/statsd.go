@@ -0,0 +1,166 @@
+// Package statsd pushes the metrics of a pipeline to a StatsD or DogStatsD
+// agent over UDP, for deployments that do not scrape Prometheus.
+package statsd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"concurrency/pipeline"
+)
+
+// maxPacket is the largest datagram sent, small enough not to be fragmented
+// on an Ethernet link.
+const maxPacket = 1432
+
+// An Emitter periodically sends the counters of a pipeline.Metrics to a
+// StatsD agent. For every stage it sends, under prefix.stage.name:
+//
+//   - in, out and errors, the items received, sent on and failed on since the
+//     last push, as counters;
+//   - backlog and active_workers, as gauges;
+//   - latency, the average time taken per item since the last push, in
+//     milliseconds, as a timer, if any item was timed.
+//
+// With DogStatsD set, the stage is sent as a "stage" tag instead of being
+// part of the name, and Tags are added to every metric.
+type Emitter struct {
+	// Prefix starts the name of every metric. If empty, "pipeline" is
+	// used.
+	Prefix string
+	// Interval is the time between pushes made by Run. If zero, 10 seconds
+	// is used.
+	Interval time.Duration
+	// DogStatsD selects the DogStatsD dialect, which supports tags.
+	DogStatsD bool
+	// Tags are added to every metric in the DogStatsD dialect, such as
+	// "env:prod".
+	Tags []string
+
+	metrics *pipeline.Metrics
+	conn    net.Conn
+
+	mu   sync.Mutex
+	last map[string]pipeline.StageStats
+}
+
+// New returns an Emitter sending the metrics of m to the agent listening on
+// addr, such as "127.0.0.1:8125".
+func New(m *pipeline.Metrics, addr string) (*Emitter, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("statsd: %w", err)
+	}
+	return &Emitter{metrics: m, conn: conn, last: make(map[string]pipeline.StageStats)}, nil
+}
+
+// Run pushes the metrics every Interval until the context is cancelled, then
+// pushes them one last time and closes the connection. A push that fails is
+// not retried, since StatsD tolerates missing packets; Run returns the
+// context's error.
+func (e *Emitter) Run(ctx context.Context) error {
+	defer e.conn.Close()
+	interval := e.Interval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			e.Push()
+		case <-ctx.Done():
+			e.Push()
+			return ctx.Err()
+		}
+	}
+}
+
+// Push sends the current metrics once. Counters are sent as the change since
+// the previous push.
+func (e *Emitter) Push() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var lines []string
+	for _, s := range e.metrics.Metrics() {
+		prev := e.last[s.Name]
+		e.last[s.Name] = s
+		metric := func(name string, value any, kind string) {
+			lines = append(lines, e.line(s.Name, name, value, kind))
+		}
+		metric("in", s.In-prev.In, "c")
+		metric("out", s.Out-prev.Out, "c")
+		metric("errors", s.Errors-prev.Errors, "c")
+		metric("backlog", s.Backlog(), "g")
+		metric("active_workers", s.Active, "g")
+		var timed int64
+		for i, n := range s.Latency {
+			timed += n
+			if i < len(prev.Latency) {
+				timed -= prev.Latency[i]
+			}
+		}
+		if timed > 0 {
+			avg := (s.Busy - prev.Busy) / time.Duration(timed)
+			metric("latency", float64(avg)/float64(time.Millisecond), "ms")
+		}
+	}
+	return e.send(lines)
+}
+
+// line formats a single metric of stage.
+func (e *Emitter) line(stage, name string, value any, kind string) string {
+	prefix := e.Prefix
+	if prefix == "" {
+		prefix = "pipeline"
+	}
+	if !e.DogStatsD {
+		return fmt.Sprintf("%s.%s.%s:%v|%s", prefix, sanitize(stage), name, value, kind)
+	}
+	tags := append([]string{"stage:" + sanitize(stage)}, e.Tags...)
+	return fmt.Sprintf("%s.%s:%v|%s|#%s", prefix, name, value, kind, strings.Join(tags, ","))
+}
+
+// send sends lines, packing as many in every datagram as fit.
+func (e *Emitter) send(lines []string) error {
+	var buf bytes.Buffer
+	var first error
+	flush := func() {
+		if buf.Len() == 0 {
+			return
+		}
+		if _, err := e.conn.Write(buf.Bytes()); err != nil && first == nil {
+			first = fmt.Errorf("statsd: %w", err)
+		}
+		buf.Reset()
+	}
+	for _, l := range lines {
+		if buf.Len() > 0 && buf.Len()+1+len(l) > maxPacket {
+			flush()
+		}
+		if buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(l)
+	}
+	flush()
+	return first
+}
+
+// sanitize replaces the characters of a stage name that StatsD gives a
+// meaning to.
+func sanitize(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-':
+			return r
+		}
+		return '_'
+	}, name)
+}
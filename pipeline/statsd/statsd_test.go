@@ -0,0 +1,160 @@
+package statsd
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"concurrency/pipeline"
+)
+
+// listen returns a UDP socket standing in for the agent.
+func listen(t *testing.T) net.PacketConn {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	return pc
+}
+
+// receive returns the metric lines of the packets that arrive within a short
+// while, sorted.
+func receive(t *testing.T, pc net.PacketConn) []string {
+	t.Helper()
+	var lines []string
+	buf := make([]byte, 64<<10)
+	for {
+		pc.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			break
+		}
+		if n > maxPacket {
+			t.Errorf("packet of %d bytes", n)
+		}
+		lines = append(lines, strings.Split(string(buf[:n]), "\n")...)
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+// run passes vs through a stage instrumented under "parse file", failing on
+// odd values.
+func run(m *pipeline.Metrics, vs ...int) {
+	ctx := context.Background()
+	fn := pipeline.InstrumentFunc(m, "parse file", func(_ context.Context, v int) (int, error) {
+		if v%2 == 1 {
+			return 0, errors.New("odd")
+		}
+		return v, nil
+	})
+	stage := pipeline.Instrument(m, "parse file", func(ctx context.Context, in <-chan int) <-chan pipeline.Result[int] {
+		return pipeline.TryMap(ctx, in, fn)
+	})
+	for range stage(ctx, pipeline.Gen(ctx, vs...)) {
+	}
+}
+
+func TestPush(t *testing.T) {
+	pc := listen(t)
+	m := pipeline.NewMetrics()
+	e, err := New(m, pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	run(m, 1, 2, 3)
+	if err := e.Push(); err != nil {
+		t.Fatal(err)
+	}
+	got := receive(t, pc)
+	want := []string{
+		"pipeline.parse_file.active_workers:0|g",
+		"pipeline.parse_file.backlog:0|g",
+		"pipeline.parse_file.errors:2|c",
+		"pipeline.parse_file.in:3|c",
+		"pipeline.parse_file.latency:",
+		"pipeline.parse_file.out:3|c",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %q", got)
+	}
+	for i := range want {
+		if !strings.HasPrefix(got[i], want[i]) {
+			t.Errorf("got %q, want %q", got[i], want[i])
+		}
+	}
+
+	// Counters are sent as deltas, and no latency without new items.
+	run(m, 2)
+	e.Push()
+	got = receive(t, pc)
+	if len(got) != 6 || got[2] != "pipeline.parse_file.errors:0|c" || got[3] != "pipeline.parse_file.in:1|c" {
+		t.Errorf("second push: %q", got)
+	}
+	e.Push()
+	for _, l := range receive(t, pc) {
+		if strings.Contains(l, "latency") {
+			t.Errorf("latency sent without new items: %q", l)
+		}
+	}
+}
+
+func TestDogStatsD(t *testing.T) {
+	pc := listen(t)
+	m := pipeline.NewMetrics()
+	e, err := New(m, pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Prefix, e.DogStatsD, e.Tags = "crawler", true, []string{"env:test"}
+	run(m, 2)
+	e.Push()
+	got := receive(t, pc)
+	if len(got) == 0 || got[0] != "crawler.active_workers:0|g|#stage:parse_file,env:test" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestPacketsAreSplit(t *testing.T) {
+	pc := listen(t)
+	m := pipeline.NewMetrics()
+	e, err := New(m, pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for i := 0; i < 200; i++ {
+		lines = append(lines, strings.Repeat("x", 40))
+	}
+	if err := e.send(lines); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(t, pc); len(got) != 200 {
+		t.Errorf("received %d lines, want 200", len(got))
+	}
+}
+
+func TestRunPushesOnCancel(t *testing.T) {
+	pc := listen(t)
+	m := pipeline.NewMetrics()
+	e, err := New(m, pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Interval = time.Hour
+	run(m, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := e.Run(ctx); err != context.Canceled {
+		t.Fatalf("Run returned %v", err)
+	}
+	if got := receive(t, pc); len(got) == 0 {
+		t.Error("nothing pushed on cancellation")
+	}
+}
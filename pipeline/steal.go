@@ -0,0 +1,160 @@
+package pipeline
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+)
+
+// WorkStealing applies fn to the values received from in with n workers, like
+// a FanOut of TryMap, but hands the values out to a queue per worker, in
+// turn, rather than through a single channel, and lets the workers that run
+// out of values steal from the queues of the others. A value that takes long
+// thus only holds up the values queued behind it until another worker takes
+// them, which keeps every worker busy on workloads whose values vary widely
+// in cost. Up to local values are queued per worker, on average. The results
+// come out in no particular order.
+func WorkStealing[In, Out any](ctx context.Context, in <-chan In, n, local int, fn func(context.Context, In) (Out, error)) <-chan Result[Out] {
+	if n < 1 {
+		n = 1
+	}
+	if local < 1 {
+		local = 1
+	}
+	queues := make([]*stealQueue[In], n)
+	for i := range queues {
+		queues[i] = &stealQueue[In]{}
+	}
+	// slots bounds the number of values queued across all the workers.
+	slots := make(chan struct{}, n*local)
+	// wake nudges idle workers when a value is queued.
+	wake := make(chan struct{}, n)
+	// closed is closed once in has been drained.
+	closed := make(chan struct{})
+
+	go func() {
+		defer close(closed)
+		for i := 0; ; i = (i + 1) % n {
+			var v In
+			select {
+			case w, ok := <-in:
+				if !ok {
+					return
+				}
+				v = w
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			queues[i].push(v)
+			select {
+			case wake <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	out := make(chan Result[Out])
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := range queues {
+		go func(self int) {
+			defer wg.Done()
+			// next takes a value from the worker's own queue or, if it is
+			// empty, steals half of the queue of another worker.
+			next := func() (In, bool) {
+				if v, ok := queues[self].pop(); ok {
+					return v, true
+				}
+				start := rand.Intn(n)
+				for j := 0; j < n; j++ {
+					victim := (start + j) % n
+					if victim == self {
+						continue
+					}
+					if vs := queues[victim].steal(); len(vs) > 0 {
+						queues[self].push(vs[1:]...)
+						return vs[0], true
+					}
+				}
+				var zero In
+				return zero, false
+			}
+			for {
+				v, ok := next()
+				if !ok {
+					select {
+					case <-wake:
+						continue
+					case <-closed:
+						// Values queued before in was drained
+						// are still to be done.
+						if v, ok = next(); !ok {
+							return
+						}
+					case <-ctx.Done():
+						return
+					}
+				}
+				<-slots
+				var r Result[Out]
+				r.Value, r.Err = fn(ctx, v)
+				select {
+				case out <- r:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(i)
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+// A stealQueue is the queue of a WorkStealing worker. The worker takes values
+// from its front, the oldest first, and thieves from its back.
+type stealQueue[T any] struct {
+	mu sync.Mutex
+	vs []T
+}
+
+func (q *stealQueue[T]) push(vs ...T) {
+	q.mu.Lock()
+	q.vs = append(q.vs, vs...)
+	q.mu.Unlock()
+}
+
+func (q *stealQueue[T]) pop() (T, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var zero T
+	if len(q.vs) == 0 {
+		return zero, false
+	}
+	v := q.vs[0]
+	q.vs[0] = zero
+	q.vs = q.vs[1:]
+	return v, true
+}
+
+// steal removes the newer half of the values queued, rounded up, and returns
+// them.
+func (q *stealQueue[T]) steal() []T {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	half := len(q.vs) - len(q.vs)/2
+	stolen := append([]T(nil), q.vs[len(q.vs)-half:]...)
+	var zero T
+	for i := len(q.vs) - half; i < len(q.vs); i++ {
+		q.vs[i] = zero
+	}
+	q.vs = q.vs[:len(q.vs)-half]
+	return stolen
+}
@@ -0,0 +1,98 @@
+package pipeline
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestWorkStealing(t *testing.T) {
+	ctx := context.Background()
+	values := make([]int, 200)
+	want := make([]int, len(values))
+	for i := range values {
+		values[i] = i
+		want[i] = i * i
+	}
+	square := func(_ context.Context, v int) (int, error) {
+		if v == 13 {
+			return 0, errDown
+		}
+		return v * v, nil
+	}
+	var got []int
+	failed := 0
+	for r := range WorkStealing(ctx, Gen(ctx, values...), 4, 2, square) {
+		if r.Err != nil {
+			failed++
+			got = append(got, 13*13)
+			continue
+		}
+		got = append(got, r.Value)
+	}
+	sort.Ints(got)
+	if !reflect.DeepEqual(got, want) || failed != 1 {
+		t.Errorf("got %v with %d errors, want every value squared once and one error", got, failed)
+	}
+}
+
+func TestWorkStealingAroundSlowValue(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// The value 0 takes until every other value is done. Some of those are
+	// queued behind it, and only come out if another worker steals them.
+	rest := make(chan struct{})
+	fn := func(ctx context.Context, v int) (int, error) {
+		if v == 0 {
+			select {
+			case <-rest:
+			case <-ctx.Done():
+			}
+		}
+		return v, nil
+	}
+	out := WorkStealing(ctx, Gen(ctx, 0, 1, 2, 3, 4, 5, 6, 7), 2, 4, fn)
+	var got []int
+	for len(got) < 7 {
+		select {
+		case r := <-out:
+			got = append(got, r.Value)
+		case <-time.After(time.Second):
+			t.Fatalf("got %v, want the values queued behind the slow one stolen", got)
+		}
+	}
+	close(rest)
+	if r := <-out; r.Value != 0 {
+		t.Errorf("got %d, want the slow value last", r.Value)
+	}
+	collect(t, out)
+}
+
+func TestWorkStealingCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	out := WorkStealing(ctx, make(chan int), 3, 1, func(_ context.Context, v int) (int, error) { return v, nil })
+	cancel()
+	collect(t, out)
+}
+
+func TestStealQueue(t *testing.T) {
+	var q stealQueue[int]
+	q.push(1, 2, 3, 4, 5)
+	if got := q.steal(); !reflect.DeepEqual(got, []int{3, 4, 5}) {
+		t.Errorf("stole %v, want the newer half, rounded up", got)
+	}
+	if v, ok := q.pop(); !ok || v != 1 {
+		t.Errorf("popped %v, %v, want the oldest value", v, ok)
+	}
+	if got := q.steal(); !reflect.DeepEqual(got, []int{2}) {
+		t.Errorf("stole %v, want the last value", got)
+	}
+	if _, ok := q.pop(); ok {
+		t.Error("popped from an empty queue")
+	}
+	if got := q.steal(); len(got) != 0 {
+		t.Errorf("stole %v from an empty queue", got)
+	}
+}
@@ -0,0 +1,105 @@
+package pipeline
+
+import "context"
+
+// Take passes on the first n values received from in and then closes its
+// output channel. It goes on reading from in after that, discarding what it
+// receives, so that the stages upstream are not left blocked on their next
+// send: they finish once their own input runs out or ctx is cancelled.
+// Upstream stages that never run out, such as generators, should be started
+// with a context that is cancelled once the output of Take has been read,
+// as in
+//
+//	ctx, cancel := context.WithCancel(ctx)
+//	defer cancel()
+//	for v := range Take(ctx, FanOut(ctx, in, 4, worker), 10) {
+//		...
+//	}
+func Take[T any](ctx context.Context, in <-chan T, n int) <-chan T {
+	out := make(chan T)
+	go func() {
+		// Deferred first, so that it runs once out has been closed.
+		defer discard(ctx, in)
+		defer close(out)
+		for i := 0; i < n; i++ {
+			select {
+			case v, ok := <-in:
+				if !ok {
+					return
+				}
+				select {
+				case out <- v:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// TakeWhile passes on the values received from in for as long as keep
+// returns true, and closes its output channel at the first value for which
+// it returns false, which is dropped. Like Take, it then discards the rest
+// of in to release the stages upstream.
+func TakeWhile[T any](ctx context.Context, in <-chan T, keep func(T) bool) <-chan T {
+	out := make(chan T)
+	go func() {
+		// Deferred first, so that it runs once out has been closed.
+		defer discard(ctx, in)
+		defer close(out)
+		for {
+			select {
+			case v, ok := <-in:
+				if !ok || !keep(v) {
+					return
+				}
+				select {
+				case out <- v:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// discard receives and drops the values from in until it is closed or ctx
+// is cancelled.
+func discard[T any](ctx context.Context, in <-chan T) {
+	for {
+		select {
+		case _, ok := <-in:
+			if !ok {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Skip drops the first n values received from in and passes on the rest.
+func Skip[T any](ctx context.Context, in <-chan T, n int) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for v := range in {
+			if n > 0 {
+				n--
+				continue
+			}
+			select {
+			case out <- v:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,104 @@
+package pipeline
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// count sends 0, 1, 2... until ctx is cancelled, then closes stopped.
+func count(ctx context.Context, stopped chan<- struct{}) <-chan int {
+	out := make(chan int)
+	go func() {
+		defer close(stopped)
+		defer close(out)
+		for i := 0; ; i++ {
+			select {
+			case out <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// upTo sends 0, 1, 2... up to n-1 without heeding any context, then closes
+// stopped.
+func upTo(n int, stopped chan<- struct{}) <-chan int {
+	out := make(chan int)
+	go func() {
+		defer close(stopped)
+		defer close(out)
+		for i := 0; i < n; i++ {
+			out <- i
+		}
+	}()
+	return out
+}
+
+func TestTakeReleasesUpstream(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		take func(ctx context.Context, in <-chan int) <-chan int
+	}{
+		{"Take", func(ctx context.Context, in <-chan int) <-chan int {
+			return Take(ctx, in, 3)
+		}},
+		{"TakeWhile", func(ctx context.Context, in <-chan int) <-chan int {
+			return TakeWhile(ctx, in, func(v int) bool { return v < 3 })
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			stopped := make(chan struct{})
+			var got []int
+			for v := range tt.take(ctx, upTo(10, stopped)) {
+				got = append(got, v)
+			}
+			if want := []int{0, 1, 2}; !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+			select {
+			case <-stopped:
+			case <-time.After(time.Second):
+				t.Error("upstream blocked after the values were taken")
+			}
+
+			// An endless upstream stops with the context.
+			stopped = make(chan struct{})
+			for range tt.take(ctx, count(ctx, stopped)) {
+			}
+			cancel()
+			select {
+			case <-stopped:
+			case <-time.After(time.Second):
+				t.Error("upstream still running after cancelling")
+			}
+		})
+	}
+}
+
+func TestTakeShortInput(t *testing.T) {
+	ctx := context.Background()
+	var got []int
+	for v := range Take(ctx, Gen(ctx, 1, 2), 5) {
+		got = append(got, v)
+	}
+	if want := []int{1, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSkip(t *testing.T) {
+	ctx := context.Background()
+	var got []int
+	for v := range Skip(ctx, Gen(ctx, 1, 2, 3, 4), 2) {
+		got = append(got, v)
+	}
+	if want := []int{3, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,68 @@
+package pipeline
+
+import "context"
+
+// TeeN copies every value received from in onto n output channels, so that
+// independent downstream pipelines can consume the same stream. If
+// transforms are given, the value sent on the i-th output is first passed
+// through transforms[i]; outputs without a corresponding or with a nil
+// transform get the value unchanged. Transforms are run on the goroutine that
+// feeds all the outputs and should therefore be cheap.
+//
+// Each value is sent to every output before the next one is received, so the
+// outputs advance together at the pace of the slowest consumer. All outputs
+// are closed once in is closed or the context is cancelled.
+func TeeN[T any](ctx context.Context, in <-chan T, n int, transforms ...func(T) T) []<-chan T {
+	outs := make([]chan T, n)
+	res := make([]<-chan T, n)
+	for i := range outs {
+		outs[i] = make(chan T)
+		res[i] = outs[i]
+	}
+	go func() {
+		defer func() {
+			for _, out := range outs {
+				close(out)
+			}
+		}()
+		for {
+			var v T
+			select {
+			case w, ok := <-in:
+				if !ok {
+					return
+				}
+				v = w
+			case <-ctx.Done():
+				return
+			}
+			for i, out := range outs {
+				w := v
+				if i < len(transforms) && transforms[i] != nil {
+					w = transforms[i](v)
+				}
+				select {
+				case out <- w:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return res
+}
+
+// Tee copies every value received from in onto two output channels, for
+// example to persist a stream on one branch while computing aggregates from
+// it on the other. Both outputs must be consumed concurrently; see TeeN.
+func Tee[T any](ctx context.Context, in <-chan T) (<-chan T, <-chan T) {
+	outs := TeeN(ctx, in, 2)
+	return outs[0], outs[1]
+}
+
+// Broadcast copies every value received from in onto n output channels. It is
+// TeeN without transforms. To add and remove consumers while the stream is
+// running, use a Broadcaster instead.
+func Broadcast[T any](ctx context.Context, in <-chan T, n int) []<-chan T {
+	return TeeN(ctx, in, n)
+}
@@ -0,0 +1,96 @@
+package pipeline
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// drainAll collects the values of every channel in cs concurrently.
+func drainAll[T any](t *testing.T, cs []<-chan T) [][]T {
+	t.Helper()
+	got := make([][]T, len(cs))
+	var wg sync.WaitGroup
+	wg.Add(len(cs))
+	for i, c := range cs {
+		go func(i int, c <-chan T) {
+			defer wg.Done()
+			for v := range c {
+				got[i] = append(got[i], v)
+			}
+		}(i, c)
+	}
+	wg.Wait()
+	return got
+}
+
+func TestTeeN(t *testing.T) {
+	ctx := context.Background()
+	outs := TeeN(ctx, Gen(ctx, "a", "b"), 3, strings.ToUpper, nil)
+	got := drainAll(t, outs)
+	want := [][]string{{"A", "B"}, {"a", "b"}, {"a", "b"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestTeeNLockstep(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	in := make(chan int)
+	outs := TeeN(ctx, in, 2)
+	go func() {
+		in <- 1
+		in <- 2
+	}()
+	if v := <-outs[0]; v != 1 {
+		t.Fatalf("got %d, want 1", v)
+	}
+	// The first output cannot run ahead until the second has taken the
+	// value too.
+	select {
+	case v := <-outs[0]:
+		t.Fatalf("first output got %d before the second took 1", v)
+	case <-time.After(20 * time.Millisecond):
+	}
+	if v := <-outs[1]; v != 1 {
+		t.Fatalf("got %d, want 1", v)
+	}
+	if v := <-outs[0]; v != 2 {
+		t.Fatalf("got %d, want 2", v)
+	}
+
+	cancel()
+	for _, out := range outs {
+		for range out {
+		}
+	}
+}
+
+func TestTee(t *testing.T) {
+	ctx := context.Background()
+	a, b := Tee(ctx, Gen(ctx, 1, 2, 3))
+	got := drainAll(t, []<-chan int{a, b})
+	if want := [][]int{{1, 2, 3}, {1, 2, 3}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestBroadcastCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	outs := Broadcast(ctx, Gen(ctx, 1, 2, 3), 3)
+	if len(outs) != 3 {
+		t.Fatalf("got %d outputs, want 3", len(outs))
+	}
+	if v := <-outs[0]; v != 1 {
+		t.Fatalf("got %d, want 1", v)
+	}
+	// The other outputs are never read; cancelling still closes them all.
+	cancel()
+	for _, out := range outs {
+		collect(t, out)
+	}
+}
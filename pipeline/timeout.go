@@ -0,0 +1,37 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// WithTimeout wraps fn so that it may spend at most d on an item. Once d has
+// passed, the context fn was given for the item is cancelled and the wrapped
+// function returns an error wrapping context.DeadlineExceeded, so that one
+// slow item fails on its own instead of stalling the stage. If fn ignores its
+// context, it keeps running in the background until it returns, and its
+// result is discarded.
+func WithTimeout[In, Out any](fn func(context.Context, In) (Out, error), d time.Duration) func(context.Context, In) (Out, error) {
+	return func(ctx context.Context, v In) (Out, error) {
+		ctx, cancel := context.WithTimeout(ctx, d)
+		defer cancel()
+		done := make(chan Result[Out], 1)
+		go func() {
+			var r Result[Out]
+			r.Value, r.Err = fn(ctx, v)
+			// No select needed for this send, since done is buffered.
+			done <- r
+		}()
+		select {
+		case r := <-done:
+			return r.Value, r.Err
+		case <-ctx.Done():
+			var zero Out
+			if ctx.Err() == context.DeadlineExceeded {
+				return zero, fmt.Errorf("pipeline: item timed out after %v: %w", d, ctx.Err())
+			}
+			return zero, ctx.Err()
+		}
+	}
+}
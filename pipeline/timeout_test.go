@@ -0,0 +1,62 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWithTimeout(t *testing.T) {
+	ctx := context.Background()
+	sleep := func(ctx context.Context, d time.Duration) (time.Duration, error) {
+		select {
+		case <-time.After(d):
+			return d, nil
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+	fn := WithTimeout(sleep, 20*time.Millisecond)
+	if v, err := fn(ctx, time.Millisecond); err != nil || v != time.Millisecond {
+		t.Errorf("got %v, %v for a quick item", v, err)
+	}
+	start := time.Now()
+	if _, err := fn(ctx, time.Hour); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want context.DeadlineExceeded", err)
+	}
+	if d := time.Since(start); d > 200*time.Millisecond {
+		t.Errorf("slow item took %v to fail, want about 20ms", d)
+	}
+}
+
+func TestWithTimeoutIgnoredContext(t *testing.T) {
+	ctx := context.Background()
+	release := make(chan struct{})
+	defer close(release)
+	stubborn := func(_ context.Context, v int) (int, error) {
+		<-release
+		return v, nil
+	}
+	// The item fails on time even though fn never looks at its context.
+	start := time.Now()
+	if _, err := WithTimeout(stubborn, 10*time.Millisecond)(ctx, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want context.DeadlineExceeded", err)
+	}
+	if d := time.Since(start); d > 200*time.Millisecond {
+		t.Errorf("took %v to give up, want about 10ms", d)
+	}
+}
+
+func TestWithTimeoutCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	block := func(ctx context.Context, v int) (int, error) {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	}
+	_, err := WithTimeout(block, time.Hour)(ctx, 1)
+	if !errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want the stage's cancellation passed through", err)
+	}
+}
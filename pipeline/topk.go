@@ -0,0 +1,99 @@
+package pipeline
+
+import (
+	"container/heap"
+	"context"
+	"sort"
+	"sync"
+)
+
+// TopK keeps the k values with the highest score out of all those added to
+// it, in memory bounded by k however long the stream. It is safe for
+// concurrent use, so that Snapshot can be called while Run is draining a
+// channel into it.
+type TopK[T any] struct {
+	k     int
+	score func(T) float64
+
+	mu sync.Mutex
+	h  scoredHeap[T]
+}
+
+// NewTopK returns an empty TopK keeping the k values scoring highest by
+// score.
+func NewTopK[T any](k int, score func(T) float64) *TopK[T] {
+	return &TopK[T]{k: k, score: score}
+}
+
+// Add adds v, evicting the value scoring lowest if there are k already and v
+// scores higher. Of values with equal scores, the earliest added are kept.
+func (t *TopK[T]) Add(v T) {
+	if t.k <= 0 {
+		return
+	}
+	s := scored[T]{v, t.score(v)}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.h) < t.k {
+		heap.Push(&t.h, s)
+		return
+	}
+	if s.score > t.h[0].score {
+		t.h[0] = s
+		heap.Fix(&t.h, 0)
+	}
+}
+
+// Snapshot returns the values kept so far, highest score first.
+func (t *TopK[T]) Snapshot() []T {
+	t.mu.Lock()
+	h := append(scoredHeap[T](nil), t.h...)
+	t.mu.Unlock()
+	sort.SliceStable(h, func(i, j int) bool { return h[i].score > h[j].score })
+	vs := make([]T, len(h))
+	for i, s := range h {
+		vs[i] = s.v
+	}
+	return vs
+}
+
+// Run adds every value received from in and returns the final Snapshot once
+// in is closed. If the context is cancelled first, it returns the snapshot
+// so far along with the context's error.
+//
+//	top := NewTopK(10, func(p Page) float64 { return float64(p.Hits) })
+//	go serveSnapshots(top) // calls top.Snapshot on request
+//	pages, err := top.Run(ctx, in)
+func (t *TopK[T]) Run(ctx context.Context, in <-chan T) ([]T, error) {
+	for {
+		select {
+		case v, ok := <-in:
+			if !ok {
+				return t.Snapshot(), nil
+			}
+			t.Add(v)
+		case <-ctx.Done():
+			return t.Snapshot(), ctx.Err()
+		}
+	}
+}
+
+type scored[T any] struct {
+	v     T
+	score float64
+}
+
+// scoredHeap is a min-heap of values by score, so that the lowest of the top
+// k is at its root.
+type scoredHeap[T any] []scored[T]
+
+func (h scoredHeap[T]) Len() int           { return len(h) }
+func (h scoredHeap[T]) Less(i, j int) bool { return h[i].score < h[j].score }
+func (h scoredHeap[T]) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *scoredHeap[T]) Push(x any)        { *h = append(*h, x.(scored[T])) }
+func (h *scoredHeap[T]) Pop() any {
+	old := *h
+	s := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return s
+}
@@ -0,0 +1,71 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestTopK(t *testing.T) {
+	type page struct {
+		url  string
+		hits int
+	}
+	hits := func(p page) float64 { return float64(p.hits) }
+	top := NewTopK(2, hits)
+	ctx := context.Background()
+	got, err := top.Run(ctx, Gen(ctx,
+		page{"/a", 3},
+		page{"/b", 7},
+		page{"/c", 1},
+		page{"/d", 7},
+		page{"/e", 5},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The two pages tied on top may come in either order.
+	if len(got) != 2 || got[0].hits != 7 || got[1].hits != 7 || got[0].url == got[1].url {
+		t.Errorf("got %v, want /b and /d", got)
+	}
+
+	// Of equal scores, the earliest added are kept.
+	top.Add(page{"/f", 7})
+	for _, p := range top.Snapshot() {
+		if p.url == "/f" {
+			t.Errorf("later value with an equal score evicted an earlier one: %v", top.Snapshot())
+		}
+	}
+}
+
+func TestTopKSnapshotOrder(t *testing.T) {
+	top := NewTopK(3, func(v int) float64 { return float64(v) })
+	for _, v := range []int{4, 9, 1, 7, 3, 8} {
+		top.Add(v)
+	}
+	if got := top.Snapshot(); !reflect.DeepEqual(got, []int{9, 8, 7}) {
+		t.Errorf("got %v, want [9 8 7]", got)
+	}
+	if got := NewTopK(0, func(v int) float64 { return 0 }); len(got.Snapshot()) != 0 {
+		t.Error("TopK of zero kept values")
+	}
+}
+
+func TestTopKRunCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	top := NewTopK(5, func(v int) float64 { return float64(v) })
+	in := make(chan int)
+	go func() {
+		in <- 1
+		in <- 2
+		cancel()
+	}()
+	got, err := top.Run(ctx, in)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+	if !reflect.DeepEqual(got, []int{2, 1}) {
+		t.Errorf("got %v, want the snapshot so far", got)
+	}
+}
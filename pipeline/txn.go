@@ -0,0 +1,130 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrUnknownTransaction is returned by TransactionalSink.Commit for a
+// transaction the sink has no record of.
+var ErrUnknownTransaction = errors.New("pipeline: unknown transaction")
+
+// A TransactionalSink writes batches of items atomically, in two phases, so
+// that together with a WAL every item ends up written exactly once even if
+// the process crashes at any point.
+//
+// Prepare must durably stage the batch under the transaction id without
+// making it visible, Commit must make a prepared transaction visible and
+// Abort must discard it. Commit must be idempotent: committing a transaction
+// that has already been committed succeeds. Committing a transaction the
+// sink has never prepared must fail with ErrUnknownTransaction.
+type TransactionalSink[T any] interface {
+	Prepare(ctx context.Context, id string, batch []T) error
+	Commit(ctx context.Context, id string) error
+	Abort(ctx context.Context, id string) error
+}
+
+// A PreparedLister is a TransactionalSink that can list the transactions it
+// holds prepared but not yet committed or aborted. ResolveTransactions uses
+// it to abort transactions prepared by a run that crashed before recording
+// them in its WAL.
+type PreparedLister interface {
+	Prepared(ctx context.Context) ([]string, error)
+}
+
+// WriteTransactions writes every batch of entries received from in to sink
+// as a transaction and marks the entries complete in w once it has been
+// committed.
+//
+// The WAL acts as the coordinator of the two-phase commit: once the sink has
+// prepared a batch, the transaction is recorded in w, and from that point on
+// it is committed, after a crash by ResolveTransactions. A batch that fails
+// to be prepared is aborted. WriteTransactions stops at the first error and
+// returns it; it returns nil once in is closed.
+func WriteTransactions[T any](ctx context.Context, in <-chan []WALEntry[T], w *WAL[T], sink TransactionalSink[T]) error {
+	for {
+		var batch []WALEntry[T]
+		select {
+		case b, ok := <-in:
+			if !ok {
+				return nil
+			}
+			batch = b
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if len(batch) == 0 {
+			continue
+		}
+		// Sequence numbers are unique within a WAL, so the first one and the
+		// size of the batch identify the transaction.
+		id := fmt.Sprintf("%d+%d", batch[0].Seq, len(batch))
+		items := make([]T, len(batch))
+		seqs := make([]uint64, len(batch))
+		for i, e := range batch {
+			items[i], seqs[i] = e.Item, e.Seq
+		}
+		if err := sink.Prepare(ctx, id, items); err != nil {
+			return errors.Join(err, sink.Abort(ctx, id))
+		}
+		if err := w.prepare(id, seqs); err != nil {
+			return errors.Join(err, sink.Abort(ctx, id))
+		}
+		if err := commit(ctx, w, sink, id, seqs); err != nil {
+			return err
+		}
+	}
+}
+
+// commit commits the prepared transaction id and marks its entries complete.
+func commit[T any](ctx context.Context, w *WAL[T], sink TransactionalSink[T], id string, seqs []uint64) error {
+	if err := sink.Commit(ctx, id); err != nil {
+		return err
+	}
+	for _, seq := range seqs {
+		if err := w.Complete(seq); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ResolveTransactions finishes the two-phase commits interrupted by a crash.
+// Transactions recorded in w as prepared are committed and their entries
+// marked complete. A recorded transaction the sink does not know was lost
+// before the sink made it durable; its entries are left pending so that they
+// are replayed. If sink implements PreparedLister, transactions it holds
+// prepared that are not recorded in w are aborted.
+//
+// Call ResolveTransactions on startup, before WAL.Recover.
+func ResolveTransactions[T any](ctx context.Context, w *WAL[T], sink TransactionalSink[T]) error {
+	prepares, err := w.prepared()
+	if err != nil {
+		return err
+	}
+	recorded := make(map[string]bool, len(prepares))
+	for _, rec := range prepares {
+		recorded[rec.Txn] = true
+		err := commit(ctx, w, sink, rec.Txn, rec.Seqs)
+		if err != nil && !errors.Is(err, ErrUnknownTransaction) {
+			return err
+		}
+	}
+	lister, ok := sink.(PreparedLister)
+	if !ok {
+		return nil
+	}
+	ids, err := lister.Prepared(ctx)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if !recorded[id] {
+			if err := sink.Abort(ctx, id); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// txnSink is a TransactionalSink and PreparedLister keeping its transactions
+// in memory.
+type txnSink struct {
+	prepared  map[string][]string
+	committed map[string][]string
+	aborted   []string
+	// failPrepare makes Prepare fail for batches holding this item.
+	failPrepare string
+}
+
+func newTxnSink() *txnSink {
+	return &txnSink{prepared: make(map[string][]string), committed: make(map[string][]string)}
+}
+
+func (s *txnSink) Prepare(_ context.Context, id string, batch []string) error {
+	for _, v := range batch {
+		if v == s.failPrepare {
+			return errors.New("cannot stage " + v)
+		}
+	}
+	s.prepared[id] = batch
+	return nil
+}
+
+func (s *txnSink) Commit(_ context.Context, id string) error {
+	if _, ok := s.committed[id]; ok {
+		return nil
+	}
+	batch, ok := s.prepared[id]
+	if !ok {
+		return ErrUnknownTransaction
+	}
+	delete(s.prepared, id)
+	s.committed[id] = batch
+	return nil
+}
+
+func (s *txnSink) Abort(_ context.Context, id string) error {
+	delete(s.prepared, id)
+	s.aborted = append(s.aborted, id)
+	return nil
+}
+
+func (s *txnSink) Prepared(context.Context) ([]string, error) {
+	var ids []string
+	for id := range s.prepared {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// admitAll admits vs to w and returns their entries.
+func admitAll(t *testing.T, w *WAL[string], vs ...string) []WALEntry[string] {
+	t.Helper()
+	entries := make([]WALEntry[string], len(vs))
+	for i, v := range vs {
+		e, err := w.Admit(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[i] = e
+	}
+	return entries
+}
+
+func TestWriteTransactions(t *testing.T) {
+	ctx := context.Background()
+	w, _ := NewWAL[string](&MemoryWALStorage{})
+	sink := newTxnSink()
+	batches := Gen(ctx, admitAll(t, w, "a", "b"), admitAll(t, w, "c"))
+	if err := WriteTransactions(ctx, batches, w, sink); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{"1+2": {"a", "b"}, "3+1": {"c"}}
+	if !reflect.DeepEqual(sink.committed, want) {
+		t.Errorf("got committed %v, want %v", sink.committed, want)
+	}
+	if pending, _ := w.Pending(); len(pending) != 0 {
+		t.Errorf("got pending %v after committing everything", pending)
+	}
+}
+
+func TestWriteTransactionsPrepareFails(t *testing.T) {
+	ctx := context.Background()
+	w, _ := NewWAL[string](&MemoryWALStorage{})
+	sink := newTxnSink()
+	sink.failPrepare = "bad"
+	err := WriteTransactions(ctx, Gen(ctx, admitAll(t, w, "a", "bad")), w, sink)
+	if err == nil {
+		t.Fatal("failed prepare not reported")
+	}
+	if !reflect.DeepEqual(sink.aborted, []string{"1+2"}) {
+		t.Errorf("got aborted %v, want the failed transaction", sink.aborted)
+	}
+	if pending, _ := w.Pending(); len(pending) != 2 {
+		t.Errorf("got %d pending entries, want both left to be replayed", len(pending))
+	}
+}
+
+func TestResolveTransactions(t *testing.T) {
+	ctx := context.Background()
+	w, _ := NewWAL[string](&MemoryWALStorage{})
+	sink := newTxnSink()
+
+	// The run crashed after recording the first transaction, but before
+	// committing it.
+	first := admitAll(t, w, "a", "b")
+	sink.Prepare(ctx, "1+2", []string{"a", "b"})
+	w.prepare("1+2", seqs(first))
+	// The sink lost the second transaction, which it never made durable.
+	second := admitAll(t, w, "c")
+	w.prepare("3+1", seqs(second))
+	// The third was prepared by the sink, but not recorded in the WAL.
+	admitAll(t, w, "d")
+	sink.Prepare(ctx, "4+1", []string{"d"})
+
+	if err := ResolveTransactions(ctx, w, sink); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(sink.committed, map[string][]string{"1+2": {"a", "b"}}) {
+		t.Errorf("got committed %v, want only the recorded transaction", sink.committed)
+	}
+	if !reflect.DeepEqual(sink.aborted, []string{"4+1"}) {
+		t.Errorf("got aborted %v, want the unrecorded transaction", sink.aborted)
+	}
+	pending, _ := w.Pending()
+	if want := []uint64{3, 4}; !reflect.DeepEqual(seqs(pending), want) {
+		t.Errorf("got pending %v, want %v to be replayed", seqs(pending), want)
+	}
+
+	// Resolving again finds nothing left to do.
+	if err := ResolveTransactions(ctx, w, sink); err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.committed) != 1 || len(sink.aborted) != 1 {
+		t.Errorf("second resolution changed the sink: committed %v, aborted %v", sink.committed, sink.aborted)
+	}
+}
@@ -0,0 +1,141 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Op is the kind of change an item represents for a table maintained by an
+// UpsertSink.
+type Op int
+
+// The operations an UpsertSink can apply.
+const (
+	Insert Op = iota + 1
+	Update
+	Delete
+)
+
+func (op Op) String() string {
+	switch op {
+	case Insert:
+		return "insert"
+	case Update:
+		return "update"
+	case Delete:
+		return "delete"
+	}
+	return fmt.Sprintf("Op(%d)", int(op))
+}
+
+// Change is an item together with the operation it should be applied as.
+type Change[T any] struct {
+	Op   Op
+	Item T
+}
+
+// UpsertSink applies batches of changes to a materialized table.
+//
+// Apply should apply as much of the batch as it can. Changes that conflict
+// with the state of the table, such as an insert of a key that already exists
+// or an update of a key that does not, should be reported by returning a
+// *ConflictError listing them; any other error is treated as fatal.
+type UpsertSink[T any] interface {
+	Apply(ctx context.Context, changes []Change[T]) error
+}
+
+// ConflictError is returned by UpsertSink.Apply when some of the changes in a
+// batch could not be applied because they conflict with the table.
+type ConflictError[T any] struct {
+	Changes []Change[T]
+}
+
+func (e *ConflictError[T]) Error() string {
+	return fmt.Sprintf("pipeline: %d conflicting changes", len(e.Changes))
+}
+
+// Resolver decides what to do with a change that conflicted with the table. It
+// returns the change to apply in its place and true, or false to drop it.
+type Resolver[T any] func(Change[T]) (Change[T], bool)
+
+// Upsert resolves conflicts the way an upsert would: a conflicting insert is
+// retried as an update, a conflicting update is retried as an insert and a
+// delete of a missing key is dropped.
+func Upsert[T any](c Change[T]) (Change[T], bool) {
+	switch c.Op {
+	case Insert:
+		c.Op = Update
+	case Update:
+		c.Op = Insert
+	default:
+		return c, false
+	}
+	return c, true
+}
+
+// ToUpsertSink classifies every item received from in as an insert, update or
+// delete and applies the resulting changes to sink in batches of at most size.
+// A batch is applied as soon as it is full or as soon as no further item is
+// immediately available, so a quiet stream never holds changes back.
+//
+// When the sink reports conflicts they are passed to resolve and the
+// replacement changes are applied once more. If resolve is nil, or if the
+// replacements conflict again, ToUpsertSink stops and returns the
+// *ConflictError. ToUpsertSink returns nil once in is closed and every change
+// has been applied.
+func ToUpsertSink[T any](ctx context.Context, in <-chan T, classify func(T) Op, sink UpsertSink[T], size int, resolve Resolver[T]) error {
+	if size < 1 {
+		size = 1
+	}
+	batch := make([]Change[T], 0, size)
+	for {
+		select {
+		case v, ok := <-in:
+			if !ok {
+				return nil
+			}
+			batch = append(batch[:0], Change[T]{classify(v), v})
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		closed := false
+	fill:
+		for len(batch) < size {
+			select {
+			case v, ok := <-in:
+				if !ok {
+					closed = true
+					break fill
+				}
+				batch = append(batch, Change[T]{classify(v), v})
+			default:
+				break fill
+			}
+		}
+		if err := applyChanges(ctx, sink, batch, resolve); err != nil {
+			return err
+		}
+		if closed {
+			return nil
+		}
+	}
+}
+
+func applyChanges[T any](ctx context.Context, sink UpsertSink[T], batch []Change[T], resolve Resolver[T]) error {
+	err := sink.Apply(ctx, batch)
+	var conflict *ConflictError[T]
+	if err == nil || resolve == nil || !errors.As(err, &conflict) {
+		return err
+	}
+	var retry []Change[T]
+	for _, c := range conflict.Changes {
+		if c, ok := resolve(c); ok {
+			retry = append(retry, c)
+		}
+	}
+	if len(retry) == 0 {
+		return nil
+	}
+	return sink.Apply(ctx, retry)
+}
@@ -0,0 +1,98 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type row struct {
+	id  int
+	val string
+}
+
+// table is an UpsertSink over a map that rejects inserts of existing rows and
+// updates or deletes of missing ones.
+type table struct {
+	rows    map[int]string
+	batches [][]Change[row]
+}
+
+func (tb *table) Apply(_ context.Context, changes []Change[row]) error {
+	tb.batches = append(tb.batches, append([]Change[row](nil), changes...))
+	var conflicts []Change[row]
+	for _, c := range changes {
+		_, exists := tb.rows[c.Item.id]
+		switch {
+		case c.Op == Insert && exists, c.Op != Insert && !exists:
+			conflicts = append(conflicts, c)
+		case c.Op == Delete:
+			delete(tb.rows, c.Item.id)
+		default:
+			tb.rows[c.Item.id] = c.Item.val
+		}
+	}
+	if len(conflicts) > 0 {
+		return &ConflictError[row]{Changes: conflicts}
+	}
+	return nil
+}
+
+// classifyRow treats rows with an empty value as deletes and all others as
+// inserts.
+func classifyRow(r row) Op {
+	if r.val == "" {
+		return Delete
+	}
+	return Insert
+}
+
+func TestToUpsertSink(t *testing.T) {
+	ctx := context.Background()
+	tb := &table{rows: map[int]string{1: "old"}}
+	in := Gen(ctx, row{1, "new"}, row{2, "two"}, row{3, ""}, row{2, ""})
+	if err := ToUpsertSink(ctx, in, classifyRow, tb, 2, Upsert[row]); err != nil {
+		t.Fatal(err)
+	}
+	// The insert of the existing row 1 became an update, and the delete of
+	// the missing row 3 was dropped.
+	if want := map[int]string{1: "new"}; !reflect.DeepEqual(tb.rows, want) {
+		t.Errorf("got table %v, want %v", tb.rows, want)
+	}
+	for _, b := range tb.batches {
+		if len(b) > 2 {
+			t.Errorf("applied a batch of %d changes, want at most 2", len(b))
+		}
+	}
+}
+
+func TestToUpsertSinkWithoutResolver(t *testing.T) {
+	ctx := context.Background()
+	tb := &table{rows: map[int]string{1: "old"}}
+	err := ToUpsertSink(ctx, Gen(ctx, row{1, "new"}), classifyRow, tb, 10, nil)
+	var conflict *ConflictError[row]
+	if !errors.As(err, &conflict) {
+		t.Fatalf("got %v, want a ConflictError", err)
+	}
+	if want := []Change[row]{{Insert, row{1, "new"}}}; !reflect.DeepEqual(conflict.Changes, want) {
+		t.Errorf("got conflicts %v, want %v", conflict.Changes, want)
+	}
+}
+
+func TestUpsert(t *testing.T) {
+	for _, tt := range []struct {
+		op   Op
+		want Op
+		ok   bool
+	}{
+		{Insert, Update, true},
+		{Update, Insert, true},
+		{Delete, Delete, false},
+	} {
+		c, ok := Upsert(Change[int]{tt.op, 1})
+		if ok != tt.ok || ok && c.Op != tt.want {
+			t.Errorf("Upsert of %v: got %v, %v, want %v, %v", tt.op, c.Op, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,521 @@
+package pipeline
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// WALStorage persists the records of a WAL. Implementations must be safe for
+// use by a single WAL at a time; the WAL serializes its calls.
+type WALStorage interface {
+	// Append durably appends a record.
+	Append(rec []byte) error
+	// Records calls fn with every record appended so far, in order.
+	Records(fn func(rec []byte) error) error
+	// Replace atomically replaces all records with recs.
+	Replace(recs [][]byte) error
+}
+
+// A WALEntry is an item admitted to a WAL, tagged with the sequence number
+// under which it was logged.
+type WALEntry[T any] struct {
+	Seq  uint64
+	Item T
+}
+
+// walRecord is the encoded form of a WAL record. Op is "admit", in which case
+// Item holds the item, "done", "prepare", in which case Txn and Seqs name a
+// transaction prepared by a TransactionalSink and the entries it holds,
+// "mark", which only records the highest sequence number issued so far, or
+// "version", the header that opens the log and states its format Version.
+type walRecord struct {
+	Op      string          `json:"op"`
+	Version int             `json:"version,omitempty"`
+	Seq     uint64          `json:"seq,omitempty"`
+	Item    json.RawMessage `json:"item,omitempty"`
+	Txn     string          `json:"txn,omitempty"`
+	Seqs    []uint64        `json:"seqs,omitempty"`
+}
+
+// A WAL is a write-ahead log of the items in flight in a pipeline. Every item
+// is recorded when it is admitted, at the source, and marked complete when the
+// sink has dealt with it, so that after a crash the log tells exactly which
+// items were in flight. Items are stored as JSON.
+type WAL[T any] struct {
+	store WALStorage
+
+	mu   sync.Mutex
+	next uint64
+	// key, keys and seqs track the idempotency keys of the items in flight
+	// once Recover has been called.
+	key  func(T) string
+	keys map[string]uint64
+	seqs map[uint64]string
+}
+
+// NewWAL returns a WAL kept in store. A log written in an older format is
+// migrated to the current one first. Sequence numbers continue from the
+// highest one already in store.
+func NewWAL[T any](store WALStorage) (*WAL[T], error) {
+	if err := upgradeWAL(store); err != nil {
+		return nil, err
+	}
+	w := &WAL[T]{store: store, next: 1}
+	err := store.Records(func(b []byte) error {
+		var rec walRecord
+		if err := json.Unmarshal(b, &rec); err != nil {
+			return err
+		}
+		if rec.Seq >= w.next {
+			w.next = rec.Seq + 1
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Admit records v as in flight and returns the entry to pass down the
+// pipeline.
+func (w *WAL[T]) Admit(v T) (WALEntry[T], error) {
+	item, err := json.Marshal(v)
+	if err != nil {
+		return WALEntry[T]{}, err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.append(walRecord{Op: "admit", Seq: w.next, Item: item}); err != nil {
+		return WALEntry[T]{}, err
+	}
+	w.next++
+	return WALEntry[T]{w.next - 1, v}, nil
+}
+
+// Complete records that the item logged under seq has been dealt with.
+func (w *WAL[T]) Complete(seq uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if k, ok := w.seqs[seq]; ok {
+		delete(w.seqs, seq)
+		delete(w.keys, k)
+	}
+	return w.append(walRecord{Op: "done", Seq: seq})
+}
+
+// track registers the idempotency key of the item logged under seq, unless
+// an item with the same key is already in flight, in which case it reports
+// false. w.mu must be held.
+func (w *WAL[T]) track(seq uint64, v T) bool {
+	if w.key == nil {
+		return true
+	}
+	k := w.key(v)
+	if _, ok := w.keys[k]; ok {
+		return false
+	}
+	w.keys[k] = seq
+	w.seqs[seq] = k
+	return true
+}
+
+func (w *WAL[T]) append(rec walRecord) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return w.store.Append(b)
+}
+
+// Pending returns the entries that have been admitted but not completed, in
+// the order they were admitted.
+func (w *WAL[T]) Pending() ([]WALEntry[T], error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	pending, _, err := w.scan()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]WALEntry[T], 0, len(pending))
+	for _, rec := range pending {
+		var v T
+		if err := json.Unmarshal(rec.Item, &v); err != nil {
+			return nil, err
+		}
+		entries = append(entries, WALEntry[T]{rec.Seq, v})
+	}
+	return entries, nil
+}
+
+// scan reads the log and returns the admit records that have no matching done
+// record, in the order they were admitted, and the prepare records of
+// transactions that still hold such entries. w.mu must be held.
+func (w *WAL[T]) scan() (admits, prepares []walRecord, err error) {
+	admitted := make(map[uint64]walRecord)
+	var prepared []walRecord
+	err = w.store.Records(func(b []byte) error {
+		var rec walRecord
+		if err := json.Unmarshal(b, &rec); err != nil {
+			return err
+		}
+		switch rec.Op {
+		case "admit":
+			admitted[rec.Seq] = rec
+		case "done":
+			delete(admitted, rec.Seq)
+		case "prepare":
+			prepared = append(prepared, rec)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	admits = make([]walRecord, 0, len(admitted))
+	for _, rec := range admitted {
+		admits = append(admits, rec)
+	}
+	sort.Slice(admits, func(i, j int) bool { return admits[i].Seq < admits[j].Seq })
+	for _, rec := range prepared {
+		for _, seq := range rec.Seqs {
+			if _, ok := admitted[seq]; ok {
+				prepares = append(prepares, rec)
+				break
+			}
+		}
+	}
+	return admits, prepares, nil
+}
+
+// Compact rewrites the log so that it only holds the entries still pending,
+// and the transactions they belong to, keeping it from growing without bound
+// over a long run.
+func (w *WAL[T]) Compact() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	admits, prepares, err := w.scan()
+	if err != nil {
+		return err
+	}
+	recs := make([][]byte, 0, len(admits)+len(prepares)+2)
+	keep := append([]walRecord{walHeader()}, admits...)
+	keep = append(keep, prepares...)
+	if w.next > 1 {
+		// Keep the highest sequence number on record, so that numbers are
+		// never reused after a restart, even if nothing is pending.
+		keep = append(keep, walRecord{Op: "mark", Seq: w.next - 1})
+	}
+	for _, rec := range keep {
+		b, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		recs = append(recs, b)
+	}
+	return w.store.Replace(recs)
+}
+
+// prepare records that the entries logged under seqs have been prepared by a
+// TransactionalSink as the transaction id. From then on the transaction is
+// bound to be committed, if need be by ResolveTransactions after a crash.
+func (w *WAL[T]) prepare(id string, seqs []uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.append(walRecord{Op: "prepare", Txn: id, Seqs: seqs})
+}
+
+// prepared returns the prepare records of the transactions that still hold
+// pending entries.
+func (w *WAL[T]) prepared() ([]walRecord, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, prepares, err := w.scan()
+	return prepares, err
+}
+
+// Recover turns w into a durable queue feeding a pipeline after a restart. It
+// first emits the entries left pending by the previous run, then admits and
+// emits the items received from in, exactly like Log. The sink must call
+// w.Complete for every entry, replayed or new, once it has dealt with it.
+// Pipelines writing to a TransactionalSink must call ResolveTransactions
+// before Recover, so that entries already prepared are not replayed.
+//
+// If key is not nil it returns the idempotency key of an item, and an item is
+// neither replayed nor admitted while another item with the same key is in
+// flight: a duplicate pending entry is marked complete instead of being
+// replayed, and a duplicate new item is dropped.
+func (w *WAL[T]) Recover(ctx context.Context, in <-chan T, key func(T) string) (<-chan WALEntry[T], <-chan error) {
+	out := make(chan WALEntry[T])
+	errc := make(chan error, 1)
+	go func() {
+		defer close(out)
+		pending, err := w.Pending()
+		if err != nil {
+			errc <- err
+			return
+		}
+		w.mu.Lock()
+		w.key = key
+		w.keys = make(map[string]uint64)
+		w.seqs = make(map[uint64]string)
+		w.mu.Unlock()
+
+		send := func(e WALEntry[T]) bool {
+			select {
+			case out <- e:
+				return true
+			case <-ctx.Done():
+				errc <- ctx.Err()
+				return false
+			}
+		}
+		for _, e := range pending {
+			w.mu.Lock()
+			ok := w.track(e.Seq, e.Item)
+			w.mu.Unlock()
+			if !ok {
+				if err := w.Complete(e.Seq); err != nil {
+					errc <- err
+					return
+				}
+				continue
+			}
+			if !send(e) {
+				return
+			}
+		}
+		for {
+			select {
+			case v, ok := <-in:
+				if !ok {
+					errc <- nil
+					return
+				}
+				e, ok, err := w.admitUnique(v)
+				if err != nil {
+					errc <- err
+					return
+				}
+				if ok && !send(e) {
+					return
+				}
+			case <-ctx.Done():
+				errc <- ctx.Err()
+				return
+			}
+		}
+	}()
+	return out, errc
+}
+
+// admitUnique is like Admit, but reports false without logging v if an item
+// with the same idempotency key is already in flight.
+func (w *WAL[T]) admitUnique(v T) (WALEntry[T], bool, error) {
+	item, err := json.Marshal(v)
+	if err != nil {
+		return WALEntry[T]{}, false, err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.track(w.next, v) {
+		return WALEntry[T]{}, false, nil
+	}
+	if err := w.append(walRecord{Op: "admit", Seq: w.next, Item: item}); err != nil {
+		k := w.seqs[w.next]
+		delete(w.seqs, w.next)
+		delete(w.keys, k)
+		return WALEntry[T]{}, false, err
+	}
+	w.next++
+	return WALEntry[T]{w.next - 1, v}, true, nil
+}
+
+// Log admits every item received from in to w and passes it on as an entry.
+// The sink must call w.Complete with the entry's sequence number once it has
+// dealt with the item. Log stops at the first error, which it sends on the
+// error channel once the output channel has been closed.
+func (w *WAL[T]) Log(ctx context.Context, in <-chan T) (<-chan WALEntry[T], <-chan error) {
+	out := make(chan WALEntry[T])
+	errc := make(chan error, 1)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case v, ok := <-in:
+				if !ok {
+					errc <- nil
+					return
+				}
+				e, err := w.Admit(v)
+				if err != nil {
+					errc <- err
+					return
+				}
+				select {
+				case out <- e:
+				case <-ctx.Done():
+					errc <- ctx.Err()
+					return
+				}
+			case <-ctx.Done():
+				errc <- ctx.Err()
+				return
+			}
+		}
+	}()
+	return out, errc
+}
+
+// MemoryWALStorage is a WALStorage that keeps records in memory. It is meant
+// for tests; it does not survive the process.
+type MemoryWALStorage struct {
+	mu   sync.Mutex
+	recs [][]byte
+}
+
+// Append implements WALStorage.
+func (s *MemoryWALStorage) Append(rec []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recs = append(s.recs, append([]byte(nil), rec...))
+	return nil
+}
+
+// Records implements WALStorage.
+func (s *MemoryWALStorage) Records(fn func(rec []byte) error) error {
+	s.mu.Lock()
+	recs := s.recs
+	s.mu.Unlock()
+	for _, rec := range recs {
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Replace implements WALStorage.
+func (s *MemoryWALStorage) Replace(recs [][]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recs = append([][]byte(nil), recs...)
+	return nil
+}
+
+// FileWALStorage is a WALStorage that keeps records in a file, one per line.
+// A record torn by a crash in the middle of a write is ignored when the file
+// is read back.
+type FileWALStorage struct {
+	path string
+	sync bool
+	f    *os.File
+}
+
+// OpenFileWALStorage opens, creating it if needed, the WAL file at path. If
+// sync is true every append is flushed to stable storage before it returns,
+// which makes the log survive power loss as well as process crashes at a
+// considerable cost in throughput.
+func OpenFileWALStorage(path string, sync bool) (*FileWALStorage, error) {
+	// Cut off a record torn by a crash, so that the next append starts on a
+	// line of its own.
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if n := bytes.LastIndexByte(data, '\n') + 1; n < len(data) {
+		if err := os.Truncate(path, int64(n)); err != nil {
+			return nil, err
+		}
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileWALStorage{path: path, sync: sync, f: f}, nil
+}
+
+// Append implements WALStorage.
+func (s *FileWALStorage) Append(rec []byte) error {
+	line := make([]byte, len(rec)+1)
+	copy(line, rec)
+	line[len(rec)] = '\n'
+	if _, err := s.f.Write(line); err != nil {
+		return err
+	}
+	if s.sync {
+		return s.f.Sync()
+	}
+	return nil
+}
+
+// Records implements WALStorage.
+func (s *FileWALStorage) Records(fn func(rec []byte) error) error {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	// Anything after the last newline is a write still in progress.
+	data = data[:bytes.LastIndexByte(data, '\n')+1]
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(nil, len(data)+1)
+	for sc.Scan() {
+		if err := fn(sc.Bytes()); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+// Replace implements WALStorage. It writes the new records to a temporary
+// file and renames it over the old one.
+func (s *FileWALStorage) Replace(recs [][]byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	w := bufio.NewWriter(tmp)
+	for _, rec := range recs {
+		w.Write(rec)
+		w.WriteByte('\n')
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	s.f.Close()
+	s.f = f
+	return nil
+}
+
+// Close closes the file.
+func (s *FileWALStorage) Close() error {
+	return s.f.Close()
+}
@@ -0,0 +1,189 @@
+package pipeline
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// seqs returns the sequence numbers of entries.
+func seqs[T any](entries []WALEntry[T]) []uint64 {
+	s := make([]uint64, len(entries))
+	for i, e := range entries {
+		s[i] = e.Seq
+	}
+	return s
+}
+
+func TestWAL(t *testing.T) {
+	store := &MemoryWALStorage{}
+	w, err := NewWAL[string](store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{"a", "b", "c"} {
+		if _, err := w.Admit(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Complete(2); err != nil {
+		t.Fatal(err)
+	}
+	pending, err := w.Pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []WALEntry[string]{{1, "a"}, {3, "c"}}; !reflect.DeepEqual(pending, want) {
+		t.Errorf("got pending %v, want %v", pending, want)
+	}
+
+	// Compacting keeps the pending entries, and sequence numbers carry on
+	// from the highest ever issued, even once nothing is pending.
+	w.Complete(1)
+	w.Complete(3)
+	if err := w.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := NewWAL[string](store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := reopened.Admit("d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Seq != 4 {
+		t.Errorf("got sequence number %d after compacting and reopening, want 4", e.Seq)
+	}
+	if pending, _ := reopened.Pending(); !reflect.DeepEqual(seqs(pending), []uint64{4}) {
+		t.Errorf("got pending %v, want only the new entry", pending)
+	}
+}
+
+func TestFileWALStorage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+	store, err := OpenFileWALStorage(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := NewWAL[int](store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 3; i++ {
+		w.Admit(i * 10)
+	}
+	w.Complete(1)
+	if err := w.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	w.Admit(40)
+	store.Close()
+
+	// A crash in the middle of an append leaves a torn record behind.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"op":"admit","seq":5,"it`)
+	f.Close()
+
+	store, err = OpenFileWALStorage(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	w, err = NewWAL[int](store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending, err := w.Pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []WALEntry[int]{{2, 20}, {3, 30}, {4, 40}}; !reflect.DeepEqual(pending, want) {
+		t.Errorf("got pending %v, want %v", pending, want)
+	}
+	// The torn record was cut off, so the next one starts on a line of its
+	// own.
+	if e, err := w.Admit(50); err != nil || e.Seq != 5 {
+		t.Fatalf("got %v, %v, want sequence number 5", e, err)
+	}
+	if pending, _ := w.Pending(); len(pending) != 4 {
+		t.Errorf("got %d pending entries after appending past a torn record, want 4", len(pending))
+	}
+}
+
+func TestWALLog(t *testing.T) {
+	ctx := context.Background()
+	w, err := NewWAL[string](&MemoryWALStorage{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, errc := w.Log(ctx, Gen(ctx, "a", "b"))
+	for e := range out {
+		// The entry is on record before it reaches the sink.
+		if pending, _ := w.Pending(); len(pending) == 0 || pending[0] != e {
+			t.Errorf("entry %v not pending when received", e)
+		}
+		if err := w.Complete(e.Seq); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if pending, _ := w.Pending(); len(pending) != 0 {
+		t.Errorf("got pending %v after completing every entry", pending)
+	}
+}
+
+func TestWALRecover(t *testing.T) {
+	ctx := context.Background()
+	store := &MemoryWALStorage{}
+	w, err := NewWAL[string](store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The previous run crashed with a, b and a second a in flight.
+	w.Admit("a")
+	w.Admit("b")
+	w.Admit("a")
+	w.Admit("c")
+	w.Complete(4)
+
+	w, err = NewWAL[string](store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := make(chan string)
+	out, errc := w.Recover(ctx, in, func(s string) string { return s })
+	// The duplicate pending a is not replayed.
+	if got := []WALEntry[string]{<-out, <-out}; !reflect.DeepEqual(got, []WALEntry[string]{{1, "a"}, {2, "b"}}) {
+		t.Errorf("replayed %v, want a and b", got)
+	}
+	// A new item is dropped while one with the same key is in flight, and
+	// admitted again once it has been completed.
+	in <- "b"
+	in <- "d"
+	if e := <-out; e != (WALEntry[string]{5, "d"}) {
+		t.Errorf("got %v, want d as entry 5", e)
+	}
+	w.Complete(2)
+	in <- "b"
+	if e := <-out; e != (WALEntry[string]{6, "b"}) {
+		t.Errorf("got %v, want b readmitted as entry 6", e)
+	}
+	close(in)
+	for range out {
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	pending, _ := w.Pending()
+	if want := []uint64{1, 5, 6}; !reflect.DeepEqual(seqs(pending), want) {
+		t.Errorf("got pending %v, want %v", seqs(pending), want)
+	}
+}
@@ -0,0 +1,71 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// walVersion is the version of the WAL format written by this package.
+// Version 1 logs predate the version header.
+const walVersion = 2
+
+// walMigrations[v] migrates a single record from version v of the WAL format
+// to version v+1. Every change to the format must bump walVersion and add a
+// migration, so that logs written by older releases can still be recovered.
+var walMigrations = map[int]func(rec []byte) ([]byte, error){
+	// Version 2 only introduced the version header; records are unchanged.
+	1: func(rec []byte) ([]byte, error) { return rec, nil },
+}
+
+func walHeader() walRecord {
+	return walRecord{Op: "version", Version: walVersion}
+}
+
+// upgradeWAL brings the log in store to the current format. An empty log gets
+// a version header; a log in an older format has every record migrated and is
+// rewritten in full. A log in a newer format than this package understands is
+// rejected.
+func upgradeWAL(store WALStorage) error {
+	version := 0
+	var recs [][]byte
+	err := store.Records(func(b []byte) error {
+		if version == 0 {
+			var rec walRecord
+			if err := json.Unmarshal(b, &rec); err != nil {
+				return err
+			}
+			version = 1
+			if rec.Op == "version" {
+				version = rec.Version
+				return nil
+			}
+		}
+		recs = append(recs, append([]byte(nil), b...))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	header, err := json.Marshal(walHeader())
+	if err != nil {
+		return err
+	}
+	switch {
+	case version == 0:
+		return store.Append(header)
+	case version == walVersion:
+		return nil
+	case version > walVersion:
+		return fmt.Errorf("pipeline: WAL format version %d is newer than supported version %d", version, walVersion)
+	}
+	for v := version; v < walVersion; v++ {
+		migrate := walMigrations[v]
+		for i, rec := range recs {
+			if recs[i], err = migrate(rec); err != nil {
+				return fmt.Errorf("pipeline: migrating WAL from version %d: %w", v, err)
+			}
+		}
+	}
+	return store.Replace(append([][]byte{header}, recs...))
+}
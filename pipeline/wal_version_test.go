@@ -0,0 +1,75 @@
+package pipeline
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// records returns the records in store as strings.
+func records(t *testing.T, store WALStorage) []string {
+	t.Helper()
+	var recs []string
+	if err := store.Records(func(rec []byte) error {
+		recs = append(recs, string(rec))
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return recs
+}
+
+func TestWALVersionHeader(t *testing.T) {
+	store := &MemoryWALStorage{}
+	if _, err := NewWAL[int](store); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := records(t, store), []string{`{"op":"version","version":2}`}; !reflect.DeepEqual(got, want) {
+		t.Errorf("new log holds %v, want %v", got, want)
+	}
+	// Opening a current log leaves it as it is.
+	if _, err := NewWAL[int](store); err != nil {
+		t.Fatal(err)
+	}
+	if got := records(t, store); len(got) != 1 {
+		t.Errorf("reopening rewrote the log to %v", got)
+	}
+}
+
+func TestWALUpgradesVersion1(t *testing.T) {
+	// Version 1 logs have no header.
+	store := &MemoryWALStorage{}
+	store.Append([]byte(`{"op":"admit","seq":1,"item":"a"}`))
+	store.Append([]byte(`{"op":"admit","seq":2,"item":"b"}`))
+	store.Append([]byte(`{"op":"done","seq":1}`))
+
+	w, err := NewWAL[string](store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending, err := w.Pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []WALEntry[string]{{2, "b"}}; !reflect.DeepEqual(pending, want) {
+		t.Errorf("got pending %v, want %v", pending, want)
+	}
+	recs := records(t, store)
+	if len(recs) != 4 || recs[0] != `{"op":"version","version":2}` {
+		t.Errorf("upgraded log holds %v, want a header and the three records", recs)
+	}
+}
+
+func TestWALRejectsNewerVersion(t *testing.T) {
+	store := &MemoryWALStorage{}
+	store.Append([]byte(`{"op":"version","version":3}`))
+	store.Append([]byte(`{"op":"admit","seq":1,"item":"a"}`))
+	_, err := NewWAL[string](store)
+	if err == nil || !strings.Contains(err.Error(), "version 3 is newer") {
+		t.Fatalf("got %v, want the newer version rejected", err)
+	}
+	// The log is left for a newer release to read.
+	if got := records(t, store); len(got) != 2 {
+		t.Errorf("rejected log was rewritten to %v", got)
+	}
+}
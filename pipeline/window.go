@@ -0,0 +1,209 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// A Window is the aggregate of the items received during a span of wall-clock
+// time, from Start inclusive to End exclusive.
+type Window[A any] struct {
+	Start, End time.Time
+	Value      A
+	// Count is the number of items aggregated.
+	Count int
+}
+
+// TumblingWindow aggregates the values received from in over consecutive,
+// non-overlapping windows of the given size, aligned to the wall clock: with
+// a size of a minute, windows start on the minute. Every value is folded
+// into the aggregate of its window with add, starting from initial(), and the
+// window is sent on once it has ended. Windows in which nothing was received
+// are skipped.
+//
+// When in is closed, the window in progress is sent on straight away, and so
+// is it when a Flusher attached to the context is flushed, with End set to
+// the time of the flush. When the context is cancelled, the window in
+// progress is handed, ending at that moment, to a receiver already waiting
+// for it, but not waited for: close in to be sure to get the last window.
+//
+// TumblingWindow panics if size is not positive.
+func TumblingWindow[T, A any](ctx context.Context, in <-chan T, size time.Duration, initial func() A, add func(A, T) A) <-chan Window[A] {
+	if size <= 0 {
+		panic(fmt.Sprintf("pipeline: TumblingWindow size must be positive, got %v", size))
+	}
+	out := make(chan Window[A])
+	flushes := watchFlushes(ctx)
+	go func() {
+		defer close(out)
+		now := time.Now()
+		w := Window[A]{Start: now.Truncate(size), Value: initial()}
+		w.End = w.Start.Add(size)
+		timer := time.NewTimer(w.End.Sub(now))
+		defer timer.Stop()
+
+		// emit sends w on, if it holds anything, and starts the window
+		// beginning at next.
+		emit := func(next time.Time) bool {
+			done := w
+			w = Window[A]{Start: next, End: next.Add(size), Value: initial()}
+			if done.Count == 0 {
+				return true
+			}
+			select {
+			case out <- done:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		for {
+			select {
+			case v, ok := <-in:
+				if !ok {
+					w.End = time.Now()
+					emit(w.End)
+					return
+				}
+				if now := time.Now(); !now.Before(w.End) {
+					// The timer has not fired yet; close the window
+					// here.
+					if !emit(now.Truncate(size)) {
+						return
+					}
+				}
+				w.Value = add(w.Value, v)
+				w.Count++
+			case <-timer.C:
+				now := time.Now()
+				// The window may have been closed on receiving a
+				// value already.
+				if !now.Before(w.End) && !emit(now.Truncate(size)) {
+					return
+				}
+				timer.Reset(w.End.Sub(now))
+			case <-flushes.requested():
+				flushes.done()
+				now := time.Now()
+				start := w.Start
+				w.End = now
+				if !emit(start) {
+					return
+				}
+				// The window goes on, counting afresh from now.
+				w.Start = now
+			case <-ctx.Done():
+				if w.Count > 0 {
+					w.End = time.Now()
+					select {
+					case out <- w:
+					default:
+					}
+				}
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// SlidingWindow aggregates the values received from in over overlapping
+// windows of the given size, one ending every slide, aligned to the wall
+// clock: with a size of a minute and a slide of ten seconds, every ten
+// seconds the values received in the past minute are aggregated. Every window
+// is aggregated afresh, folding its values into initial() with add, so the
+// values of the last size are kept in memory. Windows in which nothing was
+// received are skipped.
+//
+// When in is closed, a final window ending at that moment is sent on, and so
+// is one when a Flusher attached to the context is flushed. When the context
+// is cancelled, a final window is handed to a receiver already waiting for it,
+// but not waited for.
+//
+// SlidingWindow panics if size or slide is not positive.
+func SlidingWindow[T, A any](ctx context.Context, in <-chan T, size, slide time.Duration, initial func() A, add func(A, T) A) <-chan Window[A] {
+	if size <= 0 || slide <= 0 {
+		panic(fmt.Sprintf("pipeline: SlidingWindow size and slide must be positive, got %v and %v", size, slide))
+	}
+	type stamped struct {
+		at time.Time
+		v  T
+	}
+	out := make(chan Window[A])
+	flushes := watchFlushes(ctx)
+	go func() {
+		defer close(out)
+		var items []stamped
+		now := time.Now()
+		end := now.Truncate(slide).Add(slide)
+		timer := time.NewTimer(end.Sub(now))
+		defer timer.Stop()
+
+		// window aggregates the window ending at end.
+		window := func(end time.Time) Window[A] {
+			start := end.Add(-size)
+			// Forget the values that have slid out of every window
+			// still to come.
+			i := 0
+			for i < len(items) && items[i].at.Before(start) {
+				i++
+			}
+			items = append(items[:0], items[i:]...)
+			w := Window[A]{Start: start, End: end, Value: initial()}
+			for _, it := range items {
+				if !it.at.Before(end) {
+					break
+				}
+				w.Value = add(w.Value, it.v)
+				w.Count++
+			}
+			return w
+		}
+		// emit sends the window ending at end.
+		emit := func(end time.Time) bool {
+			w := window(end)
+			if w.Count == 0 {
+				return true
+			}
+			select {
+			case out <- w:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		for {
+			select {
+			case v, ok := <-in:
+				if !ok {
+					emit(time.Now())
+					return
+				}
+				items = append(items, stamped{time.Now(), v})
+			case <-timer.C:
+				if !emit(end) {
+					return
+				}
+				now := time.Now()
+				end = now.Truncate(slide).Add(slide)
+				timer.Reset(end.Sub(now))
+			case <-flushes.requested():
+				flushes.done()
+				// Include the value received this very instant.
+				if !emit(time.Now().Add(1)) {
+					return
+				}
+			case <-ctx.Done():
+				if w := window(time.Now().Add(1)); w.Count > 0 {
+					select {
+					case out <- w:
+					default:
+					}
+				}
+				return
+			}
+		}
+	}()
+	return out
+}
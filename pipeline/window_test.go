@@ -0,0 +1,148 @@
+package pipeline
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// mustPanic fails the test unless fn panics.
+func mustPanic(t *testing.T, name string, fn func()) {
+	t.Helper()
+	defer func() {
+		if recover() == nil {
+			t.Errorf("%s did not panic", name)
+		}
+	}()
+	fn()
+}
+
+func sum(a, v int) int { return a + v }
+
+func zero() int { return 0 }
+
+func TestTumblingWindow(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	f := NewFlusher()
+	in := make(chan int)
+	out := TumblingWindow(WithFlusher(ctx, f), in, time.Hour, zero, sum)
+
+	for _, v := range []int{1, 2, 3} {
+		in <- v
+	}
+	f.Flush()
+	first := <-out
+	if first.Count != 3 || first.Value != 6 {
+		t.Errorf("flushed window: got %d items summing to %d, want 3 summing to 6", first.Count, first.Value)
+	}
+	if first.Start != first.Start.Truncate(time.Hour) || !first.End.After(first.Start) {
+		t.Errorf("flushed window spans %v to %v, want it to start on the hour", first.Start, first.End)
+	}
+
+	in <- 4
+	close(in)
+	last, ok := <-out
+	if !ok {
+		t.Fatal("no window sent on closing the input")
+	}
+	if last.Count != 1 || last.Value != 4 {
+		t.Errorf("last window: got %d items summing to %d, want 1 summing to 4", last.Count, last.Value)
+	}
+	if !last.Start.Equal(first.End) {
+		t.Errorf("last window starts at %v, want the end of the flushed one, %v", last.Start, first.End)
+	}
+	if _, ok := <-out; ok {
+		t.Error("output not closed after the input")
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	f := NewFlusher()
+	in := make(chan int)
+	out := SlidingWindow(WithFlusher(ctx, f), in, time.Hour, time.Hour, zero, sum)
+
+	in <- 1
+	in <- 2
+	f.Flush()
+	if w := <-out; w.Count != 2 || w.Value != 3 {
+		t.Errorf("flushed window: got %d items summing to %d, want 2 summing to 3", w.Count, w.Value)
+	}
+	// The values stay in the windows still to come.
+	in <- 3
+	close(in)
+	if w := <-out; w.Count != 3 || w.Value != 6 || w.End.Sub(w.Start) != time.Hour {
+		t.Errorf("last window: got %d items summing to %d over %v, want 3 summing to 6 over an hour", w.Count, w.Value, w.End.Sub(w.Start))
+	}
+}
+
+func TestSlidingWindowSlides(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	in := make(chan int)
+	out := SlidingWindow(ctx, in, 20*time.Millisecond, 10*time.Millisecond, zero, sum)
+	in <- 1
+	// The value is in the two windows ending after it, or in one if the
+	// timer fired late, and in no other.
+	n := 0
+	for {
+		select {
+		case w := <-out:
+			if w.Count != 1 {
+				t.Fatalf("got window of %d items, want 1", w.Count)
+			}
+			n++
+			continue
+		case <-time.After(100 * time.Millisecond):
+		}
+		break
+	}
+	if n < 1 || n > 2 {
+		t.Errorf("value seen in %d windows, want 1 or 2", n)
+	}
+}
+
+func TestWindowsOnCancel(t *testing.T) {
+	for name, window := range map[string]func(context.Context, <-chan int) <-chan Window[int]{
+		"TumblingWindow": func(ctx context.Context, in <-chan int) <-chan Window[int] {
+			return TumblingWindow(ctx, in, time.Hour, zero, sum)
+		},
+		"SlidingWindow": func(ctx context.Context, in <-chan int) <-chan Window[int] {
+			return SlidingWindow(ctx, in, time.Hour, time.Hour, zero, sum)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			in := make(chan int)
+			out := window(ctx, in)
+			got := make(chan []Window[int])
+			go func() {
+				var ws []Window[int]
+				for w := range out {
+					ws = append(ws, w)
+				}
+				got <- ws
+			}()
+			in <- 1
+			in <- 2
+			// Let the receiver wait for a window.
+			time.Sleep(10 * time.Millisecond)
+			cancel()
+			ws := <-got
+			if len(ws) != 1 || ws[0].Count != 2 || ws[0].Value != 3 {
+				t.Errorf("got windows %+v, want the partial one with 1 and 2", ws)
+			}
+		})
+	}
+}
+
+func TestWindowRejectsNonPositiveDurations(t *testing.T) {
+	ctx := context.Background()
+	in := make(chan int)
+	mustPanic(t, "TumblingWindow of size 0", func() { TumblingWindow(ctx, in, 0, zero, sum) })
+	mustPanic(t, "SlidingWindow of size 0", func() { SlidingWindow(ctx, in, 0, time.Second, zero, sum) })
+	mustPanic(t, "SlidingWindow with a negative slide", func() { SlidingWindow(ctx, in, time.Second, -time.Second, zero, sum) })
+}
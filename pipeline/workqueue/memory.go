@@ -0,0 +1,119 @@
+package workqueue
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Memory is a Queue held in memory. It is shared only by the goroutines of a
+// single process, which makes it useful for tests and for trying out a
+// pipeline before moving it to a shared backend.
+type Memory struct {
+	mu    sync.Mutex
+	next  uint64
+	items []*memoryItem
+}
+
+type memoryItem struct {
+	id         string
+	body       []byte
+	visible    time.Time
+	deliveries int
+}
+
+// NewMemory returns an empty Memory queue.
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+// Push implements Queue.
+func (q *Memory) Push(ctx context.Context, body []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.next++
+	q.items = append(q.items, &memoryItem{
+		id:   strconv.FormatUint(q.next, 10),
+		body: append([]byte(nil), body...),
+	})
+	return nil
+}
+
+// Lease implements Queue.
+func (q *Memory) Lease(ctx context.Context, max int, visibility time.Duration) ([]Message, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	var leased []Message
+	for _, it := range q.items {
+		if len(leased) == max {
+			break
+		}
+		if it.visible.After(now) {
+			continue
+		}
+		it.visible = now.Add(visibility)
+		it.deliveries++
+		leased = append(leased, Message{ID: it.id, Body: it.body, Deliveries: it.deliveries})
+	}
+	return leased, nil
+}
+
+// Ack implements Queue.
+func (q *Memory) Ack(ctx context.Context, m Message) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	i, err := q.find(m)
+	if err != nil {
+		return err
+	}
+	q.items = append(q.items[:i], q.items[i+1:]...)
+	return nil
+}
+
+// Extend implements Queue.
+func (q *Memory) Extend(ctx context.Context, m Message, visibility time.Duration) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	i, err := q.find(m)
+	if err != nil {
+		return err
+	}
+	q.items[i].visible = time.Now().Add(visibility)
+	return nil
+}
+
+// Release implements Queue.
+func (q *Memory) Release(ctx context.Context, m Message) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	i, err := q.find(m)
+	if err != nil {
+		return err
+	}
+	q.items[i].visible = time.Time{}
+	return nil
+}
+
+// Len returns the number of items in the queue, leased or not.
+func (q *Memory) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+// find returns the index of the item m was leased as, provided the lease is
+// still held.
+func (q *Memory) find(m Message) (int, error) {
+	for i, it := range q.items {
+		if it.id != m.ID {
+			continue
+		}
+		if it.deliveries != m.Deliveries || !it.visible.After(time.Now()) {
+			return 0, ErrLeaseLost
+		}
+		return i, nil
+	}
+	return 0, ErrLeaseLost
+}
@@ -0,0 +1,147 @@
+package workqueue
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// SQL is a Queue kept in a database/sql table, shared by every process that
+// can reach the database. Leases are taken with conditional updates rather
+// than row locks or transactions, so it works with any database: a worker
+// that loses the race for a row simply skips it.
+type SQL struct {
+	// DB is the database holding the queue.
+	DB *sql.DB
+	// Table is the name of the queue table. Empty means "pipeline_queue".
+	Table string
+	// Rebind, if not nil, rewrites the "?" placeholders of every statement
+	// for drivers with other placeholder styles, such as PostgreSQL's "$1".
+	Rebind func(query string) string
+}
+
+// NewSQL returns an SQL queue kept in the default table of db.
+func NewSQL(db *sql.DB) *SQL {
+	return &SQL{DB: db}
+}
+
+// CreateTable creates the queue table, unless it already exists. The column
+// types suit SQLite and MySQL; other databases may need the table created by
+// hand, with a binary type for body and 64-bit integers for the rest.
+func (q *SQL) CreateTable(ctx context.Context) error {
+	_, err := q.DB.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+q.table()+` (
+	id VARCHAR(32) PRIMARY KEY,
+	body BLOB NOT NULL,
+	enqueued BIGINT NOT NULL,
+	visible BIGINT NOT NULL,
+	deliveries INTEGER NOT NULL
+)`)
+	return err
+}
+
+func (q *SQL) table() string {
+	if q.Table == "" {
+		return "pipeline_queue"
+	}
+	return q.Table
+}
+
+// stmt returns query with the queue table substituted for "%t" and its
+// placeholders rebound.
+func (q *SQL) stmt(query string) string {
+	query = strings.ReplaceAll(query, "%t", q.table())
+	if q.Rebind != nil {
+		query = q.Rebind(query)
+	}
+	return query
+}
+
+// Push implements Queue.
+func (q *SQL) Push(ctx context.Context, body []byte) error {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return err
+	}
+	_, err := q.DB.ExecContext(ctx, q.stmt("INSERT INTO %t (id, body, enqueued, visible, deliveries) VALUES (?, ?, ?, 0, 0)"),
+		hex.EncodeToString(id[:]), body, time.Now().UnixNano())
+	return err
+}
+
+// Lease implements Queue.
+func (q *SQL) Lease(ctx context.Context, max int, visibility time.Duration) ([]Message, error) {
+	now := time.Now()
+	rows, err := q.DB.QueryContext(ctx, q.stmt("SELECT id, body, deliveries FROM %t WHERE visible <= ? ORDER BY enqueued LIMIT ?"),
+		now.UnixNano(), max)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []Message
+	for rows.Next() {
+		var m Message
+		if err := rows.Scan(&m.ID, &m.Body, &m.Deliveries); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		candidates = append(candidates, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var leased []Message
+	for _, m := range candidates {
+		res, err := q.DB.ExecContext(ctx, q.stmt("UPDATE %t SET visible = ?, deliveries = deliveries + 1 WHERE id = ? AND deliveries = ? AND visible <= ?"),
+			now.Add(visibility).UnixNano(), m.ID, m.Deliveries, now.UnixNano())
+		if err != nil {
+			return nil, err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return nil, err
+		} else if n == 0 {
+			// Leased by another worker since the select.
+			continue
+		}
+		m.Deliveries++
+		leased = append(leased, m)
+	}
+	return leased, nil
+}
+
+// Ack implements Queue.
+func (q *SQL) Ack(ctx context.Context, m Message) error {
+	return q.held(q.DB.ExecContext(ctx, q.stmt("DELETE FROM %t WHERE id = ? AND deliveries = ? AND visible > ?"),
+		m.ID, m.Deliveries, time.Now().UnixNano()))
+}
+
+// Extend implements Queue.
+func (q *SQL) Extend(ctx context.Context, m Message, visibility time.Duration) error {
+	now := time.Now()
+	return q.held(q.DB.ExecContext(ctx, q.stmt("UPDATE %t SET visible = ? WHERE id = ? AND deliveries = ? AND visible > ?"),
+		now.Add(visibility).UnixNano(), m.ID, m.Deliveries, now.UnixNano()))
+}
+
+// Release implements Queue.
+func (q *SQL) Release(ctx context.Context, m Message) error {
+	return q.held(q.DB.ExecContext(ctx, q.stmt("UPDATE %t SET visible = 0 WHERE id = ? AND deliveries = ? AND visible > ?"),
+		m.ID, m.Deliveries, time.Now().UnixNano()))
+}
+
+// held turns the result of a statement conditional on a lease into
+// ErrLeaseLost if it matched no row.
+func (q *SQL) held(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
@@ -0,0 +1,338 @@
+package workqueue
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"concurrency/pipeline"
+)
+
+// fakeDB is an in-memory database understanding just the statements SQL
+// issues, for a queue table of any name. It accepts "$1" placeholders as
+// well as "?" and records every statement it is given.
+type fakeDB struct {
+	mu      sync.Mutex
+	tables  []string
+	rows    map[string]*fakeRow
+	queries []string
+	// selected, if not nil, is called after every select of candidates
+	// for a lease, before they are leased.
+	selected func()
+}
+
+type fakeRow struct {
+	body       []byte
+	enqueued   int64
+	visible    int64
+	deliveries int64
+}
+
+func (db *fakeDB) Open(string) (driver.Conn, error)             { return &fakeConn{db: db}, nil }
+func (db *fakeDB) Connect(context.Context) (driver.Conn, error) { return &fakeConn{db: db}, nil }
+func (db *fakeDB) Driver() driver.Driver                        { return db }
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c *fakeConn) Close() error                        { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+var (
+	numbered   = regexp.MustCompile(`\$\d+`)
+	createStmt = regexp.MustCompile(`^CREATE TABLE IF NOT EXISTS (\w+) \(`)
+	tableName  = regexp.MustCompile(`(?:INTO|FROM|UPDATE) (\w+) `)
+)
+
+// statement records query and returns it with its table name replaced by
+// "%t" and its placeholders by "?", to match the statements in sql.go.
+func (db *fakeDB) statement(query string) string {
+	db.queries = append(db.queries, query)
+	query = numbered.ReplaceAllString(query, "?")
+	if m := tableName.FindStringSubmatch(query); m != nil {
+		query = strings.Replace(query, " "+m[1]+" ", " %t ", 1)
+	}
+	return query
+}
+
+func (c *fakeConn) ExecContext(_ context.Context, query string, named []driver.NamedValue) (driver.Result, error) {
+	db := c.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if m := createStmt.FindStringSubmatch(query); m != nil {
+		db.queries = append(db.queries, query)
+		db.tables = append(db.tables, m[1])
+		return driver.RowsAffected(0), nil
+	}
+	args := make([]any, len(named))
+	for i, a := range named {
+		args[i] = a.Value
+	}
+	// held reports whether r is leased with the given deliveries at now.
+	held := func(r *fakeRow, deliveries, now any) bool {
+		return r != nil && r.deliveries == deliveries.(int64) && r.visible > now.(int64)
+	}
+	var n int64
+	switch stmt := db.statement(query); stmt {
+	case "INSERT INTO %t (id, body, enqueued, visible, deliveries) VALUES (?, ?, ?, 0, 0)":
+		if db.rows == nil {
+			db.rows = make(map[string]*fakeRow)
+		}
+		db.rows[args[0].(string)] = &fakeRow{body: args[1].([]byte), enqueued: args[2].(int64)}
+		n = 1
+	case "UPDATE %t SET visible = ?, deliveries = deliveries + 1 WHERE id = ? AND deliveries = ? AND visible <= ?":
+		if r := db.rows[args[1].(string)]; r != nil && r.deliveries == args[2].(int64) && r.visible <= args[3].(int64) {
+			r.visible = args[0].(int64)
+			r.deliveries++
+			n = 1
+		}
+	case "DELETE FROM %t WHERE id = ? AND deliveries = ? AND visible > ?":
+		if held(db.rows[args[0].(string)], args[1], args[2]) {
+			delete(db.rows, args[0].(string))
+			n = 1
+		}
+	case "UPDATE %t SET visible = ? WHERE id = ? AND deliveries = ? AND visible > ?":
+		if r := db.rows[args[1].(string)]; held(r, args[2], args[3]) {
+			r.visible = args[0].(int64)
+			n = 1
+		}
+	case "UPDATE %t SET visible = 0 WHERE id = ? AND deliveries = ? AND visible > ?":
+		if r := db.rows[args[0].(string)]; held(r, args[1], args[2]) {
+			r.visible = 0
+			n = 1
+		}
+	default:
+		return nil, errors.New("unexpected statement " + query)
+	}
+	return driver.RowsAffected(n), nil
+}
+
+func (c *fakeConn) QueryContext(_ context.Context, query string, named []driver.NamedValue) (driver.Rows, error) {
+	db := c.db
+	db.mu.Lock()
+	if stmt := db.statement(query); stmt != "SELECT id, body, deliveries FROM %t WHERE visible <= ? ORDER BY enqueued LIMIT ?" {
+		db.mu.Unlock()
+		return nil, errors.New("unexpected query " + query)
+	}
+	now, limit := named[0].Value.(int64), named[1].Value.(int64)
+	rows := &leaseRows{}
+	for id, r := range db.rows {
+		if r.visible <= now {
+			rows.rows = append(rows.rows, []driver.Value{id, r.body, r.deliveries, r.enqueued})
+		}
+	}
+	sort.Slice(rows.rows, func(i, j int) bool { return rows.rows[i][3].(int64) < rows.rows[j][3].(int64) })
+	if int64(len(rows.rows)) > limit {
+		rows.rows = rows.rows[:limit]
+	}
+	selected := db.selected
+	db.mu.Unlock()
+	if selected != nil {
+		selected()
+	}
+	return rows, nil
+}
+
+type leaseRows struct {
+	rows [][]driver.Value
+}
+
+func (r *leaseRows) Columns() []string { return []string{"id", "body", "deliveries"} }
+func (r *leaseRows) Close() error      { return nil }
+func (r *leaseRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0][:3])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+// newSQL returns an SQL queue on a new fakeDB, holding the given items.
+func newSQL(t *testing.T, items ...string) (*SQL, *fakeDB) {
+	t.Helper()
+	fake := &fakeDB{}
+	db := sql.OpenDB(fake)
+	t.Cleanup(func() { db.Close() })
+	q := NewSQL(db)
+	for _, item := range items {
+		if err := q.Push(context.Background(), []byte(item)); err != nil {
+			t.Fatal(err)
+		}
+		// Keep the items in order on coarse clocks.
+		time.Sleep(time.Microsecond)
+	}
+	return q, fake
+}
+
+func TestSQLLeases(t *testing.T) {
+	ctx := context.Background()
+	q, _ := newSQL(t, "a", "b")
+
+	leased, err := q.Lease(ctx, 1, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leased) != 1 || string(leased[0].Body) != "a" || leased[0].Deliveries != 1 {
+		t.Fatalf("leased %v, want a on its first delivery", leased)
+	}
+	// A leased item is invisible to other workers.
+	if others, _ := q.Lease(ctx, 2, time.Hour); len(others) != 1 || string(others[0].Body) != "b" {
+		t.Fatalf("leased %v, want only b", others)
+	}
+	if err := q.Extend(ctx, leased[0], time.Hour); err != nil {
+		t.Errorf("extending a held lease: %v", err)
+	}
+	if err := q.Release(ctx, leased[0]); err != nil {
+		t.Fatal(err)
+	}
+	// The released lease is gone.
+	for name, err := range map[string]error{
+		"acknowledging": q.Ack(ctx, leased[0]),
+		"extending":     q.Extend(ctx, leased[0], time.Hour),
+		"releasing":     q.Release(ctx, leased[0]),
+	} {
+		if !errors.Is(err, ErrLeaseLost) {
+			t.Errorf("%s a released lease: got %v, want ErrLeaseLost", name, err)
+		}
+	}
+	again, _ := q.Lease(ctx, 2, time.Hour)
+	if len(again) != 1 || string(again[0].Body) != "a" || again[0].Deliveries != 2 {
+		t.Fatalf("leased %v, want a again on its second delivery", again)
+	}
+	// The earlier lease on the same item does not count for the new one.
+	if err := q.Ack(ctx, leased[0]); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("acknowledging a superseded lease: got %v, want ErrLeaseLost", err)
+	}
+	if err := q.Ack(ctx, again[0]); err != nil {
+		t.Errorf("acknowledging a held lease: %v", err)
+	}
+	if err := q.Ack(ctx, again[0]); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("acknowledging twice: got %v, want ErrLeaseLost", err)
+	}
+}
+
+func TestSQLLeaseExpires(t *testing.T) {
+	ctx := context.Background()
+	q, _ := newSQL(t, "a")
+	leased, _ := q.Lease(ctx, 1, 10*time.Millisecond)
+	if len(leased) != 1 {
+		t.Fatalf("leased %v, want a", leased)
+	}
+	time.Sleep(20 * time.Millisecond)
+	for name, err := range map[string]error{
+		"acknowledging": q.Ack(ctx, leased[0]),
+		"extending":     q.Extend(ctx, leased[0], time.Hour),
+		"releasing":     q.Release(ctx, leased[0]),
+	} {
+		if !errors.Is(err, ErrLeaseLost) {
+			t.Errorf("%s an expired lease: got %v, want ErrLeaseLost", name, err)
+		}
+	}
+	if again, _ := q.Lease(ctx, 1, time.Hour); len(again) != 1 || again[0].Deliveries != 2 {
+		t.Errorf("leased %v, want a again once its lease expired", again)
+	}
+}
+
+func TestSQLCompetingLeases(t *testing.T) {
+	ctx := context.Background()
+	q, fake := newSQL(t, "a")
+	var other []Message
+	// Let another worker lease the item between the select and the
+	// update of the first one.
+	fake.selected = func() {
+		fake.mu.Lock()
+		fake.selected = nil
+		fake.mu.Unlock()
+		var err error
+		if other, err = q.Lease(ctx, 1, time.Hour); err != nil {
+			t.Error(err)
+		}
+	}
+	leased, err := q.Lease(ctx, 1, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leased) != 0 || len(other) != 1 || other[0].Deliveries != 1 {
+		t.Errorf("leased %v and %v, want the item leased once", leased, other)
+	}
+}
+
+func TestSQLTableAndRebind(t *testing.T) {
+	ctx := context.Background()
+	q, fake := newSQL(t)
+	q.Table = "jobs"
+	q.Rebind = func(query string) string {
+		n := 0
+		return regexp.MustCompile(`\?`).ReplaceAllStringFunc(query, func(string) string {
+			n++
+			return fmt.Sprintf("$%d", n)
+		})
+	}
+	if err := q.CreateTable(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.tables) != 1 || fake.tables[0] != "jobs" {
+		t.Errorf("created tables %v, want jobs", fake.tables)
+	}
+	fake.queries = nil
+	if err := q.Push(ctx, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	leased, err := q.Lease(ctx, 1, time.Hour)
+	if err != nil || len(leased) != 1 {
+		t.Fatalf("leased %v, %v, want a", leased, err)
+	}
+	if err := q.Ack(ctx, leased[0]); err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range fake.queries {
+		if !strings.Contains(query, " jobs ") || strings.Contains(query, "?") || !strings.Contains(query, "$1") {
+			t.Errorf("got statement %q, want it on jobs with numbered placeholders", query)
+		}
+	}
+
+	NewSQL(q.DB).CreateTable(ctx)
+	if len(fake.tables) != 2 || fake.tables[1] != "pipeline_queue" {
+		t.Errorf("created tables %v, want pipeline_queue by default", fake.tables)
+	}
+}
+
+func TestWorkSQL(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	q, fake := newSQL(t)
+	if err := Enqueue(ctx, q, pipeline.Gen(ctx, 1, 2, 3)); err != nil {
+		t.Fatal(err)
+	}
+	var (
+		mu  sync.Mutex
+		sum int
+	)
+	err := Work(ctx, q, func(ctx context.Context, v int) error {
+		mu.Lock()
+		defer mu.Unlock()
+		sum += v
+		if sum == 6 {
+			cancel()
+		}
+		return nil
+	}, Options{Workers: 2, Poll: time.Millisecond})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+	if sum != 6 || len(fake.rows) != 0 {
+		t.Errorf("got sum %d with %d rows left, want every item processed once", sum, len(fake.rows))
+	}
+}
@@ -0,0 +1,322 @@
+// Package workqueue lets several processes share the work of a pipeline by
+// pulling items from a common queue. Items are leased rather than removed: a
+// leased item is invisible to other workers until its visibility timeout
+// runs out, and is removed only once it has been acknowledged. An item whose
+// worker dies is therefore delivered again, to some other worker, once its
+// lease expires.
+package workqueue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"concurrency/pipeline"
+)
+
+// ErrLeaseLost is returned by Queue.Ack, Queue.Extend and Queue.Release when
+// the lease on a message has expired and the message may have been delivered
+// to another worker.
+var ErrLeaseLost = errors.New("workqueue: lease lost")
+
+// A Message is an item leased from a Queue.
+type Message struct {
+	// ID identifies the item in the queue.
+	ID string
+	// Body is the encoded item.
+	Body []byte
+	// Deliveries is the number of times the item has been leased,
+	// including this one.
+	Deliveries int
+}
+
+// A Queue is a backend shared by the processes pulling work from it.
+//
+// Lease must hand out every message to at most one caller at a time: a
+// message leased by one caller must not be leased by another until
+// visibility has passed without the lease being extended. Ack, Extend and
+// Release apply to the lease a message was received with, and fail with
+// ErrLeaseLost if that lease is no longer held.
+type Queue interface {
+	// Push adds an item to the queue.
+	Push(ctx context.Context, body []byte) error
+	// Lease returns up to max messages that are not leased, making them
+	// invisible for visibility. It returns no messages, rather than
+	// waiting, if the queue is empty.
+	Lease(ctx context.Context, max int, visibility time.Duration) ([]Message, error)
+	// Ack removes a leased message from the queue.
+	Ack(ctx context.Context, m Message) error
+	// Extend keeps a leased message invisible for visibility from now.
+	Extend(ctx context.Context, m Message, visibility time.Duration) error
+	// Release ends the lease on a message, making it visible again.
+	Release(ctx context.Context, m Message) error
+}
+
+// Options configure Work.
+type Options struct {
+	// Workers is the number of items processed concurrently. Zero means 1.
+	Workers int
+	// Visibility is how long a lease lasts. Leases are extended while
+	// their item is being processed, so it only bounds the time it takes
+	// to notice a dead worker. Zero means 30 seconds.
+	Visibility time.Duration
+	// Poll is how long to wait before asking an empty queue again. Zero
+	// means 1 second.
+	Poll time.Duration
+	// MaxDeliveries is the number of times an item is tried before it is
+	// given up on. Zero means 5.
+	MaxDeliveries int
+	// OnDead, if not nil, is called with every message that is given up
+	// on, because it could not be decoded or because processing it failed
+	// MaxDeliveries times, and the last error. The message is then removed
+	// from the queue.
+	OnDead func(m Message, err error)
+}
+
+func (o Options) withDefaults() Options {
+	if o.Workers <= 0 {
+		o.Workers = 1
+	}
+	if o.Visibility <= 0 {
+		o.Visibility = 30 * time.Second
+	}
+	if o.Poll <= 0 {
+		o.Poll = time.Second
+	}
+	if o.MaxDeliveries <= 0 {
+		o.MaxDeliveries = 5
+	}
+	return o
+}
+
+// Enqueue pushes every item received from in to q, encoded as JSON. It
+// returns once in is closed, or with the first error.
+func Enqueue[T any](ctx context.Context, q Queue, in <-chan T) error {
+	for {
+		select {
+		case v, ok := <-in:
+			if !ok {
+				return nil
+			}
+			body, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			if err := q.Push(ctx, body); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Work pulls items pushed by Enqueue from q and processes them with fn, until
+// the context is cancelled or q fails. An item is acknowledged once fn
+// returns nil for it. When fn fails the item is released, to be tried again
+// by whichever worker leases it next, until it has failed
+// opt.MaxDeliveries times.
+//
+// Items are leased only when a worker is free to process them, so any number
+// of processes can run Work on the same queue and share its items between
+// them. Delivery is at least once: fn may see an item again if its lease was
+// lost, so it should be idempotent.
+func Work[T any](ctx context.Context, q Queue, fn func(context.Context, T) error, opt Options) error {
+	opt = opt.withDefaults()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg      sync.WaitGroup
+		errOnce sync.Once
+		werr    error
+	)
+	fail := func(err error) {
+		errOnce.Do(func() {
+			werr = err
+			cancel()
+		})
+	}
+	// idle holds a token for every worker that is not processing an item,
+	// so that leases are only taken for items that are processed right
+	// away rather than expiring in a buffer.
+	idle := make(chan struct{}, opt.Workers)
+	msgs := make(chan Message)
+	for i := 0; i < opt.Workers; i++ {
+		idle <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for m := range msgs {
+				if err := process(ctx, q, m, fn, opt); err != nil {
+					fail(err)
+				}
+				idle <- struct{}{}
+			}
+		}()
+	}
+	if err := poll(ctx, q, idle, msgs, opt); err != nil && ctx.Err() == nil {
+		fail(err)
+	}
+	close(msgs)
+	wg.Wait()
+	if werr != nil {
+		return werr
+	}
+	return ctx.Err()
+}
+
+// poll leases as many messages as there are idle workers and hands them to
+// the workers on msgs, until the context is cancelled or q fails.
+func poll(ctx context.Context, q Queue, idle chan struct{}, msgs chan<- Message, opt Options) error {
+	for {
+		select {
+		case <-idle:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		n := 1
+	count:
+		for n < opt.Workers {
+			select {
+			case <-idle:
+				n++
+			default:
+				break count
+			}
+		}
+		batch, err := q.Lease(ctx, n, opt.Visibility)
+		if err != nil {
+			return err
+		}
+		for i := len(batch); i < n; i++ {
+			idle <- struct{}{}
+		}
+		if len(batch) == 0 {
+			t := time.NewTimer(opt.Poll)
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+				return ctx.Err()
+			}
+			continue
+		}
+		for i, m := range batch {
+			select {
+			case msgs <- m:
+			case <-ctx.Done():
+				// Hand back the leases that no worker will take.
+				release := context.WithoutCancel(ctx)
+				for _, m := range batch[i:] {
+					q.Release(release, m)
+				}
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// process handles a single leased message, keeping its lease alive while fn
+// runs. It only returns an error if q fails.
+func process[T any](ctx context.Context, q Queue, m Message, fn func(context.Context, T) error, opt Options) error {
+	var v T
+	if err := json.Unmarshal(m.Body, &v); err != nil {
+		return dead(ctx, q, m, err, opt)
+	}
+
+	fctx, cancel := context.WithCancel(ctx)
+	lost := make(chan struct{})
+	stop := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		t := time.NewTicker(opt.Visibility / 3)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				err := q.Extend(fctx, m, opt.Visibility)
+				if errors.Is(err, ErrLeaseLost) {
+					// Without a lease the item may already be
+					// with another worker; stop working on it.
+					close(lost)
+					cancel()
+					return
+				}
+				// Other failures, such as a flaky connection or
+				// a shutdown, leave the lease with us: try
+				// again on the next tick, which comes well
+				// before the lease runs out.
+			case <-stop:
+				return
+			}
+		}
+	}()
+	err := fn(fctx, v)
+	close(stop)
+	<-exited
+	cancel()
+
+	select {
+	case <-lost:
+		return nil
+	default:
+	}
+	if err == nil {
+		// Acknowledge even when shutting down, so that finished work
+		// is not done again.
+		return ignoreLost(q.Ack(context.WithoutCancel(ctx), m))
+	}
+	if ctx.Err() != nil {
+		// Let another worker have the item now rather than after the
+		// lease runs out.
+		q.Release(context.WithoutCancel(ctx), m)
+		return nil
+	}
+	if m.Deliveries >= opt.MaxDeliveries {
+		return dead(ctx, q, m, err, opt)
+	}
+	return ignoreLost(q.Release(ctx, m))
+}
+
+// dead gives up on m, reporting it to opt.OnDead and removing it from q.
+func dead(ctx context.Context, q Queue, m Message, err error, opt Options) error {
+	if opt.OnDead != nil {
+		opt.OnDead(m, err)
+	}
+	return ignoreLost(q.Ack(ctx, m))
+}
+
+// ignoreLost treats a lost lease as success: the item is someone else's to
+// finish now.
+func ignoreLost(err error) error {
+	if errors.Is(err, ErrLeaseLost) {
+		return nil
+	}
+	return err
+}
+
+// RunStage pulls items pushed by Enqueue from q and passes each one through
+// stage on its own, sending everything stage produces for it to emit. The
+// item is acknowledged once stage has closed its output and emit has
+// succeeded for all of it, so existing stages can be spread across processes
+// unchanged. Stages that hold items back across inputs, such as batching
+// stages, are not suitable. See Work for the delivery guarantees.
+func RunStage[In, Out any](ctx context.Context, q Queue, stage pipeline.Stage[In, Out], emit func(context.Context, Out) error, opt Options) error {
+	return Work(ctx, q, func(ctx context.Context, v In) error {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		in := make(chan In, 1)
+		in <- v
+		close(in)
+		for out := range stage(ctx, in) {
+			if err := emit(ctx, out); err != nil {
+				return err
+			}
+		}
+		return ctx.Err()
+	}, opt)
+}
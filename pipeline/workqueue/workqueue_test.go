@@ -0,0 +1,277 @@
+package workqueue
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"concurrency/pipeline"
+)
+
+func TestMemoryLeases(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemory()
+	q.Push(ctx, []byte("a"))
+	q.Push(ctx, []byte("b"))
+
+	leased, _ := q.Lease(ctx, 1, time.Hour)
+	if len(leased) != 1 || string(leased[0].Body) != "a" || leased[0].Deliveries != 1 {
+		t.Fatalf("leased %v, want a on its first delivery", leased)
+	}
+	// A leased item is invisible to other workers.
+	if others, _ := q.Lease(ctx, 2, time.Hour); len(others) != 1 || string(others[0].Body) != "b" {
+		t.Fatalf("leased %v, want only b", others)
+	}
+	if err := q.Release(ctx, leased[0]); err != nil {
+		t.Fatal(err)
+	}
+	again, _ := q.Lease(ctx, 2, 10*time.Millisecond)
+	if len(again) != 1 || again[0].Deliveries != 2 {
+		t.Fatalf("leased %v, want a again on its second delivery", again)
+	}
+	// The earlier lease on the same item is gone.
+	if err := q.Ack(ctx, leased[0]); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("acknowledging a superseded lease: got %v, want ErrLeaseLost", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if err := q.Ack(ctx, again[0]); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("acknowledging an expired lease: got %v, want ErrLeaseLost", err)
+	}
+	if q.Len() != 2 {
+		t.Errorf("got %d items, want both still queued", q.Len())
+	}
+}
+
+func TestWork(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	q := NewMemory()
+	if err := Enqueue(ctx, q, pipeline.Gen(ctx, 1, 2, 3, 4, 5, 6)); err != nil {
+		t.Fatal(err)
+	}
+	var (
+		mu   sync.Mutex
+		seen []int
+	)
+	err := Work(ctx, q, func(ctx context.Context, v int) error {
+		mu.Lock()
+		defer mu.Unlock()
+		seen = append(seen, v)
+		if len(seen) == 6 {
+			cancel()
+		}
+		return nil
+	}, Options{Workers: 3, Poll: time.Millisecond})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+	sort.Ints(seen)
+	if want := []int{1, 2, 3, 4, 5, 6}; !reflect.DeepEqual(seen, want) {
+		t.Errorf("processed %v, want every item once", seen)
+	}
+	if q.Len() != 0 {
+		t.Errorf("%d items left after acknowledging everything", q.Len())
+	}
+}
+
+func TestWorkRetriesThenGivesUp(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	q := NewMemory()
+	q.Push(ctx, []byte(`"bad"`))
+	q.Push(ctx, []byte(`not json`))
+	var (
+		mu    sync.Mutex
+		tries int
+		dead  []string
+	)
+	err := Work(ctx, q, func(ctx context.Context, v string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		tries++
+		return errors.New("cannot process " + v)
+	}, Options{
+		Poll:          time.Millisecond,
+		MaxDeliveries: 3,
+		OnDead: func(m Message, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			dead = append(dead, string(m.Body))
+			if len(dead) == 2 {
+				cancel()
+			}
+		},
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+	if tries != 3 {
+		t.Errorf("tried %d times, want MaxDeliveries", tries)
+	}
+	// The undecodable item is given up on right away.
+	sort.Strings(dead)
+	if len(dead) != 2 || dead[0] != `"bad"` || dead[1] != "not json" {
+		t.Errorf("gave up on %q, want both items", dead)
+	}
+	if q.Len() != 0 {
+		t.Errorf("%d items left, want dead items removed", q.Len())
+	}
+}
+
+func TestWorkExtendsLeases(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	q := NewMemory()
+	q.Push(ctx, []byte("1"))
+	var (
+		mu         sync.Mutex
+		deliveries int
+	)
+	// Two workers, so that the item would be leased again if its lease
+	// ran out while it is being processed.
+	err := Work(ctx, q, func(ctx context.Context, v int) error {
+		mu.Lock()
+		deliveries++
+		mu.Unlock()
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+		return nil
+	}, Options{Workers: 2, Visibility: 30 * time.Millisecond, Poll: time.Millisecond})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+	if deliveries != 1 {
+		t.Errorf("item delivered %d times while its lease was being extended", deliveries)
+	}
+	if q.Len() != 0 {
+		t.Errorf("finished item not acknowledged after cancellation")
+	}
+}
+
+func TestRunStage(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	q := NewMemory()
+	Enqueue(ctx, q, pipeline.Gen(ctx, 1, 2))
+	split := func(ctx context.Context, in <-chan int) <-chan int {
+		out := make(chan int)
+		go func() {
+			defer close(out)
+			for v := range in {
+				for _, w := range []int{v, 10 * v} {
+					select {
+					case out <- w:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}()
+		return out
+	}
+	var (
+		mu  sync.Mutex
+		got []int
+	)
+	err := RunStage(ctx, q, split, func(ctx context.Context, v int) error {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, v)
+		if len(got) == 4 {
+			cancel()
+		}
+		return nil
+	}, Options{Poll: time.Millisecond})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+	sort.Ints(got)
+	if want := []int{1, 2, 10, 20}; !reflect.DeepEqual(got, want) {
+		t.Errorf("emitted %v, want %v", got, want)
+	}
+}
+
+// flaky is a Memory queue whose Extend fails with the errors returned by
+// extend, if not nil, and which counts the leases released.
+type flaky struct {
+	*Memory
+	extend func(ctx context.Context) error
+
+	mu       sync.Mutex
+	released int
+}
+
+func (q *flaky) Extend(ctx context.Context, m Message, visibility time.Duration) error {
+	if err := q.extend(ctx); err != nil {
+		return err
+	}
+	return q.Memory.Extend(ctx, m, visibility)
+}
+
+func (q *flaky) Release(ctx context.Context, m Message) error {
+	q.mu.Lock()
+	q.released++
+	q.mu.Unlock()
+	return q.Memory.Release(ctx, m)
+}
+
+func TestWorkKeepsLeaseOnExtendErrors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	failures := 0
+	q := &flaky{Memory: NewMemory(), extend: func(context.Context) error {
+		// Fail every other extension, as a flaky connection might.
+		if failures++; failures%2 == 1 {
+			return errors.New("connection reset")
+		}
+		return nil
+	}}
+	q.Push(ctx, []byte("1"))
+	var calls, finished int
+	err := Work(ctx, q, func(ctx context.Context, v int) error {
+		calls++
+		select {
+		case <-time.After(100 * time.Millisecond):
+			finished++
+		case <-ctx.Done():
+		}
+		cancel()
+		return nil
+	}, Options{Visibility: 60 * time.Millisecond, Poll: time.Millisecond})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+	if calls != 1 || finished != 1 {
+		t.Errorf("processed the item %d times, finishing %d, want it finished once", calls, finished)
+	}
+	if q.Len() != 0 {
+		t.Errorf("finished item not acknowledged")
+	}
+}
+
+func TestWorkReleasesOnShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Extending fails once the worker is shutting down.
+	q := &flaky{Memory: NewMemory(), extend: func(ctx context.Context) error { return ctx.Err() }}
+	q.Push(ctx, []byte("1"))
+	err := Work(ctx, q, func(ctx context.Context, v int) error {
+		cancel()
+		// Take long enough to see an extension fail.
+		time.Sleep(50 * time.Millisecond)
+		return ctx.Err()
+	}, Options{Visibility: 30 * time.Millisecond, Poll: time.Millisecond})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+	if q.released != 1 {
+		t.Errorf("released %d leases, want the unfinished item released", q.released)
+	}
+	if again, _ := q.Lease(context.Background(), 1, time.Hour); len(again) != 1 {
+		t.Errorf("unfinished item not available again")
+	}
+}
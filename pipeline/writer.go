@@ -0,0 +1,104 @@
+package pipeline
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/gob"
+	"encoding/json"
+	"io"
+)
+
+// An Encoder prepares to write a stream of values to w and returns the
+// function that encodes a single value. ToWriter calls it once and then calls
+// the returned function for every item it receives.
+type Encoder[T any] func(w io.Writer) func(T) error
+
+// JSONEncoder writes every value as a line of JSON.
+func JSONEncoder[T any]() Encoder[T] {
+	return func(w io.Writer) func(T) error {
+		enc := json.NewEncoder(w)
+		return func(v T) error { return enc.Encode(v) }
+	}
+}
+
+// CSVEncoder writes every value as a CSV record made of the fields returned by
+// record.
+func CSVEncoder[T any](record func(T) []string) Encoder[T] {
+	return func(w io.Writer) func(T) error {
+		cw := csv.NewWriter(w)
+		return func(v T) error {
+			if err := cw.Write(record(v)); err != nil {
+				return err
+			}
+			// The csv writer has a buffer of its own; ToWriter does the
+			// buffering, so hand the record over straight away.
+			cw.Flush()
+			return cw.Error()
+		}
+	}
+}
+
+// GobEncoder writes the values as a gob stream.
+func GobEncoder[T any]() Encoder[T] {
+	return func(w io.Writer) func(T) error {
+		enc := gob.NewEncoder(w)
+		return func(v T) error { return enc.Encode(v) }
+	}
+}
+
+// executor is implemented by both text/template and html/template templates.
+type executor interface {
+	Execute(w io.Writer, data any) error
+}
+
+// TemplateEncoder writes every value by executing t with the value as its
+// data. Both *text/template.Template and *html/template.Template can be used.
+func TemplateEncoder[T any](t executor) Encoder[T] {
+	return func(w io.Writer) func(T) error {
+		return func(v T) error { return t.Execute(w, v) }
+	}
+}
+
+// ToWriter encodes every item received from in to w using enc. Output is
+// buffered and flushed whenever in has no further item immediately available,
+// so that a slow stream still reaches w promptly. ToWriter returns once in is
+// closed, the context is cancelled or an encoding or write error occurs; in
+// every case whatever has already been encoded is flushed to w first.
+func ToWriter[T any](ctx context.Context, in <-chan T, w io.Writer, enc Encoder[T]) (err error) {
+	bw := bufio.NewWriter(w)
+	defer func() {
+		if ferr := bw.Flush(); err == nil {
+			err = ferr
+		}
+	}()
+	encode := enc(bw)
+	for {
+		var (
+			v  T
+			ok bool
+		)
+		select {
+		case v, ok = <-in:
+		default:
+			// The input is drained for now; flush before blocking.
+			if err := bw.Flush(); err != nil {
+				return err
+			}
+			select {
+			case v, ok = <-in:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		if !ok {
+			return nil
+		}
+		if err := encode(v); err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package pipeline
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"io"
+	"reflect"
+	"strconv"
+	"testing"
+	"text/template"
+	"time"
+)
+
+type point struct {
+	X, Y int
+}
+
+func TestToWriterEncoders(t *testing.T) {
+	ctx := context.Background()
+	points := []point{{1, 2}, {3, 4}}
+	for _, tt := range []struct {
+		name string
+		enc  Encoder[point]
+		want string
+	}{
+		{"json", JSONEncoder[point](), "{\"X\":1,\"Y\":2}\n{\"X\":3,\"Y\":4}\n"},
+		{"csv", CSVEncoder(func(p point) []string {
+			return []string{strconv.Itoa(p.X), "y is " + strconv.Itoa(p.Y) + ", quoted"}
+		}), "1,\"y is 2, quoted\"\n3,\"y is 4, quoted\"\n"},
+		{"template", TemplateEncoder[point](template.Must(template.New("").Parse("({{.X}} {{.Y}})"))), "(1 2)(3 4)"},
+	} {
+		var b bytes.Buffer
+		if err := ToWriter(ctx, Gen(ctx, points...), &b, tt.enc); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if b.String() != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, b.String(), tt.want)
+		}
+	}
+}
+
+func TestToWriterGob(t *testing.T) {
+	ctx := context.Background()
+	var b bytes.Buffer
+	if err := ToWriter(ctx, Gen(ctx, point{1, 2}, point{3, 4}), &b, GobEncoder[point]()); err != nil {
+		t.Fatal(err)
+	}
+	dec := gob.NewDecoder(&b)
+	var got []point
+	for {
+		var p point
+		if err := dec.Decode(&p); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, p)
+	}
+	if want := []point{{1, 2}, {3, 4}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("decoded %v, want %v", got, want)
+	}
+}
+
+func TestToWriterFlushesWhenIdle(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r, w := io.Pipe()
+	in := make(chan point)
+	done := make(chan error)
+	go func() { done <- ToWriter(ctx, in, w, JSONEncoder[point]()) }()
+
+	// The line reaches the reader while the input stays open.
+	in <- point{1, 2}
+	line := make(chan string)
+	go func() {
+		s, _ := bufio.NewReader(r).ReadString('\n')
+		line <- s
+	}()
+	select {
+	case s := <-line:
+		if s != "{\"X\":1,\"Y\":2}\n" {
+			t.Errorf("got %q", s)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("value not flushed while waiting for the next")
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("disk full") }
+
+func TestToWriterWriteError(t *testing.T) {
+	ctx := context.Background()
+	err := ToWriter(ctx, Gen(ctx, point{1, 2}), failingWriter{}, JSONEncoder[point]())
+	if err == nil || err.Error() != "disk full" {
+		t.Errorf("got %v, want the write error", err)
+	}
+}
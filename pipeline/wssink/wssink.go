@@ -0,0 +1,156 @@
+// Package wssink pushes the output of a pipeline to WebSocket clients, so that
+// live dashboards can watch results as they are produced.
+package wssink
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Policy decides what happens to a client that cannot keep up with the
+// stream.
+type Policy int
+
+const (
+	// DropMessages skips messages for a client whose buffer is full; the
+	// client stays connected and receives later messages once it catches up.
+	DropMessages Policy = iota
+	// DropClient disconnects a client as soon as its buffer is full.
+	DropClient
+)
+
+// writeWait bounds how long a single write to a client may take.
+const writeWait = 10 * time.Second
+
+// Sink broadcasts values to every connected WebSocket client as JSON
+// messages. Clients connect through its ServeHTTP method; values are fed to it
+// through Run. Every client has its own buffer, so a slow client never holds
+// up the pipeline or the other clients.
+type Sink[T any] struct {
+	// Upgrader is used to upgrade incoming HTTP requests. Its zero value is
+	// usable; set CheckOrigin to accept cross-origin dashboards.
+	Upgrader websocket.Upgrader
+
+	buffer int
+	policy Policy
+
+	mu      sync.Mutex
+	clients map[*client[T]]struct{}
+	closed  bool
+}
+
+type client[T any] struct {
+	conn *websocket.Conn
+	send chan T
+}
+
+// New returns a Sink that buffers up to buffer messages per client and
+// applies policy to clients whose buffer is full.
+func New[T any](buffer int, policy Policy) *Sink[T] {
+	return &Sink[T]{
+		buffer:  buffer,
+		policy:  policy,
+		clients: make(map[*client[T]]struct{}),
+	}
+}
+
+// ServeHTTP upgrades the request to a WebSocket connection and streams values
+// to it until the client goes away or Run returns.
+func (s *Sink[T]) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.Upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already replied to the client.
+		return
+	}
+	c := &client[T]{conn: conn, send: make(chan T, s.buffer)}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseGoingAway, "stream finished"),
+			time.Now().Add(writeWait))
+		conn.Close()
+		return
+	}
+	s.clients[c] = struct{}{}
+	s.mu.Unlock()
+
+	// The read loop only exists to process control frames and to notice
+	// that the client has gone away.
+	go func() {
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				s.remove(c)
+				return
+			}
+		}
+	}()
+
+	for v := range c.send {
+		conn.SetWriteDeadline(time.Now().Add(writeWait))
+		if err := conn.WriteJSON(v); err != nil {
+			s.remove(c)
+			break
+		}
+	}
+	conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(writeWait))
+	conn.Close()
+}
+
+// remove unregisters c and closes its send channel, which makes its writer
+// finish. It is safe to call more than once.
+func (s *Sink[T]) remove(c *client[T]) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.clients[c]; ok {
+		delete(s.clients, c)
+		close(c.send)
+	}
+}
+
+// Run sends every value received from in to all connected clients. When in is
+// closed or the context is cancelled, Run disconnects all clients, refuses new
+// ones and returns.
+func (s *Sink[T]) Run(ctx context.Context, in <-chan T) error {
+	defer func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.closed = true
+		for c := range s.clients {
+			delete(s.clients, c)
+			close(c.send)
+		}
+	}()
+	for {
+		select {
+		case v, ok := <-in:
+			if !ok {
+				return nil
+			}
+			s.broadcast(v)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *Sink[T]) broadcast(v T) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.clients {
+		select {
+		case c.send <- v:
+		default:
+			if s.policy == DropClient {
+				delete(s.clients, c)
+				close(c.send)
+			}
+		}
+	}
+}
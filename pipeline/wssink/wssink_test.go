@@ -0,0 +1,122 @@
+package wssink
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// dial connects to the sink served by srv and waits for it to register the
+// client.
+func dial(t *testing.T, s *Sink[int], srv *httptest.Server) *websocket.Conn {
+	t.Helper()
+	s.mu.Lock()
+	before := len(s.clients)
+	s.mu.Unlock()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		s.mu.Lock()
+		n := len(s.clients)
+		s.mu.Unlock()
+		if n > before {
+			return conn
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("client not registered")
+		}
+	}
+}
+
+func TestSink(t *testing.T) {
+	s := New[int](10, DropMessages)
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	a, b := dial(t, s, srv), dial(t, s, srv)
+
+	in := make(chan int)
+	done := make(chan error)
+	go func() { done <- s.Run(context.Background(), in) }()
+	for i := 1; i <= 3; i++ {
+		in <- i
+	}
+	close(in)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	for _, conn := range []*websocket.Conn{a, b} {
+		for i := 1; i <= 3; i++ {
+			var v int
+			if err := conn.ReadJSON(&v); err != nil {
+				t.Fatal(err)
+			}
+			if v != i {
+				t.Errorf("got %d, want %d", v, i)
+			}
+		}
+		_, _, err := conn.ReadMessage()
+		if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+			t.Errorf("got %v once the stream finished, want a normal closure", err)
+		}
+	}
+
+	// Clients connecting after Run has returned are turned away.
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("got %v after the stream finished, want going away", err)
+	}
+}
+
+func TestSinkRunCancelled(t *testing.T) {
+	s := New[int](1, DropMessages)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.Run(ctx, make(chan int)); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+}
+
+func TestSinkPolicies(t *testing.T) {
+	for _, tt := range []struct {
+		policy    Policy
+		connected bool
+	}{
+		{DropMessages, true},
+		{DropClient, false},
+	} {
+		s := New[int](1, tt.policy)
+		// A client that has not taken anything from its buffer yet.
+		c := &client[int]{send: make(chan int, 1)}
+		s.clients[c] = struct{}{}
+		s.broadcast(1)
+		s.broadcast(2)
+		if _, ok := s.clients[c]; ok != tt.connected {
+			t.Errorf("policy %d: client connected %v, want %v", tt.policy, ok, tt.connected)
+		}
+		if v := <-c.send; v != 1 {
+			t.Errorf("policy %d: got %d, want the message that fit the buffer", tt.policy, v)
+		}
+		if tt.connected {
+			select {
+			case v := <-c.send:
+				t.Errorf("policy %d: got %d, want the overflowing message dropped", tt.policy, v)
+			default:
+			}
+		} else if _, ok := <-c.send; ok {
+			t.Errorf("policy %d: buffer of a dropped client not closed", tt.policy)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package pipeline
+
+import "context"
+
+// A Pair holds the values at the same position in two streams.
+type Pair[A, B any] struct {
+	First  A
+	Second B
+}
+
+// Zip pairs the i-th value received from a with the i-th value received from
+// b, for joining parallel computations over the same sequence of inputs. The
+// output channel is closed as soon as either input is closed; the values left
+// in the other one are not read.
+func Zip[A, B any](ctx context.Context, a <-chan A, b <-chan B) <-chan Pair[A, B] {
+	out := make(chan Pair[A, B])
+	go func() {
+		defer close(out)
+		for {
+			var p Pair[A, B]
+			// Receive from whichever input is ready first, so that
+			// neither is held up waiting for the other.
+			ra, rb := a, b
+			for ra != nil || rb != nil {
+				select {
+				case v, ok := <-ra:
+					if !ok {
+						return
+					}
+					p.First, ra = v, nil
+				case v, ok := <-rb:
+					if !ok {
+						return
+					}
+					p.Second, rb = v, nil
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case out <- p:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
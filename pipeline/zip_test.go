@@ -0,0 +1,32 @@
+package pipeline
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestZip(t *testing.T) {
+	ctx := context.Background()
+	out := Zip(ctx, Gen(ctx, 1, 2, 3), Gen(ctx, "a", "b"))
+	// The output ends with the shorter input.
+	want := []Pair[int, string]{{1, "a"}, {2, "b"}}
+	if got := collect(t, out); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestZipEitherOrder(t *testing.T) {
+	ctx := context.Background()
+	a := make(chan int)
+	b := make(chan int)
+	out := Zip(ctx, a, b)
+	// b is ready before a; Zip takes it without waiting for a first.
+	b <- 10
+	a <- 1
+	if p := <-out; p != (Pair[int, int]{1, 10}) {
+		t.Errorf("got %v, want {1 10}", p)
+	}
+	close(a)
+	collect(t, out)
+}